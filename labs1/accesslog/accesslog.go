@@ -0,0 +1,188 @@
+// Package accesslog — журнал запросов серверов labs1 в Combined Log Format
+// или JSON-строках, с идентификатором запроса и ротацией файла по размеру.
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Форматы журнала.
+const (
+	Combined = "combined"
+	JSON     = "json"
+)
+
+// Entry — одна строка журнала.
+type Entry struct {
+	Time       time.Time
+	Server     string
+	RemoteAddr string
+	User       string
+	Method     string
+	URI        string
+	Proto      string
+	Host       string
+	Route      string // шаблон маршрута, если запрос нашёл обработчик
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+	Referer    string
+	UserAgent  string
+	RequestID  string
+}
+
+// Sink принимает записи о запросах: журнал или, например, счётчики
+// метрик.
+type Sink interface {
+	Log(e Entry)
+}
+
+// Tee раздаёт каждую запись всем sinks по очереди.
+func Tee(sinks ...Sink) Sink { return tee(sinks) }
+
+type tee []Sink
+
+func (t tee) Log(e Entry) {
+	for _, s := range t {
+		s.Log(e)
+	}
+}
+
+// Logger пишет записи в w; запись одной строки атомарна, поэтому один
+// Logger можно отдать всем серверам сразу.
+type Logger struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// New создаёт журнал в формате Combined или JSON.
+func New(w io.Writer, format string) (*Logger, error) {
+	switch format {
+	case Combined, JSON:
+	default:
+		return nil, fmt.Errorf("неизвестный формат журнала %q (ожидается %s или %s)", format, Combined, JSON)
+	}
+	return &Logger{w: w, format: format}, nil
+}
+
+// Log записывает строку. Ошибки записи не мешают обслуживать запросы и
+// поэтому не возвращаются.
+func (l *Logger) Log(e Entry) {
+	if l == nil {
+		return
+	}
+	var line []byte
+	if l.format == JSON {
+		line = jsonLine(e)
+	} else {
+		line = combinedLine(e)
+	}
+	l.mu.Lock()
+	l.w.Write(line)
+	l.mu.Unlock()
+}
+
+// combinedLine — формат Apache "combined" плюс два поля в конце:
+// идентификатор запроса в кавычках и время обработки в секундах.
+//
+//	127.0.0.1 - bob [10/Oct/2000:13:55:36 +0300] "GET /x HTTP/1.1" 200 2326 "-" "curl/8.0" "3f2a..." 0.001234
+func combinedLine(e Entry) []byte {
+	var b strings.Builder
+	b.WriteString(dash(e.RemoteAddr))
+	b.WriteString(" - ")
+	b.WriteString(dash(e.User))
+	b.WriteString(e.Time.Format(" [02/Jan/2006:15:04:05 -0700] "))
+	b.WriteString(quote(e.Method + " " + e.URI + " " + e.Proto))
+	b.WriteByte(' ')
+	b.WriteString(strconv.Itoa(e.Status))
+	b.WriteByte(' ')
+	if e.Bytes > 0 {
+		b.WriteString(strconv.FormatInt(e.Bytes, 10))
+	} else {
+		b.WriteByte('-')
+	}
+	b.WriteByte(' ')
+	b.WriteString(quote(dash(e.Referer)))
+	b.WriteByte(' ')
+	b.WriteString(quote(dash(e.UserAgent)))
+	b.WriteByte(' ')
+	b.WriteString(quote(dash(e.RequestID)))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(e.Duration.Seconds(), 'f', 6, 64))
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+type jsonEntry struct {
+	Time       string  `json:"time"`
+	Server     string  `json:"server"`
+	RemoteAddr string  `json:"remote_addr"`
+	User       string  `json:"user,omitempty"`
+	Method     string  `json:"method"`
+	URI        string  `json:"uri"`
+	Proto      string  `json:"proto"`
+	Host       string  `json:"host,omitempty"`
+	Route      string  `json:"route,omitempty"`
+	Status     int     `json:"status"`
+	Bytes      int64   `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+	RequestID  string  `json:"request_id"`
+}
+
+func jsonLine(e Entry) []byte {
+	line, _ := json.Marshal(jsonEntry{
+		Time:       e.Time.Format(time.RFC3339Nano),
+		Server:     e.Server,
+		RemoteAddr: e.RemoteAddr,
+		User:       e.User,
+		Method:     e.Method,
+		URI:        e.URI,
+		Proto:      e.Proto,
+		Host:       e.Host,
+		Route:      e.Route,
+		Status:     e.Status,
+		Bytes:      e.Bytes,
+		DurationMS: float64(e.Duration.Microseconds()) / 1000,
+		Referer:    e.Referer,
+		UserAgent:  e.UserAgent,
+		RequestID:  e.RequestID,
+	})
+	return append(line, '\n')
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// quote заключает поле в кавычки, экранируя кавычки, обратную косую черту
+// и управляющие символы, чтобы клиент не мог подделать строку журнала.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\x%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,226 @@
+package accesslog
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"labs1/httpraw"
+)
+
+// RequestIDHeader — заголовок с идентификатором запроса. Пришедший от
+// клиента или балансировщика идентификатор сохраняется, иначе создаётся
+// новый; обработчики читают его из заголовков запроса, клиент получает
+// его в ответе.
+const RequestIDHeader = "X-Request-ID"
+
+// NewRequestID возвращает 16 случайных шестнадцатеричных символов.
+func NewRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// requestID принимает входящий идентификатор, если он короткий и состоит
+// из безопасных символов: он попадает в журнал и в заголовки ответа.
+func requestID(incoming string) string {
+	if incoming == "" || len(incoming) > 128 {
+		return NewRequestID()
+	}
+	for i := 0; i < len(incoming); i++ {
+		c := incoming[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return NewRequestID()
+		}
+	}
+	return incoming
+}
+
+// Handler передаёт в sink запись о каждом запросе к net/http серверу
+// server.
+func Handler(server string, sink Sink, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestID(r.Header.Get(RequestIDHeader))
+		r.Header.Set(RequestIDHeader, id)
+		w.Header().Set(RequestIDHeader, id)
+
+		// имя пользователя узнаёт обработчик авторизации глубже по
+		// цепочке и записывает его через SetUser
+		user := new(string)
+		r = r.WithContext(context.WithValue(r.Context(), userKey{}, user))
+
+		rec := &httpRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		sink.Log(Entry{
+			Time:       start,
+			Server:     server,
+			RemoteAddr: hostOnly(r.RemoteAddr),
+			User:       *user,
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Host:       r.Host,
+			Route:      r.Pattern,
+			Status:     statusOr200(rec.status),
+			Bytes:      rec.size,
+			Duration:   time.Since(start),
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			RequestID:  id,
+		})
+	})
+}
+
+type userKey struct{}
+
+// SetUser записывает в журнал доступа имя пользователя, прошедшего
+// авторизацию. Для raw сервера то же делает поле Request.User.
+func SetUser(r *http.Request, user string) {
+	if p, ok := r.Context().Value(userKey{}).(*string); ok {
+		*p = user
+	}
+}
+
+// RawHandler — то же для raw сервера (HTTP/1.x и HTTP/2). Для
+// захваченного соединения (WebSocket) в журнал попадает 101 и время
+// жизни соединения.
+func RawHandler(server string, sink Sink, next httpraw.Handler) httpraw.Handler {
+	return httpraw.HandlerFunc(func(w httpraw.ResponseWriter, r *httpraw.Request) {
+		start := time.Now()
+		id := requestID(r.Header.Get(RequestIDHeader))
+		r.Header.Set(RequestIDHeader, id)
+		w.Header().Set(RequestIDHeader, id)
+
+		rec := &rawRecorder{w: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if rec.hijacked && status == 0 {
+			status = http.StatusSwitchingProtocols
+		}
+		sink.Log(Entry{
+			Time:       start,
+			Server:     server,
+			RemoteAddr: hostOnly(r.RemoteAddr),
+			User:       r.User,
+			Method:     r.Method,
+			URI:        r.RequestURI,
+			Proto:      r.Proto,
+			Host:       r.Host,
+			Route:      r.Pattern,
+			Status:     statusOr200(status),
+			Bytes:      rec.size,
+			Duration:   time.Since(start),
+			Referer:    r.Header.Get("Referer"),
+			UserAgent:  r.Header.Get("User-Agent"),
+			RequestID:  id,
+		})
+	})
+}
+
+func statusOr200(status int) int {
+	if status == 0 {
+		return http.StatusOK
+	}
+	return status
+}
+
+func hostOnly(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// httpRecorder запоминает код ответа и число байт тела.
+type httpRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (r *httpRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *httpRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *httpRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *httpRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := r.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("ResponseWriter не поддерживает Hijack")
+}
+
+// Unwrap нужен http.ResponseController.
+func (r *httpRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// rawRecorder реализует httpraw.ResponseWriter, httpraw.Flusher и
+// httpraw.Hijacker поверх писателя raw сервера.
+type rawRecorder struct {
+	w        httpraw.ResponseWriter
+	status   int
+	size     int64
+	hijacked bool
+}
+
+func (r *rawRecorder) Header() httpraw.Header { return r.w.Header() }
+
+func (r *rawRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.w.WriteHeader(code)
+}
+
+func (r *rawRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.w.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rawRecorder) Flush() error {
+	if f, ok := r.w.(httpraw.Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (r *rawRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.w.(httpraw.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("ResponseWriter не поддерживает Hijack")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		r.hijacked = true
+	}
+	return conn, rw, err
+}
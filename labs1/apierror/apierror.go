@@ -0,0 +1,176 @@
+// Package apierror отвечает на ошибки API net/http серверов labs1
+// единообразно: JSON-объект с устойчивым кодом ошибки, сообщением для
+// человека и идентификатором запроса из журнала доступа. Браузер (Accept
+// предпочитает text/html) получает ту же ошибку страницей HTML.
+//
+//	{"error": {"status": 404, "code": "file_not_found",
+//	           "message": "Файл не найден: /etc/nope", "request_id": "..."}}
+//
+// Обработчики вызывают Write. Ответы об ошибках, которые пишут через
+// http.Error чужие обработчики — ServeMux (404, 405), авторизация,
+// ограничение частоты, — перехватывает Handler.
+package apierror
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"labs1/accesslog"
+)
+
+// Устойчивые коды ошибок. Клиенты сравнивают именно их: сообщение может
+// меняться.
+const (
+	BadRequest        = "bad_request"
+	MissingParameter  = "missing_parameter"
+	InvalidParameter  = "invalid_parameter"
+	InvalidURL        = "invalid_url"
+	UnsupportedScheme = "unsupported_scheme"
+	Unauthorized      = "unauthorized"
+	Forbidden         = "forbidden"
+	NotFound          = "not_found"
+	FileNotFound      = "file_not_found"
+	MethodNotAllowed  = "method_not_allowed"
+	TooLarge          = "payload_too_large"
+	TooManyRequests   = "too_many_requests"
+	Internal          = "internal_error"
+	FileReadFailed    = "file_read_failed"
+	DNSQueryFailed    = "dns_query_failed"
+	Unavailable       = "service_unavailable"
+)
+
+// Codes — все коды, для описания API.
+var Codes = []string{
+	BadRequest, MissingParameter, InvalidParameter, InvalidURL, UnsupportedScheme,
+	Unauthorized, Forbidden, NotFound, FileNotFound, MethodNotAllowed, TooLarge,
+	TooManyRequests, Internal, FileReadFailed, DNSQueryFailed, Unavailable,
+}
+
+// CodeForStatus — код ошибки по умолчанию для статуса ответа.
+func CodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return BadRequest
+	case http.StatusUnauthorized:
+		return Unauthorized
+	case http.StatusForbidden:
+		return Forbidden
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusMethodNotAllowed:
+		return MethodNotAllowed
+	case http.StatusRequestEntityTooLarge:
+		return TooLarge
+	case http.StatusTooManyRequests:
+		return TooManyRequests
+	case http.StatusServiceUnavailable:
+		return Unavailable
+	}
+	if status >= 500 {
+		return Internal
+	}
+	return BadRequest
+}
+
+// Body — содержимое поля "error" ответа.
+type Body struct {
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+// Write отвечает ошибкой status с кодом code: JSON или, для браузера,
+// HTML. Заголовки, уже выставленные обработчиком (Allow,
+// WWW-Authenticate, Retry-After), сохраняются.
+func Write(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	body := Body{
+		Status:    status,
+		Code:      code,
+		Message:   message,
+		RequestID: r.Header.Get(accesslog.RequestIDHeader),
+	}
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Content-Disposition")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Add("Vary", "Accept")
+
+	if prefersHTML(r.Header.Get("Accept")) {
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		writeHTML(w, body)
+		return
+	}
+	h.Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error Body `json:"error"`
+	}{body})
+}
+
+// Errorf — Write с кодом по умолчанию для статуса и форматированным
+// сообщением.
+func Errorf(w http.ResponseWriter, r *http.Request, status int, format string, args ...any) {
+	Write(w, r, status, CodeForStatus(status), fmt.Sprintf(format, args...))
+}
+
+func writeHTML(w http.ResponseWriter, b Body) {
+	title := html.EscapeString(fmt.Sprintf("%d %s", b.Status, http.StatusText(b.Status)))
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n", title)
+	fmt.Fprintf(w, "<h1>%s</h1>\n<p>%s</p>\n", title, html.EscapeString(b.Message))
+	fmt.Fprintf(w, "<p><small>Код ошибки: <code>%s</code>", html.EscapeString(b.Code))
+	if b.RequestID != "" {
+		fmt.Fprintf(w, ", запрос <code>%s</code>", html.EscapeString(b.RequestID))
+	}
+	fmt.Fprint(w, "</small></p>\n</body>\n</html>\n")
+}
+
+// prefersHTML проверяет, ставит ли Accept text/html выше JSON. Клиенты
+// без Accept или с */* получают JSON.
+func prefersHTML(accept string) bool {
+	if accept == "" {
+		return false
+	}
+	htmlQ, jsonQ := 0.0, 0.0
+	htmlSpec, jsonSpec := 0, 0 // точность совпавшего диапазона
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, _ := strings.Cut(part, ";")
+		mediaRange = strings.ToLower(strings.TrimSpace(mediaRange))
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(k), "q") {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				}
+			}
+		}
+		if s := specificity(mediaRange, "text/html"); s > htmlSpec {
+			htmlQ, htmlSpec = q, s
+		}
+		if s := specificity(mediaRange, "application/json"); s > jsonSpec {
+			jsonQ, jsonSpec = q, s
+		}
+	}
+	return htmlQ > jsonQ
+}
+
+// specificity — насколько точно диапазон Accept описывает тип: 3 —
+// точно, 2 — type/*, 1 — */*, 0 — не подходит (RFC 9110 12.5.1).
+func specificity(mediaRange, mediaType string) int {
+	typ, _, _ := strings.Cut(mediaType, "/")
+	switch mediaRange {
+	case mediaType:
+		return 3
+	case typ + "/*":
+		return 2
+	case "*/*":
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,72 @@
+package apierror
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// maxMessage — сколько текста ошибки http.Error сохранять для message.
+const maxMessage = 1 << 10
+
+// Handler переписывает текстовые ответы об ошибках (статус 4xx/5xx с
+// Content-Type text/plain, как у http.Error) в формат Write: текст
+// становится сообщением, код выбирается по статусу. Ответы, которые
+// обработчик уже оформил сам (JSON, HTML, сжатые), проходят как есть.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if ew.status != 0 {
+			Write(w, r, ew.status, CodeForStatus(ew.status), strings.TrimSpace(ew.message.String()))
+		}
+	})
+}
+
+// errorWriter откладывает текстовый ответ об ошибке до конца
+// обработчика, чтобы заменить его.
+type errorWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	status      int // перехваченный статус, 0 — ответ идёт как есть
+	message     bytes.Buffer
+}
+
+func (ew *errorWriter) WriteHeader(code int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+	h := ew.Header()
+	if code >= 400 && h.Get("Content-Encoding") == "" &&
+		strings.HasPrefix(h.Get("Content-Type"), "text/plain") {
+		ew.status = code
+		return
+	}
+	ew.ResponseWriter.WriteHeader(code)
+}
+
+func (ew *errorWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if ew.status != 0 {
+		if room := maxMessage - ew.message.Len(); room > 0 {
+			ew.message.Write(b[:min(len(b), room)])
+		}
+		return len(b), nil
+	}
+	return ew.ResponseWriter.Write(b)
+}
+
+func (ew *errorWriter) Flush() {
+	if ew.status != 0 {
+		return
+	}
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap открывает исходный ResponseWriter для http.ResponseController.
+func (ew *errorWriter) Unwrap() http.ResponseWriter { return ew.ResponseWriter }
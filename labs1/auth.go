@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"labs1/auth"
+	"labs1/middleware"
+)
+
+var (
+	authUsers = flag.String("auth", "", "логины и пароли для авторизации в открытом виде: user:pass[,user:pass]")
+	authFile  = flag.String("auth-file", "", "файл пользователей в формате htpasswd (bcrypt, $5$) или htdigest")
+)
+
+// authConfig — раздел "auth" файла настроек.
+type authConfig struct {
+	File    string        `json:"file"`
+	Realm   string        `json:"realm"`
+	Lockout lockoutConfig `json:"lockout"`
+	// Routes — какие пути каких серверов защищены и какими схемами.
+	// Если список пуст, а пользователи есть, защищены file и dns
+	// серверы целиком, Digest и Basic.
+	Routes []authRoute `json:"routes"`
+}
+
+type lockoutConfig struct {
+	// MaxFailures — неудачных попыток до блокировки, 0 — не блокировать.
+	MaxFailures int      `json:"max_failures"`
+	Window      duration `json:"window"`
+	Duration    duration `json:"duration"`
+}
+
+type authRoute struct {
+	Server string `json:"server"`
+	Path   string `json:"path"`
+	// Schemes — "digest" и/или "basic" в порядке предпочтения; пустой
+	// список открывает путь внутри защищённого.
+	Schemes []string `json:"schemes"`
+}
+
+var defaultAuthRoutes = []authRoute{
+	{Server: "file", Path: "/", Schemes: []string{"digest", "basic"}},
+	{Server: "dns", Path: "/", Schemes: []string{"digest", "basic"}},
+}
+
+// validate проверяет раздел до запуска серверов.
+func (ac authConfig) validate() error {
+	if ac.Realm == "" {
+		return fmt.Errorf("auth: realm не может быть пустым")
+	}
+	if ac.Lockout.MaxFailures > 0 && (ac.Lockout.Window <= 0 || ac.Lockout.Duration <= 0) {
+		return fmt.Errorf("auth: для блокировки нужны положительные window и duration")
+	}
+	for _, r := range ac.Routes {
+		switch r.Server {
+		case "raw", "file", "dns":
+		default:
+			return fmt.Errorf("auth: неизвестный сервер %q (raw, file или dns)", r.Server)
+		}
+		if !strings.HasPrefix(r.Path, "/") {
+			return fmt.Errorf("auth: путь %q должен начинаться с /", r.Path)
+		}
+		for _, s := range r.Schemes {
+			if _, err := auth.ParseScheme(s); err != nil {
+				return fmt.Errorf("auth: %s%s: %v", r.Server, r.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// labAuth и authRules задаёт setupAuth; nil labAuth — авторизация
+// выключена.
+var (
+	labAuth   *auth.Authenticator
+	authRules map[string][]auth.Rule
+)
+
+// setupAuth загружает пользователей из файла и флага -auth и собирает
+// правила по серверам. Без пользователей авторизация не включается.
+func setupAuth(ac authConfig) error {
+	store := auth.NewStore()
+	if ac.File != "" {
+		var err error
+		if store, err = auth.LoadFile(ac.File); err != nil {
+			return err
+		}
+	}
+	users, err := parseAuthUsers(*authUsers)
+	if err != nil {
+		return err
+	}
+	for user, pass := range users {
+		store.Add(user, pass)
+	}
+	if store.Len() == 0 {
+		if len(ac.Routes) > 0 {
+			return fmt.Errorf("маршруты в auth.routes защищены, но пользователей нет (-auth-file или -auth)")
+		}
+		return nil
+	}
+
+	routes := ac.Routes
+	if len(routes) == 0 {
+		routes = defaultAuthRoutes
+	}
+	authRules = make(map[string][]auth.Rule)
+	for _, r := range routes {
+		rule := auth.Rule{Path: r.Path}
+		for _, s := range r.Schemes {
+			scheme, _ := auth.ParseScheme(s)
+			rule.Schemes = append(rule.Schemes, scheme)
+		}
+		authRules[r.Server] = append(authRules[r.Server], rule)
+	}
+
+	var lockout *auth.Lockout
+	if ac.Lockout.MaxFailures > 0 {
+		lockout = &auth.Lockout{
+			MaxFailures: ac.Lockout.MaxFailures,
+			Window:      time.Duration(ac.Lockout.Window),
+			Duration:    time.Duration(ac.Lockout.Duration),
+		}
+	}
+	labAuth = auth.New(ac.Realm, store, lockout)
+	labAuth.Logf = func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
+	}
+	fmt.Printf("Авторизация: %d пользователей, realm %q\n", store.Len(), ac.Realm)
+	for _, r := range routes {
+		schemes := strings.Join(r.Schemes, ", ")
+		if schemes == "" {
+			schemes = "открыт"
+		}
+		fmt.Printf("  %s %s: %s\n", r.Server, r.Path, schemes)
+	}
+	return nil
+}
+
+// authMiddleware — авторизация net/http сервера name, nil — если его
+// маршруты не защищены.
+func authMiddleware(name string) middleware.Middleware {
+	if labAuth == nil || len(authRules[name]) == 0 {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		return labAuth.Handler(authRules[name], next)
+	}
+}
+
+func parseAuthUsers(s string) (map[string]string, error) {
+	users := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		user, pass, ok := strings.Cut(pair, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("-auth: ожидается user:pass, получено %q", pair)
+		}
+		users[user] = pass
+	}
+	return users, nil
+}
@@ -0,0 +1,240 @@
+// Package auth — Basic (RFC 7617) и Digest (RFC 7616) авторизация,
+// включаемая для отдельных маршрутов net/http и raw серверов.
+// Пользователи берутся из файла в духе htpasswd (см. Store), неудачные
+// попытки входа ведут к временной блокировке клиента (см. Lockout), а
+// имя вошедшего пользователя попадает в журнал доступа.
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"labs1/accesslog"
+	"labs1/httpraw"
+)
+
+// Scheme — схема авторизации.
+type Scheme string
+
+const (
+	Basic  Scheme = "basic"
+	Digest Scheme = "digest"
+)
+
+// Rule включает авторизацию для пути Path и всего, что под ним:
+// "/file" защищает /file и /file/..., "/" — весь сервер. Из нескольких
+// подходящих правил действует самое длинное. Пустой Schemes открывает
+// путь — так из защищённого сервера исключаются, например, /metrics.
+type Rule struct {
+	Path    string
+	Schemes []Scheme
+}
+
+// Authenticator проверяет учётные данные запросов. Один Authenticator
+// можно использовать на нескольких серверах: блокировки и nonce у них
+// общие.
+type Authenticator struct {
+	Realm   string
+	Store   *Store
+	Lockout *Lockout
+	// Logf получает сообщения о блокировках; nil — не писать.
+	Logf func(format string, args ...any)
+
+	nonces *nonces
+	opaque string
+}
+
+// New создаёт Authenticator для области realm.
+func New(realm string, store *Store, lockout *Lockout) *Authenticator {
+	opaque := make([]byte, 12)
+	rand.Read(opaque)
+	return &Authenticator{
+		Realm:   realm,
+		Store:   store,
+		Lockout: lockout,
+		nonces:  newNonces(),
+		opaque:  base64.RawURLEncoding.EncodeToString(opaque),
+	}
+}
+
+// Handler пропускает в next запросы к незащищённым путям и запросы с
+// верными учётными данными. Остальные получают 401 с вызовами
+// WWW-Authenticate или 429 на время блокировки.
+func (a *Authenticator) Handler(rules []Rule, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule := matchRule(rules, r.URL.Path)
+		if rule == nil || len(rule.Schemes) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d := a.check(rule.Schemes, r.Method, r.RequestURI, r.Header.Get("Authorization"), hostOnly(r.RemoteAddr))
+		if d.status != 0 {
+			d.writeHeaders(w.Header())
+			http.Error(w, d.message, d.status)
+			return
+		}
+		accesslog.SetUser(r, d.user)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RawHandler — то же для raw сервера.
+func (a *Authenticator) RawHandler(rules []Rule, next httpraw.Handler) httpraw.Handler {
+	return httpraw.HandlerFunc(func(w httpraw.ResponseWriter, r *httpraw.Request) {
+		rule := matchRule(rules, r.Path)
+		if rule == nil || len(rule.Schemes) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d := a.check(rule.Schemes, r.Method, r.RequestURI, r.Header.Get("Authorization"), hostOnly(r.RemoteAddr))
+		if d.status != 0 {
+			d.writeHeaders(w.Header())
+			httpraw.Error(w, d.message, d.status)
+			return
+		}
+		r.User = d.user
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decision — итог проверки: status 0 пропускает запрос.
+type decision struct {
+	user       string
+	status     int
+	message    string
+	challenges []string
+	retryAfter time.Duration
+}
+
+// headerWriter — общее у http.Header и httpraw.Header.
+type headerWriter interface {
+	Add(key, value string)
+	Set(key, value string)
+}
+
+// writeHeaders добавляет заголовки отказа.
+func (d decision) writeHeaders(h headerWriter) {
+	for _, c := range d.challenges {
+		h.Add("WWW-Authenticate", c)
+	}
+	if d.retryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(int(d.retryAfter.Round(time.Second)/time.Second)))
+	}
+}
+
+func (a *Authenticator) check(schemes []Scheme, method, uri, authorization, client string) decision {
+	now := time.Now()
+	if left := a.Lockout.locked(client, now); left > 0 {
+		return decision{status: http.StatusTooManyRequests, message: "Слишком много неудачных попыток входа", retryAfter: left}
+	}
+
+	scheme, credentials, _ := strings.Cut(authorization, " ")
+	allowed := func(s Scheme) bool {
+		if !strings.EqualFold(scheme, string(s)) {
+			return false
+		}
+		for _, rs := range schemes {
+			if rs == s {
+				return true
+			}
+		}
+		return false
+	}
+
+	var err error
+	stale := false
+	switch {
+	case authorization == "":
+	case allowed(Basic):
+		var user string
+		if user, err = a.checkBasic(credentials); err == nil {
+			a.Lockout.succeed(client)
+			return decision{user: user}
+		}
+	case allowed(Digest):
+		var user string
+		user, stale, err = a.checkDigest(credentials, method, uri, now)
+		if err == nil && !stale {
+			a.Lockout.succeed(client)
+			return decision{user: user}
+		}
+	default:
+		err = fmt.Errorf("схема %q не принимается на этом пути", scheme)
+	}
+
+	if err != nil && a.Lockout.fail(client, now) {
+		a.logf("Auth: %s заблокирован на %v после %d неудачных попыток (последняя: %v)",
+			client, a.Lockout.Duration, a.Lockout.MaxFailures, err)
+		return decision{status: http.StatusTooManyRequests, message: "Слишком много неудачных попыток входа", retryAfter: a.Lockout.Duration}
+	}
+	d := decision{status: http.StatusUnauthorized, message: "Требуется авторизация"}
+	for _, s := range schemes {
+		switch s {
+		case Digest:
+			d.challenges = append(d.challenges, a.digestChallenges(stale)...)
+		case Basic:
+			d.challenges = append(d.challenges, fmt.Sprintf("Basic realm=%s, charset=\"UTF-8\"", quoteParam(a.Realm)))
+		}
+	}
+	return d
+}
+
+func (a *Authenticator) checkBasic(credentials string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return "", fmt.Errorf("Basic: некорректный base64")
+	}
+	user, password, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return "", fmt.Errorf("Basic: ожидается user:password")
+	}
+	if !a.Store.checkPassword(user, a.Realm, password) {
+		return "", fmt.Errorf("неверный пароль пользователя %q", user)
+	}
+	return user, nil
+}
+
+func (a *Authenticator) logf(format string, args ...any) {
+	if a.Logf != nil {
+		a.Logf(format, args...)
+	}
+}
+
+// ParseScheme проверяет имя схемы из настроек.
+func ParseScheme(s string) (Scheme, error) {
+	switch Scheme(strings.ToLower(s)) {
+	case Basic:
+		return Basic, nil
+	case Digest:
+		return Digest, nil
+	}
+	return "", fmt.Errorf("неизвестная схема авторизации %q (basic или digest)", s)
+}
+
+// matchRule выбирает правило с самым длинным подходящим путём.
+func matchRule(rules []Rule, path string) *Rule {
+	var best *Rule
+	for i := range rules {
+		p := strings.TrimSuffix(rules[i].Path, "/")
+		if path != p && !strings.HasPrefix(path, p+"/") {
+			continue
+		}
+		if best == nil || len(rules[i].Path) > len(best.Path) {
+			best = &rules[i]
+		}
+	}
+	return best
+}
+
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
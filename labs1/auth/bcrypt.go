@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync"
+)
+
+// bcrypt (Provos, Mazières, 1999) — это Blowfish с дорогой подготовкой
+// ключа (EksBlowfish): 2^cost раз ключ и соль заново перемешиваются с
+// таблицами, после чего строкой "OrpheanBeholderScryDoubt" 64 раза
+// шифруется в режиме ECB. Хеш записывается так:
+//
+//	$2b$10$<22 символа соли><31 символ хеша>
+//
+// Варианты $2a$, $2b$ и $2y$ для паролей короче 255 байт считаются
+// одинаково.
+
+// bcryptEncoding — base64 с алфавитом bcrypt, без дополнения.
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+
+const bcryptMagic = "OrpheanBeholderScryDoubt"
+
+type blowfish struct {
+	p [18]uint32
+	s [4][256]uint32
+}
+
+var (
+	blowfishInitOnce sync.Once
+	blowfishInit     blowfish
+)
+
+// initialBlowfish возвращает исходные таблицы Blowfish. По определению
+// алгоритма это шестнадцатеричные цифры дробной части π: P-массив, а за
+// ним четыре S-блока. Вместо таблицы на четыре килобайта цифры один раз
+// вычисляются по формуле Мэчина π = 16·arctg(1/5) − 4·arctg(1/239).
+func initialBlowfish() *blowfish {
+	blowfishInitOnce.Do(func() {
+		const words = 18 + 4*256
+		// 64 лишних бита поглощают ошибку округления рядов
+		bits := uint(words*32 + 64)
+		one := new(big.Int).Lsh(big.NewInt(1), bits)
+
+		pi := arctanInv(5, one)
+		pi.Mul(pi, big.NewInt(16))
+		pi.Sub(pi, new(big.Int).Mul(arctanInv(239, one), big.NewInt(4)))
+		// целая часть (3) не нужна
+		pi.Sub(pi, new(big.Int).Mul(one, big.NewInt(3)))
+
+		digits := pi.Rsh(pi, 64).FillBytes(make([]byte, words*4))
+		for i := range blowfishInit.p {
+			blowfishInit.p[i] = binary.BigEndian.Uint32(digits[i*4:])
+		}
+		digits = digits[len(blowfishInit.p)*4:]
+		for i := range blowfishInit.s {
+			for j := range blowfishInit.s[i] {
+				blowfishInit.s[i][j] = binary.BigEndian.Uint32(digits[(i*256+j)*4:])
+			}
+		}
+	})
+	return &blowfishInit
+}
+
+// arctanInv считает arctg(1/x) в фиксированной точке с единицей one:
+// 1/x − 1/(3x³) + 1/(5x⁵) − ...
+func arctanInv(x int64, one *big.Int) *big.Int {
+	sum := new(big.Int)
+	term := new(big.Int).Div(one, big.NewInt(x))
+	x2 := big.NewInt(x * x)
+	q := new(big.Int)
+	for k := int64(0); term.Sign() != 0; k++ {
+		q.Div(term, big.NewInt(2*k+1))
+		if k%2 == 0 {
+			sum.Add(sum, q)
+		} else {
+			sum.Sub(sum, q)
+		}
+		term.Div(term, x2)
+	}
+	return sum
+}
+
+func (c *blowfish) f(x uint32) uint32 {
+	return ((c.s[0][x>>24] + c.s[1][x>>16&0xff]) ^ c.s[2][x>>8&0xff]) + c.s[3][x&0xff]
+}
+
+func (c *blowfish) encrypt(l, r uint32) (uint32, uint32) {
+	l ^= c.p[0]
+	for i := 1; i < 16; i += 2 {
+		r ^= c.f(l) ^ c.p[i]
+		l ^= c.f(r) ^ c.p[i+1]
+	}
+	r ^= c.p[17]
+	return r, l
+}
+
+// nextWord берёт из data четыре байта начиная с *pos, по кругу.
+func nextWord(data []byte, pos *int) uint32 {
+	var w uint32
+	for i := 0; i < 4; i++ {
+		w = w<<8 | uint32(data[*pos])
+		*pos = (*pos + 1) % len(data)
+	}
+	return w
+}
+
+// expandKey смешивает ключ с P-массивом и заново заполняет таблицы
+// шифрованием; salt (если не nil) подмешивается в каждый блок.
+func (c *blowfish) expandKey(key, salt []byte) {
+	pos := 0
+	for i := range c.p {
+		c.p[i] ^= nextWord(key, &pos)
+	}
+	var l, r uint32
+	pos = 0
+	next := func(dst *uint32, dst2 *uint32) {
+		if salt != nil {
+			l ^= nextWord(salt, &pos)
+			r ^= nextWord(salt, &pos)
+		}
+		l, r = c.encrypt(l, r)
+		*dst, *dst2 = l, r
+	}
+	for i := 0; i < len(c.p); i += 2 {
+		next(&c.p[i], &c.p[i+1])
+	}
+	for i := range c.s {
+		for j := 0; j < 256; j += 2 {
+			next(&c.s[i][j], &c.s[i][j+1])
+		}
+	}
+}
+
+// bcryptHash считает 23 байта хеша bcrypt.
+func bcryptHash(password, salt []byte, cost int) []byte {
+	// ключ — пароль с нулевым байтом на конце, не длиннее 72 байт
+	key := append(append([]byte{}, password...), 0)
+	if len(key) > 72 {
+		key = key[:72]
+	}
+
+	c := *initialBlowfish()
+	c.expandKey(key, salt)
+	for i := uint64(0); i < 1<<uint(cost); i++ {
+		c.expandKey(key, nil)
+		c.expandKey(salt, nil)
+	}
+
+	var text [6]uint32
+	for i := range text {
+		text[i] = binary.BigEndian.Uint32([]byte(bcryptMagic[i*4:]))
+	}
+	for n := 0; n < 64; n++ {
+		for i := 0; i < len(text); i += 2 {
+			text[i], text[i+1] = c.encrypt(text[i], text[i+1])
+		}
+	}
+	out := make([]byte, 24)
+	for i, w := range text {
+		binary.BigEndian.PutUint32(out[i*4:], w)
+	}
+	// в записи хеша последний байт не используется
+	return out[:23]
+}
+
+// checkBcrypt сверяет пароль с хешем вида $2b$10$...
+func checkBcrypt(hash, password string) (bool, error) {
+	// "$2b$" + "10" + "$" + 22 + 31
+	if len(hash) != 60 || hash[0] != '$' || hash[1] != '2' || hash[3] != '$' || hash[6] != '$' {
+		return false, fmt.Errorf("некорректный bcrypt хеш")
+	}
+	switch hash[2] {
+	case 'a', 'b', 'y':
+	default:
+		return false, fmt.Errorf("неподдерживаемый вариант bcrypt $2%c$", hash[2])
+	}
+	cost, err := strconv.Atoi(hash[4:6])
+	if err != nil || cost < 4 || cost > 31 {
+		return false, fmt.Errorf("некорректная стоимость bcrypt %q", hash[4:6])
+	}
+	salt, err := bcryptEncoding.DecodeString(hash[7:29])
+	if err != nil {
+		return false, fmt.Errorf("некорректная соль bcrypt: %v", err)
+	}
+	sum := bcryptEncoding.EncodeToString(bcryptHash([]byte(password), salt, cost))
+	return subtle.ConstantTimeCompare([]byte(sum), []byte(hash[29:])) == 1, nil
+}
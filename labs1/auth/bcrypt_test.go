@@ -0,0 +1,43 @@
+package auth
+
+import "testing"
+
+// Векторы из набора тестов jBCrypt/OpenBSD; те же хеши выдаёт crypt(3).
+func TestCheckBcrypt(t *testing.T) {
+	tests := []struct {
+		password, hash string
+	}{
+		{"", "$2a$06$DCq7YPn5Rq63x1Lad4cll.TV4S6ytwfsfvkgY8jIucDrjc8deX1s."},
+		{"a", "$2a$06$m0CrhHm10qJ3lXRY.5zDGO3rS2KdeeWLuGmsfGlMfOxih58VYVfxe"},
+		{"abc", "$2a$06$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i"},
+		{"abcdefghijklmnopqrstuvwxyz", "$2a$06$.rCVZVOThsIa97pEDOxvGuRRgzG64bvtJ0938xuqzv18d3ZpQhstC"},
+		{"~!@#$%^&*()      ~!@#$%^&*()PNBFRD", "$2a$06$fPIsBO8qRqkjj273rfaOI.HtSV9jLDpTbZn782DC6/t7qT67P6FfO"},
+		// $2b$ и $2y$ отличаются от $2a$ только меткой
+		{"abc", "$2b$06$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i"},
+		{"abc", "$2y$06$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i"},
+	}
+	for _, tt := range tests {
+		ok, err := checkBcrypt(tt.hash, tt.password)
+		if err != nil || !ok {
+			t.Errorf("%q с %s = %v, %v; ожидалось совпадение", tt.password, tt.hash, ok, err)
+		}
+		if ok, _ := checkBcrypt(tt.hash, tt.password+"x"); ok {
+			t.Errorf("%q+x совпал с %s", tt.password, tt.hash)
+		}
+	}
+}
+
+func TestCheckBcryptMalformed(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"$2a$06$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0", // короче 60
+		"$2x$06$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i",
+		"$2a$03$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i",
+		"$2a$xx$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i",
+		"$2a$06$If6bvum7DFjUnE9p2uDeD!0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i",
+	} {
+		if _, err := checkBcrypt(hash, "abc"); err == nil {
+			t.Errorf("%q принят", hash)
+		}
+	}
+}
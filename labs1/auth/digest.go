@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Digest авторизация (RFC 7616). Сервер выдаёт nonce, клиент отвечает
+//
+//	response = H(HA1 ":" nonce ":" nc ":" cnonce ":" qop ":" HA2)
+//	HA1 = H(user ":" realm ":" password)   (для -sess ещё ":" nonce ":" cnonce)
+//	HA2 = H(method ":" uri)
+//
+// и пароль по сети не идёт. Поддерживаются SHA-256 и MD5, их -sess
+// варианты, qop=auth и userhash.
+
+// nonceTTL — сколько живёт nonce. Ответ со старым nonce получает 401 с
+// stale=true, и клиент повторяет запрос с новым без вопроса к
+// пользователю.
+const nonceTTL = 5 * time.Minute
+
+// digestAlgorithms в порядке предпочтения: клиент берёт первый вызов,
+// который понимает.
+var digestAlgorithms = []string{"SHA-256", "MD5"}
+
+// nonces выдаёт и проверяет nonce. Nonce — это время выдачи, случайные
+// байты и HMAC от них: хранить выданные nonce не нужно, запоминаются
+// только счётчики nc, чтобы один ответ нельзя было повторить.
+type nonces struct {
+	secret [32]byte
+
+	mu        sync.Mutex
+	counts    map[string]uint64 // nonce → последний принятый nc
+	lastSweep time.Time
+}
+
+func newNonces() *nonces {
+	n := &nonces{counts: make(map[string]uint64)}
+	if _, err := rand.Read(n.secret[:]); err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func (n *nonces) mac(b []byte) []byte {
+	m := hmac.New(sha256.New, n.secret[:])
+	m.Write(b)
+	return m.Sum(nil)[:16]
+}
+
+func (n *nonces) issue(now time.Time) string {
+	b := make([]byte, 16, 32)
+	binary.BigEndian.PutUint64(b, uint64(now.UnixNano()))
+	rand.Read(b[8:])
+	return base64.RawURLEncoding.EncodeToString(append(b, n.mac(b)...))
+}
+
+// check проверяет подпись и возраст nonce и то, что nc больше всех
+// принятых ранее с этим nonce. stale — nonce наш, но устарел.
+func (n *nonces) check(nonce string, nc uint64, now time.Time) (ok, stale bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 32 || !hmac.Equal(b[16:], n.mac(b[:16])) {
+		return false, false
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+	if now.Sub(issued) > nonceTTL {
+		return false, true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if now.Sub(n.lastSweep) > time.Minute {
+		for k := range n.counts {
+			if kb, err := base64.RawURLEncoding.DecodeString(k); err == nil &&
+				now.Sub(time.Unix(0, int64(binary.BigEndian.Uint64(kb)))) > nonceTTL {
+				delete(n.counts, k)
+			}
+		}
+		n.lastSweep = now
+	}
+	if nc <= n.counts[nonce] {
+		return false, false
+	}
+	n.counts[nonce] = nc
+	return true, false
+}
+
+// digestChallenges — заголовки WWW-Authenticate, по одному на алгоритм.
+func (a *Authenticator) digestChallenges(stale bool) []string {
+	nonce := a.nonces.issue(time.Now())
+	out := make([]string, 0, len(digestAlgorithms))
+	for _, alg := range digestAlgorithms {
+		c := fmt.Sprintf("Digest realm=%s, qop=\"auth\", algorithm=%s, nonce=\"%s\", opaque=\"%s\", charset=UTF-8, userhash=true",
+			quoteParam(a.Realm), alg, nonce, a.opaque)
+		if stale {
+			c += ", stale=true"
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// checkDigest разбирает параметры ответа Digest. При успехе возвращает
+// имя пользователя; stale — nonce устарел, пароль не проверялся.
+func (a *Authenticator) checkDigest(params, method, uri string, now time.Time) (user string, stale bool, err error) {
+	p, err := parseAuthParams(params)
+	if err != nil {
+		return "", false, err
+	}
+	alg := p["algorithm"]
+	if alg == "" {
+		alg = "MD5"
+	}
+	upper := strings.ToUpper(alg)
+	sess := strings.HasSuffix(upper, "-SESS")
+	base := strings.TrimSuffix(upper, "-SESS")
+	if base != "MD5" && base != "SHA-256" {
+		return "", false, fmt.Errorf("алгоритм %q не поддерживается", alg)
+	}
+	if p["realm"] != a.Realm {
+		return "", false, fmt.Errorf("чужой realm %q", p["realm"])
+	}
+	if p["opaque"] != a.opaque {
+		return "", false, fmt.Errorf("неверный opaque")
+	}
+	if p["qop"] != "auth" {
+		return "", false, fmt.Errorf("поддерживается только qop=auth")
+	}
+	// uri в ответе должен совпадать с целью запроса, иначе ответ от
+	// одного ресурса можно было бы предъявить к другому
+	if p["uri"] != uri {
+		return "", false, fmt.Errorf("uri %q не совпадает с запросом %q", p["uri"], uri)
+	}
+	nc, err := strconv.ParseUint(p["nc"], 16, 64)
+	if err != nil || len(p["nc"]) != 8 || p["cnonce"] == "" {
+		return "", false, fmt.Errorf("нужны nc из 8 hex-цифр и cnonce")
+	}
+
+	user = p["username"]
+	if p["userhash"] == "true" {
+		var ok bool
+		if user, ok = a.Store.userByHash(base, a.Realm, strings.ToLower(user)); !ok {
+			return "", false, fmt.Errorf("неизвестный пользователь")
+		}
+	}
+	ha1, ok := a.Store.digestHA1(user, a.Realm, base)
+	if !ok {
+		return "", false, fmt.Errorf("нет Digest учётной записи %s для %q", base, user)
+	}
+	want := digestResponse(base, sess, ha1, method, uri, p)
+	if subtle.ConstantTimeCompare([]byte(want), []byte(strings.ToLower(p["response"]))) != 1 {
+		return "", false, fmt.Errorf("неверный пароль пользователя %q", user)
+	}
+	// nonce проверяется после пароля: неверный ответ не должен сжигать
+	// значение nc
+	ok, stale = a.nonces.check(p["nonce"], nc, now)
+	if stale {
+		return "", true, nil
+	}
+	if !ok {
+		return "", false, fmt.Errorf("недействительный или повторный nonce")
+	}
+	return user, false, nil
+}
+
+// digestResponse — ожидаемое значение response для HA1 пользователя и
+// параметров ответа p; base — алгоритм без суффикса -sess.
+func digestResponse(base string, sess bool, ha1, method, uri string, p map[string]string) string {
+	if sess {
+		ha1 = digestHash(base, ha1+":"+p["nonce"]+":"+p["cnonce"])
+	}
+	ha2 := digestHash(base, method+":"+uri)
+	return digestHash(base, strings.Join([]string{ha1, p["nonce"], p["nc"], p["cnonce"], p["qop"], ha2}, ":"))
+}
+
+// parseAuthParams разбирает список `имя=токен` и `имя="строка"` через
+// запятую (RFC 9110 11.2). Имена приводятся к нижнему регистру.
+func parseAuthParams(s string) (map[string]string, error) {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params, nil
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("некорректный параметр в %q", s)
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var ok bool
+			if value, s, ok = readQuoted(s); !ok {
+				return nil, fmt.Errorf("незакрытая кавычка в параметре %q", name)
+			}
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = strings.TrimSpace(s[:end]), s[end:]
+		}
+		if _, dup := params[name]; dup {
+			return nil, fmt.Errorf("параметр %q повторяется", name)
+		}
+		params[name] = value
+		s = strings.TrimLeft(s, " \t")
+		if s != "" && s[0] != ',' {
+			return nil, fmt.Errorf("ожидалась ',' перед %q", s)
+		}
+	}
+}
+
+// readQuoted читает quoted-string с начала s.
+func readQuoted(s string) (value, rest string, ok bool) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], true
+		case '\\':
+			if i+1 == len(s) {
+				return "", "", false
+			}
+			i++
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", false
+}
+
+// quoteParam заключает значение в кавычки, экранируя " и \.
+func quoteParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
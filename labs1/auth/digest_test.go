@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Пример из RFC 7616 3.9.1: пользователь Mufasa с паролем
+// "Circle of Life" запрашивает GET /dir/index.html.
+func TestDigestResponseRFC7616(t *testing.T) {
+	const params = `username="Mufasa", realm="http-auth@example.org", uri="/dir/index.html", ` +
+		`algorithm=%s, nonce="7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", nc=00000001, ` +
+		`cnonce="f2/wE4q74E6zIJEtWaHKaf5wv/H5QzzpXusqGemxURZJ", qop=auth, response="%s", ` +
+		`opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+	store := NewStore()
+	store.Add("Mufasa", "Circle of Life")
+	for _, tt := range []struct{ alg, response string }{
+		{"MD5", "8ca523f5e9506fed4657c9700eebdbec"},
+		{"SHA-256", "753927fa0e85d155564e2e272a28d1802ca10daf4496794697cf8db5856cb6c1"},
+	} {
+		p, err := parseAuthParams(fmt.Sprintf(params, tt.alg, tt.response))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.alg, err)
+		}
+		ha1, ok := store.digestHA1(p["username"], p["realm"], tt.alg)
+		if !ok {
+			t.Fatalf("%s: нет HA1", tt.alg)
+		}
+		if got := digestResponse(tt.alg, false, ha1, "GET", p["uri"], p); got != p["response"] {
+			t.Errorf("%s: response %s; ожидалось %s", tt.alg, got, p["response"])
+		}
+	}
+}
+
+// checkDigest целиком: верный ответ принимается один раз, повтор с тем
+// же nc, чужой uri и старый nonce отклоняются.
+func TestCheckDigest(t *testing.T) {
+	store := NewStore()
+	store.Add("Mufasa", "Circle of Life")
+	a := New("http-auth@example.org", store, nil)
+	now := time.Now()
+	nonce := a.nonces.issue(now)
+
+	answer := func(alg, user, nc, uri, password string, userhash bool) string {
+		p := map[string]string{"nonce": nonce, "nc": nc, "cnonce": "0a4f113b", "qop": "auth"}
+		base := strings.TrimSuffix(alg, "-sess")
+		ha1 := digestHash(base, user+":"+a.Realm+":"+password)
+		if userhash {
+			user = digestHash(base, user+":"+a.Realm)
+		}
+		return fmt.Sprintf(`username="%s", realm="%s", uri="%s", algorithm=%s, nonce="%s", nc=%s, cnonce="0a4f113b", qop=auth, response="%s", opaque="%s", userhash=%v`,
+			user, a.Realm, uri, alg, nonce, nc, digestResponse(base, base != alg, ha1, "GET", uri, p), a.opaque, userhash)
+	}
+
+	tests := []struct {
+		name   string
+		params string
+		uri    string
+		at     time.Time
+		ok     bool
+		stale  bool
+	}{
+		{"SHA-256", answer("SHA-256", "Mufasa", "00000001", "/dir/index.html", "Circle of Life", false), "/dir/index.html", now, true, false},
+		{"повтор nc", answer("SHA-256", "Mufasa", "00000001", "/dir/index.html", "Circle of Life", false), "/dir/index.html", now, false, false},
+		{"MD5-sess", answer("MD5-sess", "Mufasa", "00000002", "/dir/index.html", "Circle of Life", false), "/dir/index.html", now, true, false},
+		{"userhash", answer("SHA-256", "Mufasa", "00000003", "/", "Circle of Life", true), "/", now, true, false},
+		{"неверный пароль", answer("SHA-256", "Mufasa", "00000004", "/", "circle of life", false), "/", now, false, false},
+		{"чужой uri", answer("SHA-256", "Mufasa", "00000005", "/a", "Circle of Life", false), "/b", now, false, false},
+		{"старый nonce", answer("SHA-256", "Mufasa", "00000006", "/", "Circle of Life", false), "/", now.Add(nonceTTL + time.Second), false, true},
+	}
+	for _, tt := range tests {
+		user, stale, err := a.checkDigest(tt.params, "GET", tt.uri, tt.at)
+		if ok := err == nil && !stale && user == "Mufasa"; ok != tt.ok || stale != tt.stale {
+			t.Errorf("%s: пользователь %q, stale %v, ошибка %v", tt.name, user, stale, err)
+		}
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	p, err := parseAuthParams(`Username="a\"b", realm=r ,qop="auth, auth-int",, nc=01`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"username": `a"b`, "realm": "r", "qop": "auth, auth-int", "nc": "01"}
+	if fmt.Sprint(p) != fmt.Sprint(want) {
+		t.Errorf("параметры %v; ожидалось %v", p, want)
+	}
+	for _, bad := range []string{`a="открыта`, `=1`, `a=1, a=2`, `a="1"x`} {
+		if _, err := parseAuthParams(bad); err == nil {
+			t.Errorf("%q принят", bad)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"strings"
+)
+
+// Store — учётные записи пользователей. Файл в духе htpasswd/htdigest
+// Apache, по записи в строке:
+//
+//	alice:$2y$10$...                   bcrypt (htpasswd -B)
+//	bob:$5$соль$...                    SHA-256 crypt (htpasswd -2)
+//	carol:labs1:5f4dcc3b5aa765d61d8... htdigest: H(user:realm:password),
+//	                                   32 hex-цифры — MD5, 64 — SHA-256
+//
+// Строки с # и пустые пропускаются. У пользователя может быть несколько
+// строк: хеш для Basic и HA1 для Digest. Digest возможен только с
+// записью htdigest (или паролем, заданным открыто через Add) — из
+// bcrypt и SHA-crypt нужный ему хеш не получить.
+type Store struct {
+	users map[string]*account
+}
+
+type account struct {
+	hashes []string          // bcrypt и SHA-crypt
+	plain  *string           // пароль из командной строки
+	ha1    map[string][]byte // "realm algorithm" → H(user:realm:password)
+}
+
+// NewStore возвращает пустое хранилище.
+func NewStore() *Store {
+	return &Store{users: make(map[string]*account)}
+}
+
+// LoadFile читает файл учётных записей.
+func LoadFile(path string) (*Store, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := NewStore()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := s.addLine(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) addLine(line string) error {
+	user, rest, ok := strings.Cut(line, ":")
+	if !ok || user == "" || rest == "" {
+		return fmt.Errorf("ожидается user:hash или user:realm:HA1")
+	}
+	acc := s.account(user)
+	if strings.HasPrefix(rest, "$2") || strings.HasPrefix(rest, "$5$") {
+		acc.hashes = append(acc.hashes, rest)
+		return nil
+	}
+	if strings.HasPrefix(rest, "$") || strings.HasPrefix(rest, "{") {
+		return fmt.Errorf("пользователь %s: поддерживаются только bcrypt ($2y$) и SHA-256 ($5$)", user)
+	}
+	realm, ha1hex, ok := strings.Cut(rest, ":")
+	if !ok {
+		return fmt.Errorf("пользователь %s: пароль открытым текстом в файле не поддерживается", user)
+	}
+	ha1, err := hex.DecodeString(ha1hex)
+	if err != nil {
+		return fmt.Errorf("пользователь %s: HA1 должен быть hex: %v", user, err)
+	}
+	var alg string
+	switch len(ha1) {
+	case md5.Size:
+		alg = "MD5"
+	case sha256.Size:
+		alg = "SHA-256"
+	default:
+		return fmt.Errorf("пользователь %s: HA1 длиной %d байт — ни MD5, ни SHA-256", user, len(ha1))
+	}
+	if acc.ha1 == nil {
+		acc.ha1 = make(map[string][]byte)
+	}
+	acc.ha1[realm+" "+alg] = ha1
+	return nil
+}
+
+// Add добавляет пользователя с паролем в открытом виде (флаг -auth).
+func (s *Store) Add(user, password string) {
+	s.account(user).plain = &password
+}
+
+// Len — число пользователей.
+func (s *Store) Len() int {
+	return len(s.users)
+}
+
+func (s *Store) account(user string) *account {
+	acc := s.users[user]
+	if acc == nil {
+		acc = &account{}
+		s.users[user] = acc
+	}
+	return acc
+}
+
+// checkPassword проверяет пароль из Basic авторизации по любой записи
+// пользователя.
+func (s *Store) checkPassword(user, realm, password string) bool {
+	acc := s.users[user]
+	if acc == nil {
+		return false
+	}
+	if acc.plain != nil && subtle.ConstantTimeCompare([]byte(*acc.plain), []byte(password)) == 1 {
+		return true
+	}
+	for _, h := range acc.hashes {
+		var ok bool
+		if strings.HasPrefix(h, "$5$") {
+			ok, _ = checkSHACrypt(h, password)
+		} else {
+			ok, _ = checkBcrypt(h, password)
+		}
+		if ok {
+			return true
+		}
+	}
+	for _, alg := range []string{"SHA-256", "MD5"} {
+		if want := acc.ha1[realm+" "+alg]; want != nil {
+			got := digestHash(alg, user+":"+realm+":"+password)
+			if subtle.ConstantTimeCompare([]byte(got), []byte(hex.EncodeToString(want))) == 1 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// digestHA1 возвращает H(user:realm:password) в hex для алгоритма Digest
+// ("MD5" или "SHA-256").
+func (s *Store) digestHA1(user, realm, alg string) (string, bool) {
+	acc := s.users[user]
+	if acc == nil {
+		return "", false
+	}
+	if ha1 := acc.ha1[realm+" "+alg]; ha1 != nil {
+		return hex.EncodeToString(ha1), true
+	}
+	if acc.plain != nil {
+		return digestHash(alg, user+":"+realm+":"+*acc.plain), true
+	}
+	return "", false
+}
+
+// userByHash находит пользователя по H(user:realm) — так имя передаётся
+// при userhash=true (RFC 7616 3.4.4).
+func (s *Store) userByHash(alg, realm, userhash string) (string, bool) {
+	for user := range s.users {
+		if subtle.ConstantTimeCompare([]byte(digestHash(alg, user+":"+realm)), []byte(userhash)) == 1 {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+// digestHash — H(data) алгоритма Digest в нижнем регистре hex.
+func digestHash(alg, data string) string {
+	var h hash.Hash
+	if alg == "MD5" {
+		h = md5.New()
+	} else {
+		h = sha256.New()
+	}
+	h.Write([]byte(data))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// Lockout блокирует клиента (по IP) после MaxFailures неудачных попыток
+// входа за Window: следующие Duration его запросы к защищённым
+// маршрутам получают 429 без проверки пароля. Удачный вход сбрасывает
+// счётчик. Nil Lockout ничего не блокирует.
+type Lockout struct {
+	MaxFailures int
+	Window      time.Duration
+	Duration    time.Duration
+
+	mu        sync.Mutex
+	clients   map[string]*lockState
+	lastSweep time.Time
+}
+
+type lockState struct {
+	first    time.Time // начало окна подсчёта неудач
+	failures int
+	until    time.Time // блокировка действует до этого момента
+}
+
+// locked возвращает, сколько ещё длится блокировка клиента.
+func (l *Lockout) locked(client string, now time.Time) time.Duration {
+	if l == nil || l.MaxFailures <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if st := l.clients[client]; st != nil && now.Before(st.until) {
+		return st.until.Sub(now)
+	}
+	return 0
+}
+
+// fail учитывает неудачную попытку и возвращает true, если клиент
+// только что заблокирован.
+func (l *Lockout) fail(client string, now time.Time) bool {
+	if l == nil || l.MaxFailures <= 0 {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.clients == nil {
+		l.clients = make(map[string]*lockState)
+	}
+	if now.Sub(l.lastSweep) > time.Minute {
+		// забытые клиенты не должны копиться вечно
+		for k, st := range l.clients {
+			if now.Sub(st.first) > l.Window && now.After(st.until) {
+				delete(l.clients, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	st := l.clients[client]
+	if st == nil || now.Sub(st.first) > l.Window {
+		st = &lockState{first: now}
+		l.clients[client] = st
+	}
+	st.failures++
+	if st.failures < l.MaxFailures {
+		return false
+	}
+	st.failures = 0
+	st.first = now
+	st.until = now.Add(l.Duration)
+	return true
+}
+
+// succeed сбрасывает счётчик неудач клиента.
+func (l *Lockout) succeed(client string) {
+	if l == nil || l.MaxFailures <= 0 {
+		return
+	}
+	l.mu.Lock()
+	delete(l.clients, client)
+	l.mu.Unlock()
+}
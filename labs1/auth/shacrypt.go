@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SHA-crypt (Drepper, "Unix crypt using SHA-256 and SHA-512") — формат
+// $5$, который выдают `htpasswd -2`, `openssl passwd -5` и crypt(3):
+//
+//	$5$[rounds=N$]соль$хеш
+//
+// Соль — до 16 символов, по умолчанию 5000 раундов.
+const (
+	shaCryptDefaultRounds = 5000
+	shaCryptMinRounds     = 1000
+	shaCryptMaxRounds     = 999999999
+	shaCryptAlphabet      = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// checkSHACrypt сверяет пароль с хешем вида $5$соль$хеш.
+func checkSHACrypt(hash, password string) (bool, error) {
+	rest := strings.TrimPrefix(hash, "$5$")
+	rounds, custom := shaCryptDefaultRounds, false
+	if r, ok := strings.CutPrefix(rest, "rounds="); ok {
+		n, tail, found := strings.Cut(r, "$")
+		v, err := strconv.Atoi(n)
+		if !found || err != nil {
+			return false, fmt.Errorf("некорректное число раундов в SHA-crypt хеше")
+		}
+		rounds, custom, rest = min(max(v, shaCryptMinRounds), shaCryptMaxRounds), true, tail
+	}
+	salt, _, ok := strings.Cut(rest, "$")
+	if !ok {
+		return false, fmt.Errorf("некорректный SHA-crypt хеш")
+	}
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	want := shaCrypt([]byte(password), []byte(salt), rounds, custom)
+	return subtle.ConstantTimeCompare([]byte(want), []byte(hash)) == 1, nil
+}
+
+// shaCrypt считает хеш $5$ целиком, вместе с префиксом и солью.
+func shaCrypt(password, salt []byte, rounds int, custom bool) string {
+	// B = H(пароль соль пароль)
+	h := sha256.New()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(password)
+	b := h.Sum(nil)
+
+	// A = H(пароль соль, затем B на длину пароля, затем по битам длины
+	// пароля: B за единицу, пароль за ноль)
+	h.Reset()
+	h.Write(password)
+	h.Write(salt)
+	h.Write(repeatTo(b, len(password)))
+	for n := len(password); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(password)
+		}
+	}
+	a := h.Sum(nil)
+
+	// P — H(пароль, повторённый столько раз, сколько в нём байт)
+	h.Reset()
+	for range password {
+		h.Write(password)
+	}
+	p := repeatTo(h.Sum(nil), len(password))
+
+	// S — H(соль, повторённая 16 + A[0] раз)
+	h.Reset()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(salt)
+	}
+	s := repeatTo(h.Sum(nil), len(salt))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h.Reset()
+		if i%2 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i%2 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString("$5$")
+	if custom {
+		fmt.Fprintf(&out, "rounds=%d$", rounds)
+	}
+	out.Write(salt)
+	out.WriteByte('$')
+	// байты хеша перемешаны по тройкам, как в описании алгоритма
+	order := [][3]int{
+		{0, 10, 20}, {21, 1, 11}, {12, 22, 2}, {3, 13, 23}, {24, 4, 14},
+		{15, 25, 5}, {6, 16, 26}, {27, 7, 17}, {18, 28, 8}, {9, 19, 29},
+	}
+	for _, o := range order {
+		cryptBase64(&out, uint(c[o[0]])<<16|uint(c[o[1]])<<8|uint(c[o[2]]), 4)
+	}
+	cryptBase64(&out, uint(c[31])<<8|uint(c[30]), 3)
+	return out.String()
+}
+
+// repeatTo повторяет src, пока не наберётся n байт.
+func repeatTo(src []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, src[:min(len(src), n-len(out))]...)
+	}
+	return out
+}
+
+// cryptBase64 записывает n символов из младших битов w.
+func cryptBase64(out *strings.Builder, w uint, n int) {
+	for ; n > 0; n-- {
+		out.WriteByte(shaCryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+}
@@ -0,0 +1,40 @@
+package auth
+
+import "testing"
+
+// Векторы из "Unix crypt using SHA-256 and SHA-512" (Drepper). Соль
+// длиннее 16 символов обрезается, число раундов меньше 1000
+// поднимается до 1000 — в записи хеша уже итоговые значения.
+func TestCheckSHACrypt(t *testing.T) {
+	tests := []struct {
+		password, hash string
+	}{
+		{"Hello world!", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+		{"Hello world!", "$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+		{"This is just a test", "$5$rounds=5000$toolongsaltstrin$Un/5jzAHMgOGZ5.mWJpuVolil07guHPvOW8mGRcvxa5"},
+		{"we have a short salt string but not a short password", "$5$rounds=77777$short$JiO1O3ZpDAxGJeaDIuqCoEFysAe1mZNJRs3pw0KQRd/"},
+		{"the minimum number is still observed", "$5$rounds=1000$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC"},
+	}
+	for _, tt := range tests {
+		ok, err := checkSHACrypt(tt.hash, tt.password)
+		if err != nil || !ok {
+			t.Errorf("%q с %s = %v, %v; ожидалось совпадение", tt.password, tt.hash, ok, err)
+		}
+		if ok, _ := checkSHACrypt(tt.hash, tt.password+"x"); ok {
+			t.Errorf("%q+x совпал с %s", tt.password, tt.hash)
+		}
+	}
+}
+
+func TestCheckSHACryptMalformed(t *testing.T) {
+	for _, hash := range []string{
+		"$5$",
+		"$5$saltstring",
+		"$5$rounds=abc$salt$hash",
+		"$5$rounds=5000",
+	} {
+		if _, err := checkSHACrypt(hash, "x"); err == nil {
+			t.Errorf("%q принят", hash)
+		}
+	}
+}
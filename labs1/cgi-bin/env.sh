@@ -0,0 +1,12 @@
+#!/bin/sh
+# Печатает мета-переменные CGI и тело запроса.
+echo "Content-Type: text/plain; charset=utf-8"
+echo
+echo "CGI/1.1 окружение:"
+env | grep -E '^(GATEWAY_INTERFACE|SERVER_|REQUEST_|SCRIPT_|PATH_INFO|PATH_TRANSLATED|QUERY_STRING|REMOTE_|CONTENT_|HTTPS|AUTH_TYPE|DOCUMENT_ROOT|HTTP_)' | sort
+if [ -n "$CONTENT_LENGTH" ]; then
+	echo
+	echo "Тело ($CONTENT_LENGTH байт):"
+	head -c "$CONTENT_LENGTH"
+	echo
+fi
@@ -0,0 +1,11 @@
+#!/bin/sh
+# NPH-скрипт: сам пишет статусную строку и заголовки, сервер передаёт
+# вывод клиенту без разбора. Пять строк с интервалом в секунду.
+printf 'HTTP/1.1 200 OK\r\n'
+printf 'Content-Type: text/plain\r\n'
+printf 'Connection: close\r\n'
+printf '\r\n'
+for i in 1 2 3 4 5; do
+	date
+	sleep 1
+done
@@ -0,0 +1,9 @@
+#!/bin/sh
+# ?local — локальное перенаправление (сервер сам отдаёт /hello/cgi),
+# иначе — перенаправление клиента на /static/index.html.
+if [ "$QUERY_STRING" = "local" ]; then
+	echo "Location: /hello/cgi"
+else
+	echo "Location: http://$HTTP_HOST/static/index.html"
+fi
+echo
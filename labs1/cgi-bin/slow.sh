@@ -0,0 +1,6 @@
+#!/bin/sh
+# Работает дольше -cgi-timeout по умолчанию: сервер должен ответить 504.
+sleep 60
+echo "Content-Type: text/plain"
+echo
+echo "слишком поздно"
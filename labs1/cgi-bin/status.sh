@@ -0,0 +1,7 @@
+#!/bin/sh
+# Ответ с кодом из QUERY_STRING (по умолчанию 418) через поле Status.
+code=${QUERY_STRING:-418}
+echo "Status: $code Custom"
+echo "Content-Type: text/plain"
+echo
+echo "status $code"
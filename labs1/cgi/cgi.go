@@ -0,0 +1,460 @@
+// Package cgi запускает внешние программы по CGI/1.1 (RFC 3875) для raw
+// сервера: запрос передаётся через переменные окружения и stdin, ответ
+// скрипт печатает в stdout — сначала поля заголовка, затем тело.
+package cgi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"labs1/httpraw"
+)
+
+// Handler выполняет скрипты из Dir для запросов под префиксом Root.
+// Путь /cgi-bin/dir/script.sh/extra/path даёт SCRIPT_NAME
+// /cgi-bin/dir/script.sh и PATH_INFO /extra/path: скриптом считается
+// первый обычный файл на пути.
+type Handler struct {
+	Root string // префикс URL, например "/cgi-bin/"
+	Dir  string // каталог со скриптами
+
+	// DocumentRoot нужен для PATH_TRANSLATED.
+	DocumentRoot string
+	// Timeout ограничивает работу скрипта целиком; по истечении процесс
+	// убивается. 0 — 30 секунд.
+	Timeout time.Duration
+	// Env добавляется к окружению скрипта ("NAME=value").
+	Env []string
+	// LocalRedirect обслуживает локальное перенаправление (Location: /path
+	// без Status, RFC 3875 6.2.2). Это должна быть та же цепочка, что и
+	// у исходного запроса, — с авторизацией и ограничением частоты, —
+	// иначе скрипт открыл бы закрытые ими пути. nil — пути под Root
+	// обслуживаются напрямую, остальные превращаются в 302.
+	LocalRedirect httpraw.Handler
+	Logf          func(format string, args ...any)
+}
+
+const (
+	defaultTimeout = 30 * time.Second
+	// maxLocalRedirects защищает от скрипта, перенаправляющего сам на себя.
+	maxLocalRedirects = 10
+)
+
+func (h *Handler) logf(format string, args ...any) {
+	if h.Logf != nil {
+		h.Logf(format, args...)
+	}
+}
+
+func (h *Handler) ServeHTTP(w httpraw.ResponseWriter, req *httpraw.Request) {
+	root := "/" + strings.Trim(h.Root, "/")
+	rel, ok := strings.CutPrefix(req.Path, root)
+	if !ok || rel != "" && rel[0] != '/' {
+		httpraw.Error(w, "404 Not Found", 404)
+		return
+	}
+	script, pathInfo, code := h.lookup(rel)
+	if code != 0 {
+		httpraw.Error(w, fmt.Sprintf("%d %s", code, httpraw.StatusText(code)), code)
+		return
+	}
+	scriptName := root + filepath.ToSlash(strings.TrimPrefix(script, filepath.Clean(h.Dir)))
+	// относительный путь exec считал бы от cmd.Dir
+	if abs, err := filepath.Abs(script); err == nil {
+		script = abs
+	}
+
+	// CONTENT_LENGTH обязателен, если есть тело (RFC 3875 4.1.2), поэтому
+	// chunked тело приходится прочитать заранее; его размер уже ограничен
+	// сервером.
+	var stdin io.Reader
+	var contentLength int64
+	switch {
+	case req.ContentLength > 0:
+		stdin = io.LimitReader(req.Body, req.ContentLength)
+		contentLength = req.ContentLength
+	case req.ContentLength < 0:
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		stdin = bytes.NewReader(body)
+		contentLength = int64(len(body))
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Dir = filepath.Dir(script)
+	cmd.Env = h.env(req, script, scriptName, pathInfo, contentLength)
+	cmd.Stdin = stdin
+	stderr := &lineLogger{prefix: "CGI " + scriptName + ": ", logf: h.logf}
+	cmd.Stderr = stderr
+	killGroup(cmd)
+	// после убийства по таймауту не ждать бесконечно потомков скрипта,
+	// унаследовавших stdout
+	cmd.WaitDelay = time.Second
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := cmd.Start(); err != nil {
+		h.logf("CGI %s: запуск: %v", scriptName, err)
+		httpraw.Error(w, "500 Internal Server Error", 500)
+		return
+	}
+	start := time.Now()
+	defer func() {
+		// stdout дочитывается, чтобы скрипт не завис на записи в pipe
+		io.Copy(io.Discard, stdout)
+		err := cmd.Wait()
+		stderr.flush()
+		switch {
+		case ctx.Err() == context.DeadlineExceeded:
+			h.logf("CGI %s: прерван по таймауту %v", scriptName, timeout)
+		case err != nil:
+			h.logf("CGI %s: %v", scriptName, err)
+		}
+		h.logf("CGI %s: выполнен за %v", scriptName, time.Since(start).Round(time.Millisecond))
+	}()
+
+	p := httpraw.NewParser(stdout, nil)
+	if strings.HasPrefix(filepath.Base(script), "nph-") {
+		h.serveNPH(ctx, w, req, p, scriptName)
+		return
+	}
+
+	header, err := p.ReadHeader()
+	if err != nil {
+		h.failHeader(ctx, w, scriptName, err)
+		return
+	}
+	status := 200
+	statusField := header.Get("Status")
+	if statusField != "" {
+		code, _, _ := strings.Cut(statusField, " ")
+		status, err = strconv.Atoi(code)
+		if err != nil || status < 100 || status > 999 {
+			h.logf("CGI %s: некорректный Status %q", scriptName, statusField)
+			httpraw.Error(w, "502 Bad Gateway", 502)
+			return
+		}
+		header.Del("Status")
+	}
+
+	location := header.Get("Location")
+	switch {
+	case strings.HasPrefix(location, "/") && statusField == "":
+		// локальное перенаправление: тело скрипта не нужно, сервер сам
+		// обслуживает новый путь методом GET
+		h.localRedirect(w, req, location)
+		return
+	case location != "" && statusField == "":
+		// перенаправление клиента
+		status = 302
+	case location == "" && header.Get("Content-Type") == "":
+		h.logf("CGI %s: нет Content-Type в ответе", scriptName)
+		httpraw.Error(w, "502 Bad Gateway", 502)
+		return
+	}
+
+	copyHeader(w.Header(), header)
+	w.WriteHeader(status)
+	copyBody(w, p.Reader())
+}
+
+// lookup ищет скрипт по пути rel внутри Dir. Возвращает путь к файлу,
+// PATH_INFO и код ошибки, если скрипт не найден или его нельзя запускать.
+func (h *Handler) lookup(rel string) (script, pathInfo string, code int) {
+	segments := strings.Split(strings.TrimPrefix(rel, "/"), "/")
+	path := filepath.Clean(h.Dir)
+	for i, seg := range segments {
+		// скрытые файлы, "." и ".." в имени скрипта не допускаются
+		if seg == "" || strings.HasPrefix(seg, ".") || strings.ContainsAny(seg, `\`+"\x00") {
+			return "", "", 404
+		}
+		path = filepath.Join(path, seg)
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", "", 404
+		}
+		if info.IsDir() {
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			return "", "", 403
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm()&0o111 == 0 {
+			return "", "", 403
+		}
+		if rest := segments[i+1:]; len(rest) > 0 {
+			pathInfo = "/" + strings.Join(rest, "/")
+		}
+		return path, pathInfo, 0
+	}
+	// путь кончился каталогом: листинг cgi-bin не показываем
+	return "", "", 403
+}
+
+// env собирает мета-переменные RFC 3875 4.1 и поля запроса HTTP_*.
+func (h *Handler) env(req *httpraw.Request, script, scriptName, pathInfo string, contentLength int64) []string {
+	serverName, serverPort, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		serverName = req.Host
+		serverPort = "80"
+		if req.TLS != nil {
+			serverPort = "443"
+		}
+	}
+	remoteAddr, remotePort, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		remoteAddr = req.RemoteAddr
+	}
+	env := []string{
+		"GATEWAY_INTERFACE=CGI/1.1",
+		"SERVER_SOFTWARE=labs1-httpraw",
+		"SERVER_PROTOCOL=" + req.Proto,
+		"SERVER_NAME=" + serverName,
+		"SERVER_PORT=" + serverPort,
+		"REQUEST_METHOD=" + req.Method,
+		"REQUEST_URI=" + req.RequestURI,
+		"SCRIPT_NAME=" + scriptName,
+		"SCRIPT_FILENAME=" + script,
+		"PATH_INFO=" + pathInfo,
+		"QUERY_STRING=" + req.RawQuery,
+		"REMOTE_ADDR=" + remoteAddr,
+		"REMOTE_HOST=" + remoteAddr,
+		"REMOTE_PORT=" + remotePort,
+		// php-cgi без этого отказывается работать
+		"REDIRECT_STATUS=200",
+	}
+	if h.DocumentRoot != "" {
+		docRoot, _ := filepath.Abs(h.DocumentRoot)
+		env = append(env, "DOCUMENT_ROOT="+docRoot)
+		if pathInfo != "" {
+			env = append(env, "PATH_TRANSLATED="+filepath.Join(docRoot, filepath.FromSlash(pathInfo)))
+		}
+	}
+	if req.ContentLength != 0 {
+		env = append(env, "CONTENT_LENGTH="+strconv.FormatInt(contentLength, 10))
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		env = append(env, "CONTENT_TYPE="+ct)
+	}
+	if req.TLS != nil {
+		env = append(env, "HTTPS=on")
+	}
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		scheme, _, _ := strings.Cut(auth, " ")
+		env = append(env, "AUTH_TYPE="+scheme)
+	}
+
+	for name, values := range req.Header {
+		// X_Forwarded_For дал бы то же HTTP_X_FORWARDED_FOR, что и
+		// X-Forwarded-For, и подменил бы его; Apache и nginx такие
+		// заголовки тоже отбрасывают
+		if strings.Contains(name, "_") {
+			continue
+		}
+		switch name {
+		case "Content-Length", "Content-Type":
+			// уже переданы как CONTENT_*
+			continue
+		case "Transfer-Encoding", "Connection":
+			// тело скрипт получает уже без chunked кадрирования
+			continue
+		case "Authorization":
+			// RFC 3875 4.1.18: пароли скрипту не передаются
+			continue
+		case "Proxy":
+			// HTTP_PROXY скрипт принял бы за настройку прокси (httpoxy)
+			continue
+		}
+		sep := ", "
+		if name == "Cookie" {
+			sep = "; "
+		}
+		key := "HTTP_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		env = append(env, key+"="+strings.Join(values, sep))
+	}
+
+	for _, name := range []string{"PATH", "LD_LIBRARY_PATH", "TZ", "LANG", "SystemRoot", "COMSPEC", "PATHEXT", "WINDIR"} {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	return append(env, h.Env...)
+}
+
+// localRedirect обслуживает новый путь вместо ответа скрипта. Метод
+// становится GET, тело запроса отбрасывается.
+func (h *Handler) localRedirect(w httpraw.ResponseWriter, req *httpraw.Request, location string) {
+	u, err := url.Parse(location)
+	if err != nil {
+		httpraw.Error(w, "502 Bad Gateway", 502)
+		return
+	}
+	next := *req
+	next.Method = "GET"
+	next.RequestURI = location
+	next.Path = u.Path
+	next.RawQuery = u.RawQuery
+	next.Header = req.Header.Clone()
+	next.Header.Del("Content-Length")
+	next.Header.Del("Content-Type")
+	next.Body = strings.NewReader("")
+	next.ContentLength = 0
+	next.Chunked = false
+	next.Form, next.PostForm = nil, nil
+	next.Pattern, next.Params = "", nil
+	// счётчик едет в самом запросе: цепочка LocalRedirect может
+	// скопировать запрос или привести его в другой экземпляр Handler
+	next.Redirects = req.Redirects + 1
+
+	root := "/" + strings.Trim(h.Root, "/")
+	local := h.LocalRedirect != nil || next.Path == root || strings.HasPrefix(next.Path, root+"/")
+	if local && req.Redirects >= maxLocalRedirects {
+		h.logf("CGI: больше %d локальных перенаправлений, последнее на %s", maxLocalRedirects, location)
+		httpraw.Error(w, "500 Internal Server Error", 500)
+		return
+	}
+	switch {
+	case h.LocalRedirect != nil:
+		h.LocalRedirect.ServeHTTP(w, &next)
+	case local:
+		h.ServeHTTP(w, &next)
+	default:
+		w.Header().Set("Location", location)
+		w.WriteHeader(302)
+	}
+}
+
+// serveNPH передаёт вывод скрипта с "nph-" в имени (non-parsed header)
+// клиенту как есть: скрипт сам пишет статусную строку и заголовки. Для
+// HTTP/2 и там, где соединение забрать нельзя, ответ разбирается и
+// пересобирается.
+func (h *Handler) serveNPH(ctx context.Context, w httpraw.ResponseWriter, req *httpraw.Request, p *httpraw.Parser, scriptName string) {
+	if hj, ok := w.(httpraw.Hijacker); ok && req.ProtoMajor == 1 {
+		conn, rw, err := hj.Hijack()
+		if err == nil {
+			// границу ответа знает только скрипт, поэтому соединение
+			// закрывается после него
+			rw.Flush()
+			io.Copy(conn, p.Reader())
+			return
+		}
+	}
+
+	resp, err := p.ReadResponse(req.Method)
+	if err != nil {
+		h.failHeader(ctx, w, scriptName, err)
+		return
+	}
+	copyHeader(w.Header(), resp.Header)
+	w.WriteHeader(resp.Status)
+	copyBody(w, resp.Body)
+}
+
+// failHeader отвечает на скрипт, не приславший заголовки: 504, если он
+// не успел до таймаута, иначе 502.
+func (h *Handler) failHeader(ctx context.Context, w httpraw.ResponseWriter, scriptName string, err error) {
+	if ctx.Err() == context.DeadlineExceeded {
+		httpraw.Error(w, "504 Gateway Timeout", 504)
+		return
+	}
+	h.logf("CGI %s: некорректный ответ: %v", scriptName, err)
+	httpraw.Error(w, "502 Bad Gateway", 502)
+}
+
+// copyHeader переносит поля ответа скрипта, кроме тех, что описывают
+// соединение: кадрирование тела выбирает сервер.
+func copyHeader(dst, src httpraw.Header) {
+	for name, values := range src {
+		switch name {
+		case "Connection", "Keep-Alive", "Transfer-Encoding", "Upgrade", "Trailer":
+			continue
+		}
+		for _, v := range values {
+			dst.Add(name, v)
+		}
+	}
+}
+
+// copyBody отдаёт тело по мере вывода скрипта.
+func copyBody(w httpraw.ResponseWriter, body io.Reader) {
+	flusher, _ := w.(httpraw.Flusher)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeError(w httpraw.ResponseWriter, err error) {
+	code := httpraw.StatusCode(err)
+	if code == 0 {
+		code = 500
+	}
+	w.Header().Set("Connection", "close")
+	httpraw.Error(w, err.Error(), code)
+}
+
+// lineLogger пишет stderr скрипта в журнал построчно.
+type lineLogger struct {
+	prefix string
+	logf   func(format string, args ...any)
+	buf    []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.logf("%s%s", l.prefix, strings.TrimRight(string(l.buf[:i]), "\r"))
+		l.buf = l.buf[i+1:]
+	}
+	if len(l.buf) > 4<<10 {
+		// строка без конца не должна расти бесконечно
+		l.flush()
+	}
+	return len(p), nil
+}
+
+// flush выводит недописанную строку.
+func (l *lineLogger) flush() {
+	if len(l.buf) > 0 {
+		l.logf("%s%s", l.prefix, l.buf)
+		l.buf = l.buf[:0]
+	}
+}
@@ -0,0 +1,290 @@
+package cgi
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"labs1/httpraw"
+)
+
+type recorder struct {
+	header httpraw.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newRecorder() *recorder { return &recorder{header: httpraw.Header{}} }
+
+func (r *recorder) Header() httpraw.Header { return r.header }
+func (r *recorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+func (r *recorder) Write(b []byte) (int, error) {
+	r.WriteHeader(200)
+	return r.body.Write(b)
+}
+
+// writeScripts создаёт в каталоге исполняемые shell-скрипты.
+func writeScripts(t *testing.T, scripts map[string]string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("скрипты на sh")
+	}
+	dir := t.TempDir()
+	for name, body := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func newRequest(method, target string, header ...string) *httpraw.Request {
+	path, query, _ := strings.Cut(target, "?")
+	r := &httpraw.Request{
+		Method:     method,
+		RequestURI: target,
+		Path:       path,
+		RawQuery:   query,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     httpraw.Header{},
+		Host:       "cgi.example:8080",
+		RemoteAddr: "192.0.2.7:5555",
+		Body:       strings.NewReader(""),
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Add(header[i], header[i+1])
+	}
+	return r
+}
+
+// Два скрипта перенаправляют друг на друга через цепочку, которая
+// копирует запрос и передаёт его другому экземпляру Handler, — как
+// middleware и виртуальные хосты raw сервера. Счётчик перенаправлений
+// должен дожить до проверки.
+func TestLocalRedirectLoop(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"a.sh": `printf 'Location: /cgi-bin/b.sh\r\n\r\n'`,
+		"b.sh": `printf 'Location: /cgi-bin/a.sh\r\n\r\n'`,
+	})
+	calls := 0
+	var chain httpraw.Handler
+	newHandler := func() *Handler {
+		return &Handler{
+			Root: "/cgi-bin/",
+			Dir:  dir,
+			LocalRedirect: httpraw.HandlerFunc(func(w httpraw.ResponseWriter, r *httpraw.Request) {
+				chain.ServeHTTP(w, r)
+			}),
+		}
+	}
+	chain = httpraw.HandlerFunc(func(w httpraw.ResponseWriter, r *httpraw.Request) {
+		calls++
+		next := *r
+		newHandler().ServeHTTP(w, &next)
+	})
+
+	w := newRecorder()
+	newHandler().ServeHTTP(w, newRequest("GET", "/cgi-bin/a.sh"))
+	if w.code != 500 {
+		t.Errorf("код %d; ожидался 500", w.code)
+	}
+	if calls != maxLocalRedirects {
+		t.Errorf("перенаправлений %d; ожидалось %d", calls, maxLocalRedirects)
+	}
+}
+
+// runEnv выполняет env.sh и разбирает его окружение из тела ответа.
+func runEnv(t *testing.T, h *Handler, req *httpraw.Request) map[string]string {
+	t.Helper()
+	w := newRecorder()
+	h.ServeHTTP(w, req)
+	if w.code != 200 {
+		t.Fatalf("код %d; ожидался 200, тело %q", w.code, w.body.String())
+	}
+	env := map[string]string{}
+	for _, line := range strings.Split(w.body.String(), "\n") {
+		if k, v, ok := strings.Cut(line, "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+func TestEnv(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"env.sh": "printf 'Content-Type: text/plain\\r\\n\\r\\n'\nenv\n",
+	})
+	withBody := func(r *httpraw.Request, body string, n int64) *httpraw.Request {
+		r.Body = strings.NewReader(body)
+		r.ContentLength = n
+		return r
+	}
+	tests := []struct {
+		name   string
+		req    *httpraw.Request
+		want   map[string]string
+		absent []string
+	}{
+		{"мета-переменные", newRequest("GET", "/cgi-bin/env.sh/extra/path?a=1&b=2"), map[string]string{
+			"GATEWAY_INTERFACE": "CGI/1.1",
+			"SERVER_PROTOCOL":   "HTTP/1.1",
+			"SERVER_NAME":       "cgi.example",
+			"SERVER_PORT":       "8080",
+			"REQUEST_METHOD":    "GET",
+			"REQUEST_URI":       "/cgi-bin/env.sh/extra/path?a=1&b=2",
+			"SCRIPT_NAME":       "/cgi-bin/env.sh",
+			"PATH_INFO":         "/extra/path",
+			"QUERY_STRING":      "a=1&b=2",
+			"REMOTE_ADDR":       "192.0.2.7",
+			"REMOTE_PORT":       "5555",
+		}, []string{"CONTENT_LENGTH", "CONTENT_TYPE", "AUTH_TYPE", "HTTPS"}},
+		{"тело с Content-Length",
+			withBody(newRequest("POST", "/cgi-bin/env.sh", "Content-Type", "text/plain", "Content-Length", "5"), "hello", 5),
+			map[string]string{"REQUEST_METHOD": "POST", "CONTENT_LENGTH": "5", "CONTENT_TYPE": "text/plain", "PATH_INFO": ""},
+			[]string{"HTTP_CONTENT_LENGTH", "HTTP_CONTENT_TYPE"}},
+		{"chunked тело читается заранее",
+			withBody(newRequest("POST", "/cgi-bin/env.sh", "Transfer-Encoding", "chunked"), "abc", -1),
+			map[string]string{"CONTENT_LENGTH": "3"},
+			[]string{"HTTP_TRANSFER_ENCODING"}},
+		{"поля запроса", newRequest("GET", "/cgi-bin/env.sh",
+			"Accept", "text/html", "Accept", "text/plain",
+			"Cookie", "a=1", "Cookie", "b=2",
+			"X-Forwarded-For", "198.51.100.1"), map[string]string{
+			"HTTP_ACCEPT":          "text/html, text/plain",
+			"HTTP_COOKIE":          "a=1; b=2",
+			"HTTP_X_FORWARDED_FOR": "198.51.100.1",
+		}, nil},
+		{"поле с подчёркиванием не подменяет поле с дефисом", newRequest("GET", "/cgi-bin/env.sh",
+			"X_Forwarded_For", "203.0.113.66"), nil, []string{"HTTP_X_FORWARDED_FOR"}},
+		{"Authorization даёт только AUTH_TYPE", newRequest("GET", "/cgi-bin/env.sh",
+			"Authorization", "Basic dXNlcjpwYXNz"), map[string]string{"AUTH_TYPE": "Basic"},
+			[]string{"HTTP_AUTHORIZATION"}},
+		{"Proxy не становится HTTP_PROXY", newRequest("GET", "/cgi-bin/env.sh",
+			"Proxy", "http://evil.example:3128"), nil, []string{"HTTP_PROXY"}},
+	}
+	h := &Handler{Root: "/cgi-bin/", Dir: dir}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := runEnv(t, h, tt.req)
+			for k, want := range tt.want {
+				if got, ok := env[k]; !ok || got != want {
+					t.Errorf("%s = %q (есть: %v); ожидалось %q", k, got, ok, want)
+				}
+			}
+			for _, k := range tt.absent {
+				if v, ok := env[k]; ok {
+					t.Errorf("%s = %q; переменной быть не должно", k, v)
+				}
+			}
+		})
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"local.sh":   `printf 'Location: /cgi-bin/target.sh?from=local\r\n\r\nэто тело не нужно'`,
+		"client.sh":  `printf 'Location: http://other.example/x\r\n\r\n'`,
+		"status.sh":  `printf 'Status: 301 Moved\r\nLocation: /cgi-bin/target.sh\r\n\r\n'`,
+		"outside.sh": `printf 'Location: /static/page.html\r\n\r\n'`,
+		"target.sh":  `printf 'Content-Type: text/plain\r\n\r\n%s %s' "$REQUEST_METHOD" "$QUERY_STRING"`,
+	})
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		code     int
+		location string
+		body     string
+	}{
+		{"локальное: новый путь методом GET", "POST", "/cgi-bin/local.sh", 200, "", "GET from=local"},
+		{"клиентское: абсолютный URL даёт 302", "GET", "/cgi-bin/client.sh", 302, "http://other.example/x", ""},
+		{"со Status путь отдаётся клиенту", "GET", "/cgi-bin/status.sh", 301, "/cgi-bin/target.sh", ""},
+		{"путь вне Root без LocalRedirect даёт 302", "GET", "/cgi-bin/outside.sh", 302, "/static/page.html", ""},
+	}
+	h := &Handler{Root: "/cgi-bin/", Dir: dir}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newRequest(tt.method, tt.target, "Content-Length", "4")
+			req.Body, req.ContentLength = strings.NewReader("data"), 4
+			w := newRecorder()
+			h.ServeHTTP(w, req)
+			if w.code != tt.code {
+				t.Fatalf("код %d; ожидался %d, тело %q", w.code, tt.code, w.body.String())
+			}
+			if got := w.header.Get("Location"); got != tt.location {
+				t.Errorf("Location %q; ожидалось %q", got, tt.location)
+			}
+			if tt.body != "" && w.body.String() != tt.body {
+				t.Errorf("тело %q; ожидалось %q", w.body.String(), tt.body)
+			}
+		})
+	}
+
+	// с LocalRedirect путь вне Root тоже обслуживается внутри
+	var got *httpraw.Request
+	h = &Handler{Root: "/cgi-bin/", Dir: dir,
+		LocalRedirect: httpraw.HandlerFunc(func(w httpraw.ResponseWriter, r *httpraw.Request) {
+			got = r
+			w.WriteHeader(204)
+		})}
+	w := newRecorder()
+	h.ServeHTTP(w, newRequest("POST", "/cgi-bin/outside.sh"))
+	if w.code != 204 || got == nil {
+		t.Fatalf("код %d; LocalRedirect не вызван", w.code)
+	}
+	if got.Method != "GET" || got.Path != "/static/page.html" || got.Redirects != 1 {
+		t.Errorf("запрос %s %s, перенаправлений %d; ожидалось GET /static/page.html, 1",
+			got.Method, got.Path, got.Redirects)
+	}
+}
+
+const nphOutput = "HTTP/1.1 299 Custom\r\nX-Raw: 1\r\nContent-Length: 8\r\n\r\nraw body"
+
+// Скрипт nph- пишет ответ целиком; сервер передаёт его клиенту байт в
+// байт и закрывает соединение.
+func TestNPHHijack(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"nph-raw.sh": "printf '" + strings.ReplaceAll(strings.ReplaceAll(nphOutput, "\r", `\r`), "\n", `\n`) + "'",
+	})
+	s := &httpraw.Server{Handler: &Handler{Root: "/cgi-bin/", Dir: dir}, IdleTimeout: 5 * time.Second}
+	c1, c2 := net.Pipe()
+	go func() {
+		s.ServeConn(c1)
+		c1.Close()
+	}()
+	defer c2.Close()
+	c2.SetDeadline(time.Now().Add(5 * time.Second))
+	go io.WriteString(c2, "GET /cgi-bin/nph-raw.sh HTTP/1.1\r\nHost: cgi.example\r\n\r\n")
+	got, err := io.ReadAll(c2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != nphOutput {
+		t.Errorf("ответ %q; ожидалось %q", got, nphOutput)
+	}
+}
+
+// Без Hijacker (HTTP/2, тестовый писатель) ответ nph- разбирается и
+// пересобирается.
+func TestNPHParsed(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"nph-raw.sh": "printf '" + strings.ReplaceAll(strings.ReplaceAll(nphOutput, "\r", `\r`), "\n", `\n`) + "'",
+	})
+	w := newRecorder()
+	(&Handler{Root: "/cgi-bin/", Dir: dir}).ServeHTTP(w, newRequest("GET", "/cgi-bin/nph-raw.sh"))
+	if w.code != 299 || w.header.Get("X-Raw") != "1" || w.body.String() != "raw body" {
+		t.Errorf("ответ %d %v %q; ожидалось 299, X-Raw: 1, %q", w.code, w.header, w.body.String(), "raw body")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package cgi
+
+import "os/exec"
+
+// killGroup: вне Unix групп процессов нет, по таймауту убивается только
+// сам скрипт.
+func killGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,18 @@
+//go:build unix
+
+package cgi
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killGroup запускает скрипт в отдельной группе процессов и по таймауту
+// убивает всю группу: иначе потомки скрипта (sleep, curl) держали бы
+// stdout открытым и после его смерти.
+func killGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,91 @@
+package compress
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/textproto"
+
+	"labs1/httpraw"
+)
+
+// Handler оборачивает обработчик net/http сжатием ответа.
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &httpWriter{w: w}
+		cw.encoder = encoder{
+			header: textproto.MIMEHeader(w.Header()),
+			method: r.Method,
+			accept: r.Header.Get("Accept-Encoding"),
+			send:   w.WriteHeader,
+			write:  w.Write,
+		}
+		if f, ok := w.(http.Flusher); ok {
+			cw.flushFn = func() error { f.Flush(); return nil }
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+type httpWriter struct {
+	encoder
+	w http.ResponseWriter
+}
+
+func (cw *httpWriter) Header() http.Header { return cw.w.Header() }
+
+// Flush реализует http.Flusher.
+func (cw *httpWriter) Flush() { cw.encoder.Flush() }
+
+// RawHandler оборачивает обработчик raw сервера (HTTP/1.x и HTTP/2)
+// сжатием ответа. Запросы на Upgrade (WebSocket) проходят как есть.
+func RawHandler(next httpraw.Handler) httpraw.Handler {
+	return httpraw.HandlerFunc(func(w httpraw.ResponseWriter, r *httpraw.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &rawWriter{w: w}
+		cw.encoder = encoder{
+			header: textproto.MIMEHeader(w.Header()),
+			method: r.Method,
+			accept: r.Header.Get("Accept-Encoding"),
+			send:   w.WriteHeader,
+			write:  w.Write,
+		}
+		if f, ok := w.(httpraw.Flusher); ok {
+			cw.flushFn = f.Flush
+		}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// rawWriter реализует httpraw.ResponseWriter, httpraw.Flusher и
+// httpraw.Hijacker.
+type rawWriter struct {
+	encoder
+	w httpraw.ResponseWriter
+}
+
+func (cw *rawWriter) Header() httpraw.Header { return cw.w.Header() }
+
+// Hijack отдаёт соединение обработчику, пока он ничего не написал:
+// сжимать тогда нечего, а close ничего не отправит (так работают NPH
+// скрипты CGI).
+func (cw *rawWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if cw.wroteHeader || len(cw.buf) > 0 {
+		return nil, nil, errors.New("ответ уже начат")
+	}
+	h, ok := cw.w.(httpraw.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("ResponseWriter не поддерживает Hijack")
+	}
+	return h.Hijack()
+}
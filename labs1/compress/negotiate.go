@@ -0,0 +1,84 @@
+// Package compress сжимает ответы gzip или deflate по заголовку
+// Accept-Encoding клиента. Одно и то же ядро работает и для raw сервера
+// (httpraw.ResponseWriter), и для обработчиков net/http.
+package compress
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Поддерживаемые кодировки в порядке предпочтения при равном q.
+var codings = []string{"gzip", "deflate"}
+
+// acceptEncoding разбирает Accept-Encoding в таблицу кодировка → q.
+// Элемент "*" хранится под своим именем.
+func acceptEncoding(header string) map[string]float64 {
+	q := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		weight := 1.0
+		for _, p := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+			if !ok || strings.ToLower(strings.TrimSpace(k)) != "q" {
+				continue
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil && f >= 0 && f <= 1 {
+				weight = f
+			}
+		}
+		q[name] = weight
+	}
+	return q
+}
+
+// weight — вес кодировки: явный, иначе от "*", иначе 0.
+func weight(q map[string]float64, coding string) float64 {
+	if w, ok := q[coding]; ok {
+		return w
+	}
+	if w, ok := q["*"]; ok {
+		return w
+	}
+	return 0
+}
+
+// Negotiate выбирает кодировку ответа: "gzip", "deflate" или "", если
+// клиент не принимает ни одну из них. При равных q побеждает gzip.
+func Negotiate(header string) string {
+	if header == "" {
+		return ""
+	}
+	q := acceptEncoding(header)
+	best, bestQ := "", 0.0
+	for _, c := range codings {
+		if w := weight(q, c); w > bestQ {
+			best, bestQ = c, w
+		}
+	}
+	return best
+}
+
+// Accepts сообщает, принимает ли клиент кодировку coding (q > 0).
+func Accepts(header, coding string) bool {
+	return header != "" && weight(acceptEncoding(header), coding) > 0
+}
+
+// Precompressed возвращает путь к заранее сжатому name+".gz", если клиент
+// принимает gzip и такой файл лежит рядом с исходным.
+func Precompressed(name, acceptEncodingHeader string) (string, bool) {
+	if !Accepts(acceptEncodingHeader, "gzip") {
+		return "", false
+	}
+	gz := name + ".gz"
+	info, err := os.Stat(gz)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	return gz, true
+}
@@ -0,0 +1,238 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// MinSize — ответы короче этого числа байт отправляются без сжатия:
+// заголовок gzip и словарь съедают всю выгоду.
+var MinSize = 1024
+
+// Level — уровень сжатия gzip/deflate.
+var Level = gzip.DefaultCompression
+
+// compressibleTypes — типы, которые имеет смысл сжимать, помимо text/*.
+// Картинки, архивы и видео уже сжаты.
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+	"application/wasm":       true,
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch {
+	case mediaType == "text/event-stream":
+		// события должны уходить сразу и по одному, сжатие тут мешает
+		return false
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case strings.HasSuffix(mediaType, "+json"), strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	return compressibleTypes[mediaType]
+}
+
+// flushWriteCloser — общее у gzip.Writer и zlib.Writer.
+type flushWriteCloser interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// encoder — ядро сжатия, не зависящее от конкретного ResponseWriter.
+// Заголовок ответа отправляется не в WriteHeader, а когда станет ясно,
+// сжимать ли тело: для этого копится до MinSize байт.
+type encoder struct {
+	header  textproto.MIMEHeader
+	method  string
+	accept  string
+	send    func(status int)
+	write   func([]byte) (int, error)
+	flushFn func() error // nil, если нижний writer не умеет Flush
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	zw          flushWriteCloser
+}
+
+func (e *encoder) WriteHeader(code int) {
+	if e.wroteHeader {
+		return
+	}
+	e.wroteHeader = true
+	e.status = code
+	if !bodyAllowed(code) || e.method == "HEAD" {
+		e.decide(false)
+	}
+}
+
+func (e *encoder) Write(p []byte) (int, error) {
+	if !e.wroteHeader {
+		e.WriteHeader(http.StatusOK)
+	}
+	if !e.decided {
+		if n, ok := e.declaredLength(); ok {
+			e.decide(n >= int64(MinSize))
+		} else {
+			e.buf = append(e.buf, p...)
+			if len(e.buf) < MinSize {
+				return len(p), nil
+			}
+			e.decide(true)
+			if err := e.writeBuffered(); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}
+	}
+	if e.zw != nil {
+		return e.zw.Write(p)
+	}
+	return e.write(p)
+}
+
+// Flush отправляет всё накопленное. Размер потокового ответа заранее не
+// известен, поэтому при первом Flush решение принимается в пользу сжатия.
+func (e *encoder) Flush() error {
+	if !e.wroteHeader {
+		e.WriteHeader(http.StatusOK)
+	}
+	if !e.decided {
+		n, ok := e.declaredLength()
+		e.decide(!ok || n >= int64(MinSize))
+		if err := e.writeBuffered(); err != nil {
+			return err
+		}
+	}
+	if e.zw != nil {
+		if err := e.zw.Flush(); err != nil {
+			return err
+		}
+	}
+	if e.flushFn != nil {
+		return e.flushFn()
+	}
+	return nil
+}
+
+// close завершает ответ: короткое тело уходит как есть, у сжатого
+// дописывается хвост gzip/zlib.
+func (e *encoder) close() error {
+	if !e.wroteHeader {
+		if len(e.buf) == 0 {
+			// обработчик ничего не написал — ответ за сервером
+			return nil
+		}
+		e.WriteHeader(http.StatusOK)
+	}
+	if !e.decided {
+		e.decide(false)
+		if err := e.writeBuffered(); err != nil {
+			return err
+		}
+	}
+	if e.zw != nil {
+		return e.zw.Close()
+	}
+	return nil
+}
+
+func (e *encoder) declaredLength() (int64, bool) {
+	n, err := strconv.ParseInt(e.header.Get("Content-Length"), 10, 64)
+	return n, err == nil && n >= 0
+}
+
+func (e *encoder) writeBuffered() error {
+	buf := e.buf
+	e.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if e.zw != nil {
+		_, err = e.zw.Write(buf)
+	} else {
+		_, err = e.write(buf)
+	}
+	return err
+}
+
+// decide выбирает кодировку и отправляет заголовок ответа. big — тело
+// достаточно длинное, чтобы сжатие окупилось.
+func (e *encoder) decide(big bool) {
+	e.decided = true
+	h := e.header
+	if h.Get("Content-Type") == "" && len(e.buf) > 0 && bodyAllowed(e.status) {
+		// иначе net/http определит тип уже по сжатым байтам
+		h.Set("Content-Type", http.DetectContentType(e.buf))
+	}
+	if compressible(h.Get("Content-Type")) || h.Get("Content-Encoding") != "" {
+		addVary(h, "Accept-Encoding")
+	}
+
+	coding := ""
+	if big && e.eligible() {
+		coding = Negotiate(e.accept)
+	}
+	if coding != "" {
+		h.Set("Content-Encoding", coding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		// сжатое представление побайтно отличается от исходного
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+	e.send(e.status)
+
+	switch coding {
+	case "gzip":
+		e.zw, _ = gzip.NewWriterLevel(writerFunc(e.write), Level)
+	case "deflate":
+		// HTTP deflate — это поток zlib (RFC 9110 8.4.1.2)
+		e.zw, _ = zlib.NewWriterLevel(writerFunc(e.write), Level)
+	}
+}
+
+func (e *encoder) eligible() bool {
+	h := e.header
+	return bodyAllowed(e.status) &&
+		e.status != http.StatusPartialContent &&
+		e.method != "HEAD" &&
+		h.Get("Content-Encoding") == "" &&
+		h.Get("Content-Range") == "" &&
+		compressible(h.Get("Content-Type"))
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// addVary добавляет поле в Vary, если его там ещё нет.
+func addVary(h textproto.MIMEHeader, field string) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f == "*" || strings.EqualFold(f, field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
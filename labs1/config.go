@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Настройки запуска собираются по слоям: значения по умолчанию, затем
+// JSON-файл, затем переменные окружения LABS1_*, затем явно заданные
+// флаги. Каждый следующий слой перекрывает предыдущий.
+var (
+	configPath      = flag.String("config", "", "JSON-файл с настройками серверов (по умолчанию labs1.json, если он есть)")
+	rawAddr         = flag.String("raw-addr", ":8080", "адрес raw socket сервера: host:port или unix:/path.sock")
+	fileAddr        = flag.String("file-addr", ":8081", "адрес сервера file протокола: host:port или unix:/path.sock")
+	dnsAddr         = flag.String("dns-addr", ":8082", "адрес DNS shell exec сервера: host:port или unix:/path.sock")
+	rawEnabled      = flag.Bool("raw", true, "запускать raw socket сервер")
+	fileEnabled     = flag.Bool("file", true, "запускать сервер file протокола")
+	dnsEnabled      = flag.Bool("dns", true, "запускать DNS shell exec сервер")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "сколько ждать завершения запросов при остановке")
+	vhostsStrict    = flag.Bool("vhosts-strict", false, "отвечать 421 на запросы к хостам, которых нет в vhosts")
+)
+
+const defaultConfigFile = "labs1.json"
+
+type serverConfig struct {
+	Enabled bool   `json:"enabled"`
+	Addr    string `json:"addr"`
+}
+
+type config struct {
+	Raw             serverConfig    `json:"raw"`
+	File            serverConfig    `json:"file"`
+	DNS             serverConfig    `json:"dns"`
+	ShutdownTimeout duration        `json:"shutdown_timeout"`
+	VHosts          vhostsConfig    `json:"vhosts"`
+	Auth            authConfig      `json:"auth"`
+	RateLimit       rateLimitConfig `json:"rate_limit"`
+	CORS            corsConfig      `json:"cors"`
+}
+
+// duration читается из JSON строкой вида "10s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("длительность должна быть строкой вроде \"10s\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// loadConfig собирает итоговые настройки. Вызывается после flag.Parse.
+func loadConfig() (*config, error) {
+	cfg := &config{
+		Raw:             serverConfig{Enabled: true, Addr: ":8080"},
+		File:            serverConfig{Enabled: true, Addr: ":8081"},
+		DNS:             serverConfig{Enabled: true, Addr: ":8082"},
+		ShutdownTimeout: duration(10 * time.Second),
+		Auth: authConfig{
+			Realm:   "labs1",
+			Lockout: lockoutConfig{MaxFailures: 5, Window: duration(5 * time.Minute), Duration: duration(15 * time.Minute)},
+		},
+		CORS: corsConfig{MaxAge: duration(10 * time.Minute)},
+	}
+
+	path, explicit := *configPath, *configPath != ""
+	if !explicit {
+		if env := os.Getenv("LABS1_CONFIG"); env != "" {
+			path, explicit = env, true
+		} else {
+			path = defaultConfigFile
+		}
+	}
+	if err := cfg.loadFile(path); err != nil {
+		if explicit || !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("файл настроек %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	cfg.loadFlags()
+
+	for _, s := range []struct {
+		name string
+		sc   serverConfig
+	}{{"raw", cfg.Raw}, {"file", cfg.File}, {"dns", cfg.DNS}} {
+		if s.sc.Enabled && s.sc.Addr == "" {
+			return nil, fmt.Errorf("сервер %s включён, но адрес не задан", s.name)
+		}
+		if path, ok := unixPath(s.sc.Addr); ok && path == "" {
+			return nil, fmt.Errorf("сервер %s: в адресе unix: не указан путь сокета", s.name)
+		}
+	}
+	if !cfg.Raw.Enabled && !cfg.File.Enabled && !cfg.DNS.Enabled {
+		return nil, errors.New("все серверы выключены")
+	}
+	if err := cfg.VHosts.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Auth.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.RateLimit.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.CORS.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	// опечатка в имени поля не должна молча превращаться в значение по умолчанию
+	dec.DisallowUnknownFields()
+	return dec.Decode(cfg)
+}
+
+func (cfg *config) loadEnv() error {
+	for _, s := range []struct {
+		prefix string
+		sc     *serverConfig
+	}{{"LABS1_RAW", &cfg.Raw}, {"LABS1_FILE", &cfg.File}, {"LABS1_DNS", &cfg.DNS}} {
+		if v, ok := os.LookupEnv(s.prefix + "_ADDR"); ok {
+			s.sc.Addr = v
+		}
+		if v, ok := os.LookupEnv(s.prefix + "_ENABLED"); ok {
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return fmt.Errorf("%s_ENABLED=%q: ожидается true или false", s.prefix, v)
+			}
+			s.sc.Enabled = b
+		}
+	}
+	if v, ok := os.LookupEnv("LABS1_SHUTDOWN_TIMEOUT"); ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("LABS1_SHUTDOWN_TIMEOUT=%q: %v", v, err)
+		}
+		cfg.ShutdownTimeout = duration(d)
+	}
+	if v, ok := os.LookupEnv("LABS1_AUTH_FILE"); ok {
+		cfg.Auth.File = v
+	}
+	if v, ok := os.LookupEnv("LABS1_RATE_LIMIT_ALLOW"); ok {
+		cfg.RateLimit.Allow = splitList(v)
+	}
+	if v, ok := os.LookupEnv("LABS1_CORS_ORIGINS"); ok {
+		cfg.CORS.Origins = splitList(v)
+	}
+	if v, ok := os.LookupEnv("LABS1_VHOSTS_STRICT"); ok {
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("LABS1_VHOSTS_STRICT=%q: ожидается true или false", v)
+		}
+		cfg.VHosts.Strict = b
+	}
+	return nil
+}
+
+// loadFlags применяет только флаги, заданные в командной строке: значения
+// по умолчанию у флагов не должны перекрывать файл и окружение.
+func (cfg *config) loadFlags() {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "raw-addr":
+			cfg.Raw.Addr = *rawAddr
+		case "file-addr":
+			cfg.File.Addr = *fileAddr
+		case "dns-addr":
+			cfg.DNS.Addr = *dnsAddr
+		case "raw":
+			cfg.Raw.Enabled = *rawEnabled
+		case "file":
+			cfg.File.Enabled = *fileEnabled
+		case "dns":
+			cfg.DNS.Enabled = *dnsEnabled
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = duration(*shutdownTimeout)
+		case "auth-file":
+			cfg.Auth.File = *authFile
+		case "vhosts-strict":
+			cfg.VHosts.Strict = *vhostsStrict
+		case "rate-limit-allow":
+			cfg.RateLimit.Allow = splitList(*rateLimitAllow)
+		case "cors-origins":
+			cfg.CORS.Origins = splitList(*corsOrigins)
+		case "cors-credentials":
+			cfg.CORS.Credentials = *corsCredentials
+		}
+	})
+}
+
+// urlHost превращает адрес прослушивания в host:port для ссылок в
+// сообщениях о запуске: ":8080" → "localhost:8080". У unix сокета
+// порта нет, остаётся "localhost".
+func urlHost(addr string) string {
+	if _, ok := unixPath(addr); ok {
+		return "localhost"
+	}
+	if strings.HasPrefix(addr, ":") {
+		return "localhost" + addr
+	}
+	if strings.HasPrefix(addr, "0.0.0.0:") {
+		return "localhost" + strings.TrimPrefix(addr, "0.0.0.0")
+	}
+	return addr
+}
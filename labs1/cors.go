@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"labs1/accesslog"
+	"labs1/middleware"
+)
+
+var (
+	corsOrigins     = flag.String("cors-origins", "", "origin через запятую, которым разрешён CORS: https://dash.example.com, https://*.example.com, * — любым")
+	corsCredentials = flag.Bool("cors-credentials", false, "разрешить CORS запросы с cookie и Authorization (нельзя вместе с -cors-origins '*')")
+)
+
+// corsConfig — раздел "cors" файла настроек.
+type corsConfig struct {
+	// Servers — к каким серверам применять; по умолчанию ко всем.
+	Servers []string `json:"servers"`
+	Origins []string `json:"origins"`
+	// Methods по умолчанию — GET, HEAD и POST.
+	Methods []string `json:"methods"`
+	// Headers — разрешённые заголовки запроса; по умолчанию
+	// Authorization и X-Request-ID.
+	Headers []string `json:"headers"`
+	// Expose по умолчанию — X-Request-ID и заголовки ограничения частоты.
+	Expose      []string `json:"expose"`
+	MaxAge      duration `json:"max_age"`
+	Credentials bool     `json:"credentials"`
+}
+
+// validate проверяет раздел до запуска серверов.
+func (cc corsConfig) validate() error {
+	for _, s := range cc.Servers {
+		switch s {
+		case "raw", "file", "dns":
+		default:
+			return fmt.Errorf("cors: неизвестный сервер %q (raw, file или dns)", s)
+		}
+	}
+	return cc.policy().Validate()
+}
+
+func (cc corsConfig) policy() middleware.CORSPolicy {
+	p := middleware.CORSPolicy{
+		Origins:     cc.Origins,
+		Methods:     cc.Methods,
+		Headers:     cc.Headers,
+		Expose:      cc.Expose,
+		MaxAge:      time.Duration(cc.MaxAge),
+		Credentials: cc.Credentials,
+	}
+	if p.Headers == nil {
+		p.Headers = []string{"Authorization", accesslog.RequestIDHeader}
+	}
+	if p.Expose == nil {
+		p.Expose = []string{accesslog.RequestIDHeader, "Retry-After",
+			"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"}
+	}
+	return p
+}
+
+// corsPolicies задаёт setupCORS: политика по имени сервера, у серверов
+// без CORS её нет.
+var corsPolicies map[string]middleware.CORSPolicy
+
+// setupCORS раскладывает политику по серверам.
+func setupCORS(cc corsConfig) {
+	if len(cc.Origins) == 0 {
+		return
+	}
+	servers := cc.Servers
+	if len(servers) == 0 {
+		servers = []string{"raw", "file", "dns"}
+	}
+	p := cc.policy()
+	corsPolicies = make(map[string]middleware.CORSPolicy)
+	for _, s := range servers {
+		corsPolicies[s] = p
+	}
+
+	methods := p.Methods
+	if len(methods) == 0 {
+		methods = middleware.DefaultCORSMethods
+	}
+	fmt.Printf("CORS для %s: origin %s, методы %s\n",
+		strings.Join(servers, ", "), strings.Join(p.Origins, ", "), strings.Join(methods, ", "))
+	if p.Credentials {
+		fmt.Println("  с учётными данными (cookie, Authorization)")
+	}
+}
+
+// corsMiddleware — CORS net/http сервера name, nil — если он выключен.
+func corsMiddleware(name string) middleware.Middleware {
+	p, ok := corsPolicies[name]
+	if !ok {
+		return nil
+	}
+	return middleware.CORS(p)
+}
@@ -6,33 +6,45 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+
+	"labs1/apierror"
+	"labs1/compress"
+	"labs1/middleware"
 )
 
-func handleFileProtocol() {
-	http.HandleFunc("/file", fileHandler)
-	fmt.Println("File протокол сервер запущен на порту 8081")
-	fmt.Println("Используйте: http://localhost:8081/file?path=file:///path/to/file")
-	http.ListenAndServe(":8081", nil)
+func newFileLabServer(addr string) *labServer {
+	mux := newServerMux("File протокол сервер")
+	mux.handle("GET /file", "содержимое файла по file:// URL", "/file?path=file:///etc/hostname",
+		compress.Handler(http.HandlerFunc(fileHandler)))
+	mux.handle("GET /metrics", "метрики в формате Prometheus", "/metrics", labMetrics.Handler())
+	mux.handle("GET /openapi.json", "описание API file и dns в формате OpenAPI 3", "/openapi.json", http.HandlerFunc(openAPIHandler))
+	handler := middleware.Chain(mux, serverMiddleware("file", fileRejected)...)
+	registerAPIServer("file", addr)
+	return newHTTPLabServer("file", addr, handler, fileRejected, func() {
+		fmt.Printf("File протокол сервер запущен на %s\n", addr)
+		fmt.Printf("Используйте: %s://%s/file?path=file:///path/to/file\n", scheme(), urlHost(addr))
+	})
 }
 
 func fileHandler(w http.ResponseWriter, r *http.Request) {
 	// Получаем параметр path из запроса
 	pathParam := r.URL.Query().Get("path")
 	if pathParam == "" {
-		http.Error(w, "Параметр 'path' обязателен", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.MissingParameter, "Параметр 'path' обязателен")
 		return
 	}
 
 	// Парсим URL с file протоколом
 	parsedURL, err := url.Parse(pathParam)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Ошибка парсинга URL: %v", err), http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.InvalidURL, fmt.Sprintf("Ошибка парсинга URL: %v", err))
 		return
 	}
 
 	if parsedURL.Scheme != "file" {
-		http.Error(w, "Поддерживается только протокол file://", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.UnsupportedScheme, "Поддерживается только протокол file://")
 		return
 	}
 
@@ -46,14 +58,24 @@ func fileHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Проверяем существование файла
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		http.Error(w, fmt.Sprintf("Файл не найден: %s", filePath), http.StatusNotFound)
+		apierror.Write(w, r, http.StatusNotFound, apierror.FileNotFound, fmt.Sprintf("Файл не найден: %s", filePath))
 		return
 	}
 
+	// Если рядом лежит сжатая копия и клиент принимает gzip, отдаём её
+	readPath := filePath
+	if gz, ok := compress.Precompressed(filePath, r.Header.Get("Accept-Encoding")); ok {
+		readPath = gz
+	}
+
 	// Читаем содержимое файла
-	content, err := os.ReadFile(filePath)
+	content, err := os.ReadFile(readPath)
+	if os.IsPermission(err) {
+		apierror.Write(w, r, http.StatusForbidden, apierror.Forbidden, fmt.Sprintf("Нет доступа к файлу: %s", filePath))
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Ошибка чтения файла: %v", err), http.StatusInternalServerError)
+		apierror.Write(w, r, http.StatusInternalServerError, apierror.FileReadFailed, fmt.Sprintf("Ошибка чтения файла: %v", err))
 		return
 	}
 
@@ -81,10 +103,16 @@ func fileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Отправляем ответ
+	encoding := "identity"
+	if readPath != filePath {
+		encoding = "gzip"
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"%s\"", filepath.Base(filePath)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
 
-	w.Write(content)
-
-	fmt.Printf("Обработан запрос файла: %s\n", filePath)
+	n, _ := w.Write(content)
+	fileBytes.Add(float64(n), encoding)
 }
@@ -0,0 +1,61 @@
+// Package guard защищает серверы labs1 от исчерпания ресурсов: ограничивает
+// число соединений и считает все отказы клиентам.
+package guard
+
+import "sync"
+
+// Причины отказа. Серверы могут передавать и свои строки, но эти
+// используются везде одинаково.
+const (
+	ConnLimit      = "conn_limit"       // превышен общий лимит соединений
+	IPLimit        = "ip_limit"         // превышен лимит соединений с одного IP
+	HeaderTimeout  = "header_timeout"   // 408: заголовки не пришли вовремя
+	BodyTimeout    = "body_timeout"     // 408: тело не пришло вовремя
+	HeaderTooLarge = "header_too_large" // 431
+	BodyTooLarge   = "body_too_large"   // 413
+	URITooLong     = "uri_too_long"     // 414
+	BadRequest     = "bad_request"      // 400 и прочие ошибки разбора
+)
+
+// Counters — счётчики отказов одного сервера по причинам.
+type Counters struct {
+	mu sync.Mutex
+	m  map[string]int64
+}
+
+// Inc увеличивает счётчик причины reason.
+func (c *Counters) Inc(reason string) {
+	c.mu.Lock()
+	if c.m == nil {
+		c.m = make(map[string]int64)
+	}
+	c.m[reason]++
+	c.mu.Unlock()
+}
+
+// Snapshot возвращает копию счётчиков.
+func (c *Counters) Snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]int64, len(c.m))
+	for k, v := range c.m {
+		out[k] = v
+	}
+	return out
+}
+
+// ReasonForStatus сопоставляет код ответа об ошибке разбора с причиной
+// отказа.
+func ReasonForStatus(status int) string {
+	switch status {
+	case 408:
+		return HeaderTimeout
+	case 413:
+		return BodyTooLarge
+	case 414:
+		return URITooLong
+	case 431:
+		return HeaderTooLarge
+	}
+	return BadRequest
+}
@@ -0,0 +1,139 @@
+package guard
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// Listener ограничивает число одновременно открытых соединений: всего и
+// с одного IP. Лишние соединения закрываются сразу после accept, до
+// TLS-рукопожатия и чтения запроса, — так они почти ничего не стоят.
+type Listener struct {
+	net.Listener
+	MaxConns int // 0 — без ограничения
+	MaxPerIP int // 0 — без ограничения
+	Counters *Counters
+	// OnReject, если задан, получает адрес и причину отказа.
+	OnReject func(addr net.Addr, reason string)
+
+	mu    sync.Mutex
+	total int
+	perIP map[string]int
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewListener оборачивает l ограничениями.
+func NewListener(l net.Listener, maxConns, maxPerIP int, c *Counters) *Listener {
+	return &Listener{Listener: l, MaxConns: maxConns, MaxPerIP: maxPerIP, Counters: c, perIP: make(map[string]int)}
+}
+
+// Accept возвращает следующее соединение, которое укладывается в лимиты.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := hostOf(conn.RemoteAddr())
+		if reason := l.acquire(ip); reason != "" {
+			if l.Counters != nil {
+				l.Counters.Inc(reason)
+			}
+			if l.OnReject != nil {
+				l.OnReject(conn.RemoteAddr(), reason)
+			}
+			// RST вместо FIN: клиенту не нужно ждать, сокет не висит в
+			// TIME_WAIT на нашей стороне
+			if tc, ok := conn.(*net.TCPConn); ok {
+				tc.SetLinger(0)
+			}
+			conn.Close()
+			continue
+		}
+		return &limitedConn{Conn: conn, release: func() { l.release(ip) }}, nil
+	}
+}
+
+// Close закрывает слушатель; повторный вызов ничего не делает. Так
+// можно перестать принимать соединения заранее, а сервер при остановке
+// закроет слушатель ещё раз без ошибки.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { l.closeErr = l.Listener.Close() })
+	return l.closeErr
+}
+
+func (l *Listener) acquire(ip string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.MaxConns > 0 && l.total >= l.MaxConns {
+		return ConnLimit
+	}
+	// у клиентов unix сокета нет адреса — лимит на IP к ним не относится
+	if l.MaxPerIP > 0 && ip != "" && l.perIP[ip] >= l.MaxPerIP {
+		return IPLimit
+	}
+	l.total++
+	if ip != "" {
+		l.perIP[ip]++
+	}
+	return ""
+}
+
+func (l *Listener) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.total--
+	if ip == "" {
+		return
+	}
+	if l.perIP[ip]--; l.perIP[ip] <= 0 {
+		delete(l.perIP, ip)
+	}
+}
+
+// Active возвращает число открытых соединений.
+func (l *Listener) Active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.total
+}
+
+func hostOf(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UnixAddr:
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// limitedConn освобождает место в лимитах при первом Close.
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+// SyscallConn даёт доступ к дескриптору исходного соединения — он
+// нужен серверу на epoll.
+func (c *limitedConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("соединение %T без дескриптора", c.Conn)
+	}
+	return sc.SyscallConn()
+}
@@ -0,0 +1,167 @@
+package h2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Типы кадров (RFC 9113 6).
+const (
+	frameData         = 0x0
+	frameHeaders      = 0x1
+	framePriority     = 0x2
+	frameRSTStream    = 0x3
+	frameSettings     = 0x4
+	framePushPromise  = 0x5
+	framePing         = 0x6
+	frameGoAway       = 0x7
+	frameWindowUpdate = 0x8
+	frameContinuation = 0x9
+)
+
+var frameNames = map[byte]string{
+	frameData:         "DATA",
+	frameHeaders:      "HEADERS",
+	framePriority:     "PRIORITY",
+	frameRSTStream:    "RST_STREAM",
+	frameSettings:     "SETTINGS",
+	framePushPromise:  "PUSH_PROMISE",
+	framePing:         "PING",
+	frameGoAway:       "GOAWAY",
+	frameWindowUpdate: "WINDOW_UPDATE",
+	frameContinuation: "CONTINUATION",
+}
+
+// Флаги кадров.
+const (
+	flagEndStream  = 0x1
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+// Параметры SETTINGS (RFC 9113 6.5.2).
+const (
+	settingHeaderTableSize      = 0x1
+	settingEnablePush           = 0x2
+	settingMaxConcurrentStreams = 0x3
+	settingInitialWindowSize    = 0x4
+	settingMaxFrameSize         = 0x5
+	settingMaxHeaderListSize    = 0x6
+)
+
+// Коды ошибок для RST_STREAM и GOAWAY (RFC 9113 7).
+const (
+	errCodeNo              = 0x0
+	errCodeProtocol        = 0x1
+	errCodeInternal        = 0x2
+	errCodeFlowControl     = 0x3
+	errCodeStreamClosed    = 0x5
+	errCodeFrameSize       = 0x6
+	errCodeRefusedStream   = 0x7
+	errCodeCancel          = 0x8
+	errCodeCompression     = 0x9
+	errCodeEnhanceYourCalm = 0xb
+)
+
+const (
+	// Preface — первые байты клиента HTTP/2 (RFC 9113 3.4).
+	Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+	frameHeaderLen    = 9
+	defaultMaxFrame   = 16384
+	maxFrameSizeLimit = 1<<24 - 1
+	defaultWindow     = 65535
+	maxWindow         = 1<<31 - 1
+)
+
+type frameHeader struct {
+	length   uint32
+	typ      byte
+	flags    byte
+	streamID uint32
+}
+
+func (h frameHeader) String() string {
+	name := frameNames[h.typ]
+	if name == "" {
+		name = fmt.Sprintf("UNKNOWN(%#x)", h.typ)
+	}
+	return fmt.Sprintf("%s stream=%d len=%d flags=%#x", name, h.streamID, h.length, h.flags)
+}
+
+// connError — ошибка уровня соединения: отправить GOAWAY и закрыть.
+type connError struct {
+	code   uint32
+	reason string
+}
+
+func (e *connError) Error() string {
+	return fmt.Sprintf("ошибка соединения HTTP/2 (код %d): %s", e.code, e.reason)
+}
+
+func connErrorf(code uint32, format string, args ...any) error {
+	return &connError{code: code, reason: fmt.Sprintf(format, args...)}
+}
+
+// streamError — ошибка одного потока: отправить RST_STREAM, соединение
+// продолжает работать.
+type streamError struct {
+	streamID uint32
+	code     uint32
+	reason   string
+}
+
+func (e *streamError) Error() string {
+	return fmt.Sprintf("ошибка потока %d (код %d): %s", e.streamID, e.code, e.reason)
+}
+
+func streamErrorf(id, code uint32, format string, args ...any) error {
+	return &streamError{streamID: id, code: code, reason: fmt.Sprintf(format, args...)}
+}
+
+// readFrame читает заголовок кадра и его содержимое. Кадр длиннее
+// maxSize — ошибка FRAME_SIZE_ERROR.
+func readFrame(r io.Reader, maxSize uint32) (frameHeader, []byte, error) {
+	var buf [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return frameHeader{}, nil, err
+	}
+	h := frameHeader{
+		length:   uint32(buf[0])<<16 | uint32(buf[1])<<8 | uint32(buf[2]),
+		typ:      buf[3],
+		flags:    buf[4],
+		streamID: binary.BigEndian.Uint32(buf[5:]) & maxWindow,
+	}
+	if h.length > maxSize {
+		return h, nil, connErrorf(errCodeFrameSize, "кадр %s длиннее %d байт", h, maxSize)
+	}
+	payload := make([]byte, h.length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return h, nil, err
+	}
+	return h, payload, nil
+}
+
+func appendFrameHeader(dst []byte, length int, typ, flags byte, streamID uint32) []byte {
+	dst = append(dst, byte(length>>16), byte(length>>8), byte(length), typ, flags)
+	return binary.BigEndian.AppendUint32(dst, streamID)
+}
+
+// stripPadding убирает поле Pad Length и заполнитель у DATA и HEADERS.
+func stripPadding(h frameHeader, payload []byte) ([]byte, error) {
+	if h.flags&flagPadded == 0 {
+		return payload, nil
+	}
+	if len(payload) < 1 {
+		return nil, connErrorf(errCodeFrameSize, "кадр %s без длины заполнителя", h)
+	}
+	pad := int(payload[0])
+	payload = payload[1:]
+	if pad > len(payload) {
+		return nil, connErrorf(errCodeProtocol, "заполнитель длиннее кадра %s", h)
+	}
+	return payload[:len(payload)-pad], nil
+}
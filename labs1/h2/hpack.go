@@ -0,0 +1,280 @@
+package h2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Размер записи в динамической таблице: длины имени и значения плюс 32
+// байта накладных расходов (RFC 7541 4.1).
+const entryOverhead = 32
+
+// Размер динамической таблицы по умолчанию (SETTINGS_HEADER_TABLE_SIZE).
+const defaultHeaderTableSize = 4096
+
+var errCompression = errors.New("hpack: ошибка декодирования")
+
+// errHeaderListSize — распакованный список заголовков больше
+// объявленного SETTINGS_MAX_HEADER_LIST_SIZE.
+var errHeaderListSize = errors.New("hpack: список заголовков больше допустимого")
+
+// decoder восстанавливает заголовки из блока HPACK. Состояние (динамическая
+// таблица) общее для всех потоков соединения, поэтому блоки нужно
+// декодировать строго в порядке прихода.
+type decoder struct {
+	dynamic []headerField // новые записи в начале
+	size    int
+	maxSize int
+	// limit — верхняя граница maxSize, объявленная нами в SETTINGS.
+	limit int
+	// maxListSize — предел размера распакованного списка заголовков
+	// (имя + значение + 32 на поле, RFC 9113 6.5.2); 0 — без предела.
+	// Небольшой блок из ссылок на длинную запись таблицы распаковывается
+	// в сотни мегабайт, поэтому считать нужно по ходу декодирования.
+	maxListSize int
+}
+
+func newDecoder() *decoder {
+	return &decoder{maxSize: defaultHeaderTableSize, limit: defaultHeaderTableSize}
+}
+
+func (d *decoder) entry(index uint64) (headerField, error) {
+	if index == 0 {
+		return headerField{}, fmt.Errorf("%w: индекс 0", errCompression)
+	}
+	if index <= uint64(len(staticTable)) {
+		return staticTable[index-1], nil
+	}
+	i := index - uint64(len(staticTable)) - 1
+	if i >= uint64(len(d.dynamic)) {
+		return headerField{}, fmt.Errorf("%w: индекс %d вне таблицы", errCompression, index)
+	}
+	return d.dynamic[i], nil
+}
+
+func (d *decoder) add(f headerField) {
+	d.dynamic = append([]headerField{f}, d.dynamic...)
+	d.size += len(f.name) + len(f.value) + entryOverhead
+	d.evict()
+}
+
+// evict удаляет самые старые записи, пока таблица не влезет в maxSize.
+// Запись больше всей таблицы просто очищает её.
+func (d *decoder) evict() {
+	for d.size > d.maxSize && len(d.dynamic) > 0 {
+		last := d.dynamic[len(d.dynamic)-1]
+		d.size -= len(last.name) + len(last.value) + entryOverhead
+		d.dynamic = d.dynamic[:len(d.dynamic)-1]
+	}
+}
+
+// decode разбирает блок заголовков целиком. Если список заголовков
+// больше maxListSize, блок всё равно дочитывается до конца — иначе
+// динамическая таблица разойдётся с клиентской, — но поля уже не
+// копятся, и возвращается errHeaderListSize.
+func (d *decoder) decode(block []byte) ([]headerField, error) {
+	var fields []headerField
+	listSize := 0
+	emit := func(f headerField) {
+		listSize += len(f.name) + len(f.value) + entryOverhead
+		if d.maxListSize > 0 && listSize > d.maxListSize {
+			fields = nil
+			return
+		}
+		fields = append(fields, f)
+	}
+	first := true
+	for len(block) > 0 {
+		b := block[0]
+		var err error
+		switch {
+		case b&0x80 != 0:
+			// 6.1: индексированное поле
+			var idx uint64
+			idx, block, err = readInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			f, err := d.entry(idx)
+			if err != nil {
+				return nil, err
+			}
+			emit(f)
+
+		case b&0xC0 == 0x40:
+			// 6.2.1: литерал с добавлением в таблицу
+			var f headerField
+			f, block, err = d.readLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.add(f)
+			emit(f)
+
+		case b&0xE0 == 0x20:
+			// 6.3: изменение размера таблицы — только в начале блока
+			if !first {
+				return nil, fmt.Errorf("%w: изменение размера таблицы не в начале блока", errCompression)
+			}
+			var size uint64
+			size, block, err = readInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.limit) {
+				return nil, fmt.Errorf("%w: размер таблицы %d больше разрешённого", errCompression, size)
+			}
+			d.maxSize = int(size)
+			d.evict()
+			continue
+
+		default:
+			// 6.2.2 и 6.2.3: литерал без индексации и никогда не индексируемый
+			var f headerField
+			f, block, err = d.readLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+			emit(f)
+		}
+		first = false
+	}
+	if d.maxListSize > 0 && listSize > d.maxListSize {
+		return nil, fmt.Errorf("%w: %d байт", errHeaderListSize, listSize)
+	}
+	return fields, nil
+}
+
+// readLiteral читает литерал: имя по индексу или строкой, затем значение.
+func (d *decoder) readLiteral(block []byte, prefix uint) (headerField, []byte, error) {
+	idx, block, err := readInt(block, prefix)
+	if err != nil {
+		return headerField{}, nil, err
+	}
+	var f headerField
+	if idx > 0 {
+		named, err := d.entry(idx)
+		if err != nil {
+			return headerField{}, nil, err
+		}
+		f.name = named.name
+	} else {
+		f.name, block, err = readString(block)
+		if err != nil {
+			return headerField{}, nil, err
+		}
+	}
+	f.value, block, err = readString(block)
+	return f, block, err
+}
+
+// readInt декодирует целое с N-битным префиксом (RFC 7541 5.1).
+func readInt(block []byte, n uint) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, fmt.Errorf("%w: блок оборван", errCompression)
+	}
+	mask := uint64(1)<<n - 1
+	v := uint64(block[0]) & mask
+	block = block[1:]
+	if v < mask {
+		return v, block, nil
+	}
+	var shift uint
+	for {
+		if len(block) == 0 {
+			return 0, nil, fmt.Errorf("%w: блок оборван", errCompression)
+		}
+		b := block[0]
+		block = block[1:]
+		v += uint64(b&0x7F) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return v, block, nil
+		}
+		if shift > 56 {
+			return 0, nil, fmt.Errorf("%w: слишком большое целое", errCompression)
+		}
+	}
+}
+
+// readString декодирует строку, возможно сжатую кодом Хаффмана.
+func readString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, fmt.Errorf("%w: блок оборван", errCompression)
+	}
+	huffman := block[0]&0x80 != 0
+	length, block, err := readInt(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint64(len(block)) < length {
+		return "", nil, fmt.Errorf("%w: строка длиннее блока", errCompression)
+	}
+	raw := block[:length]
+	block = block[length:]
+	if !huffman {
+		return string(raw), block, nil
+	}
+	decoded, err := huffmanDecode(raw)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", errCompression, err)
+	}
+	return string(decoded), block, nil
+}
+
+// encodeHeaders кодирует поля ответа. Динамическая таблица не
+// используется, поэтому кодировщику не нужно состояние: совпадения
+// берутся из статической таблицы, строки сжимаются Хаффманом, если так
+// короче.
+func encodeHeaders(dst []byte, fields []headerField) []byte {
+	for _, f := range fields {
+		nameIdx := 0
+		exact := 0
+		for i, s := range staticTable {
+			if s.name != f.name {
+				continue
+			}
+			if nameIdx == 0 {
+				nameIdx = i + 1
+			}
+			if s.value == f.value {
+				exact = i + 1
+				break
+			}
+		}
+		if exact > 0 {
+			dst = appendInt(dst, 0x80, 7, uint64(exact))
+			continue
+		}
+		// 6.2.2: литерал без индексации
+		dst = appendInt(dst, 0x00, 4, uint64(nameIdx))
+		if nameIdx == 0 {
+			dst = appendString(dst, f.name)
+		}
+		dst = appendString(dst, f.value)
+	}
+	return dst
+}
+
+func appendInt(dst []byte, flags byte, n uint, v uint64) []byte {
+	mask := uint64(1)<<n - 1
+	if v < mask {
+		return append(dst, flags|byte(v))
+	}
+	dst = append(dst, flags|byte(mask))
+	v -= mask
+	for v >= 0x80 {
+		dst = append(dst, byte(v&0x7F)|0x80)
+		v >>= 7
+	}
+	return append(dst, byte(v))
+}
+
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanEncodedLen(s); n < len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return huffmanEncode(dst, s)
+	}
+	dst = appendInt(dst, 0x00, 7, uint64(len(s)))
+	return append(dst, s...)
+}
@@ -0,0 +1,194 @@
+package h2
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("hex %q: %v", s, err)
+	}
+	return b
+}
+
+// RFC 7541 C.1: целые с префиксом.
+func TestReadInt(t *testing.T) {
+	tests := []struct {
+		in     string
+		prefix uint
+		want   uint64
+	}{
+		{"0a", 5, 10},       // C.1.1
+		{"1f9a0a", 5, 1337}, // C.1.2
+		{"2a", 8, 42},       // C.1.3
+	}
+	for _, tt := range tests {
+		got, rest, err := readInt(unhex(t, tt.in), tt.prefix)
+		if err != nil || got != tt.want || len(rest) != 0 {
+			t.Errorf("readInt(%s, %d) = %d, %x, %v; ожидалось %d", tt.in, tt.prefix, got, rest, err, tt.want)
+		}
+		if enc := appendInt(nil, 0, tt.prefix, tt.want); hex.EncodeToString(enc) != tt.in {
+			t.Errorf("appendInt(%d, %d) = %x; ожидалось %s", tt.want, tt.prefix, enc, tt.in)
+		}
+	}
+}
+
+type hpackStep struct {
+	block     string
+	want      []headerField
+	tableSize int
+}
+
+func runHPACK(t *testing.T, d *decoder, steps []hpackStep) {
+	t.Helper()
+	for i, step := range steps {
+		got, err := d.decode(unhex(t, step.block))
+		if err != nil {
+			t.Fatalf("блок %d: %v", i+1, err)
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("блок %d: поля %v; ожидалось %v", i+1, got, step.want)
+		}
+		if d.size != step.tableSize {
+			t.Errorf("блок %d: размер таблицы %d; ожидалось %d", i+1, d.size, step.tableSize)
+		}
+	}
+}
+
+// RFC 7541 C.2: отдельные представления полей.
+func TestDecodeFieldRepresentations(t *testing.T) {
+	tests := []struct {
+		name string
+		step hpackStep
+	}{
+		{"C.2.1 литерал с индексацией", hpackStep{
+			"400a 6375 7374 6f6d 2d6b 6579 0d63 7573 746f 6d2d 6865 6164 6572",
+			[]headerField{{"custom-key", "custom-header"}}, 55}},
+		{"C.2.2 литерал без индексации", hpackStep{
+			"040c 2f73 616d 706c 652f 7061 7468",
+			[]headerField{{":path", "/sample/path"}}, 0}},
+		{"C.2.3 никогда не индексируемый литерал", hpackStep{
+			"1008 7061 7373 776f 7264 0673 6563 7265 74",
+			[]headerField{{"password", "secret"}}, 0}},
+		{"C.2.4 индексированное поле", hpackStep{
+			"82",
+			[]headerField{{":method", "GET"}}, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runHPACK(t, newDecoder(), []hpackStep{tt.step})
+		})
+	}
+}
+
+var requestFields = [][]headerField{
+	{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"}},
+	{{":method", "GET"}, {":scheme", "http"}, {":path", "/"}, {":authority", "www.example.com"},
+		{"cache-control", "no-cache"}},
+	{{":method", "GET"}, {":scheme", "https"}, {":path", "/index.html"}, {":authority", "www.example.com"},
+		{"custom-key", "custom-value"}},
+}
+
+// RFC 7541 C.3: запросы без Хаффмана, общая динамическая таблица.
+func TestDecodeRequests(t *testing.T) {
+	runHPACK(t, newDecoder(), []hpackStep{
+		{"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d", requestFields[0], 57},
+		{"8286 84be 5808 6e6f 2d63 6163 6865", requestFields[1], 110},
+		{"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65", requestFields[2], 164},
+	})
+}
+
+// RFC 7541 C.4: те же запросы с кодом Хаффмана.
+func TestDecodeRequestsHuffman(t *testing.T) {
+	runHPACK(t, newDecoder(), []hpackStep{
+		{"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff", requestFields[0], 57},
+		{"8286 84be 5886 a8eb 1064 9cbf", requestFields[1], 110},
+		{"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf", requestFields[2], 164},
+	})
+}
+
+// RFC 7541 C.5: ответы с таблицей в 256 байт — записи вытесняются.
+func TestDecodeResponsesEviction(t *testing.T) {
+	d := newDecoder()
+	d.maxSize, d.limit = 256, 256
+	runHPACK(t, d, []hpackStep{
+		{"4803 3330 3258 0770 7269 7661 7465 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3120 474d 546e 1768 7474 7073 3a2f 2f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+			[]headerField{{":status", "302"}, {"cache-control", "private"},
+				{"date", "Mon, 21 Oct 2013 20:13:21 GMT"}, {"location", "https://www.example.com"}}, 222},
+		{"4803 3330 37c1 c0bf",
+			[]headerField{{":status", "307"}, {"cache-control", "private"},
+				{"date", "Mon, 21 Oct 2013 20:13:21 GMT"}, {"location", "https://www.example.com"}}, 222},
+		{"88c1 611d 4d6f 6e2c 2032 3120 4f63 7420 3230 3133 2032 303a 3133 3a32 3220 474d 54c0 5a04 677a 6970 7738 666f 6f3d 4153 444a 4b48 514b 425a 584f 5157 454f 5049 5541 5851 5745 4f49 553b 206d 6178 2d61 6765 3d33 3630 303b 2076 6572 7369 6f6e 3d31",
+			[]headerField{{":status", "200"}, {"cache-control", "private"},
+				{"date", "Mon, 21 Oct 2013 20:13:22 GMT"}, {"location", "https://www.example.com"},
+				{"content-encoding", "gzip"}, {"set-cookie", "foo=ASDJKHQKBZXOQWEOPIUAXQWEOIU; max-age=3600; version=1"}}, 215},
+	})
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		block string
+	}{
+		{"индекс 0", "80"},
+		{"индекс вне таблицы", "be"},
+		{"оборванная строка", "400a 6375 73"},
+		{"размер таблицы не в начале", "82 3fe1 1f"},
+		{"размер таблицы больше разрешённого", "3fe2 1f"},
+		{"переполнение целого", "ff ffff ffff ffff ffff ff7f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newDecoder().decode(unhex(t, tt.block)); !errors.Is(err, errCompression) {
+				t.Errorf("ошибка %v; ожидалась errCompression", err)
+			}
+		})
+	}
+}
+
+// Ссылки на длинную запись таблицы раздувают маленький блок: предел
+// считается по распакованному списку, а таблица остаётся согласованной.
+func TestDecodeHeaderListLimit(t *testing.T) {
+	d := newDecoder()
+	d.maxListSize = 1 << 10
+	value := strings.Repeat("x", 500)
+	block := appendInt(nil, 0x40, 6, 0)
+	block = appendString(block, "cookie")
+	block = appendInt(block, 0x00, 7, uint64(len(value)))
+	block = append(block, value...)
+	for i := 0; i < 100; i++ {
+		block = appendInt(block, 0x80, 7, uint64(len(staticTable)+1))
+	}
+	fields, err := d.decode(block)
+	if !errors.Is(err, errHeaderListSize) || fields != nil {
+		t.Fatalf("decode = %d полей, %v; ожидалась errHeaderListSize", len(fields), err)
+	}
+	// запись попала в таблицу, как у клиента: следующий блок на неё ссылается
+	fields, err = d.decode(appendInt(nil, 0x80, 7, uint64(len(staticTable)+1)))
+	if err != nil || len(fields) != 1 || fields[0].value != value {
+		t.Fatalf("следующий блок: %v", err)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	fields := []headerField{
+		{":status", "200"},
+		{"content-type", "text/html; charset=utf-8"},
+		{"x-request-id", "0123456789abcdef"},
+		{"set-cookie", "a=b; Path=/"},
+		{"x-empty", ""},
+	}
+	got, err := newDecoder().decode(encodeHeaders(nil, fields))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, fields) {
+		t.Errorf("после кодирования %v; ожидалось %v", got, fields)
+	}
+}
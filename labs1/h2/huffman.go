@@ -0,0 +1,91 @@
+package h2
+
+import "errors"
+
+var errHuffman = errors.New("hpack: некорректная строка Хаффмана")
+
+// huffNode — узел двоичного дерева декодирования: лист хранит символ.
+type huffNode struct {
+	next [2]*huffNode
+	sym  byte
+	leaf bool
+}
+
+var huffRoot = buildHuffmanTree()
+
+func buildHuffmanTree() *huffNode {
+	root := &huffNode{}
+	for sym, code := range huffmanCodes {
+		n := root
+		for i := int(huffmanCodeLen[sym]) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if n.next[bit] == nil {
+				n.next[bit] = &huffNode{}
+			}
+			n = n.next[bit]
+		}
+		n.leaf = true
+		n.sym = byte(sym)
+	}
+	return root
+}
+
+// huffmanDecode декодирует строку по дереву. Хвост допустим только как
+// префикс EOS: не длиннее 7 бит и из одних единиц (RFC 7541 5.2).
+func huffmanDecode(src []byte) ([]byte, error) {
+	dst := make([]byte, 0, len(src)*8/5)
+	n := huffRoot
+	pending := 0 // бит с начала текущего символа
+	allOnes := true
+	for _, b := range src {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			n = n.next[bit]
+			if n == nil {
+				// путь из 30 единиц — EOS, его в строке быть не должно
+				return nil, errHuffman
+			}
+			pending++
+			allOnes = allOnes && bit == 1
+			if n.leaf {
+				dst = append(dst, n.sym)
+				n = huffRoot
+				pending = 0
+				allOnes = true
+			}
+		}
+	}
+	if pending > 7 || !allOnes {
+		return nil, errHuffman
+	}
+	return dst, nil
+}
+
+// huffmanEncodedLen — длина строки после кодирования в байтах.
+func huffmanEncodedLen(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLen[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+// huffmanEncode дописывает код строки к dst, добивая последний байт
+// единицами (префиксом EOS).
+func huffmanEncode(dst []byte, s string) []byte {
+	var acc uint64
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		acc = acc<<huffmanCodeLen[s[i]] | uint64(huffmanCodes[s[i]])
+		bits += int(huffmanCodeLen[s[i]])
+		for bits >= 8 {
+			bits -= 8
+			dst = append(dst, byte(acc>>uint(bits)))
+		}
+	}
+	if bits > 0 {
+		acc = acc<<uint(8-bits) | (1<<uint(8-bits) - 1)
+		dst = append(dst, byte(acc))
+	}
+	return dst
+}
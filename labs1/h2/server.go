@@ -0,0 +1,957 @@
+// Package h2 — сервер HTTP/2 без TLS (h2c с prior knowledge, RFC 9113
+// 3.3) поверх обычного net.Conn: кадры, SETTINGS, HPACK, параллельные
+// потоки, окна управления потоком и GOAWAY. Обработчики те же, что у
+// httpraw, поэтому маршруты HTTP/1.1 работают и здесь.
+package h2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"labs1/guard"
+	"labs1/httpraw"
+)
+
+// Server обслуживает соединения HTTP/2.
+type Server struct {
+	Handler httpraw.Handler
+
+	// MaxConcurrentStreams — сколько потоков клиент может открыть
+	// одновременно; лишние получают RST_STREAM REFUSED_STREAM.
+	MaxConcurrentStreams uint32
+	// IdleTimeout — сколько держать соединение без активных потоков.
+	IdleTimeout time.Duration
+	// HeaderTimeout — за сколько должны прийти preface клиента и каждый
+	// блок заголовков вместе с CONTINUATION, иначе соединение закрывается.
+	HeaderTimeout time.Duration
+	// BodyTimeout — за сколько после HEADERS должно прийти тело запроса;
+	// обработчик не дождавшегося END_STREAM потока получит ошибку 408.
+	BodyTimeout time.Duration
+	// WriteTimeout ограничивает каждую запись в сокет; после истёкшей
+	// записи соединение закрывается.
+	WriteTimeout time.Duration
+	// MaxBodyBytes — размер тела запроса. Поток с большим content-length
+	// получает 413 без вызова обработчика, тело без него обрывается
+	// ошибкой 413 при чтении. 0 — как в httpraw.DefaultLimits, -1 — без
+	// ограничения.
+	MaxBodyBytes int64
+
+	Logf func(format string, args ...any)
+	// Rejected, если задан, считает отказы клиентам по причинам.
+	Rejected *guard.Counters
+
+	mu         sync.Mutex
+	conns      map[*serverConn]struct{}
+	inShutdown atomic.Bool
+}
+
+const (
+	defaultMaxStreams    = 100
+	defaultIdleTimeout   = 30 * time.Second
+	defaultHeaderTimeout = 10 * time.Second
+	defaultBodyTimeout   = 30 * time.Second
+	// Предел суммарного размера блока заголовков с CONTINUATION и
+	// распакованного списка заголовков (SETTINGS_MAX_HEADER_LIST_SIZE).
+	maxHeaderBlock = 64 << 10
+)
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+func (s *Server) reject(reason string) {
+	if s.Rejected != nil {
+		s.Rejected.Inc(reason)
+	}
+}
+
+func (s *Server) headerTimeout() time.Duration {
+	if s.HeaderTimeout > 0 {
+		return s.HeaderTimeout
+	}
+	return defaultHeaderTimeout
+}
+
+func (s *Server) bodyTimeout() time.Duration {
+	if s.BodyTimeout > 0 {
+		return s.BodyTimeout
+	}
+	return defaultBodyTimeout
+}
+
+func (s *Server) maxBodyBytes() int64 {
+	if s.MaxBodyBytes == 0 {
+		return httpraw.DefaultLimits.MaxBodyBytes
+	}
+	return s.MaxBodyBytes
+}
+
+// HasPreface проверяет, начинается ли поток с preface HTTP/2. Байты
+// сравниваются по мере прихода, поэтому короткий запрос HTTP/1.x не
+// заставит ждать 24 байта: "GET" отличается уже первым символом.
+func HasPreface(br *bufio.Reader) (bool, error) {
+	for i := 1; i <= len(Preface); i++ {
+		b, err := br.Peek(i)
+		if err != nil {
+			return false, err
+		}
+		if b[i-1] != Preface[i-1] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// serverConn — состояние одного соединения. Кадры читает только
+// ServeConn; писать могут все обработчики, поэтому запись под wmu.
+type serverConn struct {
+	srv  *Server
+	conn net.Conn
+	br   *bufio.Reader
+	dec  *decoder
+	tls  *tls.ConnectionState
+
+	wmu sync.Mutex
+	bw  *bufio.Writer
+
+	// mu защищает окна и таблицу потоков; cond будит писателей, когда
+	// окно открылось или поток закрыт.
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*stream
+	sendWindow        int64
+	recvWindow        int64
+	recvCredit        int64 // прочитано обработчиками, но ещё не возвращено клиенту
+	peerInitialWindow int64
+	peerMaxFrame      uint32
+	lastStreamID      uint32
+	goingAway         bool
+	closed            bool
+
+	handlers sync.WaitGroup
+	served   int
+}
+
+// ServeConn обслуживает соединение, из которого preface ещё не прочитан
+// (br может уже содержать его после HasPreface). Возвращает число
+// обработанных потоков. Соединение закрывает вызывающий.
+func (s *Server) ServeConn(conn net.Conn, br *bufio.Reader) int {
+	sc := &serverConn{
+		srv:               s,
+		conn:              conn,
+		br:                br,
+		dec:               newDecoder(),
+		bw:                bufio.NewWriterSize(deadlineWriter{conn: conn, d: s.WriteTimeout}, 16<<10),
+		streams:           make(map[uint32]*stream),
+		sendWindow:        defaultWindow,
+		recvWindow:        defaultWindow,
+		peerInitialWindow: defaultWindow,
+		peerMaxFrame:      defaultMaxFrame,
+	}
+	sc.dec.maxListSize = maxHeaderBlock
+	sc.cond = sync.NewCond(&sc.mu)
+	s.track(sc, true)
+	defer s.track(sc, false)
+	if tc, ok := conn.(*tls.Conn); ok {
+		st := tc.ConnectionState()
+		sc.tls = &st
+	}
+
+	err := sc.serve()
+	var ce *connError
+	if errors.As(err, &ce) {
+		s.logf("HTTP/2 %s: %v", conn.RemoteAddr(), err)
+		sc.goAway(ce.code, ce.reason)
+	} else if err != nil && !isClosedConn(err) {
+		s.logf("HTTP/2 %s: %v", conn.RemoteAddr(), err)
+	}
+
+	sc.shutdown()
+	sc.handlers.Wait()
+	return sc.served
+}
+
+func (s *Server) track(sc *serverConn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[*serverConn]struct{})
+	}
+	if add {
+		s.conns[sc] = struct{}{}
+	} else {
+		delete(s.conns, sc)
+	}
+}
+
+// Shutdown отправляет всем соединениям GOAWAY: новые потоки отклоняются,
+// начатые дорабатывают. Когда потоков не остаётся, соединение
+// закрывается. Если ctx истёк раньше, оставшиеся соединения рвутся.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+	s.mu.Lock()
+	for sc := range s.conns {
+		go sc.drain()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		n := len(s.conns)
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			for sc := range s.conns {
+				sc.conn.Close()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain — часть Shutdown для одного соединения. Если потоков уже нет,
+// будит читающую горутину, иначе это сделает последний закрытый поток.
+func (sc *serverConn) drain() {
+	sc.goAway(errCodeNo, "сервер останавливается")
+	sc.mu.Lock()
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+	if idle {
+		sc.conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (sc *serverConn) maxStreams() uint32 {
+	if sc.srv.MaxConcurrentStreams > 0 {
+		return sc.srv.MaxConcurrentStreams
+	}
+	return defaultMaxStreams
+}
+
+func (sc *serverConn) idleTimeout() time.Duration {
+	if sc.srv.IdleTimeout > 0 {
+		return sc.srv.IdleTimeout
+	}
+	return defaultIdleTimeout
+}
+
+func (sc *serverConn) serve() error {
+	sc.conn.SetReadDeadline(time.Now().Add(sc.srv.headerTimeout()))
+	preface := make([]byte, len(Preface))
+	if _, err := io.ReadFull(sc.br, preface); err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			sc.srv.reject(guard.HeaderTimeout)
+		}
+		return err
+	}
+	if string(preface) != Preface {
+		return connErrorf(errCodeProtocol, "неверный preface")
+	}
+
+	var settings []byte
+	settings = binary.BigEndian.AppendUint16(settings, settingMaxConcurrentStreams)
+	settings = binary.BigEndian.AppendUint32(settings, sc.maxStreams())
+	settings = binary.BigEndian.AppendUint16(settings, settingMaxHeaderListSize)
+	settings = binary.BigEndian.AppendUint32(settings, maxHeaderBlock)
+	if err := sc.writeFrame(frameSettings, 0, 0, settings); err != nil {
+		return err
+	}
+
+	first := true
+	for {
+		// Без потоков соединение ждёт не дольше IdleTimeout. С потоками
+		// ждём до ближайшего срока тела: клиент, отправивший запросы
+		// целиком, вправе молчать, пока обработчики отвечают.
+		// Дедлайн ставится под sc.mu, чтобы не затереть тот, что ставит
+		// forgetIfDone, когда закрывается последний поток.
+		sc.mu.Lock()
+		idle := len(sc.streams) == 0
+		if idle && sc.srv.inShutdown.Load() {
+			// GOAWAY уже отправил Shutdown
+			sc.mu.Unlock()
+			return nil
+		}
+		if idle {
+			sc.conn.SetReadDeadline(time.Now().Add(sc.idleTimeout()))
+		} else {
+			sc.conn.SetReadDeadline(sc.bodyDeadline())
+		}
+		sc.mu.Unlock()
+
+		// Peek ничего не забирает из буфера, поэтому истёкший срок тела
+		// не рвёт кадр посередине.
+		if _, err := sc.br.Peek(1); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				if sc.srv.inShutdown.Load() {
+					return nil
+				}
+				// потоки могли закрыться, пока мы ждали
+				sc.mu.Lock()
+				idle = len(sc.streams) == 0
+				sc.mu.Unlock()
+				if !idle {
+					sc.expireBodies()
+					continue
+				}
+				sc.goAway(errCodeNo, "соединение простаивает")
+				return nil
+			}
+			return err
+		}
+		// начатый кадр должен прийти целиком без задержек
+		sc.conn.SetReadDeadline(time.Now().Add(sc.srv.headerTimeout()))
+		h, payload, err := readFrame(sc.br, defaultMaxFrame)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				if sc.srv.inShutdown.Load() {
+					return nil
+				}
+				sc.srv.reject(guard.HeaderTimeout)
+				return connErrorf(errCodeNo, "кадр не получен за %v", sc.srv.headerTimeout())
+			}
+			return err
+		}
+		// RFC 9113 3.4: preface клиента завершается кадром SETTINGS
+		if first && h.typ != frameSettings {
+			return connErrorf(errCodeProtocol, "первый кадр %s, а не SETTINGS", h)
+		}
+		first = false
+
+		err = sc.processFrame(h, payload)
+		var se *streamError
+		if errors.As(err, &se) {
+			sc.resetStream(se.streamID, se.code)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (sc *serverConn) processFrame(h frameHeader, payload []byte) error {
+	switch h.typ {
+	case frameSettings:
+		return sc.processSettings(h, payload)
+	case frameHeaders:
+		return sc.processHeaders(h, payload)
+	case frameContinuation:
+		return connErrorf(errCodeProtocol, "CONTINUATION без HEADERS")
+	case frameData:
+		return sc.processData(h, payload)
+	case frameWindowUpdate:
+		return sc.processWindowUpdate(h, payload)
+	case framePing:
+		if h.length != 8 {
+			return connErrorf(errCodeFrameSize, "PING длиной %d", h.length)
+		}
+		if h.streamID != 0 {
+			return connErrorf(errCodeProtocol, "PING на потоке %d", h.streamID)
+		}
+		if h.flags&flagAck != 0 {
+			return nil
+		}
+		return sc.writeFrame(framePing, flagAck, 0, payload)
+	case frameRSTStream:
+		return sc.processRSTStream(h, payload)
+	case framePriority:
+		// приоритеты устарели (RFC 9113 5.3.2), проверяем только формат
+		if h.streamID == 0 {
+			return connErrorf(errCodeProtocol, "PRIORITY на потоке 0")
+		}
+		if h.length != 5 {
+			return streamErrorf(h.streamID, errCodeFrameSize, "PRIORITY длиной %d", h.length)
+		}
+		return nil
+	case frameGoAway:
+		if h.streamID != 0 {
+			return connErrorf(errCodeProtocol, "GOAWAY на потоке %d", h.streamID)
+		}
+		sc.mu.Lock()
+		sc.goingAway = true
+		sc.mu.Unlock()
+		if len(payload) >= 8 {
+			sc.srv.logf("HTTP/2 %s: клиент прислал GOAWAY, код %d %s", sc.conn.RemoteAddr(),
+				binary.BigEndian.Uint32(payload[4:]), payload[8:])
+		}
+		return nil
+	case framePushPromise:
+		return connErrorf(errCodeProtocol, "PUSH_PROMISE от клиента")
+	default:
+		// неизвестные типы кадров игнорируются (RFC 9113 4.1)
+		return nil
+	}
+}
+
+func (sc *serverConn) processSettings(h frameHeader, payload []byte) error {
+	if h.streamID != 0 {
+		return connErrorf(errCodeProtocol, "SETTINGS на потоке %d", h.streamID)
+	}
+	if h.flags&flagAck != 0 {
+		if h.length != 0 {
+			return connErrorf(errCodeFrameSize, "SETTINGS ACK с данными")
+		}
+		return nil
+	}
+	if len(payload)%6 != 0 {
+		return connErrorf(errCodeFrameSize, "SETTINGS длиной %d", len(payload))
+	}
+
+	for p := payload; len(p) > 0; p = p[6:] {
+		id := binary.BigEndian.Uint16(p)
+		val := binary.BigEndian.Uint32(p[2:])
+		switch id {
+		case settingEnablePush:
+			if val > 1 {
+				return connErrorf(errCodeProtocol, "ENABLE_PUSH = %d", val)
+			}
+		case settingInitialWindowSize:
+			if val > maxWindow {
+				return connErrorf(errCodeFlowControl, "INITIAL_WINDOW_SIZE = %d", val)
+			}
+			if err := sc.setInitialWindow(int64(val)); err != nil {
+				return err
+			}
+		case settingMaxFrameSize:
+			if val < defaultMaxFrame || val > maxFrameSizeLimit {
+				return connErrorf(errCodeProtocol, "MAX_FRAME_SIZE = %d", val)
+			}
+			sc.mu.Lock()
+			sc.peerMaxFrame = val
+			sc.mu.Unlock()
+		}
+		// HEADER_TABLE_SIZE не важен: наш кодировщик не пользуется
+		// динамической таблицей
+	}
+	return sc.writeFrame(frameSettings, flagAck, 0, nil)
+}
+
+// setInitialWindow применяет разницу нового и старого начального окна ко
+// всем открытым потокам (RFC 9113 6.9.2).
+func (sc *serverConn) setInitialWindow(val int64) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delta := val - sc.peerInitialWindow
+	sc.peerInitialWindow = val
+	for _, st := range sc.streams {
+		st.sendWindow += delta
+		if st.sendWindow > maxWindow {
+			return connErrorf(errCodeFlowControl, "окно потока %d переполнено", st.id)
+		}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processHeaders(h frameHeader, payload []byte) error {
+	if h.streamID == 0 || h.streamID%2 == 0 {
+		return connErrorf(errCodeProtocol, "HEADERS на потоке %d", h.streamID)
+	}
+	block, err := stripPadding(h, payload)
+	if err != nil {
+		return err
+	}
+	if h.flags&flagPriority != 0 {
+		if len(block) < 5 {
+			return connErrorf(errCodeFrameSize, "HEADERS без данных приоритета")
+		}
+		block = block[5:]
+	}
+
+	// Блок заголовков может продолжаться в CONTINUATION; между ними
+	// другие кадры запрещены.
+	block = append([]byte(nil), block...)
+	endHeaders := h.flags&flagEndHeaders != 0
+	if !endHeaders {
+		sc.conn.SetReadDeadline(time.Now().Add(sc.srv.headerTimeout()))
+	}
+	for !endHeaders {
+		ch, cp, err := readFrame(sc.br, defaultMaxFrame)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				sc.srv.reject(guard.HeaderTimeout)
+				return connErrorf(errCodeNo, "блок заголовков не получен за %v", sc.srv.headerTimeout())
+			}
+			return err
+		}
+		if ch.typ != frameContinuation || ch.streamID != h.streamID {
+			return connErrorf(errCodeProtocol, "ожидался CONTINUATION потока %d, пришёл %s", h.streamID, ch)
+		}
+		block = append(block, cp...)
+		if len(block) > maxHeaderBlock {
+			sc.srv.reject(guard.HeaderTooLarge)
+			return connErrorf(errCodeEnhanceYourCalm, "блок заголовков больше %d байт", maxHeaderBlock)
+		}
+		endHeaders = ch.flags&flagEndHeaders != 0
+	}
+
+	// Декодировать нужно всегда, даже если поток будет отклонён: иначе
+	// динамическая таблица HPACK разойдётся с клиентской.
+	fields, err := sc.dec.decode(block)
+	tooLarge := errors.Is(err, errHeaderListSize)
+	if err != nil && !tooLarge {
+		return connErrorf(errCodeCompression, "%v", err)
+	}
+	endStream := h.flags&flagEndStream != 0
+
+	sc.mu.Lock()
+	st, exists := sc.streams[h.streamID]
+	lastID := sc.lastStreamID
+	active := uint32(len(sc.streams))
+	goingAway := sc.goingAway
+	sc.mu.Unlock()
+
+	if tooLarge {
+		sc.srv.reject(guard.HeaderTooLarge)
+	}
+	if exists {
+		if tooLarge {
+			return streamErrorf(h.streamID, errCodeProtocol, "%v", err)
+		}
+		// второй HEADERS на потоке — трейлеры запроса
+		if st.remoteClosed || !endStream {
+			return streamErrorf(h.streamID, errCodeProtocol, "лишний HEADERS")
+		}
+		st.closeRemote(nil)
+		return nil
+	}
+	if h.streamID <= lastID {
+		return connErrorf(errCodeStreamClosed, "HEADERS на закрытом потоке %d", h.streamID)
+	}
+
+	sc.mu.Lock()
+	sc.lastStreamID = h.streamID
+	sc.mu.Unlock()
+
+	if tooLarge {
+		// таблица HPACK в порядке, поэтому сбрасываем только поток
+		return streamErrorf(h.streamID, errCodeProtocol, "%v", err)
+	}
+	if goingAway {
+		return streamErrorf(h.streamID, errCodeRefusedStream, "соединение закрывается")
+	}
+	if active >= sc.maxStreams() {
+		return streamErrorf(h.streamID, errCodeRefusedStream, "больше %d потоков", sc.maxStreams())
+	}
+
+	req, err := sc.newRequest(h.streamID, fields)
+	if err != nil {
+		return err
+	}
+	if endStream && req.ContentLength < 0 {
+		// HEADERS с END_STREAM: тела точно нет, как у запроса HTTP/1.1
+		// без Content-Length
+		req.ContentLength = 0
+	}
+	st = sc.newStream(h.streamID, req)
+	handler := sc.srv.Handler
+	if max := sc.srv.maxBodyBytes(); max >= 0 && req.ContentLength > max {
+		// отвечаем сами, тело выбрасываем; после ответа closeLocal
+		// сбросит поток
+		sc.srv.reject(guard.BodyTooLarge)
+		st.rejected = true
+		handler = httpraw.HandlerFunc(func(w httpraw.ResponseWriter, r *httpraw.Request) {
+			httpraw.Error(w, fmt.Sprintf("Тело запроса больше %d байт", max), 413)
+		})
+	}
+	if endStream {
+		st.closeRemote(nil)
+	} else {
+		st.bodyDeadline = time.Now().Add(sc.srv.bodyTimeout())
+	}
+
+	sc.served++
+	req.Seq = sc.served
+	sc.handlers.Add(1)
+	go sc.runHandler(st, req, handler)
+	return nil
+}
+
+// newRequest собирает httpraw.Request из псевдозаголовков и полей
+// (RFC 9113 8.3).
+func (sc *serverConn) newRequest(id uint32, fields []headerField) (*httpraw.Request, error) {
+	req := &httpraw.Request{
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     make(httpraw.Header),
+		RemoteAddr: sc.conn.RemoteAddr().String(),
+		TLS:        sc.tls,
+	}
+	var scheme string
+	regular := false
+	var cookies []string
+	for _, f := range fields {
+		if strings.ToLower(f.name) != f.name {
+			return nil, streamErrorf(id, errCodeProtocol, "имя поля %q не в нижнем регистре", f.name)
+		}
+		// 8.2.1: CR, LF и NUL в значении делают сообщение некорректным —
+		// иначе поле, записанное дальше в HTTP/1.1, разорвёт заголовок
+		if !httpraw.ValidFieldValue(f.value) {
+			return nil, streamErrorf(id, errCodeProtocol, "недопустимые символы в значении поля %s", f.name)
+		}
+		if strings.HasPrefix(f.name, ":") {
+			if regular {
+				return nil, streamErrorf(id, errCodeProtocol, "псевдозаголовок после обычных полей")
+			}
+			var dst *string
+			switch f.name {
+			case ":method":
+				dst = &req.Method
+			case ":path":
+				dst = &req.RequestURI
+			case ":authority":
+				dst = &req.Host
+			case ":scheme":
+				dst = &scheme
+			default:
+				return nil, streamErrorf(id, errCodeProtocol, "неизвестный псевдозаголовок %s", f.name)
+			}
+			if *dst != "" {
+				return nil, streamErrorf(id, errCodeProtocol, "повтор %s", f.name)
+			}
+			*dst = f.value
+			continue
+		}
+		regular = true
+		if !httpraw.ValidFieldName(f.name) {
+			return nil, streamErrorf(id, errCodeProtocol, "некорректное имя поля %q", f.name)
+		}
+		switch f.name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			return nil, streamErrorf(id, errCodeProtocol, "поле %s запрещено в HTTP/2", f.name)
+		case "te":
+			if f.value != "trailers" {
+				return nil, streamErrorf(id, errCodeProtocol, "te: %s", f.value)
+			}
+		case "cookie":
+			// 8.2.3: cookie может прийти несколькими полями
+			cookies = append(cookies, f.value)
+			continue
+		}
+		req.Header.Add(f.name, f.value)
+	}
+	if len(cookies) > 0 {
+		req.Header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+
+	if req.Method == "" || scheme == "" || req.RequestURI == "" {
+		return nil, streamErrorf(id, errCodeProtocol, "нет обязательных псевдозаголовков")
+	}
+	if !httpraw.ValidFieldName(req.Method) {
+		return nil, streamErrorf(id, errCodeProtocol, "некорректный метод %q", req.Method)
+	}
+	u, err := url.ParseRequestURI(req.RequestURI)
+	if err != nil {
+		return nil, streamErrorf(id, errCodeProtocol, "некорректный :path %q", req.RequestURI)
+	}
+	req.Path = u.Path
+	req.RawQuery = u.RawQuery
+	if req.Host == "" {
+		req.Host = req.Header.Get("Host")
+	}
+	if req.Host != "" && !httpraw.ValidHost(req.Host) {
+		return nil, streamErrorf(id, errCodeProtocol, "некорректный :authority %q", req.Host)
+	}
+
+	req.ContentLength = -1
+	if cl := req.Header.Get("Content-Length"); cl != "" {
+		n, err := strconv.ParseInt(cl, 10, 64)
+		if err != nil || n < 0 {
+			return nil, streamErrorf(id, errCodeProtocol, "некорректный content-length %q", cl)
+		}
+		req.ContentLength = n
+	}
+	return req, nil
+}
+
+func (sc *serverConn) runHandler(st *stream, req *httpraw.Request, handler httpraw.Handler) {
+	defer sc.handlers.Done()
+	w := newResponseWriter(st, req)
+	handler.ServeHTTP(w, req)
+	if err := w.finish(); err != nil && !isClosedConn(err) {
+		sc.srv.logf("HTTP/2 поток %d: %v", st.id, err)
+	}
+	st.closeLocal()
+}
+
+func (sc *serverConn) processData(h frameHeader, payload []byte) error {
+	if h.streamID == 0 {
+		return connErrorf(errCodeProtocol, "DATA на потоке 0")
+	}
+	// окно расходуется на весь кадр вместе с заполнителем
+	size := int64(h.length)
+	sc.mu.Lock()
+	if size > sc.recvWindow {
+		sc.mu.Unlock()
+		return connErrorf(errCodeFlowControl, "клиент превысил окно соединения")
+	}
+	sc.recvWindow -= size
+	st := sc.streams[h.streamID]
+	lastID := sc.lastStreamID
+	sc.mu.Unlock()
+
+	data, err := stripPadding(h, payload)
+	if err != nil {
+		return err
+	}
+
+	if st == nil || st.remoteClosed {
+		// данные для уже закрытого потока: окно соединения всё равно
+		// нужно вернуть, иначе оно исчерпается
+		sc.credit(nil, size)
+		if h.streamID > lastID {
+			return connErrorf(errCodeProtocol, "DATA на неоткрытом потоке %d", h.streamID)
+		}
+		return streamErrorf(h.streamID, errCodeStreamClosed, "DATA после закрытия потока")
+	}
+
+	if err := st.receive(data, size); err != nil {
+		sc.credit(nil, size)
+		return err
+	}
+	// заполнитель обработчику не достанется — сразу возвращаем его в окна
+	sc.credit(st, size-int64(len(data)))
+
+	if h.flags&flagEndStream != 0 {
+		if st.req.ContentLength >= 0 && st.received != st.req.ContentLength {
+			return streamErrorf(h.streamID, errCodeProtocol, "тело %d байт вместо content-length %d",
+				st.received, st.req.ContentLength)
+		}
+		st.closeRemote(nil)
+	}
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(h frameHeader, payload []byte) error {
+	if len(payload) != 4 {
+		return connErrorf(errCodeFrameSize, "WINDOW_UPDATE длиной %d", len(payload))
+	}
+	inc := int64(binary.BigEndian.Uint32(payload) & maxWindow)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if h.streamID == 0 {
+		if inc == 0 {
+			return connErrorf(errCodeProtocol, "WINDOW_UPDATE с нулевым приращением")
+		}
+		sc.sendWindow += inc
+		if sc.sendWindow > maxWindow {
+			return connErrorf(errCodeFlowControl, "окно соединения переполнено")
+		}
+	} else {
+		st := sc.streams[h.streamID]
+		if st == nil {
+			if h.streamID > sc.lastStreamID {
+				return connErrorf(errCodeProtocol, "WINDOW_UPDATE на неоткрытом потоке %d", h.streamID)
+			}
+			return nil
+		}
+		if inc == 0 {
+			return streamErrorf(h.streamID, errCodeProtocol, "WINDOW_UPDATE с нулевым приращением")
+		}
+		st.sendWindow += inc
+		if st.sendWindow > maxWindow {
+			return streamErrorf(h.streamID, errCodeFlowControl, "окно потока переполнено")
+		}
+	}
+	sc.cond.Broadcast()
+	return nil
+}
+
+func (sc *serverConn) processRSTStream(h frameHeader, payload []byte) error {
+	if h.streamID == 0 {
+		return connErrorf(errCodeProtocol, "RST_STREAM на потоке 0")
+	}
+	if len(payload) != 4 {
+		return connErrorf(errCodeFrameSize, "RST_STREAM длиной %d", len(payload))
+	}
+	sc.mu.Lock()
+	st := sc.streams[h.streamID]
+	lastID := sc.lastStreamID
+	sc.mu.Unlock()
+	if st == nil {
+		if h.streamID > lastID {
+			return connErrorf(errCodeProtocol, "RST_STREAM на неоткрытом потоке %d", h.streamID)
+		}
+		return nil
+	}
+	st.cancel(fmt.Errorf("клиент сбросил поток, код %d", binary.BigEndian.Uint32(payload)))
+	return nil
+}
+
+// resetStream отправляет RST_STREAM и освобождает поток.
+func (sc *serverConn) resetStream(id, code uint32) {
+	sc.mu.Lock()
+	st := sc.streams[id]
+	sc.mu.Unlock()
+	if st != nil {
+		st.cancel(fmt.Errorf("поток сброшен сервером, код %d", code))
+	}
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], code)
+	sc.writeFrame(frameRSTStream, 0, id, payload[:])
+}
+
+// credit возвращает клиенту прочитанные байты окна. WINDOW_UPDATE
+// отправляется не на каждый Read, а когда накопилась половина окна.
+func (sc *serverConn) credit(st *stream, n int64) {
+	if n <= 0 {
+		return
+	}
+	sc.mu.Lock()
+	sc.recvCredit += n
+	connInc := int64(0)
+	if sc.recvCredit >= defaultWindow/2 {
+		connInc = sc.recvCredit
+		sc.recvWindow += connInc
+		sc.recvCredit = 0
+	}
+	streamInc := int64(0)
+	if st != nil && !st.remoteClosed {
+		st.recvCredit += n
+		if st.recvCredit >= defaultWindow/2 {
+			streamInc = st.recvCredit
+			st.recvWindow += streamInc
+			st.recvCredit = 0
+		}
+	}
+	sc.mu.Unlock()
+
+	if connInc > 0 {
+		sc.writeWindowUpdate(0, connInc)
+	}
+	if streamInc > 0 {
+		sc.writeWindowUpdate(st.id, streamInc)
+	}
+}
+
+func (sc *serverConn) writeWindowUpdate(id uint32, inc int64) {
+	var payload [4]byte
+	binary.BigEndian.PutUint32(payload[:], uint32(inc))
+	sc.writeFrame(frameWindowUpdate, 0, id, payload[:])
+}
+
+// bodyDeadline — ближайший срок тела среди потоков, которые ещё его
+// передают; нулевое время — таких нет. Вызывается под sc.mu.
+func (sc *serverConn) bodyDeadline() time.Time {
+	var earliest time.Time
+	for _, st := range sc.streams {
+		if st.remoteClosed || st.bodyDeadline.IsZero() {
+			continue
+		}
+		if earliest.IsZero() || st.bodyDeadline.Before(earliest) {
+			earliest = st.bodyDeadline
+		}
+	}
+	return earliest
+}
+
+// expireBodies обрывает ошибкой 408 тела, не полученные вовремя.
+// Обработчик ещё может ответить, а оставшиеся DATA будут выброшены.
+func (sc *serverConn) expireBodies() {
+	now := time.Now()
+	var expired []*stream
+	sc.mu.Lock()
+	for _, st := range sc.streams {
+		if !st.remoteClosed && !st.bodyDeadline.IsZero() && !now.Before(st.bodyDeadline) {
+			st.bodyDeadline = time.Time{}
+			expired = append(expired, st)
+		}
+	}
+	sc.mu.Unlock()
+	for _, st := range expired {
+		sc.srv.reject(guard.BodyTimeout)
+		st.rejectBody(&httpraw.StatusError{Status: 408,
+			Reason: fmt.Sprintf("тело запроса не получено за %v", sc.srv.bodyTimeout())})
+	}
+}
+
+// writeFrame отправляет один кадр и сразу сбрасывает буфер в сеть.
+func (sc *serverConn) writeFrame(typ, flags byte, streamID uint32, payload []byte) error {
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	hdr := appendFrameHeader(make([]byte, 0, frameHeaderLen), len(payload), typ, flags, streamID)
+	sc.bw.Write(hdr)
+	sc.bw.Write(payload)
+	return sc.bw.Flush()
+}
+
+// goAway сообщает клиенту номер последнего обработанного потока и
+// причину закрытия.
+func (sc *serverConn) goAway(code uint32, reason string) {
+	sc.mu.Lock()
+	sc.goingAway = true
+	last := sc.lastStreamID
+	sc.mu.Unlock()
+
+	var payload bytes.Buffer
+	binary.Write(&payload, binary.BigEndian, last)
+	binary.Write(&payload, binary.BigEndian, code)
+	payload.WriteString(reason)
+	sc.writeFrame(frameGoAway, 0, 0, payload.Bytes())
+}
+
+// shutdown будит и отменяет все потоки после того, как чтение кадров
+// прекратилось.
+func (sc *serverConn) shutdown() {
+	sc.mu.Lock()
+	sc.closed = true
+	streams := make([]*stream, 0, len(sc.streams))
+	for _, st := range sc.streams {
+		streams = append(streams, st)
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+	for _, st := range streams {
+		st.cancel(errors.New("соединение закрыто"))
+	}
+}
+
+// deadlineWriter ставит дедлайн перед каждой записью в сокет. После
+// истёкшей записи bufio.Writer соединения всё равно непригоден, поэтому
+// соединение закрывается — это разбудит и читающую горутину.
+type deadlineWriter struct {
+	conn net.Conn
+	d    time.Duration
+}
+
+func (w deadlineWriter) Write(p []byte) (int, error) {
+	if w.d > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.d))
+	}
+	n, err := w.conn.Write(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		w.conn.Close()
+	}
+	return n, err
+}
+
+func isClosedConn(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,70 @@
+package h2
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// fields собирает обязательные псевдозаголовки GET / и добавляет extra.
+func fields(extra ...string) []headerField {
+	f := []headerField{{":method", "GET"}, {":scheme", "https"}, {":path", "/p?q=1"}, {":authority", "example.com"}}
+	for i := 0; i+1 < len(extra); i += 2 {
+		f = append(f, headerField{extra[i], extra[i+1]})
+	}
+	return f
+}
+
+func TestNewRequest(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	sc := &serverConn{conn: c1}
+
+	req, err := sc.newRequest(1, fields("cookie", "a=1", "x-id", "7", "cookie", "b=2", "content-length", "3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Method != "GET" || req.Path != "/p" || req.RawQuery != "q=1" || req.Host != "example.com" {
+		t.Errorf("запрос %s %s ? %s, Host %q", req.Method, req.Path, req.RawQuery, req.Host)
+	}
+	if got := req.Header.Get("Cookie"); got != "a=1; b=2" {
+		t.Errorf("Cookie = %q; ожидалось склеенное a=1; b=2", got)
+	}
+	if req.Header.Get("X-Id") != "7" || req.ContentLength != 3 {
+		t.Errorf("X-Id = %q, ContentLength = %d", req.Header.Get("X-Id"), req.ContentLength)
+	}
+
+	tests := []struct {
+		name   string
+		fields []headerField
+	}{
+		{"имя в верхнем регистре", fields("X-Id", "1")},
+		{"имя не token", fields("x id", "1")},
+		{"двоеточие в имени", fields("x:id", "1")},
+		{"CRLF в значении", fields("x-id", "1\r\nx-injected: 2")},
+		{"LF в значении", fields("x-id", "1\nx")},
+		{"NUL в значении", fields("x-id", "1\x00")},
+		{"пробел в начале значения", fields("x-id", " 1")},
+		{"табуляция в конце значения", fields("x-id", "1\t")},
+		{"метод не token", []headerField{{":method", "GET /x HTTP/1.1\r\n"}, {":scheme", "https"}, {":path", "/"}}},
+		{"метод с пробелом", []headerField{{":method", "G T"}, {":scheme", "https"}, {":path", "/"}}},
+		{"CRLF в :authority", []headerField{{":method", "GET"}, {":scheme", "https"}, {":path", "/"}, {":authority", "a\r\nx: 1"}}},
+		{":authority с userinfo", []headerField{{":method", "GET"}, {":scheme", "https"}, {":path", "/"}, {":authority", "user@a.example"}}},
+		{":authority с путём", []headerField{{":method", "GET"}, {":scheme", "https"}, {":path", "/"}, {":authority", "a.example/x"}}},
+		{"нечисловой порт", []headerField{{":method", "GET"}, {":scheme", "https"}, {":path", "/"}, {":authority", "a.example:http"}}},
+		{"кривой host без :authority", []headerField{{":method", "GET"}, {":scheme", "https"}, {":path", "/"}, {"host", "a b"}}},
+		{"CRLF в :path", []headerField{{":method", "GET"}, {":scheme", "https"}, {":path", "/a\r\nb"}}},
+		{"псевдозаголовок после полей", []headerField{{":method", "GET"}, {"x-id", "1"}, {":scheme", "https"}, {":path", "/"}}},
+		{"connection", fields("connection", "close")},
+		{"te не trailers", fields("te", "gzip")},
+		{"нет :path", []headerField{{":method", "GET"}, {":scheme", "https"}}},
+	}
+	for _, tt := range tests {
+		_, err := sc.newRequest(3, tt.fields)
+		var se *streamError
+		if !errors.As(err, &se) || se.code != errCodeProtocol || se.streamID != 3 {
+			t.Errorf("%s: ошибка %v; ожидался PROTOCOL_ERROR потока 3", tt.name, err)
+		}
+	}
+}
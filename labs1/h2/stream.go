@@ -0,0 +1,430 @@
+package h2
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"labs1/guard"
+	"labs1/httpraw"
+)
+
+// stream — один поток: запрос клиента и ответ обработчика. Поля окон и
+// состояния защищены sc.mu.
+type stream struct {
+	id  uint32
+	sc  *serverConn
+	req *httpraw.Request
+
+	sendWindow   int64
+	recvWindow   int64
+	recvCredit   int64
+	remoteClosed bool // клиент прислал END_STREAM
+	localClosed  bool // мы отправили END_STREAM
+	resetErr     error
+
+	// Поля ниже меняет только читающая горутина.
+	received     int64     // байт тела получено
+	bodyDeadline time.Time // срок тела, нулевой — не ограничен
+	rejected     bool      // тело отвергнуто (413, 408), DATA выбрасываются
+	body         streamBody
+}
+
+func (sc *serverConn) newStream(id uint32, req *httpraw.Request) *stream {
+	st := &stream{id: id, sc: sc, req: req}
+	st.body.st = st
+	st.body.cond = sync.NewCond(&st.body.mu)
+	req.Body = &st.body
+
+	sc.mu.Lock()
+	st.sendWindow = sc.peerInitialWindow
+	st.recvWindow = defaultWindow
+	sc.streams[id] = st
+	sc.mu.Unlock()
+	return st
+}
+
+// receive учитывает DATA в окне потока и передаёт данные обработчику.
+func (st *stream) receive(data []byte, size int64) error {
+	st.sc.mu.Lock()
+	if size > st.recvWindow {
+		st.sc.mu.Unlock()
+		return streamErrorf(st.id, errCodeFlowControl, "клиент превысил окно потока")
+	}
+	st.recvWindow -= size
+	st.sc.mu.Unlock()
+
+	st.received += int64(len(data))
+	if st.req.ContentLength >= 0 && st.received > st.req.ContentLength {
+		return streamErrorf(st.id, errCodeProtocol, "тело длиннее content-length")
+	}
+	if max := st.sc.srv.maxBodyBytes(); !st.rejected && max >= 0 && st.received > max {
+		st.sc.srv.reject(guard.BodyTooLarge)
+		st.rejectBody(&httpraw.StatusError{Status: 413,
+			Reason: fmt.Sprintf("тело больше допустимых %d байт", max)})
+	}
+	if st.rejected {
+		// окно соединения возвращаем сразу, окно потока — нет: клиент
+		// скоро остановится, а ответ обработчика ещё можно отправить
+		st.sc.credit(nil, int64(len(data)))
+		return nil
+	}
+	st.body.write(data)
+	return nil
+}
+
+// rejectBody обрывает тело ошибкой: обработчик получит её после уже
+// принятых данных, а следующие DATA будут выброшены.
+func (st *stream) rejectBody(err error) {
+	st.rejected = true
+	st.body.close(err)
+}
+
+// closeRemote — клиент закончил отправку (END_STREAM).
+func (st *stream) closeRemote(err error) {
+	st.sc.mu.Lock()
+	st.remoteClosed = true
+	st.sc.mu.Unlock()
+	if err == nil {
+		err = io.EOF
+	}
+	st.body.close(err)
+	st.forgetIfDone()
+}
+
+// closeLocal вызывается после того, как ответ отправлен. Если клиент ещё
+// передаёт тело, которое уже никому не нужно, поток сбрасывается с
+// NO_ERROR (RFC 9113 8.1).
+func (st *stream) closeLocal() {
+	st.sc.mu.Lock()
+	st.localClosed = true
+	remoteOpen := !st.remoteClosed && st.resetErr == nil
+	st.sc.mu.Unlock()
+	if remoteOpen {
+		st.sc.resetStream(st.id, errCodeNo)
+		return
+	}
+	st.forgetIfDone()
+}
+
+// cancel прерывает поток: обработчик получит ошибку при чтении тела и
+// записи ответа.
+func (st *stream) cancel(err error) {
+	st.sc.mu.Lock()
+	if st.resetErr == nil {
+		st.resetErr = err
+	}
+	st.remoteClosed = true
+	st.localClosed = true
+	st.sc.cond.Broadcast()
+	st.sc.mu.Unlock()
+	st.body.close(err)
+	st.forgetIfDone()
+}
+
+// forgetIfDone удаляет закрытый с обеих сторон поток из таблицы и
+// возвращает в окно соединения то, что обработчик так и не прочитал.
+func (st *stream) forgetIfDone() {
+	sc := st.sc
+	sc.mu.Lock()
+	done := st.remoteClosed && st.localClosed
+	if done {
+		delete(sc.streams, st.id)
+		switch {
+		case len(sc.streams) > 0:
+		case sc.srv.inShutdown.Load():
+			// последний поток при остановке: будим чтение, чтобы закрыться
+			sc.conn.SetReadDeadline(time.Now())
+		default:
+			// соединение простаивает — читающая горутина ждёт без срока
+			sc.conn.SetReadDeadline(time.Now().Add(sc.idleTimeout()))
+		}
+	}
+	sc.mu.Unlock()
+	if done {
+		sc.credit(nil, int64(st.body.discard()))
+	}
+}
+
+// streamBody — тело запроса, которое наполняет читающая горутина
+// соединения, а вычитывает обработчик.
+type streamBody struct {
+	st   *stream
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	err  error
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	for b.buf.Len() == 0 && b.err == nil {
+		b.cond.Wait()
+	}
+	if b.buf.Len() > 0 {
+		n, _ := b.buf.Read(p)
+		b.mu.Unlock()
+		b.st.sc.credit(b.st, int64(n))
+		return n, nil
+	}
+	err := b.err
+	b.mu.Unlock()
+	return 0, err
+}
+
+func (b *streamBody) write(p []byte) {
+	b.mu.Lock()
+	if b.err == nil {
+		b.buf.Write(p)
+		b.cond.Signal()
+	}
+	b.mu.Unlock()
+}
+
+func (b *streamBody) close(err error) {
+	b.mu.Lock()
+	if b.err == nil {
+		b.err = err
+	}
+	b.cond.Broadcast()
+	b.mu.Unlock()
+}
+
+// discard выбрасывает непрочитанное и возвращает его размер.
+func (b *streamBody) discard() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.buf.Len()
+	b.buf.Reset()
+	return n
+}
+
+// Сколько ответа копится в памяти до первой отправки: небольшой ответ
+// уходит одним HEADERS с content-length.
+const maxBufferedBody = 16 << 10
+
+// responseWriter реализует httpraw.ResponseWriter и httpraw.Flusher
+// поверх потока HTTP/2.
+type responseWriter struct {
+	st  *stream
+	req *httpraw.Request
+
+	header      httpraw.Header
+	status      int
+	wroteHeader bool
+	sentHeader  bool
+	buf         bytes.Buffer
+}
+
+func newResponseWriter(st *stream, req *httpraw.Request) *responseWriter {
+	return &responseWriter{st: st, req: req, header: make(httpraw.Header)}
+}
+
+func (w *responseWriter) Header() httpraw.Header { return w.header }
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	// код вне 100–999 не уложить в статусную строку — это ошибка
+	// обработчика, клиент получит 500
+	if code < 100 || code > 999 {
+		code = 500
+	}
+	w.status = code
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if !bodyAllowed(w.status) {
+		return 0, fmt.Errorf("ответ %d не может содержать тело", w.status)
+	}
+	if w.sentHeader {
+		return len(b), w.writeData(b, false)
+	}
+	w.buf.Write(b)
+	if w.buf.Len() > maxBufferedBody {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush отправляет заголовки и накопленное тело, не завершая поток.
+func (w *responseWriter) Flush() error {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if !w.sentHeader {
+		if err := w.writeHeaders(false); err != nil {
+			return err
+		}
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	err := w.writeData(w.buf.Bytes(), false)
+	w.buf.Reset()
+	return err
+}
+
+func (w *responseWriter) finish() error {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if !w.sentHeader {
+		if bodyAllowed(w.status) && w.header.Get("Content-Length") == "" {
+			w.header.Set("Content-Length", strconv.Itoa(w.buf.Len()))
+		}
+		if w.buf.Len() == 0 || w.req.Method == "HEAD" {
+			return w.writeHeaders(true)
+		}
+		if err := w.writeHeaders(false); err != nil {
+			return err
+		}
+	}
+	err := w.writeData(w.buf.Bytes(), true)
+	w.buf.Reset()
+	return err
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != 204 && status != 304
+}
+
+// Поля, которые в HTTP/2 запрещены (RFC 9113 8.2.2).
+var connectionSpecific = map[string]bool{
+	"connection": true, "keep-alive": true, "proxy-connection": true,
+	"transfer-encoding": true, "upgrade": true,
+}
+
+// writeHeaders кодирует заголовки ответа и отправляет HEADERS и, если блок
+// не влез в кадр, CONTINUATION — подряд, без чужих кадров между ними.
+func (w *responseWriter) writeHeaders(endStream bool) error {
+	w.sentHeader = true
+	if w.header.Get("Date") == "" {
+		w.header.Set("Date", time.Now().UTC().Format(httpraw.TimeFormat))
+	}
+	if bodyAllowed(w.status) && w.header.Get("Content-Type") == "" && (w.buf.Len() > 0 || !endStream) {
+		w.header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	fields := []headerField{{":status", strconv.Itoa(w.status)}}
+	keys := make([]string, 0, len(w.header))
+	for k := range w.header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := strings.ToLower(k)
+		if connectionSpecific[name] {
+			continue
+		}
+		for _, v := range w.header[k] {
+			fields = append(fields, headerField{name, v})
+		}
+	}
+	block := encodeHeaders(nil, fields)
+
+	sc := w.st.sc
+	sc.mu.Lock()
+	if err := w.st.writeErr(); err != nil {
+		sc.mu.Unlock()
+		return err
+	}
+	maxFrame := int(sc.peerMaxFrame)
+	sc.mu.Unlock()
+
+	sc.wmu.Lock()
+	defer sc.wmu.Unlock()
+	typ := byte(frameHeaders)
+	for first := true; first || len(block) > 0; first = false {
+		chunk := block
+		if len(chunk) > maxFrame {
+			chunk = chunk[:maxFrame]
+		}
+		block = block[len(chunk):]
+		var flags byte
+		if len(block) == 0 {
+			flags |= flagEndHeaders
+		}
+		if first && endStream {
+			flags |= flagEndStream
+		}
+		sc.bw.Write(appendFrameHeader(nil, len(chunk), typ, flags, w.st.id))
+		sc.bw.Write(chunk)
+		typ = frameContinuation
+	}
+	return sc.bw.Flush()
+}
+
+// writeData отправляет тело кадрами DATA, не превышая окна потока и
+// соединения: если окно закрыто, ждёт WINDOW_UPDATE от клиента.
+func (w *responseWriter) writeData(data []byte, endStream bool) error {
+	if w.req.Method == "HEAD" {
+		data = nil
+		if !endStream {
+			return nil
+		}
+	}
+	sc := w.st.sc
+	for {
+		sc.mu.Lock()
+		n := 0
+		for {
+			if err := w.st.writeErr(); err != nil {
+				sc.mu.Unlock()
+				return err
+			}
+			if len(data) == 0 {
+				break
+			}
+			n = len(data)
+			n = int(min(int64(n), int64(sc.peerMaxFrame), w.st.sendWindow, sc.sendWindow))
+			if n > 0 {
+				break
+			}
+			sc.cond.Wait()
+		}
+		w.st.sendWindow -= int64(n)
+		sc.sendWindow -= int64(n)
+		sc.mu.Unlock()
+
+		chunk := data[:n]
+		data = data[n:]
+		var flags byte
+		if endStream && len(data) == 0 {
+			flags = flagEndStream
+		}
+		if len(chunk) > 0 || flags != 0 {
+			if err := sc.writeFrame(frameData, flags, w.st.id, chunk); err != nil {
+				return err
+			}
+		}
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// writeErr сообщает, почему в поток больше нельзя писать. Вызывается под
+// sc.mu.
+func (st *stream) writeErr() error {
+	if st.resetErr != nil {
+		return st.resetErr
+	}
+	if st.sc.closed {
+		return errors.New("соединение закрыто")
+	}
+	return nil
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"labs1/httpraw"
+)
+
+// Диагностические маршруты в духе httpbin.org: клиенты, прокси и
+// сниффер labs5 можно проверять на :8080 без внешних сервисов. Ответы —
+// JSON с тем, что сервер увидел в запросе.
+
+// Ограничения, чтобы диагностика не стала способом занять сервер.
+const (
+	httpbinMaxDelay     = 10 * time.Second
+	httpbinMaxBytes     = 100 << 10
+	httpbinMaxStream    = 100
+	httpbinMaxRedirects = 100
+	httpbinMaxBody      = 10 << 20
+)
+
+func registerHTTPBin(rt *httpraw.Router) {
+	rt.HandleFunc("GET", "/get", httpbinGet)
+	rt.HandleFunc("GET", "/headers", httpbinHeaders)
+	rt.HandleFunc("GET", "/ip", httpbinIP)
+	rt.HandleFunc("", "/delay/{n}", httpbinDelay)
+	rt.HandleFunc("", "/status/{code}", httpbinStatus)
+	rt.HandleFunc("GET", "/bytes/{n}", httpbinBytes)
+	rt.HandleFunc("GET", "/stream/{n}", httpbinStream)
+	rt.HandleFunc("GET", "/redirect/{n}", httpbinRedirect)
+	rt.HandleFunc("GET", "/drip", httpbinDrip)
+	rt.HandleFunc("", "/anything", httpbinAnything)
+	rt.HandleFunc("", "/anything/{path...}", httpbinAnything)
+}
+
+// httpbinRequest — описание запроса в ответах /get, /stream и других.
+type httpbinRequest struct {
+	Args    map[string]any    `json:"args"`
+	Headers map[string]string `json:"headers"`
+	Origin  string            `json:"origin"`
+	URL     string            `json:"url"`
+}
+
+// httpbinBody — то, что /anything добавляет к httpbinRequest: метод и
+// тело с разбором. Поля есть всегда, как у httpbin.org.
+type httpbinBody struct {
+	Method string           `json:"method"`
+	Data   string           `json:"data"`
+	Files  map[string]any   `json:"files"`
+	Form   map[string]any   `json:"form"`
+	JSON   *json.RawMessage `json:"json"`
+}
+
+func describeRequest(req *httpraw.Request) httpbinRequest {
+	query, _ := url.ParseQuery(req.RawQuery)
+	return httpbinRequest{
+		Args:    flatten(query),
+		Headers: httpbinHeaderMap(req.Header),
+		Origin:  remoteHost(req.RemoteAddr),
+		URL:     requestURL(req),
+	}
+}
+
+// flatten превращает url.Values в объект JSON: одно значение — строка,
+// несколько — массив.
+func flatten(v url.Values) map[string]any {
+	m := make(map[string]any, len(v))
+	for k, vs := range v {
+		if len(vs) == 1 {
+			m[k] = vs[0]
+		} else {
+			m[k] = vs
+		}
+	}
+	return m
+}
+
+func httpbinHeaderMap(h httpraw.Header) map[string]string {
+	m := make(map[string]string, len(h))
+	for k, vs := range h {
+		m[k] = strings.Join(vs, ", ")
+	}
+	return m
+}
+
+func requestURL(req *httpraw.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + req.RequestURI
+}
+
+func writeJSON(w httpraw.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+// httpbinError отвечает JSON {"error": ...}, как и остальные маршруты.
+func httpbinError(w httpraw.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+func httpbinGet(w httpraw.ResponseWriter, req *httpraw.Request) {
+	writeJSON(w, 200, describeRequest(req))
+}
+
+func httpbinHeaders(w httpraw.ResponseWriter, req *httpraw.Request) {
+	writeJSON(w, 200, map[string]any{"headers": httpbinHeaderMap(req.Header)})
+}
+
+func httpbinIP(w httpraw.ResponseWriter, req *httpraw.Request) {
+	writeJSON(w, 200, map[string]string{"origin": remoteHost(req.RemoteAddr)})
+}
+
+// httpbinAnything возвращает запрос целиком: метод, аргументы, тело и
+// его разбор — форму, файлы multipart или JSON.
+func httpbinAnything(w httpraw.ResponseWriter, req *httpraw.Request) {
+	d := httpbinBody{Method: req.Method}
+	if err := readHTTPBinBody(req, &d); err != nil {
+		code := httpraw.StatusCode(err)
+		if code == 0 {
+			code = 400
+		}
+		// тело могло остаться недочитанным
+		w.Header().Set("Connection", "close")
+		httpbinError(w, code, err.Error())
+		return
+	}
+	writeJSON(w, 200, struct {
+		httpbinRequest
+		httpbinBody
+	}{describeRequest(req), d})
+}
+
+// readHTTPBinBody заполняет data, form, files и json. Тело читается в
+// память целиком, поэтому ограничено httpbinMaxBody.
+func readHTTPBinBody(req *httpraw.Request, d *httpbinBody) error {
+	data, err := io.ReadAll(io.LimitReader(req.Body, httpbinMaxBody+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > httpbinMaxBody {
+		return &httpraw.StatusError{Status: 413, Reason: "тело длиннее " + strconv.Itoa(httpbinMaxBody) + " байт"}
+	}
+	d.Form = map[string]any{}
+	d.Files = map[string]any{}
+
+	mediaType := ""
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, _ = httpraw.ParseMediaType(ct)
+	}
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := httpraw.ParseURLEncoded(string(data))
+		if err != nil {
+			return err
+		}
+		d.Form = flatten(form)
+		data = nil
+	case strings.HasPrefix(mediaType, "multipart/"):
+		req.Body = bytes.NewReader(data)
+		if err := readHTTPBinMultipart(req, d); err != nil {
+			return err
+		}
+		data = nil
+	case json.Valid(data):
+		raw := json.RawMessage(data)
+		d.JSON = &raw
+	}
+
+	d.Data = bodyString(data, mediaType)
+	return nil
+}
+
+func readHTTPBinMultipart(req *httpraw.Request, d *httpbinBody) error {
+	mr, err := req.MultipartReader()
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return err
+		}
+		partType, _, _ := httpraw.ParseMediaType(part.Header.Get("Content-Type"))
+		if part.FileName() != "" {
+			d.Files[part.FormName()] = bodyString(content, partType)
+			continue
+		}
+		form.Add(part.FormName(), string(content))
+	}
+	d.Form = flatten(form)
+	return nil
+}
+
+// bodyString — тело как строка; двоичное тело, как и у httpbin.org,
+// отдаётся data: URL в base64.
+func bodyString(data []byte, mediaType string) string {
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// httpbinDelay отвечает как /anything через n секунд (не больше 10).
+func httpbinDelay(w httpraw.ResponseWriter, req *httpraw.Request) {
+	n, err := strconv.ParseFloat(req.Param("n"), 64)
+	if err != nil || n < 0 || math.IsNaN(n) {
+		httpbinError(w, 400, "задержка — неотрицательное число секунд")
+		return
+	}
+	// ограничиваем до перевода в Duration: 1e300 секунд переполняют int64
+	delay := time.Duration(min(n, httpbinMaxDelay.Seconds()) * float64(time.Second))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-rawServer.Done():
+		httpbinError(w, 503, "сервер останавливается")
+		return
+	}
+	httpbinAnything(w, req)
+}
+
+// httpbinStatus отвечает кодом из пути. Через запятую можно перечислить
+// несколько кодов с весами, код выбирается случайно: /status/200:3,503.
+func httpbinStatus(w httpraw.ResponseWriter, req *httpraw.Request) {
+	var codes []int
+	var weights []float64
+	total := 0.0
+	for _, item := range strings.Split(req.Param("code"), ",") {
+		codeStr, weightStr, hasWeight := strings.Cut(item, ":")
+		code, err := strconv.Atoi(codeStr)
+		if err != nil || code < 200 || code > 599 {
+			httpbinError(w, 400, "некорректный код статуса "+strconv.Quote(item))
+			return
+		}
+		weight := 1.0
+		if hasWeight {
+			if weight, err = strconv.ParseFloat(weightStr, 64); err != nil || weight < 0 {
+				httpbinError(w, 400, "некорректный вес "+strconv.Quote(item))
+				return
+			}
+		}
+		codes = append(codes, code)
+		weights = append(weights, weight)
+		total += weight
+	}
+
+	code := codes[len(codes)-1]
+	pick := rand.Float64() * total
+	for i, weight := range weights {
+		if pick < weight {
+			code = codes[i]
+			break
+		}
+		pick -= weight
+	}
+
+	switch {
+	case code >= 300 && code < 400 && code != 304:
+		w.Header().Set("Location", "/redirect/1")
+	case code == 401:
+		w.Header().Set("WWW-Authenticate", `Basic realm="httpbin"`)
+	case code == 407:
+		w.Header().Set("Proxy-Authenticate", `Basic realm="httpbin"`)
+	}
+	w.WriteHeader(code)
+}
+
+// httpbinBytes отдаёт n случайных байт; с ?seed= — всегда одни и те же.
+func httpbinBytes(w httpraw.ResponseWriter, req *httpraw.Request) {
+	n, ok := httpbinCount(w, req.Param("n"), httpbinMaxBytes)
+	if !ok {
+		return
+	}
+	seed := time.Now().UnixNano()
+	if s := req.Query().Get("seed"); s != "" {
+		var err error
+		if seed, err = strconv.ParseInt(s, 10, 64); err != nil {
+			httpbinError(w, 400, "seed — целое число")
+			return
+		}
+	}
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(n))
+	w.Write(data)
+}
+
+// httpbinStream отдаёт n строк JSON, отправляя каждую сразу: ответ идёт
+// чанками.
+func httpbinStream(w httpraw.ResponseWriter, req *httpraw.Request) {
+	n, ok := httpbinCount(w, req.Param("n"), httpbinMaxStream)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	flusher, _ := w.(httpraw.Flusher)
+	d := describeRequest(req)
+	for i := 0; i < n; i++ {
+		line, _ := json.Marshal(struct {
+			ID int `json:"id"`
+			httpbinRequest
+		}{i, d})
+		line = append(line, '\n')
+		if _, err := w.Write(line); err != nil {
+			return
+		}
+		if flusher != nil {
+			if err := flusher.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// httpbinRedirect перенаправляет n раз (302) и заканчивается на /get.
+// С ?absolute=true Location содержит полный URL.
+func httpbinRedirect(w httpraw.ResponseWriter, req *httpraw.Request) {
+	n, ok := httpbinCount(w, req.Param("n"), httpbinMaxRedirects)
+	if !ok {
+		return
+	}
+	if n == 0 {
+		httpbinError(w, 400, "число перенаправлений должно быть больше 0")
+		return
+	}
+	target := "/get"
+	if n > 1 {
+		target = "/redirect/" + strconv.Itoa(n-1)
+	}
+	if req.RawQuery != "" {
+		target += "?" + req.RawQuery
+	}
+	if req.Query().Get("absolute") == "true" {
+		u, _ := url.Parse(requestURL(req))
+		target = u.Scheme + "://" + u.Host + target
+	}
+	w.Header().Set("Location", target)
+	w.WriteHeader(302)
+}
+
+// httpbinDrip выдаёт numbytes байт "*" равномерно за duration секунд
+// после задержки delay — для проверки таймаутов чтения у клиентов.
+func httpbinDrip(w httpraw.ResponseWriter, req *httpraw.Request) {
+	q := req.Query()
+	param := func(name string, def float64) (float64, bool) {
+		s := q.Get(name)
+		if s == "" {
+			return def, true
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		// NaN не меньше нуля и не больше предела — проверяем отдельно
+		if err != nil || v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			httpbinError(w, 400, name+" — неотрицательное число")
+			return 0, false
+		}
+		return v, true
+	}
+	duration, ok1 := param("duration", 2)
+	numbytes, ok2 := param("numbytes", 10)
+	delay, ok3 := param("delay", 0)
+	if !ok1 || !ok2 || !ok3 {
+		return
+	}
+	code := 200
+	if s := q.Get("code"); s != "" {
+		var err error
+		if code, err = strconv.Atoi(s); err != nil {
+			httpbinError(w, 400, "code — целое число")
+			return
+		}
+	}
+	// drip всегда отдаёт numbytes байт с Content-Length, а у 1xx, 204 и
+	// 304 тела быть не может (RFC 9110 6.4.1): клиент принял бы его за
+	// начало следующего ответа
+	if code == 204 || code == 304 {
+		httpbinError(w, 400, "ответ "+strconv.Itoa(code)+" не может содержать тело")
+		return
+	}
+	if code < 200 || code > 599 || numbytes > httpbinMaxBytes ||
+		duration+delay > 2*httpbinMaxDelay.Seconds() {
+		httpbinError(w, 400, "параметры вне допустимых пределов")
+		return
+	}
+
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return true
+		case <-rawServer.Done():
+			return false
+		}
+	}
+	if !wait(time.Duration(delay * float64(time.Second))) {
+		httpbinError(w, 503, "сервер останавливается")
+		return
+	}
+
+	n := int(numbytes)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(n))
+	w.WriteHeader(code)
+	flusher, _ := w.(httpraw.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	pause := time.Duration(duration * float64(time.Second))
+	if n > 0 {
+		pause /= time.Duration(n)
+	}
+	for i := 0; i < n; i++ {
+		if !wait(pause) {
+			return
+		}
+		if _, err := w.Write([]byte{'*'}); err != nil {
+			return
+		}
+		if flusher != nil {
+			if err := flusher.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// httpbinCount разбирает число из пути и ограничивает его сверху.
+func httpbinCount(w httpraw.ResponseWriter, s string, limit int) (int, bool) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		httpbinError(w, 400, "ожидается неотрицательное целое, получено "+strconv.Quote(s))
+		return 0, false
+	}
+	return min(n, limit), true
+}
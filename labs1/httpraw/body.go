@@ -0,0 +1,116 @@
+package httpraw
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// Максимальная длина строки с размером чанка.
+const maxChunkLine = 4096
+
+// chunkedReader декодирует тело с Transfer-Encoding: chunked
+// (RFC 9112 7.1). Расширения чанков игнорируются, трейлеры после
+// последнего чанка складываются в *trailer (Request.Trailer или
+// Response.Trailer).
+type chunkedReader struct {
+	p       *Parser
+	trailer *Header
+	limit   int64 // -1 — без ограничения
+	// header ограничивает трейлер так же, как заголовок: числом полей и
+	// общим размером
+	header Limits
+
+	left  int64 // сколько байт осталось в текущем чанке
+	total int64
+	done  bool
+	err   error
+}
+
+func (c *chunkedReader) Read(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.done {
+		return 0, io.EOF
+	}
+	if c.left == 0 {
+		if err := c.beginChunk(); err != nil {
+			c.err = err
+			return 0, err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+	}
+
+	if int64(len(b)) > c.left {
+		b = b[:c.left]
+	}
+	n, err := c.p.r.Read(b)
+	c.left -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil && c.left == 0 {
+		err = c.endChunk()
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// beginChunk читает строку "размер[;расширения]".
+func (c *chunkedReader) beginChunk() error {
+	line, err := c.p.readLine(maxChunkLine, 400)
+	if err != nil {
+		return err
+	}
+	if i := bytes.IndexByte(line, ';'); i >= 0 {
+		line = line[:i]
+	}
+	line = bytes.TrimRight(line, " \t")
+	if len(line) == 0 || len(line) > 16 {
+		return errorf(400, "некорректный размер чанка %q", line)
+	}
+	size, err := strconv.ParseInt(string(line), 16, 64)
+	if err != nil || size < 0 {
+		return errorf(400, "некорректный размер чанка %q", line)
+	}
+
+	if size == 0 {
+		c.done = true
+		return c.readTrailer()
+	}
+
+	c.total += size
+	if c.limit >= 0 && c.total > c.limit {
+		return errorf(413, "тело больше допустимых %d байт", c.limit)
+	}
+	c.left = size
+	return nil
+}
+
+// endChunk проверяет CRLF после данных чанка.
+func (c *chunkedReader) endChunk() error {
+	line, err := c.p.readLine(2, 400)
+	if err != nil {
+		return err
+	}
+	if len(line) != 0 {
+		return errorf(400, "после данных чанка ожидался CRLF")
+	}
+	return nil
+}
+
+func (c *chunkedReader) readTrailer() error {
+	trailer, err := c.p.readHeader(c.header)
+	if err != nil {
+		return err
+	}
+	if len(trailer) > 0 {
+		*c.trailer = trailer
+	}
+	return nil
+}
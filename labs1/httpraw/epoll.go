@@ -0,0 +1,29 @@
+package httpraw
+
+import (
+	"bufio"
+	"net"
+	"time"
+)
+
+// EpollOptions — настройки ServeEpoll.
+type EpollOptions struct {
+	// Workers — сколько обработчиков запросов работает одновременно.
+	Workers int
+
+	// Opened и Closed вызываются при открытии и закрытии соединения;
+	// served — число запросов на нём.
+	Opened func(conn net.Conn)
+	Closed func(conn net.Conn, served int, lifetime time.Duration)
+
+	// Detach получает соединение, которое начинается с preface HTTP/2:
+	// цикл epoll обслуживает только HTTP/1.x. В br — уже прочитанные
+	// байты и остаток соединения. Без Detach такие соединения
+	// закрываются.
+	Detach func(conn net.Conn, br *bufio.Reader)
+}
+
+const defaultEpollWorkers = 16
+
+// h2Preface — начало соединения HTTP/2 с prior knowledge (RFC 9113 3.4).
+const h2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
@@ -0,0 +1,660 @@
+//go:build linux
+
+package httpraw
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"labs1/guard"
+)
+
+// ServeEpoll — вторая модель обслуживания соединений: вместо горутины с
+// буфером на каждое соединение один цикл epoll(7) следит за всеми
+// сокетами, а запросы выполняет небольшой пул обработчиков.
+//
+// Простаивающее keep-alive соединение в этой модели стоит дескриптор и
+// небольшую структуру: ни стека горутины, ни буфера чтения. Сокеты
+// неблокирующие; байты копятся в буфере соединения, пока запрос не
+// придёт целиком вместе с телом, и только тогда он уходит в пул.
+// Поэтому тело запроса держится в памяти (до Limits.MaxBodyBytes), а
+// долгий ответ (поток событий, WebSocket после Hijack) занимает
+// обработчик пула до конца.
+//
+// Принимает соединения одна горутина через l.Accept, чтобы работали
+// обёртки слушателя (лимиты guard). Возвращается, когда l закрыт.
+func (s *Server) ServeEpoll(l net.Listener, opts EpollOptions) error {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("epoll_create1: %w", err)
+	}
+	e := &epollServer{s: s, opts: opts, epfd: epfd, conns: make(map[int32]*epollConn)}
+	e.ready = sync.NewCond(&e.qmu)
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultEpollWorkers
+	}
+	for i := 0; i < workers; i++ {
+		go e.worker()
+	}
+	go e.loop()
+
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			e.mu.Lock()
+			e.listenerClosed = true
+			e.mu.Unlock()
+			return nil
+		}
+		if err != nil {
+			s.logf("Ошибка принятия соединения: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		if err := e.add(conn); err != nil {
+			s.logf("Соединение %s не добавлено в epoll: %v", conn.RemoteAddr(), err)
+			conn.Close()
+		}
+	}
+}
+
+type epollServer struct {
+	s    *Server
+	opts EpollOptions
+	epfd int
+
+	// mu защищает conns и все вызовы epoll_ctl и Close: иначе номер
+	// закрытого дескриптора мог бы достаться новому соединению между
+	// закрытием и перевзводом старого.
+	mu             sync.Mutex
+	conns          map[int32]*epollConn
+	nextID         int32
+	listenerClosed bool
+
+	// очередь соединений с готовыми данными для пула
+	qmu   sync.Mutex
+	ready *sync.Cond
+	queue []*epollConn
+	stop  bool
+}
+
+// Состояния соединения.
+const (
+	connIdle   = iota // ждёт данных в epoll
+	connBusy          // у обработчика пула
+	connClosed        // закрыто или отдано (Hijack, HTTP/2)
+)
+
+type epollConn struct {
+	id     int32
+	fd     int
+	conn   net.Conn
+	opened time.Time
+
+	// поля ниже меняются под epollServer.mu
+	state     int
+	since     time.Time // начало простоя или первого байта запроса
+	wantWrite chan struct{}
+
+	// поля ниже принадлежат обработчику, у которого соединение
+	in           []byte // принятые, но не обработанные байты
+	need         int    // меньше стольких байт запрос заведомо не полный
+	chunks       chunkScan
+	served       int
+	sentContinue bool
+}
+
+// rawFD достаёт дескриптор сокета. Дескриптор остаётся у net.Conn: его
+// же закрывает conn.Close, а Hijack отдаёт обработчику обычный net.Conn.
+func rawFD(conn net.Conn) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, fmt.Errorf("соединение %T без дескриптора", conn)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	fd := -1
+	if err := rc.Control(func(v uintptr) { fd = int(v) }); err != nil {
+		return 0, err
+	}
+	return fd, nil
+}
+
+func (e *epollServer) add(conn net.Conn) error {
+	fd, err := rawFD(conn)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	c := &epollConn{fd: fd, conn: conn, opened: now, since: now}
+
+	e.s.totalConns.Add(1)
+	e.s.activeConns.Add(1)
+	if !e.s.setState(conn, false) {
+		e.s.activeConns.Add(-1)
+		e.s.forget(conn)
+		return errors.New("сервер останавливается")
+	}
+	if e.opts.Opened != nil {
+		e.opts.Opened(conn)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.nextID++
+	c.id = e.nextID
+	e.conns[c.id] = c
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT, Fd: c.id}
+	if err := syscall.EpollCtl(e.epfd, syscall.EPOLL_CTL_ADD, fd, &ev); err != nil {
+		e.closeLocked(c)
+		return err
+	}
+	return nil
+}
+
+// loop ждёт событий epoll и раз в секунду закрывает простаивающие
+// соединения. Завершается, когда слушатель закрыт и соединений нет.
+func (e *epollServer) loop() {
+	events := make([]syscall.EpollEvent, 256)
+	lastSweep := time.Now()
+	for {
+		n, err := syscall.EpollWait(e.epfd, events, 1000)
+		if err != nil && err != syscall.EINTR {
+			e.s.logf("epoll_wait: %v", err)
+			time.Sleep(10 * time.Millisecond)
+		}
+		for _, ev := range events[:max(n, 0)] {
+			e.dispatch(ev.Fd)
+		}
+		if now := time.Now(); now.Sub(lastSweep) >= time.Second || e.s.inShutdown.Load() {
+			lastSweep = now
+			if e.sweep(now) {
+				break
+			}
+		}
+	}
+	syscall.Close(e.epfd)
+	e.qmu.Lock()
+	e.stop = true
+	e.qmu.Unlock()
+	e.ready.Broadcast()
+}
+
+// dispatch отдаёт соединение в пул или будит обработчик, ждущий
+// возможности писать.
+func (e *epollServer) dispatch(id int32) {
+	e.mu.Lock()
+	c := e.conns[id]
+	if c == nil {
+		e.mu.Unlock()
+		return
+	}
+	if c.wantWrite != nil {
+		close(c.wantWrite)
+		c.wantWrite = nil
+		e.mu.Unlock()
+		return
+	}
+	if c.state != connIdle {
+		e.mu.Unlock()
+		return
+	}
+	c.state = connBusy
+	e.mu.Unlock()
+
+	e.qmu.Lock()
+	e.queue = append(e.queue, c)
+	e.qmu.Unlock()
+	e.ready.Signal()
+}
+
+// sweep закрывает соединения, простоявшие дольше IdleTimeout, и
+// недописанные запросы старше HeaderTimeout+BodyTimeout (с ответом 408).
+// При остановке сервера закрываются все простаивающие. true — цикл
+// можно завершать.
+func (e *epollServer) sweep(now time.Time) bool {
+	shutdown := e.s.inShutdown.Load()
+	var timedOut []*epollConn
+
+	e.mu.Lock()
+	for _, c := range e.conns {
+		if c.state != connIdle {
+			continue
+		}
+		partial := len(c.in) > 0
+		switch {
+		case partial && now.Sub(c.since) > e.requestTimeout(c):
+			timedOut = append(timedOut, c)
+		case !partial && (shutdown || now.Sub(c.since) > e.s.idleTimeout()):
+			e.closeLocked(c)
+		}
+	}
+	for _, c := range timedOut {
+		// соединение забирается из epoll, чтобы ответ не пересёкся с
+		// обработчиком
+		c.state = connBusy
+	}
+	done := e.listenerClosed && len(e.conns) == 0
+	e.mu.Unlock()
+
+	for _, c := range timedOut {
+		e.s.reject(guard.ReasonForStatus(408))
+		WriteError(epollWriter{e, c}, errorf(408, "запрос не получен целиком за %v", e.requestTimeout(c)))
+		e.close(c)
+	}
+	return done
+}
+
+// requestTimeout — сколько ждать начатый запрос: заголовкам даётся
+// HeaderTimeout, а после них ещё BodyTimeout на тело.
+func (e *epollServer) requestTimeout(c *epollConn) time.Duration {
+	if bytes.Contains(c.in, []byte("\r\n\r\n")) || bytes.Contains(c.in, []byte("\n\n")) {
+		return e.s.headerTimeout() + e.s.bodyTimeout()
+	}
+	return e.s.headerTimeout()
+}
+
+func (e *epollServer) worker() {
+	buf := make([]byte, 64<<10)
+	for {
+		e.qmu.Lock()
+		for len(e.queue) == 0 && !e.stop {
+			e.ready.Wait()
+		}
+		if e.stop {
+			e.qmu.Unlock()
+			return
+		}
+		c := e.queue[0]
+		e.queue[0] = nil
+		e.queue = e.queue[1:]
+		e.qmu.Unlock()
+
+		e.handle(c, buf)
+	}
+}
+
+// handle читает всё, что есть в сокете, обслуживает полные запросы и
+// возвращает соединение в epoll.
+func (e *epollServer) handle(c *epollConn, buf []byte) {
+	for {
+		n, err := syscall.Read(c.fd, buf)
+		if n > 0 {
+			if len(c.in) == 0 {
+				c.since = time.Now()
+			}
+			c.in = append(c.in, buf[:n]...)
+			if n == len(buf) {
+				continue
+			}
+			break
+		}
+		if err == syscall.EINTR {
+			continue
+		}
+		if err == syscall.EAGAIN {
+			break
+		}
+		// 0 байт — клиент закрыл соединение
+		if err != nil && !errors.Is(err, syscall.ECONNRESET) {
+			e.s.logf("Ошибка чтения запроса от %s: %v", c.conn.RemoteAddr(), err)
+		}
+		e.close(c)
+		return
+	}
+
+	for len(c.in) > 0 && len(c.in) >= c.need {
+		if c.served == 0 && e.isH2(c) {
+			if len(c.in) < len(h2Preface) {
+				break
+			}
+			e.detachH2(c)
+			return
+		}
+		req, size, err := e.nextRequest(c)
+		if err != nil {
+			if code := StatusCode(err); code != 0 {
+				e.s.reject(guard.ReasonForStatus(code))
+				WriteError(epollWriter{e, c}, err)
+			}
+			e.s.logf("Ошибка чтения запроса от %s: %v", c.conn.RemoteAddr(), err)
+			e.close(c)
+			return
+		}
+		if size == 0 {
+			if req != nil && req.ExpectContinue() && !c.sentContinue {
+				c.sentContinue = true
+				io.WriteString(epollWriter{e, c}, "HTTP/1.1 100 Continue\r\n\r\n")
+			}
+			break
+		}
+		keep, hijacked := e.serve(c, req, size)
+		if hijacked {
+			return
+		}
+		if !keep {
+			e.close(c)
+			return
+		}
+	}
+	if len(c.in) == 0 {
+		// простаивающему соединению буфер не нужен
+		c.in = nil
+		c.since = time.Now()
+	}
+	e.rearm(c)
+}
+
+func (e *epollServer) isH2(c *epollConn) bool {
+	n := min(len(c.in), len(h2Preface))
+	return string(c.in[:n]) == h2Preface[:n]
+}
+
+// nextRequest разбирает запрос из начала буфера. size == 0 — запрос
+// пришёл не целиком; req тогда может быть уже разобран (для Expect).
+func (e *epollServer) nextRequest(c *epollConn) (*Request, int, error) {
+	limits := e.s.Limits.withDefaults()
+	rd := bytes.NewReader(c.in)
+	p := NewParser(rd, nil)
+	p.Limits = e.s.Limits
+	consumed := func() int { return len(c.in) - rd.Len() - p.Buffered() }
+
+	req, err := p.ReadRequest()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if !req.Chunked {
+		// длина известна: до неё буфер можно не разбирать заново
+		head := consumed()
+		if total := head + int(req.ContentLength); len(c.in) < total {
+			c.need = total
+			return req, 0, nil
+		}
+	} else if limits.MaxBodyBytes >= 0 && int64(len(c.in)) > int64(limits.MaxHeaderBytes)+2*limits.MaxBodyBytes {
+		// тело chunked крупнее лимита даже с учётом разметки
+		return nil, 0, errorf(413, "тело больше допустимых %d байт", limits.MaxBodyBytes)
+	} else {
+		// разметка проверяется с места, где остановилась в прошлый раз:
+		// тело, пришедшее мелкими порциями, не разбирается заново с
+		// начала на каждом событии
+		if c.chunks.pos == 0 {
+			c.chunks.pos = consumed()
+		}
+		if need, done := c.chunks.scan(c.in, limits.MaxBodyBytes); !done {
+			c.need = need
+			return req, 0, nil
+		}
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err == io.ErrUnexpectedEOF {
+		return req, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if cr, ok := req.Body.(*chunkedReader); ok && !cr.done {
+		return req, 0, nil
+	}
+	req.Body = bytes.NewReader(body)
+	c.need = 0
+	c.chunks = chunkScan{}
+	return req, consumed(), nil
+}
+
+// chunkScan проходит по разметке chunked тела, не копируя данные, и
+// помнит, где остановился.
+type chunkScan struct {
+	pos   int   // начало первого чанка, пришедшего не целиком
+	total int64 // сумма размеров пройденных чанков
+}
+
+// scan продолжает просмотр in. need — сколько байт нужно буферу, чтобы
+// продвинуться дальше. done — тело с трейлером пришло целиком, или
+// разметка сломана, или тело больше limit: разбор и ошибку оставляем
+// парсеру.
+func (cs *chunkScan) scan(in []byte, limit int64) (need int, done bool) {
+	for {
+		eol := bytes.IndexByte(in[cs.pos:], '\n')
+		if eol < 0 {
+			if len(in)-cs.pos > maxChunkLine {
+				return 0, true
+			}
+			return len(in) + 1, false
+		}
+		field := in[cs.pos : cs.pos+eol]
+		if i := bytes.IndexByte(field, ';'); i >= 0 {
+			field = field[:i]
+		}
+		field = bytes.TrimRight(field, " \t\r")
+		size, err := strconv.ParseInt(string(field), 16, 64)
+		if err != nil || size < 0 || len(field) > 16 {
+			return 0, true
+		}
+		if size == 0 {
+			// трейлер ограничен лимитами заголовка: его можно
+			// просматривать целиком
+			for p := cs.pos + eol + 1; ; {
+				i := bytes.IndexByte(in[p:], '\n')
+				if i < 0 {
+					return len(in) + 1, false
+				}
+				if len(bytes.TrimSuffix(in[p:p+i], []byte("\r"))) == 0 {
+					return 0, true
+				}
+				p += i + 1
+			}
+		}
+		cs.total += size
+		if limit >= 0 && cs.total > limit {
+			return 0, true
+		}
+		end := int64(cs.pos+eol+1) + size + 2 // данные и CRLF после них
+		if end > int64(len(in)) {
+			// чанк будет просмотрен заново, когда придёт целиком
+			cs.total -= size
+			return int(min(end, math.MaxInt)), false
+		}
+		cs.pos = int(end)
+	}
+}
+
+// serve выполняет обработчик для полного запроса из первых size байт
+// буфера. keep — соединение остаётся открытым.
+func (e *epollServer) serve(c *epollConn, req *Request, size int) (keep, hijacked bool) {
+	s := e.s
+	s.setState(c.conn, true)
+	c.served++
+	c.sentContinue = false
+	s.totalRequests.Add(1)
+	req.RemoteAddr = c.conn.RemoteAddr().String()
+	req.Seq = c.served
+
+	bw := bufio.NewWriterSize(epollWriter{e, c}, 4096)
+	resp := newResponse(c.conn, nil, bw, req)
+	resp.detach = func() *bufio.Reader {
+		rest := append([]byte(nil), c.in[size:]...)
+		e.detach(c)
+		return bufio.NewReader(io.MultiReader(bytes.NewReader(rest), c.conn))
+	}
+	resp.keepAlive = s.wantsKeepAlive(req) && c.served < s.maxRequests() && !s.inShutdown.Load()
+	if resp.keepAlive && !req.ProtoAtLeast(1, 1) {
+		resp.keepAliveHint = fmt.Sprintf("timeout=%d, max=%d",
+			int(s.idleTimeout().Seconds()), s.maxRequests()-c.served)
+	}
+
+	s.Handler.ServeHTTP(resp, req)
+	if resp.hijacked {
+		// как и при горутине на соединение, после Hijack соединение живёт,
+		// пока работает обработчик
+		c.conn.Close()
+		if e.opts.Closed != nil {
+			e.opts.Closed(c.conn, c.served, time.Since(c.opened))
+		}
+		return false, true
+	}
+	if s.inShutdown.Load() {
+		resp.keepAlive = false
+	}
+	resp.finish()
+	if err := bw.Flush(); err != nil {
+		if !isClosedConn(err) {
+			s.logf("Ошибка отправки ответа %s: %v", c.conn.RemoteAddr(), err)
+		}
+		return false, false
+	}
+	c.in = c.in[size:]
+	return resp.keepAlive, false
+}
+
+// rearm возвращает соединение в epoll ждать следующих байт.
+func (e *epollServer) rearm(c *epollConn) {
+	if !e.s.setState(c.conn, false) {
+		e.close(c)
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c.state == connClosed {
+		return
+	}
+	c.state = connIdle
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT, Fd: c.id}
+	if err := syscall.EpollCtl(e.epfd, syscall.EPOLL_CTL_MOD, c.fd, &ev); err != nil {
+		e.s.logf("epoll_ctl %s: %v", c.conn.RemoteAddr(), err)
+		e.closeLocked(c)
+	}
+}
+
+func (e *epollServer) close(c *epollConn) {
+	e.mu.Lock()
+	e.closeLocked(c)
+	e.mu.Unlock()
+}
+
+func (e *epollServer) closeLocked(c *epollConn) {
+	if c.state == connClosed {
+		return
+	}
+	e.removeLocked(c)
+	c.conn.Close()
+	if e.opts.Closed != nil {
+		e.opts.Closed(c.conn, c.served, time.Since(c.opened))
+	}
+}
+
+// removeLocked убирает соединение из epoll и из учёта сервера.
+func (e *epollServer) removeLocked(c *epollConn) {
+	c.state = connClosed
+	delete(e.conns, c.id)
+	syscall.EpollCtl(e.epfd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+	e.s.forget(c.conn)
+	e.s.activeConns.Add(-1)
+}
+
+// detach отдаёт соединение обработчику (Hijack): дальше им управляет
+// обычный net.Conn.
+func (e *epollServer) detach(c *epollConn) {
+	e.mu.Lock()
+	e.removeLocked(c)
+	e.mu.Unlock()
+}
+
+// detachH2 передаёт соединение с preface HTTP/2 в opts.Detach.
+func (e *epollServer) detachH2(c *epollConn) {
+	if e.opts.Detach == nil {
+		e.close(c)
+		return
+	}
+	rest := c.in
+	c.in = nil
+	e.detach(c)
+	if e.opts.Closed != nil {
+		e.opts.Closed(c.conn, 0, time.Since(c.opened))
+	}
+	go e.opts.Detach(c.conn, bufio.NewReader(io.MultiReader(bytes.NewReader(rest), c.conn)))
+}
+
+// epollWriter пишет в неблокирующий сокет. Если буфер сокета полон,
+// обработчик ждёт EPOLLOUT от цикла, но не дольше WriteTimeout.
+type epollWriter struct {
+	e *epollServer
+	c *epollConn
+}
+
+func (w epollWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n, err := syscall.Write(w.c.fd, p)
+		if n > 0 {
+			written += n
+			p = p[n:]
+			continue
+		}
+		switch err {
+		case syscall.EINTR:
+			continue
+		case syscall.EAGAIN:
+			if err := w.waitWritable(); err != nil {
+				return written, err
+			}
+			continue
+		case syscall.EPIPE, syscall.ECONNRESET:
+			return written, net.ErrClosed
+		}
+		return written, err
+	}
+	return written, nil
+}
+
+func (w epollWriter) waitWritable() error {
+	e, c := w.e, w.c
+	ready := make(chan struct{})
+	e.mu.Lock()
+	if c.state == connClosed {
+		e.mu.Unlock()
+		return net.ErrClosed
+	}
+	c.wantWrite = ready
+	ev := syscall.EpollEvent{Events: syscall.EPOLLOUT | syscall.EPOLLONESHOT, Fd: c.id}
+	err := syscall.EpollCtl(e.epfd, syscall.EPOLL_CTL_MOD, c.fd, &ev)
+	e.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	timeout := e.s.WriteTimeout
+	if timeout <= 0 {
+		timeout = time.Hour
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ready:
+		return nil
+	case <-timer.C:
+		e.mu.Lock()
+		c.wantWrite = nil
+		e.mu.Unlock()
+		return fmt.Errorf("запись в %s: %w", c.conn.RemoteAddr(), os.ErrDeadlineExceeded)
+	}
+}
+
+// EpollSupported сообщает, доступен ли ServeEpoll на этой системе.
+func EpollSupported() bool { return true }
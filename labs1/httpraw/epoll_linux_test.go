@@ -0,0 +1,213 @@
+//go:build linux
+
+package httpraw
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen запускает Server в модели goroutine или epoll и возвращает
+// адрес.
+func listen(tb testing.TB, model string, handler HandlerFunc) string {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	s := &Server{Handler: handler, IdleTimeout: time.Minute, MaxRequestsPerConn: 1 << 30, Logf: tb.Logf}
+	if model == "epoll" {
+		go s.ServeEpoll(ln, EpollOptions{})
+	} else {
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					s.ServeConn(conn)
+				}()
+			}
+		}()
+	}
+	tb.Cleanup(func() {
+		ln.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return ln.Addr().String()
+}
+
+func echoLength(w ResponseWriter, r *Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		Error(w, err.Error(), StatusCode(err))
+		return
+	}
+	io.WriteString(w, r.Path+":"+strconv.Itoa(len(body)))
+}
+
+// Тело chunked, пришедшее сотнями мелких порций, собирается целиком, и
+// следующий конвейерный запрос не теряется.
+func TestEpollChunkedSegments(t *testing.T) {
+	c, err := net.Dial("tcp", listen(t, "epoll", echoLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	io.WriteString(c, "POST /c HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked\r\n\r\n")
+	for i := 0; i < 300; i++ {
+		io.WriteString(c, "3\r\nabc\r\n")
+		if i%50 == 0 {
+			// дать циклу epoll разобрать неполное тело
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	io.WriteString(c, "0\r\nX-Sum: 900\r\n\r\nGET /next HTTP/1.1\r\nHost: h\r\n\r\n")
+
+	br := bufio.NewReader(c)
+	for _, want := range []string{"/c:900", "/next:0"} {
+		resp, err := NewParser(br, nil).ReadResponse("GET")
+		if err != nil {
+			t.Fatalf("%s: %v", want, err)
+		}
+		if body, _ := io.ReadAll(resp.Body); string(body) != want {
+			t.Errorf("ответ %d %q; ожидалось %q", resp.Status, body, want)
+		}
+	}
+}
+
+func TestEpollChunkedErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"чанк больше предела", "a00001\r\n", 413},
+		{"кривой размер", "zz\r\nx\r\n0\r\n\r\n", 400},
+		{"нет CRLF после данных", "1\r\nxy\r\n0\r\n\r\n", 400},
+	}
+	addr := listen(t, "epoll", echoLength)
+	for _, tt := range tests {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetDeadline(time.Now().Add(5 * time.Second))
+		io.WriteString(c, "POST / HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked\r\n\r\n"+tt.body)
+		resp, err := NewParser(bufio.NewReader(c), nil).ReadResponse("POST")
+		c.Close()
+		if err != nil || resp.Status != tt.status {
+			t.Errorf("%s: ответ %v, %v; ожидался %d", tt.name, resp, err, tt.status)
+		}
+	}
+}
+
+// BenchmarkIdleConns — то же сравнение, что в idlebench, в миниатюре:
+// idle keep-alive соединений после одного запроса каждое, затем b.N
+// последовательных запросов по отдельному соединению. Память на
+// простаивающее соединение — метрика idle-B/conn.
+//
+//	go test -bench IdleConns -benchtime 2000x ./httpraw/
+func BenchmarkIdleConns(b *testing.B) {
+	const idle = 1000
+	for _, model := range []string{"goroutine", "epoll"} {
+		b.Run(model, func(b *testing.B) {
+			addr := listen(b, model, func(w ResponseWriter, r *Request) {
+				io.WriteString(w, "ok")
+			})
+			request := "GET / HTTP/1.1\r\nHost: h\r\n\r\n"
+
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			conns := make([]net.Conn, 0, idle)
+			defer func() {
+				for _, c := range conns {
+					c.Close()
+				}
+			}()
+			for i := 0; i < idle; i++ {
+				c, err := net.Dial("tcp", addr)
+				if err != nil {
+					b.Fatalf("соединение %d: %v", i, err)
+				}
+				conns = append(conns, c)
+				io.WriteString(c, request)
+				if _, err := NewParser(bufio.NewReader(c), nil).ReadResponse("GET"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+			used := int64(after.HeapInuse+after.StackInuse) - int64(before.HeapInuse+before.StackInuse)
+
+			c, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer c.Close()
+			br := bufio.NewReader(c)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				io.WriteString(c, request)
+				resp, err := NewParser(br, nil).ReadResponse("GET")
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+			}
+			// клиентские соединения живут в том же процессе: их доля
+			// одинакова для обеих моделей
+			b.ReportMetric(float64(used)/idle, "idle-B/conn")
+		})
+	}
+}
+
+// BenchmarkEpollChunked — тело chunked приходит порциями по segment
+// байт, и на каждую порцию, как на событие epoll, буфер соединения
+// разбирается. Время на байт не должно расти с размером тела.
+func BenchmarkEpollChunked(b *testing.B) {
+	const segment = 64
+	for _, size := range []int{16 << 10, 256 << 10} {
+		b.Run(fmt.Sprintf("%dKiB", size>>10), func(b *testing.B) {
+			e := &epollServer{s: &Server{}}
+			head := "POST / HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked\r\n\r\n"
+			chunk := fmt.Sprintf("%x\r\n%s\r\n", segment, strings.Repeat("x", segment))
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				c := &epollConn{in: []byte(head)}
+				for n := 0; n < size; n += segment {
+					c.in = append(c.in, chunk...)
+					if len(c.in) < c.need {
+						continue
+					}
+					if _, done, err := e.nextRequest(c); done != 0 || err != nil {
+						b.Fatalf("тело разобрано раньше конца: %d, %v", done, err)
+					}
+				}
+				c.in = append(c.in, "0\r\n\r\n"...)
+				req, done, err := e.nextRequest(c)
+				if err != nil || done != len(c.in) {
+					b.Fatalf("разобрано %d из %d: %v", done, len(c.in), err)
+				}
+				if n, _ := io.Copy(io.Discard, req.Body); n != int64(size+segment-1)/segment*segment {
+					b.Fatalf("тело %d байт", n)
+				}
+			}
+		})
+	}
+}
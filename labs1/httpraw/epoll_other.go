@@ -0,0 +1,16 @@
+//go:build !linux
+
+package httpraw
+
+import (
+	"errors"
+	"net"
+)
+
+// ServeEpoll есть только в Linux.
+func (s *Server) ServeEpoll(l net.Listener, opts EpollOptions) error {
+	return errors.New("сервер на epoll доступен только в Linux")
+}
+
+// EpollSupported сообщает, доступен ли ServeEpoll на этой системе.
+func EpollSupported() bool { return false }
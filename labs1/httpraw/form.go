@@ -0,0 +1,131 @@
+package httpraw
+
+import (
+	"io"
+	"net/url"
+	"strings"
+)
+
+// maxFormBytes ограничивает тело application/x-www-form-urlencoded: оно
+// целиком читается в память.
+const maxFormBytes = 10 << 20
+
+// ParseForm заполняет PostForm из тела application/x-www-form-urlencoded
+// (для POST, PUT и PATCH) и Form — из тела и строки запроса; значения
+// тела идут первыми. Повторный вызов ничего не делает.
+func (r *Request) ParseForm() error {
+	if r.Form != nil {
+		return nil
+	}
+	r.PostForm = make(url.Values)
+	switch r.Method {
+	case "POST", "PUT", "PATCH":
+		ct := r.Header.Get("Content-Type")
+		if ct == "" {
+			break
+		}
+		mediaType, _, err := ParseMediaType(ct)
+		if err != nil {
+			return err
+		}
+		if mediaType != "application/x-www-form-urlencoded" {
+			break
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxFormBytes+1))
+		if err != nil {
+			return err
+		}
+		if len(body) > maxFormBytes {
+			return errorf(413, "форма длиннее %d байт", maxFormBytes)
+		}
+		if r.PostForm, err = ParseURLEncoded(string(body)); err != nil {
+			return err
+		}
+	}
+
+	query, err := ParseURLEncoded(r.RawQuery)
+	if err != nil {
+		return err
+	}
+	r.Form = make(url.Values)
+	for k, vs := range r.PostForm {
+		r.Form[k] = append(r.Form[k], vs...)
+	}
+	for k, vs := range query {
+		r.Form[k] = append(r.Form[k], vs...)
+	}
+	return nil
+}
+
+// FormValue возвращает первое значение поля из формы или строки запроса.
+func (r *Request) FormValue(name string) string {
+	r.ParseForm()
+	return r.Form.Get(name)
+}
+
+// PostFormValue возвращает первое значение поля только из тела формы.
+func (r *Request) PostFormValue(name string) string {
+	r.ParseForm()
+	return r.PostForm.Get(name)
+}
+
+// ParseURLEncoded разбирает строку "a=1&b=x+y&c=%D0%AF": пары через '&',
+// '+' означает пробел, %XX — байт. Пара без '=' даёт пустое значение.
+func ParseURLEncoded(s string) (url.Values, error) {
+	values := make(url.Values)
+	for s != "" {
+		var pair string
+		pair, s, _ = strings.Cut(s, "&")
+		if pair == "" {
+			continue
+		}
+		rawKey, rawValue, _ := strings.Cut(pair, "=")
+		key, err := unescapeForm(rawKey)
+		if err != nil {
+			return nil, err
+		}
+		value, err := unescapeForm(rawValue)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = append(values[key], value)
+	}
+	return values, nil
+}
+
+func unescapeForm(s string) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '+':
+			b = append(b, ' ')
+		case '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return "", errorf(400, "некорректная %%-последовательность в %q", s)
+			}
+			b = append(b, unhex(s[i+1])<<4|unhex(s[i+2]))
+			i += 2
+		default:
+			b = append(b, c)
+		}
+	}
+	return string(b), nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	default:
+		return c - 'a' + 10
+	}
+}
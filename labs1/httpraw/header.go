@@ -0,0 +1,133 @@
+package httpraw
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+// Header хранит поля заголовка. Ключи приводятся к каноническому виду
+// (content-length -> Content-Length), поэтому поиск не зависит от регистра.
+type Header map[string][]string
+
+// Get возвращает первое значение поля или пустую строку.
+func (h Header) Get(key string) string {
+	v := h[textproto.CanonicalMIMEHeaderKey(key)]
+	if len(v) == 0 {
+		return ""
+	}
+	return v[0]
+}
+
+// Values возвращает все значения поля.
+func (h Header) Values(key string) []string {
+	return h[textproto.CanonicalMIMEHeaderKey(key)]
+}
+
+// Set заменяет значения поля одним значением.
+func (h Header) Set(key, value string) {
+	h[textproto.CanonicalMIMEHeaderKey(key)] = []string{value}
+}
+
+// Add добавляет значение к полю.
+func (h Header) Add(key, value string) {
+	key = textproto.CanonicalMIMEHeaderKey(key)
+	h[key] = append(h[key], value)
+}
+
+// Del удаляет поле.
+func (h Header) Del(key string) {
+	delete(h, textproto.CanonicalMIMEHeaderKey(key))
+}
+
+// HasToken проверяет, есть ли token в списке значений поля, разделённом
+// запятыми (Connection: keep-alive, Upgrade). Сравнение без учёта регистра.
+func (h Header) HasToken(key, token string) bool {
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Tokens возвращает все элементы списка значений поля в нижнем регистре.
+func (h Header) Tokens(key string) []string {
+	var tokens []string
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if t != "" {
+				tokens = append(tokens, strings.ToLower(t))
+			}
+		}
+	}
+	return tokens
+}
+
+// Clone возвращает независимую копию заголовка.
+func (h Header) Clone() Header {
+	c := make(Header, len(h))
+	for k, v := range h {
+		c[k] = append([]string(nil), v...)
+	}
+	return c
+}
+
+// ValidFieldName проверяет, что имя поля — token (RFC 9110 5.1).
+func ValidFieldName(name string) bool {
+	return isToken(name)
+}
+
+// ValidFieldValue проверяет значение поля: без CR, LF и NUL и без
+// пробелов по краям (RFC 9110 5.5). Такое значение можно записать в
+// заголовок HTTP/1.1, не изменив его структуру.
+func ValidFieldValue(v string) bool {
+	if strings.ContainsAny(v, "\r\n\x00") {
+		return false
+	}
+	return strings.Trim(v, " \t") == v
+}
+
+// ValidHost проверяет значение Host или :authority: имя или IP-адрес,
+// IPv6 в скобках, и необязательный порт (RFC 3986 3.2.2). userinfo не
+// допускается.
+func ValidHost(host string) bool {
+	var port string
+	if strings.HasPrefix(host, "[") {
+		end := strings.IndexByte(host, ']')
+		if end < 0 || net.ParseIP(host[1:end]) == nil {
+			return false
+		}
+		rest := host[end+1:]
+		if rest != "" {
+			if rest[0] != ':' || len(rest) == 1 {
+				return false
+			}
+			port = rest[1:]
+		}
+	} else {
+		var hasPort bool
+		host, port, hasPort = strings.Cut(host, ":")
+		if host == "" || hasPort && port == "" {
+			return false
+		}
+		for i := 0; i < len(host); i++ {
+			c := host[i]
+			if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+				continue
+			}
+			if !strings.ContainsRune("-._~%!$&'()*+,;=", rune(c)) {
+				return false
+			}
+		}
+	}
+	for i := 0; i < len(port); i++ {
+		if port[i] < '0' || port[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
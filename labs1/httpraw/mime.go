@@ -0,0 +1,83 @@
+package httpraw
+
+import (
+	"strings"
+)
+
+// ParseMediaType разбирает значение вида `type/subtype; a=b; c="d"`
+// (RFC 9110 5.6.6) — так устроены Content-Type и Content-Disposition.
+// Тип и имена параметров приводятся к нижнему регистру, значения в
+// кавычках освобождаются от экранирования.
+func ParseMediaType(v string) (string, map[string]string, error) {
+	mediaType, rest, _ := strings.Cut(v, ";")
+	mediaType = strings.ToLower(strings.Trim(mediaType, " \t"))
+	if mediaType == "" {
+		return "", nil, errorf(400, "пустой тип в %q", v)
+	}
+	params := make(map[string]string)
+	for {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			return mediaType, params, nil
+		}
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 {
+			return "", nil, errorf(400, "некорректный параметр в %q", v)
+		}
+		name := strings.ToLower(strings.TrimRight(rest[:eq], " \t"))
+		if !isToken(name) {
+			return "", nil, errorf(400, "некорректное имя параметра %q", name)
+		}
+		rest = strings.TrimLeft(rest[eq+1:], " \t")
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			var ok bool
+			value, rest, ok = readQuoted(rest)
+			if !ok {
+				return "", nil, errorf(400, "незакрытая кавычка в %q", v)
+			}
+		} else {
+			end := strings.IndexByte(rest, ';')
+			if end < 0 {
+				end = len(rest)
+			}
+			value = strings.TrimRight(rest[:end], " \t")
+			rest = rest[end:]
+		}
+		if _, dup := params[name]; dup {
+			return "", nil, errorf(400, "параметр %s повторяется", name)
+		}
+		params[name] = value
+
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			return mediaType, params, nil
+		}
+		if rest[0] != ';' {
+			return "", nil, errorf(400, "ожидалась ';' в %q", v)
+		}
+		rest = rest[1:]
+	}
+}
+
+// readQuoted читает quoted-string с начала s и возвращает значение без
+// кавычек и остаток строки.
+func readQuoted(s string) (value, rest string, ok bool) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], true
+		case '\\':
+			if i+1 == len(s) {
+				return "", "", false
+			}
+			i++
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", false
+}
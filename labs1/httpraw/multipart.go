@@ -0,0 +1,233 @@
+package httpraw
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Ограничения multipart тела: заголовки каждой части и число частей.
+const (
+	maxPartHeaderBytes = 16 << 10
+	maxPartHeaders     = 50
+	maxParts           = 1000
+)
+
+// MultipartReader читает части тела multipart/* (RFC 2046 5.1, для форм —
+// RFC 7578) по очереди, не загружая их в память. Тело выглядит так:
+//
+//	преамбула
+//	--boundary\r\n
+//	заголовки части\r\n
+//	\r\n
+//	тело части\r\n--boundary\r\n
+//	...
+//	тело последней части\r\n--boundary--\r\n
+//	эпилог
+//
+// CRLF перед разделителем принадлежит разделителю, а не телу части.
+type MultipartReader struct {
+	p      *Parser
+	dash   []byte // "--boundary"
+	nlDash []byte // "\r\n--boundary"
+	part   *Part
+	parts  int
+	done   bool
+}
+
+// Part — одна часть multipart тела. Read возвращает тело части до
+// разделителя.
+type Part struct {
+	Header Header
+
+	mr  *MultipartReader
+	eof bool
+
+	disposition       string
+	dispositionParams map[string]string
+}
+
+// MultipartReader проверяет Content-Type запроса и возвращает читателя
+// частей тела. Для не-multipart тела — ошибка 415, без boundary — 400.
+func (r *Request) MultipartReader() (*MultipartReader, error) {
+	mediaType, params, err := ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, errorf(415, "ожидалось тело multipart/*, получено %s", mediaType)
+	}
+	boundary := params["boundary"]
+	// RFC 2046 5.1.1: от 1 до 70 символов, пробел не может быть последним
+	if boundary == "" || len(boundary) > 70 || strings.HasSuffix(boundary, " ") {
+		return nil, errorf(400, "некорректный boundary %q", boundary)
+	}
+	return NewMultipartReader(r.Body, boundary), nil
+}
+
+// NewMultipartReader читает части из body с разделителем boundary.
+func NewMultipartReader(body io.Reader, boundary string) *MultipartReader {
+	return &MultipartReader{
+		p:      NewParser(body, nil),
+		dash:   []byte("--" + boundary),
+		nlDash: []byte("\r\n--" + boundary),
+	}
+}
+
+// NextPart пропускает остаток текущей части и возвращает следующую.
+// После закрывающего разделителя возвращает io.EOF.
+func (mr *MultipartReader) NextPart() (*Part, error) {
+	if mr.done {
+		return nil, io.EOF
+	}
+	var err error
+	if mr.part == nil {
+		err = mr.skipPreamble()
+	} else {
+		err = mr.nextDelimiter()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if mr.done {
+		// эпилог не несёт данных, но тело нужно дочитать, чтобы
+		// соединение можно было использовать дальше
+		io.Copy(io.Discard, mr.p.r)
+		return nil, io.EOF
+	}
+
+	mr.parts++
+	if mr.parts > maxParts {
+		return nil, errorf(400, "больше %d частей", maxParts)
+	}
+	header, err := mr.p.readHeader(Limits{MaxHeaderBytes: maxPartHeaderBytes, MaxHeaders: maxPartHeaders})
+	if err != nil {
+		if StatusCode(err) == 431 {
+			err = errorf(400, "заголовки части: %v", err)
+		}
+		return nil, unexpected(err)
+	}
+	mr.part = &Part{Header: header, mr: mr}
+	return mr.part, nil
+}
+
+// skipPreamble пропускает строки до первого разделителя.
+func (mr *MultipartReader) skipPreamble() error {
+	for {
+		line, err := mr.p.readLine(8<<10, 400)
+		if err != nil {
+			return unexpected(err)
+		}
+		if !bytes.HasPrefix(line, mr.dash) {
+			continue
+		}
+		if rest, ok := mr.delimiterTail(line[len(mr.dash):]); ok {
+			mr.done = rest
+			return nil
+		}
+	}
+}
+
+// nextDelimiter дочитывает текущую часть и разбирает разделитель за ней.
+func (mr *MultipartReader) nextDelimiter() error {
+	if _, err := io.Copy(io.Discard, mr.part); err != nil {
+		return err
+	}
+	if _, err := mr.p.r.Discard(len(mr.nlDash)); err != nil {
+		return unexpected(err)
+	}
+	// после закрывающего разделителя CRLF необязателен: тело может
+	// кончиться сразу за "--boundary--"
+	if tail, _ := mr.p.r.Peek(2); bytes.Equal(tail, []byte("--")) {
+		mr.done = true
+		return nil
+	}
+	line, err := mr.p.readLine(1<<10, 400)
+	if err != nil {
+		return unexpected(err)
+	}
+	closing, ok := mr.delimiterTail(line)
+	if !ok {
+		return errorf(400, "мусор после разделителя частей: %q", line)
+	}
+	mr.done = closing
+	return nil
+}
+
+// delimiterTail разбирает то, что идёт в строке после "--boundary":
+// "--" у закрывающего разделителя или пробелы (transport padding).
+func (mr *MultipartReader) delimiterTail(tail []byte) (closing, ok bool) {
+	if bytes.HasPrefix(tail, []byte("--")) {
+		return true, true
+	}
+	return false, len(bytes.Trim(tail, " \t")) == 0
+}
+
+// Read читает тело части. Данные отдаются, только пока в буфере не
+// может начинаться разделитель: хвост короче разделителя остаётся до
+// следующего чтения.
+func (p *Part) Read(b []byte) (int, error) {
+	if p.eof {
+		return 0, io.EOF
+	}
+	br := p.mr.p.r
+	delim := p.mr.nlDash
+
+	// в буфере должно быть не меньше байт, чем в разделителе
+	_, peekErr := br.Peek(len(delim))
+	buf, _ := br.Peek(br.Buffered())
+
+	limit := len(buf)
+	if i := bytes.Index(buf, delim); i >= 0 {
+		if i == 0 {
+			p.eof = true
+			return 0, io.EOF
+		}
+		limit = i
+	} else if peekErr != nil {
+		// тело кончилось раньше разделителя
+		return 0, unexpected(peekErr)
+	} else {
+		limit = len(buf) - len(delim) + 1
+	}
+	n := copy(b, buf[:limit])
+	br.Discard(n)
+	return n, nil
+}
+
+// FormName — имя поля формы из Content-Disposition: form-data.
+func (p *Part) FormName() string {
+	p.parseDisposition()
+	if p.disposition != "form-data" {
+		return ""
+	}
+	return p.dispositionParams["name"]
+}
+
+// FileName — имя файла, как его прислал клиент. Оно может содержать
+// путь и любые символы, поэтому перед записью на диск его нужно
+// очистить.
+func (p *Part) FileName() string {
+	p.parseDisposition()
+	return p.dispositionParams["filename"]
+}
+
+func (p *Part) parseDisposition() {
+	if p.dispositionParams != nil {
+		return
+	}
+	d, params, err := ParseMediaType(p.Header.Get("Content-Disposition"))
+	if err != nil {
+		params = map[string]string{}
+	}
+	p.disposition, p.dispositionParams = d, params
+}
+
+// unexpected превращает обрыв тела в ошибку 400: в multipart теле
+// конец данных до закрывающего разделителя — ошибка клиента.
+func unexpected(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errorf(400, "тело multipart оборвалось до закрывающего разделителя")
+	}
+	return err
+}
@@ -0,0 +1,193 @@
+package httpraw
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+type testPart struct {
+	name, filename, body string
+}
+
+// readParts читает все части; body — как есть, через reader, чтобы
+// разделитель попадал на границы буфера.
+func readParts(body io.Reader, boundary string) ([]testPart, error) {
+	mr := NewMultipartReader(body, boundary)
+	var parts []testPart
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return parts, err
+		}
+		data, err := io.ReadAll(p)
+		if err != nil {
+			return parts, err
+		}
+		parts = append(parts, testPart{p.FormName(), p.FileName(), string(data)})
+	}
+}
+
+func TestMultipart(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []testPart
+	}{
+		{"поле и файл с преамбулой и эпилогом",
+			"преамбула\r\n--XyZ\r\n" +
+				"Content-Disposition: form-data; name=\"title\"\r\n\r\nотпуск\r\n" +
+				"--XyZ\r\n" +
+				"Content-Disposition: form-data; name=\"file\"; filename=\"a b.txt\"\r\nContent-Type: text/plain\r\n\r\n" +
+				"line1\r\nline2\r\n" +
+				"--XyZ--\r\nэпилог",
+			[]testPart{{"title", "", "отпуск"}, {"file", "a b.txt", "line1\r\nline2"}}},
+		{"тело похоже на разделитель",
+			"--XyZ\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n\r\n--XyY\r\n--Xy\r\n" +
+				"--XyZ--\r\n",
+			[]testPart{{"a", "", "\r\n--XyY\r\n--Xy"}}},
+		{"пустая часть и пробелы после разделителя",
+			"--XyZ \t\r\nContent-Disposition: form-data; name=\"empty\"\r\n\r\n\r\n--XyZ--",
+			[]testPart{{"empty", "", ""}}},
+		{"без частей", "--XyZ--\r\n", nil},
+		{"не form-data", "--XyZ\r\nContent-Disposition: attachment; name=\"x\"\r\n\r\ndata\r\n--XyZ--\r\n",
+			[]testPart{{"", "", "data"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, r := range []struct {
+				name string
+				r    io.Reader
+			}{
+				{"целиком", strings.NewReader(tt.body)},
+				{"по байту", iotest.OneByteReader(strings.NewReader(tt.body))},
+			} {
+				got, err := readParts(r.r, "XyZ")
+				if err != nil {
+					t.Fatalf("%s: %v", r.name, err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: части %q; ожидалось %q", r.name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// Тело, собранное mime/multipart, читается так же: большие части
+// проходят через буфер несколькими Read.
+func TestMultipartStdlibWriter(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	big := strings.Repeat("0123456789\r\n-", 2000)
+	mw.WriteField("small", "v")
+	fw, _ := mw.CreateFormFile("upload", "big.bin")
+	fw.Write([]byte(big))
+	mw.Close()
+
+	got, err := readParts(&buf, mw.Boundary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []testPart{{"small", "", "v"}, {"upload", "big.bin", big}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("получено %d частей, тела совпадают: %v", len(got), len(got) == 2 && got[1].body == big)
+	}
+}
+
+// NextPart пропускает непрочитанный остаток части.
+func TestMultipartSkipPart(t *testing.T) {
+	body := "--b\r\nContent-Disposition: form-data; name=\"skip\"\r\n\r\n" + strings.Repeat("x", 10000) +
+		"\r\n--b\r\nContent-Disposition: form-data; name=\"read\"\r\n\r\nok\r\n--b--\r\n"
+	mr := NewMultipartReader(strings.NewReader(body), "b")
+	if _, err := mr.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(p)
+	if p.FormName() != "read" || string(data) != "ok" {
+		t.Errorf("часть %q = %q; ожидалась read = ok", p.FormName(), data)
+	}
+}
+
+func TestMultipartErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"нет разделителя", "просто текст\r\n"},
+		{"обрыв тела части", "--b\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nда"},
+		{"нет закрывающего разделителя", "--b\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\nx\r\n--b\r\n"},
+		{"мусор после разделителя", "--b\r\n\r\nx\r\n--bogus\r\n\r\ny\r\n--b--"},
+		{"кривой заголовок части", "--b\r\nбез двоеточия\r\n\r\nx\r\n--b--"},
+		{"заголовки части слишком длинные", "--b\r\nX-A: " + strings.Repeat("a", maxPartHeaderBytes) + "\r\n\r\nx\r\n--b--"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readParts(strings.NewReader(tt.body), "b")
+			if StatusCode(err) != 400 {
+				t.Errorf("ошибка %v; ожидалась 400", err)
+			}
+		})
+	}
+}
+
+func TestRequestMultipartReader(t *testing.T) {
+	tests := []struct {
+		contentType string
+		status      int
+	}{
+		{"multipart/form-data; boundary=abc", 0},
+		{`multipart/mixed; boundary="a b c"`, 0},
+		{"application/json", 415},
+		{"multipart/form-data", 400},
+		{"multipart/form-data; boundary=" + strings.Repeat("a", 71), 400},
+		{`multipart/form-data; boundary="abc "`, 400},
+	}
+	for _, tt := range tests {
+		req := &Request{Header: Header{}, Body: strings.NewReader("")}
+		req.Header.Set("Content-Type", tt.contentType)
+		_, err := req.MultipartReader()
+		if got := StatusCode(err); got != tt.status {
+			t.Errorf("%s: код %d (%v); ожидался %d", tt.contentType, got, err, tt.status)
+		}
+	}
+}
+
+func TestParseURLEncoded(t *testing.T) {
+	tests := []struct {
+		in   string
+		want url.Values
+		bad  bool
+	}{
+		{"a=1&b=2&a=3", url.Values{"a": {"1", "3"}, "b": {"2"}}, false},
+		{"q=hello+world&e=%D0%B6", url.Values{"q": {"hello world"}, "e": {"ж"}}, false},
+		{"flag&empty=", url.Values{"flag": {""}, "empty": {""}}, false},
+		{"&&a=1&", url.Values{"a": {"1"}}, false},
+		{"a=%zz", nil, true},
+		{"a=%4", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseURLEncoded(tt.in)
+		if tt.bad {
+			if StatusCode(err) != 400 {
+				t.Errorf("%q: ошибка %v; ожидалась 400", tt.in, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q = %v, %v; ожидалось %v", tt.in, got, err, tt.want)
+		}
+	}
+}
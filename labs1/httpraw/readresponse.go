@@ -0,0 +1,135 @@
+package httpraw
+
+import (
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Response — ответ сервера, прочитанный клиентской стороной соединения
+// (обратный прокси, клиент). Body читается из того же Parser.
+type Response struct {
+	Status     int
+	Reason     string
+	Proto      string
+	ProtoMajor int
+	ProtoMinor int
+	Header     Header
+
+	// ContentLength: -1, если длина не известна заранее (chunked или до
+	// закрытия соединения).
+	ContentLength int64
+	Chunked       bool
+	// Close — после ответа соединение нельзя использовать повторно.
+	Close   bool
+	Body    io.Reader
+	Trailer Header
+}
+
+// ReadResponse читает ответ на запрос с методом method: от него зависит,
+// есть ли у ответа тело. Промежуточные ответы 1xx, кроме 101, пропускаются.
+// Ошибки разбора возвращаются как StatusError с кодом 502.
+func (p *Parser) ReadResponse(method string) (*Response, error) {
+	limits := p.Limits.withDefaults()
+	for {
+		line, err := p.readLine(limits.MaxRequestLine, 502)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := parseStatusLine(string(line))
+		if err != nil {
+			return nil, err
+		}
+		resp.Header, err = p.readHeader(limits)
+		if err != nil {
+			return nil, errorf(502, "заголовок ответа: %v", err)
+		}
+		if resp.Status >= 100 && resp.Status < 200 && resp.Status != 101 {
+			continue
+		}
+		if err := resp.prepareBody(p, method); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+}
+
+// ReadHeader читает блок полей заголовка до пустой строки без стартовой
+// строки — так, например, CGI-скрипт начинает свой вывод. Ошибки разбора
+// возвращаются как StatusError с кодом 502.
+func (p *Parser) ReadHeader() (Header, error) {
+	h, err := p.readHeader(p.Limits.withDefaults())
+	if err != nil && StatusCode(err) != 0 {
+		return nil, errorf(502, "заголовок: %v", err)
+	}
+	return h, err
+}
+
+func parseStatusLine(line string) (*Response, error) {
+	proto, rest, ok := strings.Cut(line, " ")
+	if !ok {
+		return nil, errorf(502, "некорректная строка статуса %q", line)
+	}
+	major, minor, ok := parseHTTPVersion(proto)
+	if !ok || major != 1 {
+		return nil, errorf(502, "некорректная версия в ответе %q", line)
+	}
+	code, reason, _ := strings.Cut(rest, " ")
+	status, err := strconv.Atoi(code)
+	if err != nil || len(code) != 3 || status < 100 {
+		return nil, errorf(502, "некорректный код статуса %q", line)
+	}
+	return &Response{
+		Status:     status,
+		Reason:     reason,
+		Proto:      proto,
+		ProtoMajor: major,
+		ProtoMinor: minor,
+	}, nil
+}
+
+// prepareBody определяет длину тела ответа (RFC 9112 6.3).
+func (resp *Response) prepareBody(p *Parser, method string) error {
+	h := resp.Header
+	resp.Close = h.HasToken("Connection", "close") ||
+		resp.ProtoMajor == 1 && resp.ProtoMinor == 0 && !h.HasToken("Connection", "keep-alive")
+
+	if method == "HEAD" || resp.Status == 101 || resp.Status == 204 || resp.Status == 304 {
+		resp.ContentLength = 0
+		if cl, err := parseContentLength(h.Values("Content-Length")); err == nil && cl >= 0 && method == "HEAD" {
+			// у HEAD длина описывает тело, которого не будет
+			resp.ContentLength = cl
+		}
+		resp.Body = eofReader{}
+		return nil
+	}
+
+	if te := h.Tokens("Transfer-Encoding"); len(te) > 0 {
+		if te[len(te)-1] == "chunked" {
+			resp.Chunked = true
+			resp.ContentLength = -1
+			resp.Body = &chunkedReader{p: p, trailer: &resp.Trailer, limit: -1, header: p.Limits.withDefaults()}
+			return nil
+		}
+		// тело без chunked в конце длится до закрытия соединения
+		resp.ContentLength = -1
+		resp.Close = true
+		resp.Body = p.r
+		return nil
+	}
+
+	if cl := h.Values("Content-Length"); len(cl) > 0 {
+		n, err := parseContentLength(cl)
+		if err != nil {
+			return errorf(502, "%v", err)
+		}
+		resp.ContentLength = n
+		resp.Body = io.LimitReader(p.r, n)
+		return nil
+	}
+
+	resp.ContentLength = -1
+	resp.Close = true
+	resp.Body = p.r
+	return nil
+}
@@ -0,0 +1,434 @@
+// Package httpraw — разбор HTTP/1.x запросов поверх обычного net.Conn без
+// net/http. Парсер читает поток инкрементально через bufio.Reader, поэтому
+// запрос, пришедший несколькими TCP-сегментами, собирается целиком.
+package httpraw
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Limits ограничивают размеры частей запроса. Нулевые поля заменяются
+// значениями по умолчанию.
+type Limits struct {
+	MaxRequestLine int   // длина стартовой строки, иначе 414
+	MaxHeaderBytes int   // суммарный размер заголовков, иначе 431
+	MaxHeaders     int   // количество полей заголовка, иначе 431
+	MaxBodyBytes   int64 // размер тела, иначе 413; -1 — без ограничения
+}
+
+// DefaultLimits используются, если в Parser не заданы свои.
+var DefaultLimits = Limits{
+	MaxRequestLine: 8 << 10,
+	MaxHeaderBytes: 32 << 10,
+	MaxHeaders:     100,
+	MaxBodyBytes:   10 << 20,
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxRequestLine <= 0 {
+		l.MaxRequestLine = DefaultLimits.MaxRequestLine
+	}
+	if l.MaxHeaderBytes <= 0 {
+		l.MaxHeaderBytes = DefaultLimits.MaxHeaderBytes
+	}
+	if l.MaxHeaders <= 0 {
+		l.MaxHeaders = DefaultLimits.MaxHeaders
+	}
+	if l.MaxBodyBytes == 0 {
+		l.MaxBodyBytes = DefaultLimits.MaxBodyBytes
+	}
+	return l
+}
+
+// Методы, которые понимает парсер. На остальные отвечаем 501.
+var knownMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "DELETE": true,
+	"OPTIONS": true, "PATCH": true, "TRACE": true, "CONNECT": true,
+}
+
+// Request — разобранный запрос.
+type Request struct {
+	Method     string
+	RequestURI string // цель запроса как пришла в стартовой строке
+	Path       string // декодированный путь без query
+	RawQuery   string
+	Proto      string
+	ProtoMajor int
+	ProtoMinor int
+	Header     Header
+	Host       string
+
+	// ContentLength равен -1 для chunked тела и 0, если тела нет.
+	ContentLength int64
+	Chunked       bool
+	Body          io.Reader
+	// Trailer заполняется после того, как chunked тело прочитано до конца.
+	Trailer Header
+
+	RemoteAddr string
+	// TLS заполняется для соединений через crypto/tls.
+	TLS *tls.ConnectionState
+	// Seq — порядковый номер запроса на этом соединении, начиная с 1.
+	Seq int
+	// User — имя пользователя, прошедшего авторизацию; его заполняет
+	// обработчик авторизации, а читает журнал доступа.
+	User string
+	// Redirects — сколько внутренних перенаправлений прошёл запрос до
+	// обработчика (локальные перенаправления CGI). Поле копируется
+	// вместе с запросом через всю цепочку, клиент задать его не может.
+	Redirects int
+
+	// Form и PostForm заполняет ParseForm.
+	Form     url.Values
+	PostForm url.Values
+
+	// Pattern и Params заполняет Router: шаблон маршрута и значения
+	// параметров {name} из пути.
+	Pattern string
+	Params  map[string]string
+}
+
+// Param возвращает значение параметра маршрута.
+func (r *Request) Param(name string) string {
+	return r.Params[name]
+}
+
+// Query разбирает строку запроса.
+func (r *Request) Query() url.Values {
+	q, _ := url.ParseQuery(r.RawQuery)
+	return q
+}
+
+// ExpectContinue сообщает, ждёт ли клиент "100 Continue" перед отправкой тела.
+func (r *Request) ExpectContinue() bool {
+	return r.ProtoAtLeast(1, 1) && strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+// ProtoAtLeast сравнивает версию протокола запроса.
+func (r *Request) ProtoAtLeast(major, minor int) bool {
+	return r.ProtoMajor > major || r.ProtoMajor == major && r.ProtoMinor >= minor
+}
+
+// Parser читает запросы из одного соединения. Писатель w нужен только для
+// промежуточного ответа "100 Continue".
+type Parser struct {
+	Limits Limits
+
+	r *bufio.Reader
+	w io.Writer
+}
+
+// NewParser создаёт парсер поверх соединения.
+func NewParser(r io.Reader, w io.Writer) *Parser {
+	return &Parser{r: bufio.NewReaderSize(r, 4096), w: w}
+}
+
+// Buffered возвращает количество байт, уже прочитанных из сокета, но ещё
+// не разобранных (например, следующий запрос при конвейерной отправке).
+func (p *Parser) Buffered() int {
+	return p.r.Buffered()
+}
+
+// Reader даёт доступ к буферизованному потоку — нужен тем, кто забирает
+// соединение после Upgrade.
+func (p *Parser) Reader() *bufio.Reader {
+	return p.r
+}
+
+// ReadRequest читает стартовую строку и заголовки и готовит Body. Тело
+// не читается заранее: обработчик сам решает, нужно ли оно.
+func (p *Parser) ReadRequest() (*Request, error) {
+	limits := p.Limits.withDefaults()
+
+	// RFC 9112 2.2: пустые строки перед стартовой строкой игнорируются.
+	var line []byte
+	var err error
+	for {
+		line, err = p.readLine(limits.MaxRequestLine, 414)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) > 0 {
+			break
+		}
+	}
+
+	req, err := parseRequestLine(string(line))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header, err = p.readHeader(limits)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := req.prepareBody(p, limits); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// readLine читает строку до LF, отбрасывая CRLF. Если строка длиннее limit,
+// возвращается ошибка с кодом status.
+func (p *Parser) readLine(limit, status int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := p.r.ReadSlice('\n')
+		if len(line)+len(chunk) > limit {
+			return nil, errorf(status, "строка длиннее %d байт", limit)
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		break
+	}
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return line, nil
+}
+
+func parseRequestLine(line string) (*Request, error) {
+	parts := strings.Split(line, " ")
+	if len(parts) != 3 {
+		return nil, errorf(400, "некорректная стартовая строка %q", line)
+	}
+	method, target, proto := parts[0], parts[1], parts[2]
+
+	if !isToken(method) {
+		return nil, errorf(400, "некорректный метод %q", method)
+	}
+	if !knownMethods[method] {
+		return nil, errorf(501, "метод %s не поддерживается", method)
+	}
+
+	major, minor, ok := parseHTTPVersion(proto)
+	if !ok {
+		return nil, errorf(400, "некорректная версия протокола %q", proto)
+	}
+	if major != 1 {
+		return nil, errorf(505, "версия %s не поддерживается", proto)
+	}
+
+	req := &Request{
+		Method:     method,
+		RequestURI: target,
+		Proto:      proto,
+		ProtoMajor: major,
+		ProtoMinor: minor,
+	}
+
+	switch {
+	case method == "CONNECT":
+		// authority-form: host:port
+		req.Host = target
+	case target == "*":
+		if method != "OPTIONS" {
+			return nil, errorf(400, "цель * допустима только для OPTIONS")
+		}
+		req.Path = "*"
+	default:
+		u, err := url.ParseRequestURI(target)
+		if err != nil {
+			return nil, errorf(400, "некорректная цель запроса %q", target)
+		}
+		req.Path = u.Path
+		req.RawQuery = u.RawQuery
+		if u.Host != "" {
+			// absolute-form: хост из URI важнее поля Host
+			req.Host = u.Host
+		}
+	}
+	return req, nil
+}
+
+func parseHTTPVersion(proto string) (major, minor int, ok bool) {
+	if len(proto) != len("HTTP/1.1") || !strings.HasPrefix(proto, "HTTP/") || proto[6] != '.' {
+		return 0, 0, false
+	}
+	if proto[5] < '0' || proto[5] > '9' || proto[7] < '0' || proto[7] > '9' {
+		return 0, 0, false
+	}
+	return int(proto[5] - '0'), int(proto[7] - '0'), true
+}
+
+func (p *Parser) readHeader(limits Limits) (Header, error) {
+	h := make(Header)
+	total := 0
+	count := 0
+	for {
+		line, err := p.readLine(limits.MaxHeaderBytes-total, 431)
+		if err != nil {
+			return nil, err
+		}
+		total += len(line) + 2
+		if len(line) == 0 {
+			return h, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			// obs-fold устарел, RFC 9112 5.2 разрешает отвечать 400
+			return nil, errorf(400, "перенос строки в заголовке не поддерживается")
+		}
+		count++
+		if count > limits.MaxHeaders {
+			return nil, errorf(431, "больше %d полей заголовка", limits.MaxHeaders)
+		}
+
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 {
+			return nil, errorf(400, "некорректное поле заголовка %q", line)
+		}
+		name := string(line[:colon])
+		if !isToken(name) {
+			// сюда же попадает пробел перед двоеточием (RFC 9112 5.1)
+			return nil, errorf(400, "некорректное имя поля %q", name)
+		}
+		value := strings.Trim(string(line[colon+1:]), " \t")
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return nil, errorf(400, "недопустимые символы в поле %s", name)
+		}
+		h.Add(name, value)
+	}
+}
+
+// prepareBody выбирает способ чтения тела по Transfer-Encoding и
+// Content-Length (RFC 9112 6.3).
+func (req *Request) prepareBody(p *Parser, limits Limits) error {
+	if req.Host == "" {
+		req.Host = req.Header.Get("Host")
+	}
+	hosts := req.Header.Values("Host")
+	if req.ProtoAtLeast(1, 1) && len(hosts) == 0 || len(hosts) > 1 {
+		return errorf(400, "запрос HTTP/1.1 должен содержать ровно одно поле Host")
+	}
+
+	if expect := req.Header.Get("Expect"); expect != "" && !strings.EqualFold(expect, "100-continue") {
+		return errorf(417, "ожидание %q не поддерживается", expect)
+	}
+
+	te := req.Header.Tokens("Transfer-Encoding")
+	cl := req.Header.Values("Content-Length")
+
+	if len(te) > 0 {
+		if len(cl) > 0 {
+			// защита от request smuggling: длина тела должна быть однозначной
+			return errorf(400, "одновременно Transfer-Encoding и Content-Length")
+		}
+		if !req.ProtoAtLeast(1, 1) {
+			return errorf(400, "Transfer-Encoding в запросе %s", req.Proto)
+		}
+		for _, coding := range te[:len(te)-1] {
+			if coding == "chunked" {
+				return errorf(400, "chunked применён больше одного раза")
+			}
+			return errorf(501, "кодирование %q не поддерживается", coding)
+		}
+		if te[len(te)-1] != "chunked" {
+			return errorf(501, "кодирование %q не поддерживается", te[len(te)-1])
+		}
+		req.Chunked = true
+		req.ContentLength = -1
+		req.Body = &chunkedReader{p: p, trailer: &req.Trailer, limit: limits.MaxBodyBytes, header: limits}
+		return req.wrapExpectContinue(p)
+	}
+
+	if len(cl) > 0 {
+		n, err := parseContentLength(cl)
+		if err != nil {
+			return err
+		}
+		if limits.MaxBodyBytes >= 0 && n > limits.MaxBodyBytes {
+			return errorf(413, "тело %d байт больше допустимых %d", n, limits.MaxBodyBytes)
+		}
+		req.ContentLength = n
+		if n > 0 {
+			req.Body = io.LimitReader(p.r, n)
+			return req.wrapExpectContinue(p)
+		}
+	}
+
+	req.Body = eofReader{}
+	return nil
+}
+
+// parseContentLength допускает повторы поля и списки только с одинаковыми
+// значениями.
+func parseContentLength(values []string) (int64, error) {
+	var result int64 = -1
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+				return 0, errorf(400, "некорректный Content-Length %q", v)
+			}
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return 0, errorf(400, "некорректный Content-Length %q", v)
+			}
+			if result >= 0 && n != result {
+				return 0, errorf(400, "противоречивые Content-Length")
+			}
+			result = n
+		}
+	}
+	return result, nil
+}
+
+func (req *Request) wrapExpectContinue(p *Parser) error {
+	if req.ExpectContinue() && p.w != nil {
+		req.Body = &continueReader{r: req.Body, w: p.w}
+	}
+	return nil
+}
+
+// continueReader отправляет "100 Continue" только при первом чтении тела:
+// если обработчик тело не читает, клиент его и не пошлёт.
+type continueReader struct {
+	r    io.Reader
+	w    io.Writer
+	sent bool
+}
+
+func (c *continueReader) Read(b []byte) (int, error) {
+	if !c.sent {
+		c.sent = true
+		if _, err := io.WriteString(c.w, "HTTP/1.1 100 Continue\r\n\r\n"); err != nil {
+			return 0, err
+		}
+	}
+	return c.r.Read(b)
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
+
+// isToken проверяет символы token из RFC 9110 5.6.2.
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			continue
+		}
+		if !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,202 @@
+package httpraw
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func parse(raw string, limits Limits) (*Request, error) {
+	p := NewParser(strings.NewReader(raw), io.Discard)
+	p.Limits = limits
+	return p.ReadRequest()
+}
+
+func TestReadRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		method   string
+		path     string
+		query    string
+		host     string
+		contentN int64
+		body     string
+	}{
+		{"простой GET", "GET /a/b?x=1 HTTP/1.1\r\nHost: example.com\r\n\r\n",
+			"GET", "/a/b", "x=1", "example.com", 0, ""},
+		{"пустые строки перед запросом", "\r\n\r\nGET / HTTP/1.1\r\nHost: h\r\n\r\n",
+			"GET", "/", "", "h", 0, ""},
+		{"LF без CR", "GET / HTTP/1.1\nHost: h\n\n",
+			"GET", "/", "", "h", 0, ""},
+		{"absolute-form важнее Host", "GET http://a.example/p HTTP/1.1\r\nHost: b.example\r\n\r\n",
+			"GET", "/p", "", "a.example", 0, ""},
+		{"HTTP/1.0 без Host", "GET / HTTP/1.0\r\n\r\n",
+			"GET", "/", "", "", 0, ""},
+		{"OPTIONS *", "OPTIONS * HTTP/1.1\r\nHost: h\r\n\r\n",
+			"OPTIONS", "*", "", "h", 0, ""},
+		{"Content-Length", "POST /f HTTP/1.1\r\nHost: h\r\nContent-Length: 5\r\n\r\nhelloGET",
+			"POST", "/f", "", "h", 5, "hello"},
+		{"повтор одинакового Content-Length", "POST /f HTTP/1.1\r\nHost: h\r\nContent-Length: 3, 3\r\nContent-Length: 3\r\n\r\nabc",
+			"POST", "/f", "", "h", 3, "abc"},
+		{"chunked с расширением и трейлером",
+			"POST /c HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked\r\n\r\n" +
+				"5\r\nhello\r\n6;ext=1\r\n world\r\n0\r\nX-Sum: 11\r\n\r\n",
+			"POST", "/c", "", "h", -1, "hello world"},
+		{"chunked, размер в верхнем регистре", "POST /c HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked\r\n\r\nA\r\n0123456789\r\n0\r\n\r\n",
+			"POST", "/c", "", "h", -1, "0123456789"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := parse(tt.raw, Limits{})
+			if err != nil {
+				t.Fatalf("ReadRequest: %v", err)
+			}
+			if req.Method != tt.method || req.Path != tt.path || req.RawQuery != tt.query || req.Host != tt.host {
+				t.Errorf("запрос %s %s ? %s, Host %q; ожидалось %s %s ? %s, Host %q",
+					req.Method, req.Path, req.RawQuery, req.Host, tt.method, tt.path, tt.query, tt.host)
+			}
+			if req.ContentLength != tt.contentN {
+				t.Errorf("ContentLength = %d; ожидалось %d", req.ContentLength, tt.contentN)
+			}
+			body, err := io.ReadAll(req.Body)
+			if err != nil || string(body) != tt.body {
+				t.Errorf("тело %q, %v; ожидалось %q", body, err, tt.body)
+			}
+		})
+	}
+}
+
+func TestReadRequestTrailer(t *testing.T) {
+	req, err := parse("POST / HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked\r\n\r\n1\r\nx\r\n0\r\nX-Sum: 1\r\nX-Sum: 2\r\n\r\n", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(req.Body)
+	if got := req.Trailer.Values("X-Sum"); len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Errorf("трейлер X-Sum = %v; ожидалось [1 2]", got)
+	}
+}
+
+func TestReadRequestErrors(t *testing.T) {
+	long := strings.Repeat("a", 200)
+	tests := []struct {
+		name   string
+		raw    string
+		limits Limits
+		status int
+	}{
+		{"obs-fold", "GET / HTTP/1.1\r\nHost: h\r\nX-A: 1\r\n  2\r\n\r\n", Limits{}, 400},
+		{"obs-fold табуляцией", "GET / HTTP/1.1\r\nHost: h\r\nX-A: 1\r\n\t2\r\n\r\n", Limits{}, 400},
+		{"заголовки больше предела", "GET / HTTP/1.1\r\nHost: h\r\nX-A: " + long + "\r\n\r\n", Limits{MaxHeaderBytes: 100}, 431},
+		{"сумма заголовков больше предела", "GET / HTTP/1.1\r\nHost: h\r\nX-A: " + long[:60] + "\r\nX-B: " + long[:60] + "\r\n\r\n",
+			Limits{MaxHeaderBytes: 100}, 431},
+		{"слишком много полей", "GET / HTTP/1.1\r\nHost: h\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n", Limits{MaxHeaders: 3}, 431},
+		{"длинная стартовая строка", "GET /" + long + " HTTP/1.1\r\nHost: h\r\n\r\n", Limits{MaxRequestLine: 100}, 414},
+		{"пробел перед двоеточием", "GET / HTTP/1.1\r\nHost : h\r\n\r\n", Limits{}, 400},
+		{"поле без двоеточия", "GET / HTTP/1.1\r\nHost h\r\n\r\n", Limits{}, 400},
+		{"нет Host в HTTP/1.1", "GET / HTTP/1.1\r\n\r\n", Limits{}, 400},
+		{"два Host", "GET / HTTP/1.1\r\nHost: a\r\nHost: b\r\n\r\n", Limits{}, 400},
+		{"лишние пробелы в стартовой строке", "GET  / HTTP/1.1\r\nHost: h\r\n\r\n", Limits{}, 400},
+		{"неизвестный метод", "BREW / HTTP/1.1\r\nHost: h\r\n\r\n", Limits{}, 501},
+		{"HTTP/2.0", "GET / HTTP/2.0\r\nHost: h\r\n\r\n", Limits{}, 505},
+		{"кривая версия", "GET / HTTP/1.x\r\nHost: h\r\n\r\n", Limits{}, 400},
+		{"Transfer-Encoding и Content-Length", "POST / HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n", Limits{}, 400},
+		{"chunked не последним", "POST / HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked, gzip\r\n\r\n", Limits{}, 400},
+		{"неизвестное кодирование", "POST / HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: gzip, chunked\r\n\r\n", Limits{}, 501},
+		{"chunked дважды", "POST / HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked, chunked\r\n\r\n", Limits{}, 400},
+		{"chunked в HTTP/1.0", "POST / HTTP/1.0\r\nTransfer-Encoding: chunked\r\n\r\n", Limits{}, 400},
+		{"противоречивые Content-Length", "POST / HTTP/1.1\r\nHost: h\r\nContent-Length: 3\r\nContent-Length: 4\r\n\r\n", Limits{}, 400},
+		{"Content-Length со знаком", "POST / HTTP/1.1\r\nHost: h\r\nContent-Length: +3\r\n\r\n", Limits{}, 400},
+		{"заявленное тело больше предела", "POST / HTTP/1.1\r\nHost: h\r\nContent-Length: 101\r\n\r\n", Limits{MaxBodyBytes: 100}, 413},
+		{"неизвестный Expect", "POST / HTTP/1.1\r\nHost: h\r\nExpect: magic\r\n\r\n", Limits{}, 417},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parse(tt.raw, tt.limits)
+			if got := StatusCode(err); got != tt.status {
+				t.Errorf("код %d (%v); ожидался %d", got, err, tt.status)
+			}
+		})
+	}
+}
+
+func TestChunkedErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks string
+		limits Limits
+		status int
+	}{
+		{"размер не hex", "zz\r\nhello\r\n0\r\n\r\n", Limits{}, 400},
+		{"пустой размер", "\r\n", Limits{}, 400},
+		{"нет CRLF после данных", "5\r\nhelloX\r\n0\r\n\r\n", Limits{}, 400},
+		{"размер длиннее 16 цифр", "00000000000000001\r\nx\r\n0\r\n\r\n", Limits{}, 400},
+		{"кривой трейлер", "1\r\nx\r\n0\r\nno colon\r\n\r\n", Limits{}, 400},
+		{"obs-fold в трейлере", "1\r\nx\r\n0\r\nX-A: 1\r\n 2\r\n\r\n", Limits{}, 400},
+		{"трейлер больше предела заголовков", "1\r\nx\r\n0\r\n" + strings.Repeat("X-Sum: 0123456789\r\n", 10) + "\r\n",
+			Limits{MaxHeaderBytes: 100}, 431},
+		{"слишком много полей трейлера", "1\r\nx\r\n0\r\nA: 1\r\nB: 2\r\nC: 3\r\n\r\n", Limits{MaxHeaders: 2}, 431},
+		{"тело больше предела", "40\r\n" + strings.Repeat("x", 64) + "\r\n40\r\n", Limits{MaxBodyBytes: 100}, 413},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := parse("POST / HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked\r\n\r\n"+tt.chunks, tt.limits)
+			if err != nil {
+				t.Fatalf("ReadRequest: %v", err)
+			}
+			_, err = io.ReadAll(req.Body)
+			if got := StatusCode(err); got != tt.status {
+				t.Errorf("код %d (%v); ожидался %d", got, err, tt.status)
+			}
+		})
+	}
+
+	req, err := parse("POST / HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhel", Limits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(req.Body); err != io.ErrUnexpectedEOF {
+		t.Errorf("оборванный чанк: %v; ожидался io.ErrUnexpectedEOF", err)
+	}
+}
+
+// Конвейерные запросы читаются по одному, тело первого не съедает
+// начало второго.
+func TestReadRequestPipelined(t *testing.T) {
+	raw := "POST /1 HTTP/1.1\r\nHost: h\r\nContent-Length: 3\r\n\r\nabc" +
+		"POST /2 HTTP/1.1\r\nHost: h\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nde\r\n0\r\n\r\n" +
+		"GET /3 HTTP/1.1\r\nHost: h\r\n\r\n"
+	p := NewParser(strings.NewReader(raw), io.Discard)
+	for _, want := range []struct{ path, body string }{{"/1", "abc"}, {"/2", "de"}, {"/3", ""}} {
+		req, err := p.ReadRequest()
+		if err != nil {
+			t.Fatalf("%s: %v", want.path, err)
+		}
+		body, _ := io.ReadAll(req.Body)
+		if req.Path != want.path || string(body) != want.body {
+			t.Errorf("%s %q; ожидалось %s %q", req.Path, body, want.path, want.body)
+		}
+	}
+	if _, err := p.ReadRequest(); err != io.EOF {
+		t.Errorf("после последнего запроса %v; ожидался io.EOF", err)
+	}
+}
+
+// "100 Continue" уходит только при первом чтении тела.
+func TestExpectContinue(t *testing.T) {
+	var out bytes.Buffer
+	p := NewParser(strings.NewReader("PUT / HTTP/1.1\r\nHost: h\r\nExpect: 100-continue\r\nContent-Length: 2\r\n\r\nok"), &out)
+	req, err := p.ReadRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("ответ %q до чтения тела", out.String())
+	}
+	body, _ := io.ReadAll(req.Body)
+	if string(body) != "ok" || out.String() != "HTTP/1.1 100 Continue\r\n\r\n" {
+		t.Errorf("тело %q, отправлено %q", body, out.String())
+	}
+}
@@ -0,0 +1,302 @@
+package httpraw
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ResponseWriter — интерфейс, через который обработчик формирует ответ.
+// Повторяет по смыслу http.ResponseWriter, но без зависимости от net/http.
+type ResponseWriter interface {
+	Header() Header
+	WriteHeader(code int)
+	Write(b []byte) (int, error)
+}
+
+// Handler обрабатывает один запрос.
+type Handler interface {
+	ServeHTTP(w ResponseWriter, r *Request)
+}
+
+// HandlerFunc позволяет использовать обычную функцию как Handler.
+type HandlerFunc func(w ResponseWriter, r *Request)
+
+func (f HandlerFunc) ServeHTTP(w ResponseWriter, r *Request) { f(w, r) }
+
+// Error отправляет текстовый ответ с кодом ошибки.
+func Error(w ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	fmt.Fprintln(w, msg)
+}
+
+// Flusher реализуют писатели, умеющие отправить уже записанную часть
+// ответа, не дожидаясь конца обработчика.
+type Flusher interface {
+	Flush() error
+}
+
+// Hijacker реализуют писатели, позволяющие обработчику забрать соединение
+// себе (например, после Upgrade: websocket). После Hijack сервер больше
+// ничего не пишет в соединение и не читает из него; закрыть его должен
+// вызывающий ServeConn после возврата обработчика.
+type Hijacker interface {
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+// Размер тела, после которого ответ перестаёт копиться в памяти и уходит
+// потоком.
+const maxBufferedBody = 32 << 10
+
+// response собирает небольшой ответ HTTP/1.x в памяти и отправляет его
+// целиком в finish, чтобы знать Content-Length. Если обработчик вызывает
+// Flush или тело не помещается в буфер, ответ переходит в потоковый
+// режим: без заданного Content-Length тело идёт чанками
+// (Transfer-Encoding: chunked), а для HTTP/1.0 — до закрытия соединения.
+type response struct {
+	conn net.Conn
+	br   *bufio.Reader
+	w    *bufio.Writer
+	req  *Request
+
+	header      Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+
+	// streaming — статусная строка и заголовки уже в сети.
+	streaming bool
+	chunked   bool
+	declared  int64 // Content-Length от обработчика, -1 если не задан
+	written   int64
+
+	// keepAlive вычисляется сервером до вызова обработчика; обработчик
+	// может закрыть соединение, выставив Connection: close.
+	keepAlive bool
+	// keepAliveHint — значение заголовка Keep-Alive для клиентов HTTP/1.0.
+	keepAliveHint string
+
+	hijacked bool
+	// detach, если задан, забирает соединение у цикла epoll при Hijack и
+	// возвращает читателя его оставшихся байт.
+	detach func() *bufio.Reader
+}
+
+func newResponse(conn net.Conn, br *bufio.Reader, w *bufio.Writer, req *Request) *response {
+	return &response{conn: conn, br: br, w: w, req: req, header: make(Header), declared: -1}
+}
+
+func (r *response) Header() Header { return r.header }
+
+func (r *response) WriteHeader(code int) {
+	if r.wroteHeader {
+		return
+	}
+	r.wroteHeader = true
+	// код вне 100–999 не уложить в статусную строку — это ошибка
+	// обработчика, клиент получит 500
+	if code < 100 || code > 999 {
+		code = 500
+	}
+	r.status = code
+}
+
+func (r *response) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(200)
+	}
+	if !bodyAllowed(r.status) {
+		return 0, fmt.Errorf("ответ %d не может содержать тело", r.status)
+	}
+	if r.streaming {
+		return r.writeBody(b)
+	}
+	n, _ := r.body.Write(b)
+	if r.body.Len() > maxBufferedBody {
+		if err := r.startStreaming(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Flush отправляет заголовки и накопленное тело клиенту.
+func (r *response) Flush() error {
+	if !r.wroteHeader {
+		r.WriteHeader(200)
+	}
+	if !r.streaming {
+		if err := r.startStreaming(); err != nil {
+			return err
+		}
+	}
+	return r.w.Flush()
+}
+
+// Hijack отдаёт соединение вместе с буферами: в br могут лежать байты,
+// пришедшие сразу за заголовками запроса.
+func (r *response) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if r.streaming {
+		return nil, nil, errors.New("ответ уже начал отправляться")
+	}
+	if r.hijacked {
+		return nil, nil, errors.New("соединение уже забрано")
+	}
+	r.hijacked = true
+	if r.detach != nil {
+		r.br = r.detach()
+	}
+	// дедлайн чтения тела к новому протоколу отношения не имеет
+	r.conn.SetReadDeadline(time.Time{})
+	return r.conn, bufio.NewReadWriter(r.br, r.w), nil
+}
+
+// bodyAllowed — RFC 9110 6.4.1: у 1xx, 204 и 304 тела нет.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != 204 && status != 304
+}
+
+// startStreaming выбирает способ разграничения тела, отправляет заголовки
+// и всё, что успело накопиться в буфере.
+func (r *response) startStreaming() error {
+	r.streaming = true
+	if bodyAllowed(r.status) {
+		if cl := r.header.Get("Content-Length"); cl != "" {
+			n, err := strconv.ParseInt(cl, 10, 64)
+			if err != nil || n < 0 {
+				return fmt.Errorf("некорректный Content-Length %q", cl)
+			}
+			r.declared = n
+		} else if r.req.ProtoAtLeast(1, 1) {
+			r.chunked = true
+			r.header.Set("Transfer-Encoding", "chunked")
+		} else {
+			// HTTP/1.0 не знает chunked: конец тела — закрытие соединения
+			r.keepAlive = false
+		}
+	}
+	r.writeHead()
+
+	buffered := r.body.Bytes()
+	r.body = bytes.Buffer{}
+	if len(buffered) > 0 {
+		if _, err := r.writeBody(buffered); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *response) writeBody(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	r.written += int64(len(b))
+	if r.declared >= 0 && r.written > r.declared {
+		return 0, fmt.Errorf("тело длиннее объявленного Content-Length %d", r.declared)
+	}
+	if r.req.Method == "HEAD" {
+		return len(b), nil
+	}
+	if r.chunked {
+		fmt.Fprintf(r.w, "%x\r\n", len(b))
+		n, err := r.w.Write(b)
+		r.w.WriteString("\r\n")
+		return n, err
+	}
+	return r.w.Write(b)
+}
+
+// finish завершает ответ: отправляет его целиком или закрывает поток.
+func (r *response) finish() error {
+	if !r.wroteHeader {
+		r.WriteHeader(200)
+	}
+	if r.streaming {
+		if r.chunked && r.req.Method != "HEAD" {
+			_, err := r.w.WriteString("0\r\n\r\n")
+			return err
+		}
+		if r.declared >= 0 && r.written != r.declared {
+			// клиент ждёт ещё байт, которых не будет: соединение не
+			// переиспользовать
+			r.keepAlive = false
+		}
+		return nil
+	}
+
+	if bodyAllowed(r.status) {
+		r.header.Set("Content-Length", strconv.Itoa(r.body.Len()))
+	}
+	r.writeHead()
+	if r.req.Method != "HEAD" && bodyAllowed(r.status) {
+		_, err := r.w.Write(r.body.Bytes())
+		return err
+	}
+	return nil
+}
+
+// writeHead записывает статусную строку и заголовки.
+func (r *response) writeHead() {
+	if r.header.HasToken("Connection", "close") {
+		r.keepAlive = false
+	}
+
+	h := r.header
+	if h.Get("Date") == "" {
+		h.Set("Date", time.Now().UTC().Format(TimeFormat))
+	}
+	if bodyAllowed(r.status) && h.Get("Content-Type") == "" && (r.body.Len() > 0 || r.streaming) {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	switch {
+	case !r.keepAlive:
+		h.Set("Connection", "close")
+	case !r.req.ProtoAtLeast(1, 1):
+		// HTTP/1.0 держит соединение только по явной договорённости
+		h.Set("Connection", "keep-alive")
+		if r.keepAliveHint != "" {
+			h.Set("Keep-Alive", r.keepAliveHint)
+		}
+	}
+
+	fmt.Fprintf(r.w, "HTTP/1.1 %d %s\r\n", r.status, StatusText(r.status))
+	writeHeader(r.w, h)
+	r.w.WriteString("\r\n")
+}
+
+// writeHeader пишет поля в алфавитном порядке, чтобы байты ответа не
+// менялись от запуска к запуску. Поля с CR или LF в имени или значении
+// пропускаются: записанные как есть, они добавили бы к ответу чужие
+// поля или тело.
+func writeHeader(w *bufio.Writer, h Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if strings.ContainsAny(k, "\r\n") {
+			continue
+		}
+		for _, v := range h[k] {
+			if strings.ContainsAny(v, "\r\n") {
+				continue
+			}
+			w.WriteString(k)
+			w.WriteString(": ")
+			w.WriteString(v)
+			w.WriteString("\r\n")
+		}
+	}
+}
+
+// TimeFormat — формат дат в заголовках (IMF-fixdate).
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
@@ -0,0 +1,151 @@
+package httpraw
+
+import (
+	"sort"
+	"strings"
+)
+
+// Router выбирает обработчик по методу и шаблону пути. В шаблоне
+// сегмент {name} совпадает с одним сегментом пути, а {name...} в конце —
+// со всем остатком пути (может быть пустым).
+//
+//	rt.HandleFunc("GET", "/users/{id}", showUser)
+//	rt.Handle("GET", "/static/{path...}", FileServer("public"))
+//
+// Пустой метод означает любой. HEAD обслуживается обработчиком GET.
+type Router struct {
+	routes []*route
+
+	// NotFound вызывается, если ни один шаблон не подошёл.
+	NotFound Handler
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  Handler
+}
+
+// NewRouter создаёт пустую таблицу маршрутов.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle регистрирует обработчик. Маршруты проверяются в порядке
+// регистрации.
+func (rt *Router) Handle(method, pattern string, h Handler) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic("httpraw: шаблон должен начинаться с /: " + pattern)
+	}
+	segments := splitPath(pattern)
+	for i, s := range segments {
+		if strings.HasSuffix(s, "...}") && i != len(segments)-1 {
+			panic("httpraw: {name...} допустим только в конце шаблона: " + pattern)
+		}
+	}
+	rt.routes = append(rt.routes, &route{
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+		handler:  h,
+	})
+}
+
+// HandleFunc регистрирует функцию-обработчик.
+func (rt *Router) HandleFunc(method, pattern string, f func(ResponseWriter, *Request)) {
+	rt.Handle(method, pattern, HandlerFunc(f))
+}
+
+// Routes возвращает зарегистрированные маршруты в виде "МЕТОД шаблон".
+func (rt *Router) Routes() []string {
+	list := make([]string, 0, len(rt.routes))
+	for _, r := range rt.routes {
+		method := r.method
+		if method == "" {
+			method = "*"
+		}
+		list = append(list, method+" "+r.pattern)
+	}
+	return list
+}
+
+func (rt *Router) ServeHTTP(w ResponseWriter, r *Request) {
+	path := splitPath(r.Path)
+	var allowed []string
+	for _, rte := range rt.routes {
+		params, ok := rte.match(path)
+		if !ok {
+			continue
+		}
+		if !rte.allows(r.Method) {
+			allowed = append(allowed, rte.method)
+			if rte.method == "GET" {
+				allowed = append(allowed, "HEAD")
+			}
+			continue
+		}
+		r.Params = params
+		r.Pattern = rte.pattern
+		rte.handler.ServeHTTP(w, r)
+		return
+	}
+
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		Error(w, "405 Method Not Allowed", 405)
+		return
+	}
+	if rt.NotFound != nil {
+		rt.NotFound.ServeHTTP(w, r)
+		return
+	}
+	Error(w, "404 Not Found", 404)
+}
+
+func (rte *route) allows(method string) bool {
+	return rte.method == "" || rte.method == method || method == "HEAD" && rte.method == "GET"
+}
+
+// match сравнивает сегменты пути с шаблоном и собирает параметры.
+func (rte *route) match(path []string) (map[string]string, bool) {
+	var params map[string]string
+	set := func(name, value string) {
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[name] = value
+	}
+
+	for i, seg := range rte.segments {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "...}") {
+			set(seg[1:len(seg)-4], strings.Join(path[i:], "/"))
+			return params, true
+		}
+		if i >= len(path) {
+			return nil, false
+		}
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if path[i] == "" {
+				return nil, false
+			}
+			set(seg[1:len(seg)-1], path[i])
+			continue
+		}
+		if seg != path[i] {
+			return nil, false
+		}
+	}
+	return params, len(path) == len(rte.segments)
+}
+
+// splitPath разбивает путь на сегменты. Завершающий слэш даёт пустой
+// последний сегмент, так что /dir и /dir/ — разные пути.
+func splitPath(p string) []string {
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
@@ -0,0 +1,395 @@
+package httpraw
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"labs1/guard"
+)
+
+// Server обслуживает соединения HTTP/1.x: держит их открытыми между
+// запросами (keep-alive) и отвечает на конвейерные запросы по порядку.
+type Server struct {
+	Handler Handler
+	Limits  Limits
+
+	// IdleTimeout — сколько ждать первый байт следующего запроса на
+	// открытом соединении. Молчащее соединение закрывается без ответа.
+	IdleTimeout time.Duration
+	// HeaderTimeout — за сколько после первого байта должны прийти
+	// стартовая строка и все заголовки, иначе 408. Защищает от клиентов,
+	// которые присылают заголовок по байту (slowloris).
+	HeaderTimeout time.Duration
+	// BodyTimeout — за сколько должно прийти тело запроса; обработчик
+	// получит при чтении ошибку 408.
+	BodyTimeout time.Duration
+	// WriteTimeout ограничивает каждую запись в сокет: медленный читатель
+	// не держит соединение, а долгий поток событий не обрывается.
+	WriteTimeout time.Duration
+	// MaxRequestsPerConn — после стольких запросов соединение закрывается.
+	MaxRequestsPerConn int
+
+	// Logf, если задан, получает сообщения об ошибках соединений.
+	Logf func(format string, args ...any)
+	// Rejected, если задан, считает отказы клиентам по причинам.
+	Rejected *guard.Counters
+
+	activeConns   atomic.Int64
+	totalConns    atomic.Int64
+	totalRequests atomic.Int64
+
+	// conns — открытые соединения: true, пока обрабатывается запрос.
+	mu         sync.Mutex
+	conns      map[net.Conn]bool
+	inShutdown atomic.Bool
+	doneOnce   sync.Once
+	closeOnce  sync.Once
+	done       chan struct{}
+}
+
+// Stats — счётчики соединений и запросов сервера.
+type Stats struct {
+	ActiveConns   int64 `json:"active_connections"`
+	TotalConns    int64 `json:"total_connections"`
+	TotalRequests int64 `json:"total_requests"`
+}
+
+// Stats возвращает текущие значения счётчиков.
+func (s *Server) Stats() Stats {
+	return Stats{
+		ActiveConns:   s.activeConns.Load(),
+		TotalConns:    s.totalConns.Load(),
+		TotalRequests: s.totalRequests.Load(),
+	}
+}
+
+const (
+	defaultIdleTimeout   = 15 * time.Second
+	defaultHeaderTimeout = 10 * time.Second
+	defaultBodyTimeout   = 30 * time.Second
+	defaultMaxRequests   = 100
+
+	// Сколько непрочитанного тела сервер готов пропустить, чтобы сохранить
+	// соединение. Если обработчик бросил больше — проще закрыть.
+	maxDrainBytes = 256 << 10
+)
+
+// Done закрывается, когда начинается Shutdown. Долгие обработчики (SSE,
+// WebSocket) ждут его, чтобы завершиться самим.
+func (s *Server) Done() <-chan struct{} {
+	s.doneOnce.Do(func() { s.done = make(chan struct{}) })
+	return s.done
+}
+
+// Shutdown останавливает сервер: простаивающие соединения закрываются
+// сразу, начатые запросы дорабатывают и получают Connection: close.
+// Слушатель закрывает вызывающий. Если ctx истёк раньше, оставшиеся
+// соединения рвутся.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+	s.Done()
+	s.closeOnce.Do(func() { close(s.done) })
+
+	s.mu.Lock()
+	for conn, busy := range s.conns {
+		if !busy {
+			// будим ожидание следующего запроса
+			conn.SetReadDeadline(time.Now())
+		}
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		s.mu.Lock()
+		n := len(s.conns)
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			for conn := range s.conns {
+				conn.Close()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// setState отмечает соединение занятым или простаивающим. Для
+// простаивающего сразу ставится дедлайн ожидания запроса — под тем же
+// мьютексом, что и в Shutdown, чтобы не перезаписать его пробуждение.
+// false — сервер останавливается и новых запросов не ждёт.
+func (s *Server) setState(conn net.Conn, busy bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]bool)
+	}
+	s.conns[conn] = busy
+	if busy {
+		return true
+	}
+	if s.inShutdown.Load() {
+		return false
+	}
+	conn.SetReadDeadline(time.Now().Add(s.idleTimeout()))
+	return true
+}
+
+func (s *Server) forget(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout > 0 {
+		return s.IdleTimeout
+	}
+	return defaultIdleTimeout
+}
+
+func (s *Server) headerTimeout() time.Duration {
+	if s.HeaderTimeout > 0 {
+		return s.HeaderTimeout
+	}
+	return defaultHeaderTimeout
+}
+
+func (s *Server) bodyTimeout() time.Duration {
+	if s.BodyTimeout > 0 {
+		return s.BodyTimeout
+	}
+	return defaultBodyTimeout
+}
+
+func (s *Server) reject(reason string) {
+	if s.Rejected != nil {
+		s.Rejected.Inc(reason)
+	}
+}
+
+func (s *Server) maxRequests() int {
+	if s.MaxRequestsPerConn > 0 {
+		return s.MaxRequestsPerConn
+	}
+	return defaultMaxRequests
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.Logf != nil {
+		s.Logf(format, args...)
+	}
+}
+
+// ServeConn обрабатывает запросы на соединении, пока клиент или сервер не
+// решат его закрыть. Возвращает число обработанных запросов. Соединение
+// закрывает вызывающий.
+func (s *Server) ServeConn(conn net.Conn) int {
+	return s.ServeBuffered(conn, bufio.NewReaderSize(conn, 4096))
+}
+
+// ServeBuffered — то же, что ServeConn, но читает через br, в котором уже
+// могут лежать байты соединения (например, после проверки preface HTTP/2).
+func (s *Server) ServeBuffered(conn net.Conn, br *bufio.Reader) int {
+	s.totalConns.Add(1)
+	s.activeConns.Add(1)
+	defer s.activeConns.Add(-1)
+	defer s.forget(conn)
+
+	out := deadlineWriter{conn: conn, d: s.WriteTimeout}
+	bw := bufio.NewWriterSize(out, 4096)
+	defer bw.Flush()
+	// "100 Continue" идёт через bw: ответы на предыдущие конвейерные
+	// запросы, ещё лежащие в буфере, должны уйти раньше него
+	parser := NewParser(br, flushWriter{bw})
+	parser.Limits = s.Limits
+
+	var tlsState *tls.ConnectionState
+	if tc, ok := conn.(*tls.Conn); ok {
+		st := tc.ConnectionState()
+		tlsState = &st
+	}
+
+	served := 0
+	for {
+		// Первый запрос ждём так же, как и последующие: молчащий клиент
+		// не должен занимать соединение вечно.
+		if !s.setState(conn, false) {
+			return served
+		}
+		if _, err := br.Peek(1); err != nil {
+			if !isClosedConn(err) {
+				s.logf("Ошибка чтения запроса от %s: %v", conn.RemoteAddr(), err)
+			}
+			return served
+		}
+		s.setState(conn, true)
+
+		// Запрос начался — теперь заголовки должны прийти целиком.
+		conn.SetReadDeadline(time.Now().Add(s.headerTimeout()))
+		req, err := parser.ReadRequest()
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				err = errorf(408, "заголовки запроса не получены за %v", s.headerTimeout())
+			}
+			if code := StatusCode(err); code != 0 {
+				s.reject(guard.ReasonForStatus(code))
+				bw.Flush()
+				WriteError(out, err)
+			}
+			if !isClosedConn(err) {
+				s.logf("Ошибка чтения запроса от %s: %v", conn.RemoteAddr(), err)
+			}
+			return served
+		}
+		expect, _ := req.Body.(*continueReader)
+		if req.ContentLength > 0 || req.Chunked {
+			conn.SetReadDeadline(time.Now().Add(s.bodyTimeout()))
+			req.Body = &timeoutBody{r: req.Body, s: s}
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+
+		served++
+		s.totalRequests.Add(1)
+		req.RemoteAddr = conn.RemoteAddr().String()
+		req.Seq = served
+		req.TLS = tlsState
+
+		resp := newResponse(conn, parser.Reader(), bw, req)
+		resp.keepAlive = s.wantsKeepAlive(req) && served < s.maxRequests() && !s.inShutdown.Load()
+		if resp.keepAlive && !req.ProtoAtLeast(1, 1) {
+			resp.keepAliveHint = fmt.Sprintf("timeout=%d, max=%d",
+				int(s.idleTimeout().Seconds()), s.maxRequests()-served)
+		}
+
+		s.Handler.ServeHTTP(resp, req)
+		if s.inShutdown.Load() {
+			// остановка началась, пока работал обработчик
+			resp.keepAlive = false
+		}
+		if resp.hijacked {
+			return served
+		}
+
+		// Остаток тела нужно прочитать, иначе он будет принят за начало
+		// следующего запроса. Если обработчик не тронул тело с
+		// Expect: 100-continue, клиент его не шлёт и ждёт ответа: чтение
+		// отправило бы запоздалое 100 и повисло, поэтому соединение
+		// просто закрывается.
+		if expect != nil && !expect.sent {
+			resp.keepAlive = false
+		}
+		if resp.keepAlive {
+			n, err := io.CopyN(io.Discard, req.Body, maxDrainBytes+1)
+			if n > maxDrainBytes || err != nil && err != io.EOF {
+				resp.keepAlive = false
+			}
+		}
+		resp.finish()
+
+		// Пока в буфере парсера лежат следующие конвейерные запросы,
+		// ответы копятся в bw и уходят одной записью.
+		if !resp.keepAlive || parser.Buffered() == 0 {
+			if err := bw.Flush(); err != nil {
+				if isClosedConn(err) {
+					return served
+				}
+				s.logf("Ошибка отправки ответа %s: %v", conn.RemoteAddr(), err)
+				return served
+			}
+		}
+		if !resp.keepAlive {
+			return served
+		}
+	}
+}
+
+// timeoutBody превращает истёкший дедлайн чтения тела в ошибку 408 и
+// учитывает отказы из-за тела.
+type timeoutBody struct {
+	r       io.Reader
+	s       *Server
+	counted bool
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = errorf(408, "тело запроса не получено за %v", b.s.bodyTimeout())
+	}
+	if code := StatusCode(err); code != 0 && !b.counted {
+		b.counted = true
+		if code == 408 {
+			b.s.reject(guard.BodyTimeout)
+		} else {
+			b.s.reject(guard.ReasonForStatus(code))
+		}
+	}
+	return n, err
+}
+
+// flushWriter пишет в bw и сразу отправляет накопленное.
+type flushWriter struct {
+	bw *bufio.Writer
+}
+
+func (w flushWriter) Write(p []byte) (int, error) {
+	n, err := w.bw.Write(p)
+	if err == nil {
+		err = w.bw.Flush()
+	}
+	return n, err
+}
+
+// deadlineWriter ставит дедлайн перед каждой записью в сокет.
+type deadlineWriter struct {
+	conn net.Conn
+	d    time.Duration
+}
+
+func (w deadlineWriter) Write(p []byte) (int, error) {
+	if w.d > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(w.d))
+	}
+	return w.conn.Write(p)
+}
+
+// wantsKeepAlive — RFC 9112 9.3: HTTP/1.1 держит соединение по умолчанию,
+// HTTP/1.0 — только с Connection: keep-alive.
+func (s *Server) wantsKeepAlive(req *Request) bool {
+	if req.Header.HasToken("Connection", "close") {
+		return false
+	}
+	if req.ProtoAtLeast(1, 1) {
+		return true
+	}
+	return req.Header.HasToken("Connection", "keep-alive")
+}
+
+// isClosedConn отделяет штатное завершение (клиент ушёл, истёк таймаут
+// простоя) от настоящих ошибок.
+func isClosedConn(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,151 @@
+package httpraw
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve запускает Server с handler на одном соединении и возвращает
+// клиентскую сторону.
+func serve(t *testing.T, handler HandlerFunc) net.Conn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &Server{Handler: handler, IdleTimeout: 5 * time.Second}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.ServeConn(conn)
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	return c
+}
+
+// Обработчик не читает тело с Expect: 100-continue — клиент получает
+// ответ без 100 и закрытое соединение, а не зависший сервер.
+func TestExpectContinueUnreadBody(t *testing.T) {
+	c := serve(t, func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "no")
+	})
+	io.WriteString(c, "POST / HTTP/1.1\r\nHost: h\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n")
+	got, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatalf("соединение не закрыто: %v, получено %q", err, got)
+	}
+	if !strings.HasPrefix(string(got), "HTTP/1.1 200 OK\r\n") || strings.Contains(string(got), "100 Continue") ||
+		!strings.Contains(string(got), "Connection: close\r\n") || !strings.HasSuffix(string(got), "\r\n\r\nno") {
+		t.Errorf("ответ %q", got)
+	}
+}
+
+// "100 Continue" для конвейерного запроса приходит после ответов на
+// предыдущие запросы, даже если они ещё лежали в буфере.
+func TestExpectContinuePipelined(t *testing.T) {
+	c := serve(t, func(w ResponseWriter, r *Request) {
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, r.Path+":"+string(body))
+	})
+	io.WriteString(c, "GET /1 HTTP/1.1\r\nHost: h\r\n\r\n"+
+		"PUT /2 HTTP/1.1\r\nHost: h\r\nExpect: 100-continue\r\nContent-Length: 2\r\n\r\n")
+	br := bufio.NewReader(c)
+
+	resp, err := NewParser(br, nil).ReadResponse("GET")
+	if err != nil {
+		t.Fatalf("первый ответ: %v", err)
+	}
+	if body, _ := io.ReadAll(resp.Body); resp.Status != 200 || string(body) != "/1:" {
+		t.Fatalf("первым пришёл ответ %d %q; ожидался 200 /1:", resp.Status, body)
+	}
+	line, err := br.ReadString('\n')
+	if err != nil || line != "HTTP/1.1 100 Continue\r\n" {
+		t.Fatalf("после первого ответа %q, %v; ожидалось 100 Continue", line, err)
+	}
+	br.ReadString('\n')
+
+	io.WriteString(c, "ok")
+	resp, err = NewParser(br, nil).ReadResponse("PUT")
+	if err != nil {
+		t.Fatalf("второй ответ: %v", err)
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "/2:ok" {
+		t.Errorf("второй ответ %q; ожидалось /2:ok", body)
+	}
+}
+
+// Значение с CRLF не попадает в ответ и не добавляет к нему полей.
+func TestResponseHeaderInjection(t *testing.T) {
+	c := serve(t, func(w ResponseWriter, r *Request) {
+		w.Header().Set("X-Echo", r.Query().Get("v"))
+		w.Header().Set("X-Safe", "1")
+		io.WriteString(w, "ok")
+	})
+	io.WriteString(c, "GET /?v=a%0d%0aSet-Cookie:%20x=1 HTTP/1.1\r\nHost: h\r\nConnection: close\r\n\r\n")
+	resp, err := NewParser(bufio.NewReader(c), nil).ReadResponse("GET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Set-Cookie") != "" || resp.Header.Get("X-Echo") != "" || resp.Header.Get("X-Safe") != "1" {
+		t.Errorf("заголовок ответа %v", resp.Header)
+	}
+}
+
+// Перенаправление на каталог со слэшем: Location строится из
+// экранированного пути.
+func TestFileServerDirRedirect(t *testing.T) {
+	root := t.TempDir()
+	for _, dir := range []string{"plain", "a b", "x\r\nSet-Cookie: y=1", "evil.example"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0o755); err != nil {
+			t.Skipf("каталог %q: %v", dir, err)
+		}
+	}
+	tests := []struct {
+		path, query, want string
+	}{
+		{"/plain", "", "/plain/"},
+		{"/plain", "q=1", "/plain/?q=1"},
+		{"/a b", "", "/a%20b/"},
+		{"/x\r\nSet-Cookie: y=1", "", "/x%0D%0ASet-Cookie:%20y=1/"},
+		{"//evil.example", "", "/evil.example/"},
+	}
+	fs := FileServer(root)
+	for _, tt := range tests {
+		w := &testWriter{header: Header{}}
+		fs.ServeHTTP(w, &Request{Method: "GET", Path: tt.path, RawQuery: tt.query, Header: Header{}})
+		if w.code != 301 || w.header.Get("Location") != tt.want {
+			t.Errorf("%q: %d, Location %q; ожидалось 301 %q", tt.path, w.code, w.header.Get("Location"), tt.want)
+		}
+	}
+}
+
+type testWriter struct {
+	header Header
+	code   int
+}
+
+func (w *testWriter) Header() Header { return w.header }
+func (w *testWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+func (w *testWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(200)
+	return len(b), nil
+}
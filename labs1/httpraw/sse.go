@@ -0,0 +1,64 @@
+package httpraw
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EventStream отправляет Server-Sent Events (text/event-stream) поверх
+// потокового ответа. Каждое событие сразу сбрасывается в сеть.
+type EventStream struct {
+	w ResponseWriter
+	f Flusher
+}
+
+// NewEventStream выставляет заголовки потока событий и отправляет их
+// клиенту. retryMs, если больше нуля, подсказывает браузеру, через сколько
+// переподключаться.
+func NewEventStream(w ResponseWriter, retryMs int) (*EventStream, error) {
+	f, ok := w.(Flusher)
+	if !ok {
+		return nil, errors.New("ResponseWriter не поддерживает Flush")
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream; charset=utf-8")
+	h.Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+
+	es := &EventStream{w: w, f: f}
+	if retryMs > 0 {
+		fmt.Fprintf(w, "retry: %d\n\n", retryMs)
+	}
+	return es, f.Flush()
+}
+
+// Send отправляет событие. Пустые event и id не пишутся; многострочные
+// данные разбиваются на несколько полей data.
+func (es *EventStream) Send(event, id, data string) error {
+	var b strings.Builder
+	if id != "" {
+		fmt.Fprintf(&b, "id: %s\n", id)
+	}
+	if event != "" {
+		fmt.Fprintf(&b, "event: %s\n", event)
+	}
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	if _, err := es.w.Write([]byte(b.String())); err != nil {
+		return err
+	}
+	return es.f.Flush()
+}
+
+// Comment отправляет строку-комментарий; клиенты её игнорируют, но она
+// держит соединение живым через прокси.
+func (es *EventStream) Comment(text string) error {
+	if _, err := fmt.Fprintf(es.w, ": %s\n\n", text); err != nil {
+		return err
+	}
+	return es.f.Flush()
+}
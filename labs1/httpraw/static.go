@@ -0,0 +1,189 @@
+package httpraw
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IndexFiles — файлы, которые отдаются при запросе каталога.
+var IndexFiles = []string{"index.html", "index.htm"}
+
+// FileServer раздаёт файлы из каталога root. Путь берётся из параметра
+// маршрута {path...}, а если его нет — из пути запроса целиком.
+// Поддерживаются ETag/If-None-Match и Last-Modified/If-Modified-Since.
+func FileServer(root string) Handler {
+	return &fileServer{root: root}
+}
+
+type fileServer struct {
+	root string
+}
+
+func (fs *fileServer) ServeHTTP(w ResponseWriter, r *Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		Error(w, "405 Method Not Allowed", 405)
+		return
+	}
+
+	name, ok := r.Params["path"]
+	if !ok {
+		name = r.Path
+	}
+	// path.Clean от корня не даёт выйти выше root через ../
+	name = path.Clean("/" + name)
+	full := filepath.Join(fs.root, filepath.FromSlash(name))
+
+	info, err := os.Stat(full)
+	if err != nil {
+		if os.IsNotExist(err) {
+			Error(w, "404 Not Found", 404)
+		} else {
+			Error(w, "403 Forbidden", 403)
+		}
+		return
+	}
+
+	if info.IsDir() {
+		// относительные ссылки внутри index.html работают только со слэшем
+		if !strings.HasSuffix(r.Path, "/") {
+			// r.Path уже декодирован: %0d%0a в нём — настоящие CR и LF.
+			// Ведущие слэши схлопываются, чтобы //host/ не стал
+			// ссылкой на чужой сайт.
+			target := (&url.URL{Path: "/" + strings.TrimLeft(r.Path, "/") + "/"}).EscapedPath()
+			if r.RawQuery != "" {
+				target += "?" + r.RawQuery
+			}
+			w.Header().Set("Location", target)
+			w.WriteHeader(301)
+			return
+		}
+		for _, index := range IndexFiles {
+			indexPath := filepath.Join(full, index)
+			if ii, err := os.Stat(indexPath); err == nil && !ii.IsDir() {
+				serveFile(w, r, indexPath, ii)
+				return
+			}
+		}
+		serveDirList(w, r, full)
+		return
+	}
+
+	serveFile(w, r, full, info)
+}
+
+// ETag строится из размера и времени изменения: дёшево и меняется при
+// любой перезаписи файла.
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
+
+func serveFile(w ResponseWriter, r *Request, name string, info os.FileInfo) {
+	etag := fileETag(info)
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Last-Modified", modTime.Format(TimeFormat))
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", ContentTypeByName(name))
+	}
+
+	if notModified(r, etag, modTime) {
+		h.Del("Content-Type")
+		w.WriteHeader(304)
+		return
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		Error(w, "403 Forbidden", 403)
+		return
+	}
+	defer f.Close()
+
+	// с известной длиной большой файл уходит потоком без chunked;
+	// при HEAD writer тело не отправит
+	h.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.WriteHeader(200)
+	io.Copy(w, f)
+}
+
+// notModified — RFC 9110 13.2.2: If-None-Match важнее If-Modified-Since.
+func notModified(r *Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := time.Parse(TimeFormat, ims)
+		return err == nil && !modTime.After(t)
+	}
+	return false
+}
+
+// etagMatch выполняет слабое сравнение со списком из If-None-Match.
+func etagMatch(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func serveDirList(w ResponseWriter, r *Request, dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		Error(w, "403 Forbidden", 403)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<html><head><title>%s</title></head><body>\n<h1>%s</h1>\n<ul>\n",
+		html.EscapeString(r.Path), html.EscapeString(r.Path))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(name), html.EscapeString(name))
+	}
+	fmt.Fprint(w, "</ul>\n</body></html>\n")
+}
+
+// ContentTypeByName определяет MIME-тип по расширению файла.
+func ContentTypeByName(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	switch ext {
+	case ".html", ".htm":
+		return "text/html; charset=utf-8"
+	case ".css":
+		return "text/css; charset=utf-8"
+	case ".js", ".mjs":
+		return "text/javascript; charset=utf-8"
+	case ".json":
+		return "application/json"
+	case ".txt", ".md":
+		return "text/plain; charset=utf-8"
+	case ".svg":
+		return "image/svg+xml"
+	case ".wasm":
+		return "application/wasm"
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
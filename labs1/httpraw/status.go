@@ -0,0 +1,98 @@
+package httpraw
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var statusText = map[int]string{
+	100: "Continue",
+	101: "Switching Protocols",
+	200: "OK",
+	201: "Created",
+	202: "Accepted",
+	204: "No Content",
+	206: "Partial Content",
+	301: "Moved Permanently",
+	302: "Found",
+	303: "See Other",
+	304: "Not Modified",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
+	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	406: "Not Acceptable",
+	408: "Request Timeout",
+	411: "Length Required",
+	413: "Content Too Large",
+	414: "URI Too Long",
+	415: "Unsupported Media Type",
+	417: "Expectation Failed",
+	421: "Misdirected Request",
+	426: "Upgrade Required",
+	429: "Too Many Requests",
+	431: "Request Header Fields Too Large",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+	505: "HTTP Version Not Supported",
+}
+
+// StatusText возвращает текстовую фразу для кода ответа.
+func StatusText(code int) string {
+	if text, ok := statusText[code]; ok {
+		return text
+	}
+	return "Status " + fmt.Sprint(code)
+}
+
+// StatusError — ошибка разбора запроса, которая уже знает, каким кодом на неё
+// нужно ответить клиенту.
+type StatusError struct {
+	Status int
+	Reason string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, StatusText(e.Status), e.Reason)
+}
+
+func errorf(status int, format string, args ...any) error {
+	return &StatusError{Status: status, Reason: fmt.Sprintf(format, args...)}
+}
+
+// StatusCode возвращает код ответа для ошибки парсера. Для прочих ошибок
+// (обрыв соединения, таймаут) возвращается 0 — отвечать уже некому.
+func StatusCode(err error) int {
+	var e *StatusError
+	if errors.As(err, &e) {
+		return e.Status
+	}
+	return 0
+}
+
+// WriteError отправляет короткий текстовый ответ об ошибке и просит
+// клиента закрыть соединение: после ошибки разбора поток байт уже
+// рассинхронизирован.
+func WriteError(w io.Writer, err error) error {
+	var e *StatusError
+	if !errors.As(err, &e) {
+		return err
+	}
+	code := e.Status
+	body := fmt.Sprintf("%d %s\n%s\n", code, StatusText(code), e.Reason)
+	_, werr := fmt.Fprintf(w,
+		"HTTP/1.1 %d %s\r\n"+
+			"Content-Type: text/plain; charset=utf-8\r\n"+
+			"Content-Length: %d\r\n"+
+			"Connection: close\r\n"+
+			"\r\n%s",
+		code, StatusText(code), len(body), body)
+	return werr
+}
@@ -0,0 +1,350 @@
+//go:build linux
+
+// idlebench сравнивает две модели raw сервера — горутину на соединение
+// (ServeConn) и цикл epoll с пулом обработчиков (ServeEpoll) — на
+// большом числе простаивающих keep-alive соединений.
+//
+// Для каждой модели запускается отдельный процесс-сервер (эта же
+// программа с флагом -serve), к нему открывается -conns соединений, по
+// каждому проходит один запрос, и соединения остаются открытыми. Затем
+// снимаются память сервера (RSS из /proc, куча и стеки из runtime) и
+// задержки последовательных запросов на ещё одном соединении.
+//
+//	go run ./idlebench -conns 10000
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"labs1/httpraw"
+)
+
+var (
+	conns    = flag.Int("conns", 10000, "сколько простаивающих keep-alive соединений держать")
+	requests = flag.Int("requests", 2000, "сколько запросов отправить для замера задержки")
+	workers  = flag.Int("workers", 16, "обработчиков в пуле epoll")
+	models   = flag.String("models", "goroutine,epoll", "какие модели сравнивать")
+	serve    = flag.String("serve", "", "служебный: запустить сервер модели goroutine или epoll")
+)
+
+// stats — то, что сервер сообщает о себе на /_stats.
+type stats struct {
+	HeapInuse  uint64 `json:"heap_inuse"`
+	StackInuse uint64 `json:"stack_inuse"`
+	Sys        uint64 `json:"sys"`
+	Goroutines int    `json:"goroutines"`
+	Active     int64  `json:"active_connections"`
+}
+
+// result — замеры одной модели.
+type result struct {
+	model      string
+	opened     int
+	openTime   time.Duration
+	before     stats
+	after      stats
+	rssBefore  uint64
+	rssAfter   uint64
+	p50, p99   time.Duration
+	maxLatency time.Duration
+}
+
+func main() {
+	flag.Parse()
+	raiseFileLimit()
+
+	if *serve != "" {
+		if err := runServer(*serve); err != nil {
+			fmt.Printf("Ошибка сервера: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var results []result
+	for _, model := range strings.Split(*models, ",") {
+		model = strings.TrimSpace(model)
+		fmt.Printf("Модель %s: открываем %d соединений...\n", model, *conns)
+		res, err := bench(model)
+		if err != nil {
+			fmt.Printf("Ошибка замера модели %s: %v\n", model, err)
+			os.Exit(1)
+		}
+		results = append(results, res)
+	}
+	printResults(results)
+}
+
+// runServer — дочерний процесс: сервер выбранной модели на случайном
+// порту. Адрес печатается первой строкой stdout.
+func runServer(model string) error {
+	srv := &httpraw.Server{
+		// простаивающие соединения не должны закрыться во время замера
+		IdleTimeout:        time.Hour,
+		MaxRequestsPerConn: 1 << 30,
+	}
+	srv.Handler = httpraw.HandlerFunc(func(w httpraw.ResponseWriter, r *httpraw.Request) {
+		if r.Path != "/_stats" {
+			w.Header().Set("Content-Type", "text/plain")
+			io.WriteString(w, "ok\n")
+			return
+		}
+		runtime.GC()
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats{
+			HeapInuse:  ms.HeapInuse,
+			StackInuse: ms.StackInuse,
+			Sys:        ms.Sys,
+			Goroutines: runtime.NumGoroutine(),
+			Active:     srv.Stats().ActiveConns,
+		})
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	fmt.Println(l.Addr())
+
+	switch model {
+	case "goroutine":
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return err
+			}
+			go func() {
+				defer conn.Close()
+				srv.ServeConn(conn)
+			}()
+		}
+	case "epoll":
+		return srv.ServeEpoll(l, httpraw.EpollOptions{Workers: *workers})
+	}
+	return fmt.Errorf("неизвестная модель %q (goroutine или epoll)", model)
+}
+
+func bench(model string) (result, error) {
+	res := result{model: model}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return res, err
+	}
+	cmd := exec.Command(exe, "-serve", model, "-workers", strconv.Itoa(*workers))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return res, err
+	}
+	if err := cmd.Start(); err != nil {
+		return res, err
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	line, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		return res, fmt.Errorf("сервер не сообщил адрес: %v", err)
+	}
+	addr := strings.TrimSpace(line)
+
+	probe, err := dial(addr)
+	if err != nil {
+		return res, err
+	}
+	defer probe.Close()
+	if err := probe.stats(&res.before); err != nil {
+		return res, err
+	}
+	res.rssBefore = rss(cmd.Process.Pid)
+
+	start := time.Now()
+	idle, err := openIdle(addr, *conns)
+	defer func() {
+		for _, c := range idle {
+			c.Close()
+		}
+	}()
+	res.opened = len(idle)
+	res.openTime = time.Since(start)
+	if err != nil {
+		return res, fmt.Errorf("открыто %d из %d соединений: %v", len(idle), *conns, err)
+	}
+
+	// даём серверу разложить всё по местам
+	time.Sleep(time.Second)
+	if err := probe.stats(&res.after); err != nil {
+		return res, err
+	}
+	res.rssAfter = rss(cmd.Process.Pid)
+
+	latencies := make([]time.Duration, 0, *requests)
+	for i := 0; i < *requests; i++ {
+		t := time.Now()
+		if err := probe.get("/", io.Discard); err != nil {
+			return res, err
+		}
+		latencies = append(latencies, time.Since(t))
+	}
+	slices.Sort(latencies)
+	if n := len(latencies); n > 0 {
+		res.p50 = latencies[n/2]
+		res.p99 = latencies[n*99/100]
+		res.maxLatency = latencies[n-1]
+	}
+	return res, nil
+}
+
+// openIdle открывает n соединений и проводит по каждому один запрос.
+func openIdle(addr string, n int) ([]*client, error) {
+	var (
+		mu       sync.Mutex
+		opened   []*client
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	next := make(chan struct{})
+	for w := 0; w < 64; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range next {
+				c, err := dial(addr)
+				if err == nil {
+					if err = c.get("/", io.Discard); err != nil {
+						c.Close()
+					}
+				}
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if err == nil {
+					opened = append(opened, c)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		next <- struct{}{}
+	}
+	close(next)
+	wg.Wait()
+	return opened, firstErr
+}
+
+// client — keep-alive соединение с сервером замера.
+type client struct {
+	net.Conn
+	parser *httpraw.Parser
+}
+
+func dial(addr string) (*client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &client{Conn: conn, parser: httpraw.NewParser(conn, nil)}, nil
+}
+
+func (c *client) get(path string, body io.Writer) error {
+	if _, err := fmt.Fprintf(c, "GET %s HTTP/1.1\r\nHost: idlebench\r\n\r\n", path); err != nil {
+		return err
+	}
+	resp, err := c.parser.ReadResponse("GET")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(body, resp.Body); err != nil {
+		return err
+	}
+	if resp.Status != 200 {
+		return fmt.Errorf("GET %s: статус %d", path, resp.Status)
+	}
+	return nil
+}
+
+func (c *client) stats(s *stats) error {
+	var buf strings.Builder
+	if err := c.get("/_stats", &buf); err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(buf.String()), s)
+}
+
+// rss — VmRSS процесса в байтах из /proc/<pid>/status.
+func rss(pid int) uint64 {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "VmRSS:"); ok {
+			kb, _ := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(v), " kB"), 10, 64)
+			return kb << 10
+		}
+	}
+	return 0
+}
+
+// raiseFileLimit поднимает мягкий лимит дескрипторов до жёсткого: и
+// серверу, и клиенту нужно по дескриптору на соединение.
+func raiseFileLimit() {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		return
+	}
+	lim.Cur = lim.Max
+	syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim)
+}
+
+func printResults(results []result) {
+	mb := func(b uint64) string { return fmt.Sprintf("%.1f", float64(b)/(1<<20)) }
+	perConn := func(before, after uint64, n int) string {
+		if n == 0 || after < before {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f", float64(after-before)/float64(n)/1024)
+	}
+
+	fmt.Println()
+	fmt.Printf("%-10s %7s %8s %8s %9s %10s %9s %8s %8s %8s %8s\n",
+		"Модель", "Соедин.", "RSS,МБ", "Куча,МБ", "Стеки,МБ", "КБ/соедин", "Горутин", "Открытие", "p50", "p99", "max")
+	for _, r := range results {
+		fmt.Printf("%-10s %7d %8s %8s %9s %10s %9d %8v %8v %8v %8v\n",
+			r.model, r.opened,
+			mb(r.rssAfter), mb(r.after.HeapInuse), mb(r.after.StackInuse),
+			perConn(r.rssBefore, r.rssAfter, r.opened),
+			r.after.Goroutines,
+			r.openTime.Round(time.Millisecond),
+			r.p50.Round(time.Microsecond), r.p99.Round(time.Microsecond), r.maxLatency.Round(time.Microsecond))
+	}
+	fmt.Println()
+	fmt.Println("КБ/соедин — прирост RSS сервера на одно простаивающее соединение;")
+	fmt.Println("задержки — последовательные GET / на отдельном соединении, пока остальные простаивают.")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"labs1/apierror"
+	"labs1/guard"
+	"labs1/httpraw"
+)
+
+// Защита от slowloris и исчерпания ресурсов: одни и те же лимиты для raw
+// сервера и обоих net/http серверов.
+var (
+	headerTimeout  = flag.Duration("header-timeout", 10*time.Second, "за сколько должны прийти заголовки запроса (иначе 408)")
+	bodyTimeout    = flag.Duration("body-timeout", 30*time.Second, "за сколько должно прийти тело запроса (иначе 408)")
+	idleTimeout    = flag.Duration("idle-timeout", 15*time.Second, "сколько держать простаивающее keep-alive соединение")
+	writeTimeout   = flag.Duration("write-timeout", 30*time.Second, "дедлайн одной записи в сокет")
+	maxConns       = flag.Int("max-conns", 1000, "максимум одновременных соединений на порт, 0 — без ограничения")
+	maxConnsPerIP  = flag.Int("max-conns-per-ip", 50, "максимум одновременных соединений с одного IP на порт, 0 — без ограничения")
+	maxHeaderBytes = flag.Int("max-header-bytes", 32<<10, "максимальный размер заголовков запроса (иначе 431)")
+	maxBodyBytes   = flag.Int64("max-body-bytes", 10<<20, "максимальный размер тела запроса (иначе 413)")
+)
+
+// Счётчики отказов по серверам. Показываются в /events и пойдут в метрики.
+var (
+	rawRejected  = &guard.Counters{}
+	fileRejected = &guard.Counters{}
+	dnsRejected  = &guard.Counters{}
+)
+
+// applyRawLimits переносит флаги в настройки raw сервера — и HTTP/1.x,
+// и HTTP/2 на том же порту.
+func applyRawLimits() {
+	rawServer.HeaderTimeout = *headerTimeout
+	rawServer.BodyTimeout = *bodyTimeout
+	rawServer.IdleTimeout = *idleTimeout
+	rawServer.WriteTimeout = *writeTimeout
+	rawServer.Limits = httpraw.DefaultLimits
+	rawServer.Limits.MaxHeaderBytes = *maxHeaderBytes
+	rawServer.Limits.MaxBodyBytes = *maxBodyBytes
+	rawServer.Rejected = rawRejected
+
+	rawH2Server.HeaderTimeout = *headerTimeout
+	rawH2Server.BodyTimeout = *bodyTimeout
+	rawH2Server.IdleTimeout = *idleTimeout
+	rawH2Server.WriteTimeout = *writeTimeout
+	rawH2Server.MaxBodyBytes = *maxBodyBytes
+	rawH2Server.Rejected = rawRejected
+}
+
+// newHTTPServer создаёт net/http сервер с таймаутами из флагов. ReadTimeout
+// покрывает заголовки и тело вместе, как это устроено в net/http.
+func newHTTPServer(addr string, handler http.Handler, rejected *guard.Counters) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		TLSConfig:         serverTLS,
+		ReadHeaderTimeout: *headerTimeout,
+		ReadTimeout:       *headerTimeout + *bodyTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		MaxHeaderBytes:    *maxHeaderBytes,
+	}
+}
+
+// limitBody отвечает 413 на заявленное слишком длинное тело и обрезает
+// тело без Content-Length. Отказы по заголовкам (431) и таймаутам
+// net/http обрабатывает сам, не сообщая о них, поэтому для net/http
+// серверов считаются только отказы по соединениям и телу. Стоит в
+// цепочке serverMiddleware после журнала и apierror, чтобы отказ был
+// в журнале и с идентификатором запроса.
+func limitBody(next http.Handler, rejected *guard.Counters) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if *maxBodyBytes >= 0 && r.ContentLength > *maxBodyBytes {
+			rejected.Inc(guard.BodyTooLarge)
+			w.Header().Set("Connection", "close")
+			apierror.Write(w, r, http.StatusRequestEntityTooLarge, apierror.TooLarge,
+				fmt.Sprintf("Тело запроса больше %d байт", *maxBodyBytes))
+			return
+		}
+		if *maxBodyBytes >= 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, *maxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newHTTPLabServer оборачивает net/http сервер в labServer. banner
+// печатается, когда сервер начинает принимать соединения.
+func newHTTPLabServer(name, addr string, handler http.Handler, rejected *guard.Counters, banner func()) *labServer {
+	server := newHTTPServer(addr, handler, rejected)
+	return &labServer{
+		name:     name,
+		addr:     addr,
+		rejected: rejected,
+		serve: func(l net.Listener) error {
+			banner()
+			var err error
+			if serverTLS != nil {
+				err = server.ServeTLS(l, "", "")
+			} else {
+				err = server.Serve(l)
+			}
+			// слушатель закрывают и до Shutdown — при перезапуске
+			if errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		},
+		// Shutdown закрывает слушатель и простаивающие соединения и ждёт,
+		// пока активные вернутся в простой
+		shutdown: server.Shutdown,
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"labs1/guard"
+)
+
+var unixMode = flag.String("unix-mode", "", "права на файл unix сокета в восьмеричном виде, например 0660 (по умолчанию — по umask)")
+
+// Первый унаследованный дескриптор: 0–2 — стандартные потоки
+// (sd_listen_fds(3)).
+const listenFDsStart = 3
+
+// unixPath возвращает путь сокета для адреса вида unix:/run/labs1.sock.
+func unixPath(addr string) (string, bool) {
+	return strings.CutPrefix(addr, "unix:")
+}
+
+// inheritedListener — слушающий сокет, полученный через LISTEN_FDS: от
+// systemd (socket activation) или от предыдущего процесса при
+// перезапуске по SIGHUP.
+type inheritedListener struct {
+	name string // из LISTEN_FDNAMES, у systemd — FileDescriptorName=
+	l    net.Listener
+	used bool
+}
+
+// inheritListeners забирает сокеты из LISTEN_FDS и убирает переменные
+// протокола из окружения, чтобы их не увидели CGI скрипты и
+// следующий процесс. LISTEN_PID, если он задан, должен совпадать с
+// нашим: иначе сокеты предназначались другому процессу. При
+// перезапуске его нет — pid нового процесса заранее неизвестен.
+func inheritListeners() ([]*inheritedListener, error) {
+	fds, pid, names := os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDNAMES")
+	ready := os.Getenv(readyFDEnv)
+	for _, v := range []string{"LISTEN_FDS", "LISTEN_PID", "LISTEN_FDNAMES", readyFDEnv} {
+		os.Unsetenv(v)
+	}
+	if ready != "" {
+		fd, err := strconv.Atoi(ready)
+		if err != nil || fd < listenFDsStart {
+			return nil, fmt.Errorf("%s=%q: ожидается номер дескриптора", readyFDEnv, ready)
+		}
+		readyPipe = os.NewFile(uintptr(fd), "ready")
+	}
+	if fds == "" || pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("LISTEN_FDS=%q: ожидается число дескрипторов", fds)
+	}
+	nameList := strings.Split(names, ":")
+
+	var inherited []*inheritedListener
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(nameList) {
+			name = nameList[i]
+		}
+		fd := listenFDsStart + i
+		f := os.NewFile(uintptr(fd), name)
+		// FileListener делает копию дескриптора, исходный больше не нужен
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, il := range inherited {
+				il.l.Close()
+			}
+			return nil, fmt.Errorf("унаследованный дескриптор %d (%s): %v", fd, name, err)
+		}
+		inherited = append(inherited, &inheritedListener{name: name, l: l})
+	}
+	return inherited, nil
+}
+
+// takeInherited выбирает унаследованный сокет для сервера: сначала по
+// имени (raw, file, dns), затем по адресу. Сокет из systemd главнее
+// настроек: если адрес в них другой, об этом только предупреждаем.
+func takeInherited(inherited []*inheritedListener, name, addr string) net.Listener {
+	for _, il := range inherited {
+		if !il.used && il.name == name {
+			il.used = true
+			if !sameAddr(il.l.Addr(), addr) {
+				fmt.Printf("Сервер %s: унаследованный сокет %s не совпадает с адресом %s из настроек, используется унаследованный\n",
+					name, il.l.Addr(), addr)
+			}
+			return il.l
+		}
+	}
+	for _, il := range inherited {
+		if !il.used && sameAddr(il.l.Addr(), addr) {
+			il.used = true
+			return il.l
+		}
+	}
+	return nil
+}
+
+// sameAddr сравнивает адрес сокета с адресом из настроек. ":8080"
+// совпадает с сокетом на всех интерфейсах.
+func sameAddr(a net.Addr, addr string) bool {
+	if path, ok := unixPath(addr); ok {
+		ua, isUnix := a.(*net.UnixAddr)
+		return isUnix && ua.Name == path
+	}
+	ta, ok := a.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	want, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil || want.Port != ta.Port {
+		return false
+	}
+	if want.IP == nil || want.IP.IsUnspecified() {
+		return ta.IP.IsUnspecified()
+	}
+	return want.IP.Equal(ta.IP)
+}
+
+// listen открывает адрес сервера: host:port или unix:/path.sock.
+func listen(addr string) (net.Listener, error) {
+	path, ok := unixPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// файл сокета мог остаться от процесса, который не успел его
+	// удалить; занятый сокет не трогаем
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s уже существует и это не сокет", path)
+		}
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("сокет %s уже слушает другой процесс", path)
+		}
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// файл удаляем сами при остановке: после передачи сокета новому
+	// процессу он должен остаться
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	if *unixMode != "" {
+		mode, err := strconv.ParseUint(*unixMode, 8, 32)
+		if err == nil && mode > 0o777 {
+			err = errors.New("больше 0777")
+		}
+		if err == nil {
+			err = os.Chmod(path, os.FileMode(mode))
+		}
+		if err != nil {
+			l.Close()
+			os.Remove(path)
+			return nil, fmt.Errorf("-unix-mode %s: %v", *unixMode, err)
+		}
+	}
+	return l, nil
+}
+
+// openListener открывает слушатель сервера — унаследованный, если он
+// есть, иначе новый — и оборачивает его ограничениями соединений.
+func openListener(srv *labServer, inherited []*inheritedListener) error {
+	l := takeInherited(inherited, srv.name, srv.addr)
+	if l != nil {
+		fmt.Printf("Сервер %s: унаследован сокет %s\n", srv.name, l.Addr())
+		// файл сокета от systemd удаляет systemd, а переданный при
+		// перезапуске — последний процесс в цепочке
+		if ua, ok := l.Addr().(*net.UnixAddr); ok && readyPipe != nil {
+			srv.unixPath = ua.Name
+		}
+	} else {
+		var err error
+		if l, err = listen(srv.addr); err != nil {
+			return err
+		}
+		if path, ok := unixPath(srv.addr); ok {
+			srv.unixPath = path
+		}
+	}
+	if path, ok := l.Addr().(*net.UnixAddr); ok {
+		fmt.Printf("Сервер %s на unix сокете: curl --unix-socket %s http://localhost/\n", srv.name, path.Name)
+	}
+	srv.socket = l
+	srv.listener = limitListener(l, srv.addr, srv.rejected)
+	return nil
+}
+
+// limitListener ограничивает число соединений слушателя.
+func limitListener(l net.Listener, addr string, rejected *guard.Counters) net.Listener {
+	gl := guard.NewListener(l, *maxConns, *maxConnsPerIP, rejected)
+	gl.OnReject = func(remote net.Addr, reason string) {
+		fmt.Printf("Соединение %s на %s отклонено: %s\n", remote, addr, reason)
+	}
+	return gl
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+
+	"labs1/accesslog"
+)
+
+var (
+	accessLogPath    = flag.String("access-log", "", "файл журнала доступа, пусто — stdout, off — не вести")
+	accessLogFormat  = flag.String("access-log-format", accesslog.Combined, "формат журнала доступа: combined или json")
+	accessLogMaxSize = flag.Int64("access-log-max-size", 100, "размер файла журнала доступа до ротации, МБ; 0 — без ротации")
+	accessLogBackups = flag.Int("access-log-backups", 5, "сколько старых файлов журнала доступа хранить")
+)
+
+// accessLog — общий журнал доступа всех серверов; nil, если он отключён.
+var accessLog *accesslog.Logger
+
+// openAccessLog открывает журнал по флагам. Закрывать файл не нужно:
+// каждая строка уходит в него сразу, без буфера.
+func openAccessLog() (*accesslog.Logger, error) {
+	if *accessLogPath == "off" {
+		return nil, nil
+	}
+	var w io.Writer = os.Stdout
+	if *accessLogPath != "" {
+		f, err := accesslog.OpenRotating(*accessLogPath, *accessLogMaxSize<<20, *accessLogBackups)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return accesslog.New(w, *accessLogFormat)
+}
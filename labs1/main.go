@@ -1,35 +1,219 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
+
+	"labs1/compress"
+	"labs1/guard"
 )
 
+var compressMin = flag.Int("compress-min", 1024, "минимальный размер ответа для сжатия gzip/deflate, байт")
+
+// labServer — один из серверов лабораторной. Порт открывается в main до
+// запуска остальных, чтобы ошибка (порт занят) сразу останавливала всё.
+// Адрес — host:port или unix:/path.sock; сокет может быть и
+// унаследован через LISTEN_FDS.
+type labServer struct {
+	name     string
+	addr     string
+	rejected *guard.Counters
+	// serve обслуживает слушатель и возвращается после shutdown.
+	serve func(l net.Listener) error
+	// shutdown перестаёт принимать соединения и ждёт начатые запросы.
+	shutdown func(ctx context.Context) error
+
+	listener net.Listener
+	// socket — слушатель без ограничений guard: его дескриптор
+	// передаётся новому процессу при перезапуске.
+	socket net.Listener
+	// unixPath — файл сокета, созданный этим процессом; удаляется при
+	// остановке, если сокет не передан новому процессу.
+	unixPath string
+}
+
 func main() {
-	var wg sync.WaitGroup
+	flag.Parse()
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Ошибка настроек: %v\n", err)
+		os.Exit(2)
+	}
+	compress.MinSize = *compressMin
 
-	// Запускаем все три сервера в отдельных горутинах
-	wg.Add(3)
+	accessLog, err = openAccessLog()
+	if err != nil {
+		fmt.Printf("Ошибка журнала доступа: %v\n", err)
+		os.Exit(1)
+	}
 
-	go func() {
-		defer wg.Done()
-		handleHTTPRawSocket()
-	}()
+	if err := setupAuth(cfg.Auth); err != nil {
+		fmt.Printf("Ошибка настройки авторизации: %v\n", err)
+		os.Exit(1)
+	}
+	if err := setupRateLimit(cfg.RateLimit); err != nil {
+		fmt.Printf("Ошибка настроек: %v\n", err)
+		os.Exit(2)
+	}
+	setupCORS(cfg.CORS)
 
-	go func() {
-		defer wg.Done()
-		handleFileProtocol()
-	}()
+	if *tlsEnabled {
+		tlsCfg, err := loadTLSConfig()
+		if err != nil {
+			fmt.Printf("Ошибка настройки TLS: %v\n", err)
+			os.Exit(1)
+		}
+		serverTLS = tlsCfg
+	}
 
-	go func() {
-		defer wg.Done()
-		handleDNSShellExec()
-	}()
+	inherited, err := inheritListeners()
+	if err != nil {
+		fmt.Printf("Ошибка получения сокетов: %v\n", err)
+		os.Exit(1)
+	}
+
+	var servers []*labServer
+	if cfg.Raw.Enabled {
+		srv, err := newRawLabServer(cfg.Raw.Addr, cfg.VHosts)
+		if err != nil {
+			fmt.Printf("Ошибка запуска сервера raw на %s: %v\n", cfg.Raw.Addr, err)
+			os.Exit(1)
+		}
+		servers = append(servers, srv)
+	}
+	if cfg.File.Enabled {
+		servers = append(servers, newFileLabServer(cfg.File.Addr))
+	}
+	if cfg.DNS.Enabled {
+		servers = append(servers, newDNSLabServer(cfg.DNS.Addr))
+	}
+
+	// Все порты открываются до того, как какой-либо сервер начнёт
+	// работать: занятый порт — повод не стартовать вовсе.
+	for _, srv := range servers {
+		if err := openListener(srv, inherited); err != nil {
+			fmt.Printf("Ошибка запуска сервера %s на %s: %v\n", srv.name, srv.addr, err)
+			for _, started := range servers {
+				if started.listener != nil {
+					started.listener.Close()
+				}
+				if started.unixPath != "" {
+					os.Remove(started.unixPath)
+				}
+			}
+			os.Exit(1)
+		}
+	}
+	for _, il := range inherited {
+		if !il.used {
+			fmt.Printf("Унаследованный сокет %s (%s) не подошёл ни одному серверу, закрываем\n", il.l.Addr(), il.name)
+			il.l.Close()
+		}
+	}
+	registerServerMetrics(servers)
+
+	failed := make(chan error, len(servers))
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv *labServer) {
+			defer wg.Done()
+			if err := srv.serve(srv.listener); err != nil {
+				failed <- fmt.Errorf("сервер %s на %s: %w", srv.name, srv.addr, err)
+			}
+		}(srv)
+	}
 
 	fmt.Println("Все серверы запущены:")
-	fmt.Println("1. HTTP Socket - http://localhost:8080")
-	fmt.Println("2. File Protocol - http://localhost:8081/file?path=file:///path/to/file")
-	fmt.Println("3. DNS Shell Exec - http://localhost:8082/dns?domain=google.com&type=A")
+	if cfg.Raw.Enabled {
+		fmt.Printf("1. HTTP Socket - %s://%s\n", scheme(), urlHost(cfg.Raw.Addr))
+	}
+	if cfg.File.Enabled {
+		fmt.Printf("2. File Protocol - %s://%s/file?path=file:///path/to/file\n", scheme(), urlHost(cfg.File.Addr))
+	}
+	if cfg.DNS.Enabled {
+		fmt.Printf("3. DNS Shell Exec - %s://%s/dns?domain=google.com&type=A\n", scheme(), urlHost(cfg.DNS.Addr))
+	}
+
+	notifyReady()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	exitCode := 0
+	handedOff := false
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Получен сигнал остановки, завершаем начатые запросы...")
+			running = false
+		case err := <-failed:
+			fmt.Printf("Ошибка: %v, останавливаем остальные серверы\n", err)
+			exitCode = 1
+			running = false
+		case <-hup:
+			fmt.Println("Получен SIGHUP, запускаем новый процесс с теми же сокетами...")
+			pid, err := restart(servers)
+			if err != nil {
+				fmt.Printf("Перезапуск не удался: %v, продолжаем работу\n", err)
+				continue
+			}
+			// systemd должен следить уже за новым процессом
+			sdNotify(fmt.Sprintf("MAINPID=%d", pid))
+			fmt.Printf("Новый процесс %d принимает соединения, завершаем начатые запросы...\n", pid)
+			// Сначала только перестаём принимать соединения: Shutdown
+			// закрывает без ответа соединение, запрос которого ещё не
+			// прочитан, а уже принятые клиенты успевают его дослать.
+			for _, srv := range servers {
+				srv.listener.Close()
+			}
+			time.Sleep(handoffDrain)
+			handedOff = true
+			running = false
+		}
+	}
+	stop()
+	signal.Stop(hup)
+
+	timeout := time.Duration(cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
+	var sg sync.WaitGroup
+	for _, srv := range servers {
+		sg.Add(1)
+		go func(srv *labServer) {
+			defer sg.Done()
+			start := time.Now()
+			err := srv.shutdown(shutdownCtx)
+			switch {
+			case errors.Is(err, context.DeadlineExceeded):
+				fmt.Printf("Сервер %s: за %v не все запросы завершились, соединения закрыты принудительно\n", srv.name, timeout)
+			case err != nil:
+				fmt.Printf("Сервер %s: ошибка остановки: %v\n", srv.name, err)
+			default:
+				fmt.Printf("Сервер %s остановлен за %v\n", srv.name, time.Since(start).Round(time.Millisecond))
+			}
+		}(srv)
+	}
+	sg.Wait()
 	wg.Wait()
+	if !handedOff {
+		for _, srv := range servers {
+			if srv.unixPath != "" {
+				os.Remove(srv.unixPath)
+			}
+		}
+	}
+	os.Exit(exitCode)
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strconv"
+
+	"labs1/accesslog"
+	"labs1/guard"
+	"labs1/metrics"
+)
+
+// labMetrics — метрики всех серверов; /metrics на любом порту отдаёт
+// их целиком.
+var labMetrics = metrics.NewRegistry()
+
+var (
+	httpRequests = labMetrics.NewCounter("labs1_http_requests_total",
+		"Запросы по серверу, маршруту и коду ответа.", "server", "route", "status")
+	httpDuration = labMetrics.NewHistogram("labs1_http_request_duration_seconds",
+		"Время обработки запроса.", metrics.DefaultBuckets, "server", "route")
+	rawConns = labMetrics.NewGauge("labs1_raw_connections_active",
+		"Открытые соединения raw сервера по протоколу.", "proto")
+	fileBytes = labMetrics.NewCounter("labs1_file_bytes_served_total",
+		"Байты файлов, отданные fileHandler, по кодированию (identity или gzip для готовой .gz копии).", "encoding")
+	dnsQueries = labMetrics.NewCounter("labs1_dns_queries_total",
+		"DNS запросы executeDNSQuery по типу записи и исходу (ok, empty, error).", "type", "outcome")
+	dnsDuration = labMetrics.NewHistogram("labs1_dns_query_duration_seconds",
+		"Время выполнения DNS запроса.", metrics.DefaultBuckets, "type")
+)
+
+// requestMetrics считает запросы по тем же записям, что уходят в журнал
+// доступа.
+type requestMetrics struct{}
+
+func (requestMetrics) Log(e accesslog.Entry) {
+	// запросы мимо маршрутов (404, прокси) сводятся в одну метку, иначе
+	// каждый случайный путь породил бы свой ряд
+	route := e.Route
+	if route == "" {
+		route = "other"
+	}
+	httpRequests.Inc(e.Server, route, strconv.Itoa(e.Status))
+	httpDuration.Observe(e.Duration.Seconds(), e.Server, route)
+}
+
+// requestSink — куда серверы отдают записи о запросах.
+func requestSink() accesslog.Sink {
+	return accesslog.Tee(accessLog, requestMetrics{})
+}
+
+// dnsTypeLabel ограничивает метку type известными типами записей: тип
+// приходит из запроса.
+func dnsTypeLabel(queryType string) string {
+	switch queryType {
+	case "A", "AAAA", "CNAME", "MX", "NS", "PTR", "SOA", "SRV", "TXT", "CAA", "ANY":
+		return queryType
+	}
+	return "other"
+}
+
+// registerServerMetrics добавляет метрики, которые читаются из серверов
+// в момент запроса: открытые соединения каждого слушателя и отказы.
+func registerServerMetrics(servers []*labServer) {
+	labMetrics.NewGaugeFunc("labs1_connections_active",
+		"Открытые TCP соединения на слушателе сервера.", []string{"server"},
+		func(emit func(float64, ...string)) {
+			for _, srv := range servers {
+				if gl, ok := srv.listener.(*guard.Listener); ok {
+					emit(float64(gl.Active()), srv.name)
+				}
+			}
+		})
+	labMetrics.NewCounterFunc("labs1_rejected_total",
+		"Отказы в соединении или запросе по причине.", []string{"server", "reason"},
+		func(emit func(float64, ...string)) {
+			for _, srv := range servers {
+				for reason, n := range srv.rejected.Snapshot() {
+					emit(float64(n), srv.name, reason)
+				}
+			}
+		})
+}
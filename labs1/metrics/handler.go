@@ -0,0 +1,23 @@
+package metrics
+
+import (
+	"net/http"
+
+	"labs1/httpraw"
+)
+
+// Handler отдаёт метрики реестра net/http серверу.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteText(w)
+	})
+}
+
+// RawHandler отдаёт метрики реестра raw серверу.
+func (r *Registry) RawHandler() httpraw.Handler {
+	return httpraw.HandlerFunc(func(w httpraw.ResponseWriter, _ *httpraw.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteText(w)
+	})
+}
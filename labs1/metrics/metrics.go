@@ -0,0 +1,319 @@
+// Package metrics — счётчики, измерители и гистограммы с метками и их
+// вывод в текстовом формате Prometheus (exposition format 0.0.4) без
+// клиентской библиотеки.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType — тип ответа /metrics.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets — границы гистограммы задержек в секундах, как у
+// клиентских библиотек Prometheus.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry хранит метрики и выводит их в порядке регистрации.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry создаёт пустой реестр.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: метрика " + name + " уже зарегистрирована")
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText выводит все метрики в текстовом формате Prometheus.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	list := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range list {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// desc — имя, описание, тип и имена меток метрики.
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, escapeHelp(d.help), d.name, d.typ)
+}
+
+// writeSample выводит одну строку name{labels} value. extra — пара
+// дополнительной метки (le у гистограммы) или пустая строка.
+func (d *desc) writeSample(w *bufio.Writer, suffix string, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(d.name)
+	w.WriteString(suffix)
+	if len(values) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, name := range d.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", name, escapeLabel(values[i]))
+		}
+		if extraName != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s ожидает %d меток, передано %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// series — значение одного набора меток.
+type series struct {
+	values []string
+	value  float64
+}
+
+// vec — общая часть счётчика и измерителя.
+type vec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func (v *vec) add(delta float64, values []string) {
+	k := v.key(values)
+	v.mu.Lock()
+	s := v.series[k]
+	if s == nil {
+		s = &series{values: append([]string(nil), values...)}
+		v.series[k] = s
+	}
+	s.value += delta
+	v.mu.Unlock()
+}
+
+func (v *vec) set(value float64, values []string) {
+	k := v.key(values)
+	v.mu.Lock()
+	s := v.series[k]
+	if s == nil {
+		s = &series{values: append([]string(nil), values...)}
+		v.series[k] = s
+	}
+	s.value = value
+	v.mu.Unlock()
+}
+
+func (v *vec) write(w *bufio.Writer) {
+	v.writeHeader(w)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, k := range sortedKeys(v.series) {
+		s := v.series[k]
+		v.writeSample(w, "", s.values, "", "", s.value)
+	}
+}
+
+// Counter — монотонно растущий счётчик с метками.
+type Counter struct{ vec }
+
+// NewCounter регистрирует счётчик. Имя по соглашению оканчивается на _total.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{vec{desc: desc{name, help, "counter", labels}, series: make(map[string]*series)}}
+	r.register(name, c)
+	return c
+}
+
+// Inc увеличивает счётчик с данными значениями меток на 1.
+func (c *Counter) Inc(labelValues ...string) { c.add(1, labelValues) }
+
+// Add увеличивает счётчик на v >= 0.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: счётчик не может уменьшаться")
+	}
+	c.add(v, labelValues)
+}
+
+// Gauge — значение, которое может и расти, и убывать.
+type Gauge struct{ vec }
+
+// NewGauge регистрирует измеритель.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{vec{desc: desc{name, help, "gauge", labels}, series: make(map[string]*series)}}
+	r.register(name, g)
+	return g
+}
+
+// Add меняет значение на delta.
+func (g *Gauge) Add(delta float64, labelValues ...string) { g.add(delta, labelValues) }
+
+// Set задаёт значение.
+func (g *Gauge) Set(v float64, labelValues ...string) { g.set(v, labelValues) }
+
+// Histogram распределяет наблюдения по корзинам. Каждая корзина хранит
+// число наблюдений не больше своей границы, как требует формат.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histSeries
+}
+
+type histSeries struct {
+	values []string
+	counts []uint64 // по корзинам, без накопления
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram регистрирует гистограмму с возрастающими границами buckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: границы гистограммы " + name + " не упорядочены")
+	}
+	h := &Histogram{
+		desc:    desc{name, help, "histogram", labels},
+		buckets: buckets,
+		series:  make(map[string]*histSeries),
+	}
+	r.register(name, h)
+	return h
+}
+
+// Observe добавляет наблюдение v.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	k := h.key(labelValues)
+	i := sort.SearchFloat64s(h.buckets, v) // первая граница >= v
+	h.mu.Lock()
+	s := h.series[k]
+	if s == nil {
+		s = &histSeries{values: append([]string(nil), labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	if i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+	h.mu.Unlock()
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.writeHeader(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range sortedKeys(h.series) {
+		s := h.series[k]
+		var cum uint64
+		for i, le := range h.buckets {
+			cum += s.counts[i]
+			h.writeSample(w, "_bucket", s.values, "le", formatFloat(le), float64(cum))
+		}
+		h.writeSample(w, "_bucket", s.values, "le", "+Inf", float64(s.count))
+		h.writeSample(w, "_sum", s.values, "", "", s.sum)
+		h.writeSample(w, "_count", s.values, "", "", float64(s.count))
+	}
+}
+
+// collector снимает значения в момент вывода.
+type collector struct {
+	desc
+	collect func(emit func(v float64, labelValues ...string))
+}
+
+// NewCounterFunc регистрирует счётчик, значения которого считает кто-то
+// другой (например, сервер): collect вызывается при каждом выводе и
+// передаёт emit значение для каждого набора меток.
+func (r *Registry) NewCounterFunc(name, help string, labels []string, collect func(emit func(v float64, labelValues ...string))) {
+	r.register(name, &collector{desc{name, help, "counter", labels}, collect})
+}
+
+// NewGaugeFunc — то же для измерителя.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func(emit func(v float64, labelValues ...string))) {
+	r.register(name, &collector{desc{name, help, "gauge", labels}, collect})
+}
+
+func (c *collector) write(w *bufio.Writer) {
+	c.writeHeader(w)
+	type sample struct {
+		key    string
+		values []string
+		v      float64
+	}
+	var samples []sample
+	c.collect(func(v float64, labelValues ...string) {
+		samples = append(samples, sample{c.key(labelValues), labelValues, v})
+	})
+	sort.Slice(samples, func(i, j int) bool { return samples[i].key < samples[j].key })
+	for _, s := range samples {
+		c.writeSample(w, "", s.values, "", "", s.v)
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
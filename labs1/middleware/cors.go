@@ -0,0 +1,232 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"labs1/httpraw"
+)
+
+// CORSPolicy описывает, каким страницам браузер разрешит читать ответы
+// сервера (Fetch Standard, раздел CORS protocol).
+type CORSPolicy struct {
+	// Origins — разрешённые origin: точные ("https://dash.example.com"),
+	// с маской поддомена ("https://*.example.com") или "*" — любой.
+	// Пустой список отключает CORS.
+	Origins []string
+	// Methods — методы, которые можно вызывать из браузера; по умолчанию
+	// GET, HEAD и POST.
+	Methods []string
+	// Headers — заголовки запроса сверх безопасных (Accept,
+	// Content-Type из форм и т. п.); "*" разрешает любые.
+	Headers []string
+	// Expose — заголовки ответа, которые увидит скрипт, кроме
+	// стандартных.
+	Expose []string
+	// MaxAge — сколько браузер может помнить ответ на предварительный
+	// запрос; 0 — на усмотрение браузера.
+	MaxAge time.Duration
+	// Credentials разрешает запросы с cookie и Authorization. С ним
+	// нельзя Origins "*": origin подставляется только из списка.
+	Credentials bool
+}
+
+// DefaultCORSMethods — методы, если CORSPolicy.Methods не задан.
+var DefaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+// CORS — проверка CORS для net/http обработчиков. Предварительный
+// запрос (OPTIONS с Access-Control-Request-Method) отвечается сразу и
+// до next не доходит: браузер отправляет его без учётных данных, поэтому
+// CORS должен стоять снаружи авторизации.
+func CORS(p CORSPolicy) Middleware {
+	if len(p.Origins) == 0 {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			status, msg := p.apply(r.Method, r.Header, w.Header())
+			switch {
+			case status == http.StatusNoContent:
+				w.WriteHeader(status)
+			case status != 0:
+				http.Error(w, msg, status)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// RawCORS — то же для raw сервера.
+func RawCORS(p CORSPolicy, next httpraw.Handler) httpraw.Handler {
+	if len(p.Origins) == 0 {
+		return next
+	}
+	return httpraw.HandlerFunc(func(w httpraw.ResponseWriter, r *httpraw.Request) {
+		status, msg := p.apply(r.Method, r.Header, w.Header())
+		switch {
+		case status == http.StatusNoContent:
+			w.WriteHeader(status)
+		case status != 0:
+			httpraw.Error(w, msg, status)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Validate проверяет сочетания, которые браузер всё равно отвергнет.
+func (p CORSPolicy) Validate() error {
+	for _, o := range p.Origins {
+		if o == "*" && p.Credentials {
+			return corsError("origin \"*\" нельзя сочетать с credentials: перечислите origin явно")
+		}
+		if o != "*" && o != "null" && !strings.Contains(o, "://") {
+			return corsError("origin " + strconv.Quote(o) + " должен содержать схему, например https://example.com")
+		}
+	}
+	if p.MaxAge < 0 {
+		return corsError("max_age не может быть отрицательным")
+	}
+	return nil
+}
+
+type corsError string
+
+func (e corsError) Error() string { return "cors: " + string(e) }
+
+// headerGetter и headerSetter — общее у http.Header и httpraw.Header.
+type headerGetter interface {
+	Get(key string) string
+}
+
+type headerSetter interface {
+	Set(key, value string)
+	Add(key, value string)
+}
+
+// apply выставляет заголовки CORS ответа. Ненулевой status значит, что
+// ответ готов: 204 — успешный предварительный запрос, 403 — отказ в нём
+// с сообщением msg. Обычные запросы с чужих origin проходят без
+// заголовков CORS: ответ получит сервер, но не скрипт.
+func (p CORSPolicy) apply(method string, req headerGetter, resp headerSetter) (status int, msg string) {
+	origin := req.Get("Origin")
+	preflight := method == http.MethodOptions && req.Get("Access-Control-Request-Method") != ""
+	if !p.anyOrigin() || p.Credentials {
+		// ответ зависит от Origin — кэши должны это учитывать
+		resp.Add("Vary", "Origin")
+	}
+	if preflight {
+		resp.Add("Vary", "Access-Control-Request-Method")
+		resp.Add("Vary", "Access-Control-Request-Headers")
+	}
+	if origin == "" {
+		return 0, ""
+	}
+	if !p.originAllowed(origin) {
+		if preflight {
+			return http.StatusForbidden, "CORS: origin " + origin + " не разрешён"
+		}
+		return 0, ""
+	}
+
+	if !preflight {
+		p.allowOrigin(origin, resp)
+		if len(p.Expose) > 0 {
+			resp.Set("Access-Control-Expose-Headers", strings.Join(p.Expose, ", "))
+		}
+		return 0, ""
+	}
+
+	// отказ в предварительном запросе — без Access-Control-Allow-Origin,
+	// чтобы браузер точно не отправил основной
+	methods := p.Methods
+	if len(methods) == 0 {
+		methods = DefaultCORSMethods
+	}
+	reqMethod := req.Get("Access-Control-Request-Method")
+	if !containsFold(methods, reqMethod) {
+		return http.StatusForbidden, "CORS: метод " + reqMethod + " не разрешён"
+	}
+	var allowHeaders []string
+	for _, h := range strings.Split(req.Get("Access-Control-Request-Headers"), ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		if !containsFold(p.Headers, "*") && !containsFold(p.Headers, h) && !safelisted(h) {
+			return http.StatusForbidden, "CORS: заголовок " + h + " не разрешён"
+		}
+		allowHeaders = append(allowHeaders, h)
+	}
+
+	p.allowOrigin(origin, resp)
+	resp.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	if len(allowHeaders) > 0 {
+		resp.Set("Access-Control-Allow-Headers", strings.Join(allowHeaders, ", "))
+	}
+	if p.MaxAge > 0 {
+		resp.Set("Access-Control-Max-Age", strconv.Itoa(int(p.MaxAge/time.Second)))
+	}
+	return http.StatusNoContent, ""
+}
+
+// allowOrigin разрешает ответ странице origin. С учётными данными "*"
+// не годится: браузер требует точный origin.
+func (p CORSPolicy) allowOrigin(origin string, resp headerSetter) {
+	if p.anyOrigin() && !p.Credentials {
+		resp.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		resp.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.Credentials {
+		resp.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+func (p CORSPolicy) anyOrigin() bool {
+	return containsFold(p.Origins, "*")
+}
+
+// originAllowed сравнивает origin со списком: точно (без учёта
+// регистра) или по маске "scheme://*.domain", которая подходит к
+// поддоменам, но не к самому domain.
+func (p CORSPolicy) originAllowed(origin string) bool {
+	for _, o := range p.Origins {
+		if o == "*" && origin != "null" || strings.EqualFold(o, origin) {
+			return true
+		}
+		scheme, host, ok := strings.Cut(o, "://*.")
+		if !ok {
+			continue
+		}
+		prefix := strings.ToLower(scheme + "://")
+		lower := strings.ToLower(origin)
+		if strings.HasPrefix(lower, prefix) && strings.HasSuffix(lower, "."+strings.ToLower(host)) {
+			return true
+		}
+	}
+	return false
+}
+
+// safelisted — заголовки, которые браузер и так отправляет без
+// разрешения (CORS-safelisted request-header). Их всё равно бывает в
+// Access-Control-Request-Headers, если значение необычное.
+func safelisted(h string) bool {
+	switch strings.ToLower(h) {
+	case "accept", "accept-language", "content-language", "content-type", "range":
+		return true
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	p := CORSPolicy{Origins: []string{"https://dash.example.com", "https://*.example.org", "http://*.Local.Test"}}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://dash.example.com", true},
+		{"HTTPS://DASH.EXAMPLE.COM", true},
+		{"http://dash.example.com", false},
+		{"https://dash.example.com:8443", false},
+		{"https://a.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evilexample.org", false},
+		{"http://a.example.org", false},
+		{"https://example.org.evil.com", false},
+		{"http://app.local.test", true},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		if got := p.originAllowed(tt.origin); got != tt.want {
+			t.Errorf("originAllowed(%q) = %v; ожидалось %v", tt.origin, got, tt.want)
+		}
+	}
+	star := CORSPolicy{Origins: []string{"*"}}
+	if !star.originAllowed("https://x.example") || star.originAllowed("null") {
+		t.Error(`"*" должен разрешать любой origin, кроме "null"`)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	exact := CORSPolicy{
+		Origins: []string{"https://app.example.com", "https://*.example.net"},
+		Methods: []string{"GET", "PUT"},
+		Headers: []string{"X-Token"},
+		Expose:  []string{"X-Request-Id"},
+		MaxAge:  10 * time.Minute,
+	}
+	creds := exact
+	creds.Credentials = true
+	wildcard := CORSPolicy{Origins: []string{"*"}, Headers: []string{"*"}}
+
+	tests := []struct {
+		name   string
+		policy CORSPolicy
+		method string
+		header map[string]string
+		code   int               // 0 — запрос дошёл до обработчика
+		want   map[string]string // "" — поля быть не должно
+	}{
+		{"обычный запрос с разрешённого origin", exact, "GET",
+			map[string]string{"Origin": "https://app.example.com"}, 0,
+			map[string]string{"Access-Control-Allow-Origin": "https://app.example.com",
+				"Access-Control-Expose-Headers": "X-Request-Id", "Vary": "Origin",
+				"Access-Control-Allow-Credentials": ""}},
+		{"поддомен по маске", exact, "GET",
+			map[string]string{"Origin": "https://a.example.net"}, 0,
+			map[string]string{"Access-Control-Allow-Origin": "https://a.example.net"}},
+		{"чужой origin проходит без заголовков", exact, "GET",
+			map[string]string{"Origin": "https://evil.example"}, 0,
+			map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"}},
+		{"без Origin", exact, "GET", nil, 0,
+			map[string]string{"Access-Control-Allow-Origin": ""}},
+		{"предварительный запрос", exact, "OPTIONS",
+			map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "PUT",
+				"Access-Control-Request-Headers": "x-token, content-type"}, 204,
+			map[string]string{"Access-Control-Allow-Origin": "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET, PUT", "Access-Control-Allow-Headers": "x-token, content-type",
+				"Access-Control-Max-Age": "600"}},
+		{"OPTIONS без Access-Control-Request-Method — обычный запрос", exact, "OPTIONS",
+			map[string]string{"Origin": "https://app.example.com"}, 0,
+			map[string]string{"Access-Control-Allow-Methods": ""}},
+		{"предварительный: чужой origin", exact, "OPTIONS",
+			map[string]string{"Origin": "https://evil.example", "Access-Control-Request-Method": "GET"}, 403,
+			map[string]string{"Access-Control-Allow-Origin": ""}},
+		{"предварительный: метод не разрешён", exact, "OPTIONS",
+			map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "DELETE"}, 403,
+			map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""}},
+		{"предварительный: заголовок не разрешён", exact, "OPTIONS",
+			map[string]string{"Origin": "https://app.example.com", "Access-Control-Request-Method": "GET",
+				"Access-Control-Request-Headers": "X-Token, X-Other"}, 403,
+			map[string]string{"Access-Control-Allow-Origin": ""}},
+		{"предварительный: методы по умолчанию", wildcard, "OPTIONS",
+			map[string]string{"Origin": "https://any.example", "Access-Control-Request-Method": "POST",
+				"Access-Control-Request-Headers": "X-Anything"}, 204,
+			map[string]string{"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Methods": "GET, HEAD, POST",
+				"Access-Control-Allow-Headers": "X-Anything", "Access-Control-Max-Age": ""}},
+		{"любой origin: ответ не зависит от Origin", wildcard, "GET",
+			map[string]string{"Origin": "https://any.example"}, 0,
+			map[string]string{"Access-Control-Allow-Origin": "*", "Vary": ""}},
+		{"origin null при \"*\" не разрешён", wildcard, "GET",
+			map[string]string{"Origin": "null"}, 0,
+			map[string]string{"Access-Control-Allow-Origin": ""}},
+		{"учётные данные: точный origin", creds, "GET",
+			map[string]string{"Origin": "https://app.example.com"}, 0,
+			map[string]string{"Access-Control-Allow-Origin": "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true", "Vary": "Origin"}},
+		{"учётные данные в предварительном запросе", creds, "OPTIONS",
+			map[string]string{"Origin": "https://b.example.net", "Access-Control-Request-Method": "GET"}, 204,
+			map[string]string{"Access-Control-Allow-Origin": "https://b.example.net",
+				"Access-Control-Allow-Credentials": "true"}},
+		{"учётные данные не даются чужому origin", creds, "GET",
+			map[string]string{"Origin": "https://evil.example"}, 0,
+			map[string]string{"Access-Control-Allow-Credentials": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached := false
+			h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }), CORS(tt.policy))
+			r := httptest.NewRequest(tt.method, "/api", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if reached != (tt.code == 0) {
+				t.Errorf("обработчик вызван: %v; ожидалось %v", reached, tt.code == 0)
+			}
+			if tt.code != 0 && w.Code != tt.code {
+				t.Errorf("код %d; ожидался %d", w.Code, tt.code)
+			}
+			for k, want := range tt.want {
+				if got := w.Header().Get(k); got != want {
+					t.Errorf("%s: %q; ожидалось %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		p     CORSPolicy
+		valid bool
+	}{
+		{"точные origin с учётными данными", CORSPolicy{Origins: []string{"https://a.example"}, Credentials: true}, true},
+		{"\"*\" без учётных данных", CORSPolicy{Origins: []string{"*"}}, true},
+		{"\"*\" с учётными данными", CORSPolicy{Origins: []string{"*"}, Credentials: true}, false},
+		{"origin без схемы", CORSPolicy{Origins: []string{"a.example"}}, false},
+		{"отрицательный MaxAge", CORSPolicy{Origins: []string{"null"}, MaxAge: -time.Second}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.p.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: Validate() = %v", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// Package middleware — обёртки для обработчиков net/http серверов labs1.
+// Каждый сервер собирает из них свою цепочку вместе с журналом доступа
+// (accesslog), авторизацией (auth) и ограничением частоты (ratelimit).
+// CORS есть и в варианте для raw сервера (RawCORS).
+package middleware
+
+import (
+	"net/http"
+)
+
+// Middleware оборачивает обработчик.
+type Middleware func(http.Handler) http.Handler
+
+// Chain применяет middleware так, что первый в списке оказывается
+// снаружи: Chain(h, A, B) обрабатывает запрос как A(B(h)).
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		if mws[i] != nil {
+			h = mws[i](h)
+		}
+	}
+	return h
+}
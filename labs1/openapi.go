@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"labs1/apierror"
+)
+
+// apiServers — базовые URL серверов file и dns для openapi.json;
+// заполняются при их создании, до начала работы серверов.
+var apiServers = map[string]string{}
+
+func registerAPIServer(name, addr string) {
+	apiServers[name] = scheme() + "://" + urlHost(addr)
+}
+
+// openAPIHandler отдаёт описание API file и dns в формате OpenAPI 3.0.
+// Один документ на оба сервера: у каждого пути свой servers.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	enc.Encode(openAPIDocument())
+}
+
+type object = map[string]any
+
+func openAPIDocument() object {
+	servers := func(name string) []object {
+		if u, ok := apiServers[name]; ok {
+			return []object{{"url": u, "description": "сервер " + name}}
+		}
+		return nil
+	}
+	fileServers, dnsServers := servers("file"), servers("dns")
+
+	errorContent := object{
+		"application/json": object{"schema": object{"$ref": "#/components/schemas/Error"}},
+		"text/html": object{"schema": object{
+			"type":        "string",
+			"description": "Та же ошибка страницей, если Accept ставит text/html выше application/json",
+		}},
+	}
+	errorResponse := func(description string) object {
+		return object{"description": description, "content": errorContent}
+	}
+	common := func(responses object) object {
+		responses["401"] = object{"$ref": "#/components/responses/Unauthorized"}
+		responses["429"] = object{"$ref": "#/components/responses/TooManyRequests"}
+		return responses
+	}
+
+	paths := object{}
+	if fileServers != nil {
+		paths["/file"] = object{
+			"servers": fileServers,
+			"get": object{
+				"operationId": "getFile",
+				"summary":     "Содержимое файла по file:// URL",
+				"description": "Отдаёт файл целиком. Если рядом лежит сжатая копия (.gz) и клиент принимает gzip, отдаётся она с Content-Encoding: gzip.",
+				"parameters": []object{{
+					"name":        "path",
+					"in":          "query",
+					"required":    true,
+					"description": "URL файла со схемой file",
+					"schema":      object{"type": "string", "format": "uri"},
+					"example":     "file:///etc/hostname",
+				}},
+				"responses": common(object{
+					"200": object{
+						"description": "Содержимое файла; Content-Type по расширению",
+						"headers": object{
+							"Content-Disposition": object{"schema": object{"type": "string"}, "example": `inline; filename="hostname"`},
+						},
+						"content": object{"*/*": object{"schema": object{"type": "string", "format": "binary"}}},
+					},
+					"400": errorResponse("Нет параметра path, некорректный URL или схема не file (missing_parameter, invalid_url, unsupported_scheme)"),
+					"403": errorResponse("Нет прав на чтение файла (forbidden)"),
+					"404": errorResponse("Файл не найден (file_not_found)"),
+					"500": errorResponse("Ошибка чтения файла (file_read_failed)"),
+				}),
+			},
+		}
+	}
+	if dnsServers != nil {
+		paths["/dns"] = object{
+			"servers": dnsServers,
+			"get": object{
+				"operationId": "queryDNS",
+				"summary":     "DNS запрос через dig или nslookup",
+				"parameters": []object{
+					{
+						"name":        "domain",
+						"in":          "query",
+						"required":    true,
+						"description": "Доменное имя",
+						"schema":      object{"type": "string"},
+						"example":     "google.com",
+					},
+					{
+						"name":        "type",
+						"in":          "query",
+						"description": "Тип записи",
+						"schema":      object{"type": "string", "enum": dnsTypes, "default": "A"},
+					},
+				},
+				"responses": common(object{
+					"200": object{
+						"description": "Результат команды",
+						"content":     object{"application/json": object{"schema": object{"$ref": "#/components/schemas/DNSResponse"}}},
+					},
+					"400": errorResponse("Нет параметра domain, некорректное имя или тип записи (missing_parameter, invalid_parameter)"),
+					"502": errorResponse("Команда dig/nslookup завершилась ошибкой (dns_query_failed)"),
+				}),
+			},
+		}
+	}
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "labs1: file и dns API",
+			"version":     "1.0.0",
+			"description": "Ошибки всех маршрутов — объект Error с устойчивым кодом; браузер получает их страницей HTML.",
+		},
+		"paths": paths,
+		"components": object{
+			"schemas": object{
+				"Error": object{
+					"type":     "object",
+					"required": []string{"error"},
+					"properties": object{
+						"error": object{
+							"type":     "object",
+							"required": []string{"status", "code", "message", "request_id"},
+							"properties": object{
+								"status":     object{"type": "integer", "example": 404},
+								"code":       object{"type": "string", "enum": apierror.Codes, "example": apierror.FileNotFound},
+								"message":    object{"type": "string", "example": "Файл не найден: /etc/nope"},
+								"request_id": object{"type": "string", "description": "Тот же идентификатор, что в заголовке X-Request-ID и журнале доступа"},
+							},
+						},
+					},
+				},
+				"DNSResponse": object{
+					"type": "object",
+					"properties": object{
+						"domain":    object{"type": "string"},
+						"type":      object{"type": "string"},
+						"command":   object{"type": "string", "example": "dig google.com A +short"},
+						"result":    object{"type": "string"},
+						"timestamp": object{"type": "string", "format": "date-time"},
+					},
+				},
+			},
+			"responses": object{
+				"Unauthorized": object{
+					"description": "Нужна авторизация, если она включена для маршрута (unauthorized)",
+					"headers": object{
+						"WWW-Authenticate": object{"schema": object{"type": "string"}},
+					},
+					"content": errorContent,
+				},
+				"TooManyRequests": object{
+					"description": "Превышен лимит частоты запросов или вход заблокирован (too_many_requests)",
+					"headers": object{
+						"Retry-After":         object{"schema": object{"type": "integer"}, "description": "Через сколько секунд повторить"},
+						"RateLimit-Limit":     object{"schema": object{"type": "integer"}},
+						"RateLimit-Remaining": object{"schema": object{"type": "integer"}},
+						"RateLimit-Reset":     object{"schema": object{"type": "integer"}},
+					},
+					"content": errorContent,
+				},
+			},
+			"securitySchemes": object{
+				"basic":  object{"type": "http", "scheme": "basic"},
+				"digest": object{"type": "http", "scheme": "digest"},
+			},
+		},
+	}
+}
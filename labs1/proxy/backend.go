@@ -0,0 +1,115 @@
+// Package proxy — обратный прокси для raw сервера: запросы клиентов
+// пересылаются на один из upstream серверов по HTTP/1.1 поверх net.Dial.
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"labs1/httpraw"
+)
+
+// Backend — один upstream сервер.
+type Backend struct {
+	Addr string // host:port
+
+	healthy atomic.Bool
+	active  atomic.Int64 // запросов в работе, для least-conn
+	total   atomic.Int64
+	fails   atomic.Int64 // неудачных соединений подряд
+
+	// счётчики проверок для гистерезиса: сколько раз подряд проверка
+	// прошла или не прошла
+	rise, fall int
+}
+
+// NewBackend разбирает адрес вида "host:port" или "http://host:port".
+// Новый backend считается живым до первой проверки.
+func NewBackend(addr string) (*Backend, error) {
+	addr = strings.TrimPrefix(addr, "http://")
+	addr = strings.TrimSuffix(addr, "/")
+	if strings.Contains(addr, "://") {
+		return nil, fmt.Errorf("upstream %q: поддерживается только http://", addr)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("upstream %q: %v", addr, err)
+	}
+	b := &Backend{Addr: addr}
+	b.healthy.Store(true)
+	return b, nil
+}
+
+// Healthy сообщает, направляются ли на backend запросы.
+func (b *Backend) Healthy() bool { return b.healthy.Load() }
+
+// BackendStats — состояние backend для страницы статуса.
+type BackendStats struct {
+	Addr    string `json:"addr"`
+	Healthy bool   `json:"healthy"`
+	Active  int64  `json:"active"`
+	Total   int64  `json:"total"`
+	Fails   int64  `json:"fails"`
+}
+
+func (b *Backend) stats() BackendStats {
+	return BackendStats{
+		Addr:    b.Addr,
+		Healthy: b.Healthy(),
+		Active:  b.active.Load(),
+		Total:   b.total.Load(),
+		Fails:   b.fails.Load(),
+	}
+}
+
+// Сколько проверок подряд нужно, чтобы сменить состояние: одна случайная
+// ошибка не выбрасывает backend из ротации.
+const (
+	riseThreshold = 2
+	fallThreshold = 2
+)
+
+// check делает GET path и считает backend живым при ответе 2xx или 3xx.
+func (b *Backend) check(path string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", b.Addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: labs1-health\r\nConnection: close\r\n\r\n", path, b.Addr)
+	resp, err := httpraw.NewParser(bufio.NewReader(conn), nil).ReadResponse("GET")
+	if err != nil {
+		return err
+	}
+	if resp.Status >= 400 {
+		return fmt.Errorf("статус %d", resp.Status)
+	}
+	return nil
+}
+
+// report учитывает результат проверки и возвращает true, если состояние
+// backend изменилось.
+func (b *Backend) report(err error) bool {
+	if err == nil {
+		b.fall = 0
+		b.rise++
+		if !b.Healthy() && b.rise >= riseThreshold {
+			b.healthy.Store(true)
+			b.fails.Store(0)
+			return true
+		}
+		return false
+	}
+	b.rise = 0
+	b.fall++
+	if b.Healthy() && b.fall >= fallThreshold {
+		b.healthy.Store(false)
+		return true
+	}
+	return false
+}
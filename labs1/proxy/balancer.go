@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// Стратегии выбора backend.
+const (
+	RoundRobin = "round-robin"
+	LeastConn  = "least-conn"
+	IPHash     = "ip-hash"
+)
+
+// Policies перечисляет допустимые значения Proxy.Policy.
+var Policies = []string{RoundRobin, LeastConn, IPHash}
+
+func validPolicy(p string) error {
+	for _, known := range Policies {
+		if p == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("неизвестная стратегия балансировки %q (допустимы %v)", p, Policies)
+}
+
+type balancer struct {
+	policy   string
+	backends []*Backend
+	next     atomic.Uint64
+}
+
+// pick выбирает живой backend, которого нет в tried. clientIP нужен для
+// ip-hash. nil — выбирать не из чего.
+func (lb *balancer) pick(clientIP string, tried map[*Backend]bool) *Backend {
+	n := len(lb.backends)
+	usable := func(b *Backend) bool { return b.Healthy() && !tried[b] }
+
+	switch lb.policy {
+	case IPHash:
+		// Хеш считается по всему списку, а не только по живым: пока
+		// backend жив, клиент попадает на него же. Если он выпал, берём
+		// следующий по кругу.
+		h := fnv.New32a()
+		h.Write([]byte(clientIP))
+		start := int(h.Sum32() % uint32(n))
+		for i := 0; i < n; i++ {
+			if b := lb.backends[(start+i)%n]; usable(b) {
+				return b
+			}
+		}
+		return nil
+
+	case LeastConn:
+		// при равной нагрузке — по кругу, чтобы не грузить первый
+		start := int(lb.next.Add(1) % uint64(n))
+		var best *Backend
+		for i := 0; i < n; i++ {
+			b := lb.backends[(start+i)%n]
+			if usable(b) && (best == nil || b.active.Load() < best.active.Load()) {
+				best = b
+			}
+		}
+		return best
+
+	default:
+		for i := 0; i < n; i++ {
+			b := lb.backends[int((lb.next.Add(1)-1)%uint64(n))]
+			if usable(b) {
+				return b
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,394 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"labs1/httpraw"
+)
+
+// Proxy пересылает запросы raw сервера на upstream backends. Поля
+// заполняются до Start и потом не меняются.
+type Proxy struct {
+	Backends []*Backend
+	Policy   string // RoundRobin, LeastConn или IPHash
+
+	// HealthPath и HealthInterval включают активные проверки. Без них
+	// backend из ротации не выпадает: ошибка соединения лишь переводит
+	// запрос на другой.
+	HealthPath     string
+	HealthInterval time.Duration
+
+	DialTimeout     time.Duration
+	ResponseTimeout time.Duration // ожидание заголовков ответа
+	// Retries — сколько других backend попробовать, если соединение с
+	// выбранным не удалось.
+	Retries int
+
+	Logf func(format string, args ...any)
+
+	lb *balancer
+}
+
+// Заголовки hop-by-hop относятся к одному соединению и дальше прокси не
+// передаются (RFC 9110 7.6.1).
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Connection", "Proxy-Authenticate",
+	"Proxy-Authorization", "TE", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// Start проверяет настройки и запускает активные проверки backends.
+func (p *Proxy) Start() error {
+	if len(p.Backends) == 0 {
+		return errors.New("не задан ни один upstream")
+	}
+	if p.Policy == "" {
+		p.Policy = RoundRobin
+	}
+	if err := validPolicy(p.Policy); err != nil {
+		return err
+	}
+	if p.DialTimeout <= 0 {
+		p.DialTimeout = 3 * time.Second
+	}
+	if p.ResponseTimeout <= 0 {
+		p.ResponseTimeout = 30 * time.Second
+	}
+	p.lb = &balancer{policy: p.Policy, backends: p.Backends}
+
+	if p.HealthInterval > 0 {
+		if p.HealthPath == "" {
+			p.HealthPath = "/"
+		}
+		go p.healthLoop()
+	}
+	return nil
+}
+
+func (p *Proxy) logf(format string, args ...any) {
+	if p.Logf != nil {
+		p.Logf(format, args...)
+	}
+}
+
+func (p *Proxy) healthLoop() {
+	ticker := time.NewTicker(p.HealthInterval)
+	defer ticker.Stop()
+	for {
+		for _, b := range p.Backends {
+			err := b.check(p.HealthPath, p.DialTimeout)
+			if b.report(err) {
+				if b.Healthy() {
+					p.logf("Прокси: upstream %s снова доступен", b.Addr)
+				} else {
+					p.logf("Прокси: upstream %s недоступен: %v", b.Addr, err)
+				}
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// Stats возвращает состояние всех backends.
+func (p *Proxy) Stats() []BackendStats {
+	out := make([]BackendStats, len(p.Backends))
+	for i, b := range p.Backends {
+		out[i] = b.stats()
+	}
+	return out
+}
+
+// ServeHTTP выбирает backend, пересылает запрос и копирует ответ.
+func (p *Proxy) ServeHTTP(w httpraw.ResponseWriter, r *httpraw.Request) {
+	if r.Method == "CONNECT" {
+		httpraw.Error(w, "CONNECT через обратный прокси не поддерживается", 405)
+		return
+	}
+	// запрос уходит к backend текстом HTTP/1.1: CR или LF в любом поле
+	// дописали бы к нему чужие заголовки или второй запрос
+	if err := checkRequest(r); err != nil {
+		httpraw.Error(w, err.Error(), 400)
+		return
+	}
+	clientIP := remoteIP(r.RemoteAddr)
+	tried := make(map[*Backend]bool)
+
+	var conn net.Conn
+	var backend *Backend
+	for attempt := 0; attempt <= p.Retries; attempt++ {
+		backend = p.lb.pick(clientIP, tried)
+		if backend == nil {
+			break
+		}
+		tried[backend] = true
+		c, err := net.DialTimeout("tcp", backend.Addr, p.DialTimeout)
+		if err == nil {
+			conn = c
+			backend.fails.Store(0)
+			break
+		}
+		backend.fails.Add(1)
+		if p.HealthInterval > 0 {
+			// не ждём следующей проверки — вернёт backend она же
+			backend.healthy.Store(false)
+		}
+		p.logf("Прокси: соединение с %s не удалось: %v", backend.Addr, err)
+	}
+	if conn == nil {
+		if len(tried) == 0 {
+			httpraw.Error(w, "Нет доступных upstream серверов", 503)
+		} else {
+			httpraw.Error(w, "Upstream серверы недоступны", 502)
+		}
+		return
+	}
+	defer conn.Close()
+
+	backend.active.Add(1)
+	backend.total.Add(1)
+	defer backend.active.Add(-1)
+
+	if err := p.forward(w, r, conn); err != nil {
+		p.logf("Прокси: %s %s через %s: %v", r.Method, r.RequestURI, backend.Addr, err)
+	}
+}
+
+// forward отправляет запрос в уже открытое соединение с backend и
+// копирует ответ клиенту.
+func (p *Proxy) forward(w httpraw.ResponseWriter, r *httpraw.Request, conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(p.ResponseTimeout))
+
+	bw := bufio.NewWriter(conn)
+	target := r.RequestURI
+	if !strings.HasPrefix(target, "/") && target != "*" {
+		// absolute-form: backend получает обычный путь
+		if u, err := url.Parse(target); err == nil {
+			target = u.RequestURI()
+		}
+	}
+	fmt.Fprintf(bw, "%s %s HTTP/1.1\r\n", r.Method, target)
+
+	h := outgoingHeader(r)
+	for k, vs := range h {
+		for _, v := range vs {
+			fmt.Fprintf(bw, "%s: %s\r\n", k, v)
+		}
+	}
+	bw.WriteString("\r\n")
+
+	var err error
+	switch {
+	case sendChunked(r):
+		err = writeChunked(bw, r.Body)
+	case r.ContentLength > 0:
+		_, err = io.Copy(bw, r.Body)
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		httpraw.Error(w, "Ошибка отправки запроса upstream", 502)
+		return err
+	}
+
+	resp, err := httpraw.NewParser(bufio.NewReader(conn), nil).ReadResponse(r.Method)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			httpraw.Error(w, "Upstream не ответил вовремя", 504)
+		} else {
+			httpraw.Error(w, "Некорректный ответ upstream", 502)
+		}
+		return err
+	}
+	// дальше тело может идти долго (потоковые ответы): ограничиваем
+	// только паузы между порциями
+	conn.SetDeadline(time.Time{})
+
+	out := w.Header()
+	for k, vs := range resp.Header {
+		for _, v := range vs {
+			out.Add(k, v)
+		}
+	}
+	removeHopHeaders(out)
+	out.Add("Via", "1.1 labs1")
+	if resp.ContentLength >= 0 && !resp.Chunked {
+		out.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	w.WriteHeader(resp.Status)
+
+	return copyBody(w, resp, conn, p.ResponseTimeout)
+}
+
+// checkRequest проверяет, что метод, цель, Host и поля запроса можно
+// без искажений записать в стартовую строку и заголовок HTTP/1.1.
+func checkRequest(r *httpraw.Request) error {
+	if !httpraw.ValidFieldName(r.Method) {
+		return fmt.Errorf("некорректный метод %q", r.Method)
+	}
+	if r.RequestURI == "" || strings.IndexFunc(r.RequestURI, func(c rune) bool { return c <= ' ' || c == 0x7f }) >= 0 {
+		return fmt.Errorf("некорректная цель запроса %q", r.RequestURI)
+	}
+	if r.Host != "" && !httpraw.ValidHost(r.Host) {
+		return fmt.Errorf("некорректный Host %q", r.Host)
+	}
+	for k, vs := range r.Header {
+		if !httpraw.ValidFieldName(k) {
+			return fmt.Errorf("некорректное имя поля %q", k)
+		}
+		for _, v := range vs {
+			if !httpraw.ValidFieldValue(v) {
+				return fmt.Errorf("недопустимые символы в поле %s", k)
+			}
+		}
+	}
+	return nil
+}
+
+// outgoingHeader готовит заголовок запроса к backend: убирает hop-by-hop
+// поля и дописывает сведения о клиенте.
+func outgoingHeader(r *httpraw.Request) httpraw.Header {
+	h := r.Header.Clone()
+	removeHopHeaders(h)
+	h.Set("Connection", "close")
+	if r.Host != "" {
+		h.Set("Host", r.Host)
+	}
+	if sendChunked(r) {
+		h.Set("Transfer-Encoding", "chunked")
+	}
+
+	ip := remoteIP(r.RemoteAddr)
+	if ip != "" {
+		if prior := strings.Join(h.Values("X-Forwarded-For"), ", "); prior != "" {
+			h.Set("X-Forwarded-For", prior+", "+ip)
+		} else {
+			h.Set("X-Forwarded-For", ip)
+		}
+	}
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	if h.Get("X-Forwarded-Proto") == "" {
+		h.Set("X-Forwarded-Proto", proto)
+	}
+	if h.Get("X-Forwarded-Host") == "" && r.Host != "" {
+		h.Set("X-Forwarded-Host", r.Host)
+	}
+
+	// RFC 7239: новый элемент дописывается в конец списка
+	elem := "for=" + forwardedNode(ip) + ";proto=" + proto
+	if r.Host != "" {
+		elem += ";host=" + quoteIfNeeded(r.Host)
+	}
+	if prior := strings.Join(h.Values("Forwarded"), ", "); prior != "" {
+		h.Set("Forwarded", prior+", "+elem)
+	} else {
+		h.Set("Forwarded", elem)
+	}
+	h.Add("Via", fmt.Sprintf("%d.%d labs1", r.ProtoMajor, r.ProtoMinor))
+	return h
+}
+
+// removeHopHeaders удаляет hop-by-hop поля, включая перечисленные в
+// Connection.
+func removeHopHeaders(h httpraw.Header) {
+	for _, name := range h.Tokens("Connection") {
+		h.Del(name)
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// forwardedNode оформляет адрес для Forwarded: IPv6 берётся в кавычки и
+// квадратные скобки.
+func forwardedNode(ip string) string {
+	if ip == "" {
+		return "unknown"
+	}
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+func quoteIfNeeded(s string) string {
+	if strings.ContainsAny(s, ":[]\" ") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// sendChunked сообщает, что тело запроса уходит к backend в chunked
+// кодировке: так пришло от клиента или длина неизвестна. У запросов
+// HTTP/2 без content-length нет ни Content-Length, ни Chunked.
+func sendChunked(r *httpraw.Request) bool {
+	return r.Chunked || r.ContentLength < 0 && r.Body != nil
+}
+
+// writeChunked передаёт тело неизвестной длины в chunked кодировке.
+func writeChunked(bw *bufio.Writer, body io.Reader) error {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			fmt.Fprintf(bw, "%x\r\n", n)
+			bw.Write(buf[:n])
+			bw.WriteString("\r\n")
+		}
+		if err == io.EOF {
+			_, err = bw.WriteString("0\r\n\r\n")
+			return err
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// copyBody копирует тело ответа клиенту. Тело без известной длины
+// сбрасывается после каждой порции, чтобы потоковые ответы (SSE) не
+// застревали в буфере.
+func copyBody(w httpraw.ResponseWriter, resp *httpraw.Response, conn net.Conn, idle time.Duration) error {
+	flusher, _ := w.(httpraw.Flusher)
+	stream := resp.ContentLength < 0 && flusher != nil
+	buf := make([]byte, 32<<10)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idle))
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if stream {
+				if ferr := flusher.Flush(); ferr != nil {
+					return ferr
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"labs1/httpraw"
+)
+
+// recorder запоминает ответ обработчика.
+type recorder struct {
+	header httpraw.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() httpraw.Header { return r.header }
+func (r *recorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+func (r *recorder) Write(b []byte) (int, error) {
+	r.WriteHeader(200)
+	return r.body.Write(b)
+}
+
+type upstreamRequest struct {
+	req  *httpraw.Request
+	body string
+}
+
+// startUpstream запускает backend, который разбирает каждый запрос
+// парсером httpraw, отдаёт его в канал и отвечает ok с парой hop-by-hop
+// полей.
+func startUpstream(t *testing.T) (*Proxy, <-chan upstreamRequest, *atomic.Int64) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan upstreamRequest, 16)
+	var conns atomic.Int64
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conn.Close()
+				req, err := httpraw.NewParser(conn, io.Discard).ReadRequest()
+				if err != nil {
+					return
+				}
+				body, _ := io.ReadAll(req.Body)
+				got <- upstreamRequest{req, string(body)}
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nConnection: X-Hop\r\nX-Hop: 1\r\nKeep-Alive: timeout=5\r\nX-Upstream: yes\r\nContent-Length: 2\r\n\r\nok")
+			}()
+		}
+	}()
+
+	b, err := NewBackend(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proxy{Backends: []*Backend{b}}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return p, got, &conns
+}
+
+func newRequest(method, target string, header ...string) *httpraw.Request {
+	r := &httpraw.Request{
+		Method:     method,
+		RequestURI: target,
+		Host:       "site.example",
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     httpraw.Header{},
+		RemoteAddr: "192.0.2.7:5555",
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Add(header[i], header[i+1])
+	}
+	return r
+}
+
+func TestProxyHeaders(t *testing.T) {
+	p, got, _ := startUpstream(t)
+	r := newRequest("GET", "/path?q=1",
+		"X-Custom", "value",
+		"Connection", "keep-alive, X-Secret",
+		"X-Secret", "hop",
+		"Keep-Alive", "timeout=5",
+		"Proxy-Authorization", "Basic eDp5",
+		"TE", "trailers",
+		"Upgrade", "websocket",
+		"X-Forwarded-For", "198.51.100.1",
+	)
+	w := &recorder{header: httpraw.Header{}}
+	p.ServeHTTP(w, r)
+	if w.code != 200 || w.body.String() != "ok" {
+		t.Fatalf("ответ %d %q", w.code, w.body.String())
+	}
+	up := <-got
+
+	want := map[string]string{
+		"X-Custom":          "value",
+		"Host":              "site.example",
+		"Connection":        "close",
+		"X-Forwarded-For":   "198.51.100.1, 192.0.2.7",
+		"X-Forwarded-Proto": "http",
+		"X-Forwarded-Host":  "site.example",
+		"Forwarded":         "for=192.0.2.7;proto=http;host=site.example",
+		"Via":               "1.1 labs1",
+	}
+	if up.req.RequestURI != "/path?q=1" {
+		t.Errorf("upstream получил цель %q", up.req.RequestURI)
+	}
+	for k, v := range want {
+		if got := up.req.Header.Get(k); got != v {
+			t.Errorf("upstream: %s = %q; ожидалось %q", k, got, v)
+		}
+	}
+	for _, k := range []string{"X-Secret", "Keep-Alive", "Proxy-Authorization", "Te", "Upgrade"} {
+		if v := up.req.Header.Values(k); v != nil {
+			t.Errorf("hop-by-hop поле %s дошло до upstream: %q", k, v)
+		}
+	}
+
+	if w.header.Get("X-Upstream") != "yes" || w.header.Get("Via") != "1.1 labs1" {
+		t.Errorf("заголовок ответа %v", w.header)
+	}
+	for _, k := range []string{"X-Hop", "Keep-Alive", "Connection"} {
+		if v := w.header.Values(k); v != nil {
+			t.Errorf("hop-by-hop поле %s дошло до клиента: %q", k, v)
+		}
+	}
+}
+
+// Тело без длины (HTTP/2 без content-length) уходит chunked.
+func TestProxyUnknownLengthBody(t *testing.T) {
+	p, got, _ := startUpstream(t)
+	r := newRequest("POST", "/upload")
+	r.ContentLength = -1
+	r.Body = strings.NewReader("hello")
+	w := &recorder{header: httpraw.Header{}}
+	p.ServeHTTP(w, r)
+	up := <-got
+	if !up.req.Chunked || up.body != "hello" {
+		t.Errorf("upstream: chunked %v, тело %q", up.req.Chunked, up.body)
+	}
+}
+
+// Запрос, который нельзя записать в HTTP/1.1 без изменения его
+// структуры, получает 400 и к upstream не уходит.
+func TestProxyRejectsInjection(t *testing.T) {
+	p, _, conns := startUpstream(t)
+	tests := []struct {
+		name string
+		r    *httpraw.Request
+	}{
+		{"CRLF в значении", newRequest("GET", "/", "X-A", "1\r\nX-Injected: 2")},
+		{"второй запрос в значении", newRequest("POST", "/", "X-A", "1\r\n\r\nGET /admin HTTP/1.1\r\nHost: x")},
+		{"LF в значении", newRequest("GET", "/", "X-A", "1\nX-B: 2")},
+		{"NUL в значении", newRequest("GET", "/", "X-A", "1\x00")},
+		{"имя с пробелом", newRequest("GET", "/", "X A", "1")},
+		{"имя с CRLF", newRequest("GET", "/", "X-A\r\nX-B", "1")},
+		{"метод с пробелом", newRequest("GET / HTTP/1.1\r\nX:", "/")},
+		{"пробел в цели", newRequest("GET", "/a HTTP/1.1\r\nX-B: 1")},
+		{"CRLF в Host", func() *httpraw.Request {
+			r := newRequest("GET", "/")
+			r.Host = "a.example\r\nX-B: 1"
+			return r
+		}()},
+	}
+	for _, tt := range tests {
+		w := &recorder{header: httpraw.Header{}}
+		p.ServeHTTP(w, tt.r)
+		if w.code != 400 {
+			t.Errorf("%s: код %d; ожидался 400", tt.name, w.code)
+		}
+	}
+	if n := conns.Load(); n != 0 {
+		t.Errorf("к upstream открыто %d соединений", n)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"labs1/middleware"
+	"labs1/ratelimit"
+)
+
+var (
+	rateLimit      = flag.Float64("rate-limit", 0, "запросов в секунду с одного IP для file и dns серверов, 0 — без ограничения")
+	rateLimitBurst = flag.Int("rate-limit-burst", 0, "сколько запросов подряд разрешать при -rate-limit (по умолчанию равно -rate-limit)")
+	rateLimitAllow = flag.String("rate-limit-allow", "", "адреса и сети без ограничения частоты через запятую: 127.0.0.1,10.0.0.0/8")
+)
+
+// rateLimitConfig — раздел "rate_limit" файла настроек.
+type rateLimitConfig struct {
+	// Allow — доверенные адреса и сети.
+	Allow []string `json:"allow"`
+	// Routes — лимиты по серверам и путям. Флаг -rate-limit добавляет к
+	// ним общий лимит "/" для file и dns; пути из Routes точнее него.
+	Routes []rateLimitRoute `json:"routes"`
+}
+
+type rateLimitRoute struct {
+	Server string  `json:"server"`
+	Path   string  `json:"path"`
+	Rate   float64 `json:"rate"`
+	// Burst по умолчанию — Rate, округлённый вверх. Rate 0 открывает путь
+	// внутри ограниченного.
+	Burst int `json:"burst"`
+}
+
+// validate проверяет раздел до запуска серверов.
+func (rc rateLimitConfig) validate() error {
+	for _, a := range rc.Allow {
+		if _, err := ratelimit.ParseAllow(a); err != nil {
+			return fmt.Errorf("rate_limit.allow: %v", err)
+		}
+	}
+	for _, r := range rc.Routes {
+		switch r.Server {
+		case "raw", "file", "dns":
+		default:
+			return fmt.Errorf("rate_limit: неизвестный сервер %q (raw, file или dns)", r.Server)
+		}
+		if !strings.HasPrefix(r.Path, "/") {
+			return fmt.Errorf("rate_limit: путь %q должен начинаться с /", r.Path)
+		}
+		if r.Rate < 0 || r.Burst < 0 {
+			return fmt.Errorf("rate_limit: %s%s: rate и burst не могут быть отрицательными", r.Server, r.Path)
+		}
+	}
+	return nil
+}
+
+// labLimiter и rateRules задаёт setupRateLimit; nil labLimiter —
+// ограничений нет.
+var (
+	labLimiter *ratelimit.Limiter
+	rateRules  map[string][]ratelimit.Rule
+)
+
+// setupRateLimit собирает правила из настроек и флага -rate-limit.
+func setupRateLimit(rc rateLimitConfig) error {
+	routes := rc.Routes
+	if *rateLimit < 0 || *rateLimitBurst < 0 {
+		return fmt.Errorf("-rate-limit и -rate-limit-burst не могут быть отрицательными")
+	}
+	if *rateLimit > 0 {
+		for _, server := range []string{"file", "dns"} {
+			routes = append(routes, rateLimitRoute{Server: server, Path: "/", Rate: *rateLimit, Burst: *rateLimitBurst})
+		}
+	}
+	if len(routes) == 0 {
+		return nil
+	}
+
+	var allow []netip.Prefix
+	for _, a := range rc.Allow {
+		p, err := ratelimit.ParseAllow(a)
+		if err != nil {
+			return err
+		}
+		allow = append(allow, p)
+	}
+
+	rateRules = make(map[string][]ratelimit.Rule)
+	for _, r := range routes {
+		if r.Burst == 0 && r.Rate > 0 {
+			r.Burst = int(math.Ceil(r.Rate))
+		}
+		rateRules[r.Server] = append(rateRules[r.Server], ratelimit.Rule{Path: r.Path, Rate: r.Rate, Burst: r.Burst})
+	}
+	labLimiter = ratelimit.New(allow)
+	labLimiter.Logf = func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
+	}
+
+	fmt.Println("Ограничение частоты запросов:")
+	for _, server := range []string{"raw", "file", "dns"} {
+		for _, r := range rateRules[server] {
+			if r.Rate <= 0 {
+				fmt.Printf("  %s %s: без ограничения\n", server, r.Path)
+				continue
+			}
+			fmt.Printf("  %s %s: %g запросов/с, подряд до %d\n", server, r.Path, r.Rate, r.Burst)
+		}
+	}
+	if len(allow) > 0 {
+		fmt.Printf("  без ограничения для %s\n", strings.Join(rc.Allow, ", "))
+	}
+	return nil
+}
+
+// rateLimitMiddleware — ограничение частоты net/http сервера name, nil —
+// если для него нет правил.
+func rateLimitMiddleware(name string) middleware.Middleware {
+	if labLimiter == nil || len(rateRules[name]) == 0 {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		return labLimiter.Handler(rateRules[name], next)
+	}
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,237 @@
+// Package ratelimit ограничивает частоту запросов каждого клиента (по IP)
+// к отдельным маршрутам net/http и raw серверов. Каждой паре «клиент,
+// правило» соответствует корзина токенов (token bucket): она вмещает
+// Burst токенов и пополняется со скоростью Rate в секунду, запрос
+// забирает один токен. Когда токенов нет, клиент получает 429 с
+// Retry-After. Все ответы несут поля RateLimit-* (draft-ietf-httpapi-
+// ratelimit-headers), чтобы клиент мог подстроиться заранее.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"labs1/httpraw"
+)
+
+// Rule ограничивает запросы к Path и всему, что под ним: "/dns" — это
+// /dns и /dns/..., "/" — весь сервер. Из нескольких подходящих правил
+// действует самое длинное. Rate 0 снимает ограничение с пути внутри
+// ограниченного.
+type Rule struct {
+	Path string
+	// Rate — сколько токенов в секунду получает корзина клиента.
+	Rate float64
+	// Burst — ёмкость корзины: столько запросов подряд клиент может
+	// сделать после паузы.
+	Burst int
+}
+
+// Limiter хранит корзины клиентов. Один Limiter обслуживает несколько
+// серверов: корзины различаются по правилу, поэтому лимиты разных
+// серверов не смешиваются.
+type Limiter struct {
+	// Allow — доверенные адреса и сети, на которые лимиты не действуют.
+	Allow []netip.Prefix
+	// Logf получает сообщения о клиентах, упёршихся в лимит; nil — не
+	// писать.
+	Logf func(format string, args ...any)
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	rule   *Rule
+	client string
+}
+
+type bucket struct {
+	tokens  float64
+	last    time.Time
+	limited bool // последний запрос получил 429
+}
+
+// New создаёт Limiter с доверенными сетями allow.
+func New(allow []netip.Prefix) *Limiter {
+	return &Limiter{Allow: allow, buckets: make(map[bucketKey]*bucket)}
+}
+
+// ParseAllow разбирает адрес или сеть для Allow: "127.0.0.1",
+// "10.0.0.0/8", "::1".
+func ParseAllow(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("некорректная сеть %q", s)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("некорректный адрес %q", s)
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Handler пропускает запросы в пределах лимита и отвечает 429 на
+// остальные.
+func (l *Limiter) Handler(rules []Rule, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := l.check(rules, r.URL.Path, r.RemoteAddr)
+		d.writeHeaders(w.Header())
+		if !d.allowed {
+			http.Error(w, "Слишком много запросов", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RawHandler — то же для raw сервера.
+func (l *Limiter) RawHandler(rules []Rule, next httpraw.Handler) httpraw.Handler {
+	return httpraw.HandlerFunc(func(w httpraw.ResponseWriter, r *httpraw.Request) {
+		d := l.check(rules, r.Path, r.RemoteAddr)
+		d.writeHeaders(w.Header())
+		if !d.allowed {
+			httpraw.Error(w, "Слишком много запросов", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decision — итог проверки. rule == nil — запрос не ограничивается и
+// заголовков не получает.
+type decision struct {
+	rule       *Rule
+	allowed    bool
+	remaining  int
+	reset      time.Duration // до полной корзины
+	retryAfter time.Duration // до следующего токена, если отказано
+}
+
+// headerWriter — общее у http.Header и httpraw.Header.
+type headerWriter interface {
+	Set(key, value string)
+}
+
+func (d decision) writeHeaders(h headerWriter) {
+	if d.rule == nil {
+		return
+	}
+	window := math.Ceil(float64(d.rule.Burst) / d.rule.Rate)
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", d.rule.Burst, int(window)))
+	h.Set("RateLimit-Limit", strconv.Itoa(d.rule.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(d.reset)))
+	if !d.allowed {
+		h.Set("Retry-After", strconv.Itoa(max(1, seconds(d.retryAfter))))
+	}
+}
+
+// seconds округляет вверх: клиент, подождавший столько, получит токен.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func (l *Limiter) check(rules []Rule, path, remoteAddr string) decision {
+	rule := matchRule(rules, path)
+	if rule == nil || rule.Rate <= 0 {
+		return decision{allowed: true}
+	}
+	client := hostOnly(remoteAddr)
+	if l.allowed(client) {
+		return decision{allowed: true}
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	key := bucketKey{rule, client}
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(rule.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(rule.Burst), b.tokens+now.Sub(b.last).Seconds()*rule.Rate)
+	b.last = now
+
+	d := decision{rule: rule, allowed: b.tokens >= 1}
+	if d.allowed {
+		b.tokens--
+		b.limited = false
+	} else {
+		d.retryAfter = time.Duration((1 - b.tokens) / rule.Rate * float64(time.Second))
+		if !b.limited && l.Logf != nil {
+			l.Logf("Rate limit: %s исчерпал лимит %s (%g запросов/с, запас %d)", client, rule.Path, rule.Rate, rule.Burst)
+		}
+		b.limited = true
+	}
+	d.remaining = int(b.tokens)
+	d.reset = time.Duration((float64(rule.Burst) - b.tokens) / rule.Rate * float64(time.Second))
+	return d
+}
+
+func (l *Limiter) allowed(client string) bool {
+	if len(l.Allow) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(client)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range l.Allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sweep раз в минуту выбрасывает корзины, которые успели наполниться:
+// они ничем не отличаются от новых, а без этого таблица растёт с каждым
+// клиентом.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*k.rule.Rate >= float64(k.rule.Burst) {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastSweep = now
+}
+
+// matchRule выбирает правило с самым длинным подходящим путём.
+func matchRule(rules []Rule, path string) *Rule {
+	var best *Rule
+	for i := range rules {
+		p := strings.TrimSuffix(rules[i].Path, "/")
+		if path != p && !strings.HasPrefix(path, p+"/") {
+			continue
+		}
+		if best == nil || len(rules[i].Path) > len(best.Path) {
+			best = &rules[i]
+		}
+	}
+	return best
+}
+
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
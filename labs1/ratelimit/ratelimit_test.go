@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestMatchRule(t *testing.T) {
+	rules := []Rule{
+		{Path: "/", Rate: 10, Burst: 10},
+		{Path: "/dns", Rate: 1, Burst: 2},
+		{Path: "/dns/free/", Rate: 0},
+		{Path: "/api/v1", Rate: 5, Burst: 5},
+	}
+	tests := []struct {
+		path string
+		want string // "" — ни одно правило не подходит
+	}{
+		{"/", "/"},
+		{"/index.html", "/"},
+		{"/dns", "/dns"},
+		{"/dns/", "/dns"},
+		{"/dns/query", "/dns"},
+		{"/dnsx", "/"},
+		{"/dns/free", "/dns/free/"},
+		{"/dns/free/a", "/dns/free/"},
+		{"/api/v1/users", "/api/v1"},
+		{"/api/v10", "/"},
+	}
+	for _, tt := range tests {
+		got := matchRule(rules, tt.path)
+		if got == nil || got.Path != tt.want {
+			t.Errorf("matchRule(%q) = %v; ожидалось правило %q", tt.path, got, tt.want)
+		}
+	}
+	if got := matchRule(rules[1:], "/other"); got != nil {
+		t.Errorf("matchRule(/other) = %v; ожидалось nil", got)
+	}
+}
+
+// Корзина на 3 токена пополняется на 2 токена в секунду. Время
+// сдвигается назад в самой корзине, чтобы тест не спал.
+func TestRefill(t *testing.T) {
+	rules := []Rule{{Path: "/", Rate: 2, Burst: 3}}
+	steps := []struct {
+		name      string
+		wait      time.Duration
+		allowed   bool
+		remaining int
+	}{
+		{"полная корзина", 0, true, 2},
+		{"второй подряд", 0, true, 1},
+		{"третий подряд", 0, true, 0},
+		{"корзина пуста", 0, false, 0},
+		{"за 250 мс меньше токена", 250 * time.Millisecond, false, 0},
+		{"ещё 250 мс — токен", 250 * time.Millisecond, true, 0},
+		{"за секунду два токена", time.Second, true, 1},
+		{"пауза не переполняет корзину", time.Minute, true, 2},
+	}
+	l := New(nil)
+	for _, step := range steps {
+		for _, b := range l.buckets {
+			b.last = b.last.Add(-step.wait)
+		}
+		d := l.check(rules, "/x", "192.0.2.1:1234")
+		if d.allowed != step.allowed || d.remaining != step.remaining {
+			t.Errorf("%s: allowed %v, remaining %d; ожидалось %v, %d",
+				step.name, d.allowed, d.remaining, step.allowed, step.remaining)
+		}
+	}
+	// другой клиент и другое правило — свои корзины
+	if d := l.check(rules, "/x", "192.0.2.2:1234"); !d.allowed || d.remaining != 2 {
+		t.Errorf("другой клиент: allowed %v, remaining %d; ожидалась полная корзина", d.allowed, d.remaining)
+	}
+	other := []Rule{{Path: "/", Rate: 2, Burst: 3}}
+	if d := l.check(other, "/x", "192.0.2.1:1234"); !d.allowed || d.remaining != 2 {
+		t.Errorf("другое правило: allowed %v, remaining %d; ожидалась полная корзина", d.allowed, d.remaining)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	rules := []Rule{
+		{Path: "/", Rate: 0.5, Burst: 2},
+		{Path: "/free", Rate: 0},
+	}
+	allow, err := ParseAllow("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New([]netip.Prefix{allow})
+	h := l.Handler(rules, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name   string
+		path   string
+		remote string
+		code   int
+		header map[string]string // "" — поля быть не должно
+	}{
+		{"первый запрос", "/a", "192.0.2.1:1000", 200, map[string]string{
+			"RateLimit-Policy": "2;w=4", "RateLimit-Limit": "2", "RateLimit-Remaining": "1",
+			"RateLimit-Reset": "2", "Retry-After": ""}},
+		{"второй запрос", "/b", "192.0.2.1:1001", 200, map[string]string{
+			"RateLimit-Remaining": "0", "RateLimit-Reset": "4", "Retry-After": ""}},
+		{"лимит исчерпан", "/a", "192.0.2.1:1002", 429, map[string]string{
+			"RateLimit-Remaining": "0", "RateLimit-Reset": "4", "Retry-After": "2"}},
+		{"Rate 0 снимает ограничение", "/free/x", "192.0.2.1:1003", 200, map[string]string{
+			"RateLimit-Limit": "", "Retry-After": ""}},
+		{"доверенная сеть", "/a", "10.1.2.3:1000", 200, map[string]string{
+			"RateLimit-Limit": ""}},
+		{"IPv4 в IPv6 из доверенной сети", "/a", "[::ffff:10.1.2.3]:1000", 200, map[string]string{
+			"RateLimit-Limit": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.RemoteAddr = tt.remote
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Errorf("код %d; ожидался %d", w.Code, tt.code)
+			}
+			for k, want := range tt.header {
+				if got := w.Header().Get(k); got != want {
+					t.Errorf("%s: %q; ожидалось %q", k, got, want)
+				}
+			}
+		})
+	}
+}
@@ -1,27 +1,170 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"html"
+	"io"
 	"net"
+	"strconv"
 	"strings"
 	"time"
+
+	"labs1/accesslog"
+	"labs1/compress"
+	"labs1/h2"
+	"labs1/httpraw"
+	"labs1/middleware"
 )
 
-func handleHTTPRawSocket() {
-	// Создаем TCP сокет
-	listener, err := net.Listen("tcp", ":8080")
-	if err != nil {
-		fmt.Printf("Ошибка создания сокета: %v\n", err)
-		return
+var rawStaticDir = flag.String("static", "public", "каталог, который raw сервер раздаёт по /static/")
+
+// Сервер на сыром сокете держит соединения открытыми: HTTP/1.1 по
+// умолчанию, HTTP/1.0 — по Connection: keep-alive. Handler назначается
+// при запуске, когда флаги уже разобраны.
+var rawServer = &httpraw.Server{
+	IdleTimeout:        15 * time.Second,
+	MaxRequestsPerConn: 100,
+	Logf: func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
+	},
+}
+
+// HTTP/2 на том же порту: h2c с prior knowledge или h2 через ALPN.
+var rawH2Server = &h2.Server{
+	IdleTimeout:          30 * time.Second,
+	MaxConcurrentStreams: 100,
+	Logf: func(format string, args ...any) {
+		fmt.Printf(format+"\n", args...)
+	},
+}
+
+// rawFeatures — разделы raw сервера по именам. Основной сайт включает
+// их все, виртуальный хост — перечисленные в его "routes".
+var rawFeatures = map[string]func(rt *httpraw.Router){
+	"hello": func(rt *httpraw.Router) {
+		rt.HandleFunc("GET", "/hello/{name}", rawHelloHandler)
+	},
+	"events": func(rt *httpraw.Router) {
+		rt.HandleFunc("GET", "/events", rawEventsHandler)
+	},
+	"ws": func(rt *httpraw.Router) {
+		rt.HandleFunc("GET", "/ws/echo", wsEchoHandler)
+		rt.HandleFunc("GET", "/ws/broadcast", wsBroadcastHandler)
+	},
+	"static": func(rt *httpraw.Router) {
+		rt.Handle("GET", "/static/{path...}", httpraw.FileServer(*rawStaticDir))
+	},
+	"metrics": func(rt *httpraw.Router) {
+		rt.Handle("GET", "/metrics", labMetrics.RawHandler())
+	},
+	"upload": func(rt *httpraw.Router) {
+		rt.HandleFunc("POST", "/upload", rawUploadHandler)
+	},
+	"cgi": func(rt *httpraw.Router) {
+		rt.Handle("", "/cgi-bin/{path...}", newRawCGI())
+	},
+	"httpbin": registerHTTPBin,
+}
+
+// rawFeatureOrder — порядок регистрации разделов основного сайта.
+var rawFeatureOrder = []string{"hello", "events", "ws", "static", "metrics", "upload", "cgi", "httpbin"}
+
+func newRawRouter() *httpraw.Router {
+	rt := httpraw.NewRouter()
+	rt.HandleFunc("", "/", func(w httpraw.ResponseWriter, req *httpraw.Request) {
+		rawIndexHandler(w, req, rt.Routes())
+	})
+	for _, name := range rawFeatureOrder {
+		rawFeatures[name](rt)
 	}
-	defer listener.Close()
+	return rt
+}
 
-	fmt.Println("HTTP сервер запущен на порту 8080 (Raw Socket)")
+// newRawLabServer готовит raw сервер: маршруты или прокси, виртуальные
+// хосты, лимиты и сжатие. Ошибка настройки прокси или хостов
+// останавливает запуск.
+func newRawLabServer(addr string, vhosts vhostsConfig) (*labServer, error) {
+	var handler httpraw.Handler = newRawRouter()
+	if *proxyUpstreams != "" {
+		p, err := newRawProxy()
+		if err != nil {
+			return nil, fmt.Errorf("настройка прокси: %w", err)
+		}
+		handler = p
+	}
+	sink := requestSink()
+	if len(vhosts.Hosts) > 0 {
+		vr, err := newVHostRouter(vhosts, handler)
+		if err != nil {
+			return nil, fmt.Errorf("виртуальные хосты: %w", err)
+		}
+		rawVHosts = vr
+		handler = vr
+		sink = accesslog.Tee(sink, vr)
+	}
+	if labAuth != nil && len(authRules["raw"]) > 0 {
+		handler = labAuth.RawHandler(authRules["raw"], handler)
+	}
+	if labLimiter != nil && len(rateRules["raw"]) > 0 {
+		handler = labLimiter.RawHandler(rateRules["raw"], handler)
+	}
+	rawInternal = handler
+	if p, ok := corsPolicies["raw"]; ok {
+		// снаружи авторизации и лимита: предварительный запрос идёт без
+		// учётных данных и не должен тратить лимит
+		handler = middleware.RawCORS(p, handler)
+	}
+	if err := checkRawBackend(); err != nil {
+		return nil, err
+	}
+	applyRawLimits()
+	rawServer.Handler = accesslog.RawHandler("raw", sink, compress.RawHandler(handler))
+	rawH2Server.Handler = rawServer.Handler
 
+	var listener net.Listener
+	return &labServer{
+		name:     "raw",
+		addr:     addr,
+		rejected: rawRejected,
+		serve: func(l net.Listener) error {
+			listener = l
+			if *rawBackend == "epoll" {
+				return serveRawEpoll(l, addr)
+			}
+			return serveRaw(l, addr)
+		},
+		shutdown: func(ctx context.Context) error {
+			listener.Close()
+			errc := make(chan error, 1)
+			go func() { errc <- rawH2Server.Shutdown(ctx) }()
+			err := rawServer.Shutdown(ctx)
+			if h2err := <-errc; err == nil {
+				err = h2err
+			}
+			return err
+		},
+	}, nil
+}
+
+// serveRaw принимает соединения, пока слушатель не закроют, и
+// обслуживает каждое в своей горутине.
+func serveRaw(listener net.Listener, addr string) error {
+	printRawBanner(addr)
 	for {
 		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
 		if err != nil {
+			// например, кончились файловые дескрипторы: ждём, а не крутимся
 			fmt.Printf("Ошибка принятия соединения: %v\n", err)
+			time.Sleep(100 * time.Millisecond)
 			continue
 		}
 
@@ -29,54 +172,210 @@ func handleHTTPRawSocket() {
 	}
 }
 
+// printRawBanner перечисляет разделы raw сервера при запуске.
+func printRawBanner(addr string) {
+	host := urlHost(addr)
+	if serverTLS != nil {
+		fmt.Printf("HTTP сервер запущен на %s (Raw Socket + TLS)\n", addr)
+	} else {
+		fmt.Printf("HTTP сервер запущен на %s (Raw Socket)\n", addr)
+	}
+	if rawProxy != nil {
+		fmt.Printf("Обратный прокси (%s) на %s\n", rawProxy.Policy, *proxyUpstreams)
+		fmt.Printf("Состояние upstream: %s://%s/_proxy/status\n", scheme(), host)
+	} else {
+		fmt.Printf("Статические файлы: %s://%s/static/ -> %s\n", scheme(), host, *rawStaticDir)
+		fmt.Printf("Server-Sent Events: %s://%s/events\n", scheme(), host)
+		fmt.Printf("WebSocket: %s://%s/ws/echo и /ws/broadcast\n", wsScheme(), host)
+		fmt.Printf("Загрузка файлов: %s://%s/static/upload.html -> %s\n", scheme(), host, *uploadDir)
+		fmt.Printf("CGI: %s://%s/cgi-bin/ -> %s\n", scheme(), host, *cgiDir)
+		fmt.Printf("Диагностика в духе httpbin: %s://%s/anything, /headers, /status/{code}, /drip...\n", scheme(), host)
+	}
+	if rawVHosts != nil {
+		for _, h := range rawVHosts.hosts {
+			fmt.Printf("Виртуальный хост: %s -> %s\n", strings.Join(h.names, ", "), h.root)
+		}
+		if rawVHosts.strict {
+			fmt.Println("Неизвестные хосты получают 421 Misdirected Request")
+		}
+	}
+}
+
 func handleHTTPConnection(conn net.Conn) {
 	defer conn.Close()
 
-	// Читаем запрос
-	buffer := make([]byte, 4096)
-	n, err := conn.Read(buffer)
-	if err != nil {
-		fmt.Printf("Ошибка чтения запроса: %v\n", err)
-		return
+	start := time.Now()
+	alpn := ""
+	if serverTLS != nil {
+		tlsConn, err := rawTLSHandshake(conn)
+		if err != nil {
+			fmt.Printf("Ошибка TLS рукопожатия с %s: %v\n", conn.RemoteAddr(), err)
+			return
+		}
+		conn = tlsConn
+		alpn = tlsConn.ConnectionState().NegotiatedProtocol
 	}
 
-	request := string(buffer[:n])
-	fmt.Printf("Получен HTTP запрос:\n%s\n", request)
+	// Версию протокола выбираем по ALPN, а без TLS — по первым байтам:
+	// клиент h2c с prior knowledge начинает с preface HTTP/2.
+	br := bufio.NewReaderSize(conn, 4096)
+	isH2 := alpn == "h2"
+	if alpn == "" {
+		conn.SetReadDeadline(time.Now().Add(rawServer.IdleTimeout))
+		var err error
+		isH2, err = h2.HasPreface(br)
+		conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			return
+		}
+	}
 
-	// Парсим первую строку запроса
-	lines := strings.Split(request, "\r\n")
-	if len(lines) == 0 {
+	proto := "http1"
+	if isH2 {
+		proto = "h2"
+	}
+	rawConns.Add(1, proto)
+	defer rawConns.Add(-1, proto)
+
+	if isH2 {
+		served := rawH2Server.ServeConn(conn, br)
+		fmt.Printf("Соединение HTTP/2 %s закрыто: потоков %d, время %v\n",
+			conn.RemoteAddr(), served, time.Since(start).Round(time.Millisecond))
 		return
 	}
 
-	requestLine := strings.Split(lines[0], " ")
-	if len(requestLine) < 3 {
+	served := rawServer.ServeBuffered(conn, br)
+	fmt.Printf("Соединение %s закрыто: запросов %d, время %v\n",
+		conn.RemoteAddr(), served, time.Since(start).Round(time.Millisecond))
+}
+
+// rawTLSHandshake оборачивает принятое соединение в tls.Server и
+// выполняет рукопожатие сразу, чтобы SNI и ALPN были известны до
+// первого запроса.
+func rawTLSHandshake(conn net.Conn) (*tls.Conn, error) {
+	cfg := serverTLS.Clone()
+	cfg.NextProtos = []string{"h2", "http/1.1"}
+	if rawVHosts != nil {
+		cfg.GetCertificate = rawVHosts.certificate
+	}
+
+	tlsConn := tls.Server(conn, cfg)
+	tlsConn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// tlsSummary описывает параметры TLS-сессии для страницы ответа.
+func tlsSummary(st *tls.ConnectionState) string {
+	if st == nil {
+		return "нет"
+	}
+	sni := st.ServerName
+	if sni == "" {
+		sni = "(не передан)"
+	}
+	alpn := st.NegotiatedProtocol
+	if alpn == "" {
+		alpn = "(не согласован)"
+	}
+	return fmt.Sprintf("%s, %s, SNI: %s, ALPN: %s",
+		tls.VersionName(st.Version), tls.CipherSuiteName(st.CipherSuite), sni, alpn)
+}
+
+// rawIndexHandler показывает запрос и маршруты, которые обслуживает
+// raw сервер.
+func rawIndexHandler(w httpraw.ResponseWriter, req *httpraw.Request, routes []string) {
+	bodySize, err := io.Copy(io.Discard, req.Body)
+	if err != nil {
+		code := httpraw.StatusCode(err)
+		if code == 0 {
+			code = 400
+		}
+		httpraw.Error(w, err.Error(), code)
+		w.Header().Set("Connection", "close")
 		return
 	}
 
-	method := requestLine[0]
-	path := requestLine[1]
+	var routeList strings.Builder
+	for _, r := range routes {
+		fmt.Fprintf(&routeList, "<li><code>%s</code></li>", html.EscapeString(r))
+	}
 
 	// Формируем HTTP ответ
-	responseBody := fmt.Sprintf(`
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `
     <html>
     <head><title>Raw Socket HTTP Server</title></head>
     <body>
         <h1>Hello from Raw Socket HTTP Server!</h1>
+        <p>Protocol: %s</p>
         <p>Method: %s</p>
         <p>Path: %s</p>
+        <p>Host: %s</p>
+        <p>Headers: %d</p>
+        <p>Body: %d bytes</p>
+        <p>Connection: %s, request #%d</p>
+        <p>TLS: %s</p>
         <p>Time: %s</p>
+        <h2>Routes</h2>
+        <ul>%s</ul>
     </body>
-    </html>`, method, path, time.Now().Format("2006-01-02 15:04:05"))
-
-	httpResponse := fmt.Sprintf(
-		"HTTP/1.1 200 OK\r\n"+
-			"Content-Type: text/html; charset=utf-8\r\n"+
-			"Content-Length: %d\r\n"+
-			"Connection: close\r\n"+
-			"\r\n%s",
-		len(responseBody), responseBody)
-
-	// Отправляем ответ
-	conn.Write([]byte(httpResponse))
+    </html>`, html.EscapeString(req.Proto), html.EscapeString(req.Method), html.EscapeString(req.Path), html.EscapeString(req.Host),
+		len(req.Header), bodySize, html.EscapeString(req.RemoteAddr), req.Seq,
+		html.EscapeString(tlsSummary(req.TLS)),
+		time.Now().Format("2006-01-02 15:04:05"), routeList.String())
+}
+
+func rawHelloHandler(w httpraw.ResponseWriter, req *httpraw.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<html><body><h1>Hello, %s!</h1><p>Route: %s</p></body></html>",
+		html.EscapeString(req.Param("name")), html.EscapeString(req.Pattern))
+}
+
+// rawEvent — данные одного события /events.
+type rawEvent struct {
+	Time     string           `json:"time"`
+	Stream   string           `json:"stream_duration"`
+	Client   string           `json:"client"`
+	Stats    httpraw.Stats    `json:"stats"`
+	Rejected map[string]int64 `json:"rejected"`
+}
+
+// rawEventsHandler раз в секунду отправляет время сервера и счётчики
+// соединений, пока клиент не отключится: ошибка записи — единственный
+// признак того, что клиента больше нет.
+func rawEventsHandler(w httpraw.ResponseWriter, req *httpraw.Request) {
+	stream, err := httpraw.NewEventStream(w, 3000)
+	if err != nil {
+		httpraw.Error(w, err.Error(), 500)
+		return
+	}
+	fmt.Printf("SSE: клиент %s подключился\n", req.RemoteAddr)
+
+	start := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for id := 1; ; id++ {
+		data, _ := json.Marshal(rawEvent{
+			Time:     time.Now().Format("2006-01-02 15:04:05"),
+			Stream:   time.Since(start).Round(time.Second).String(),
+			Client:   req.RemoteAddr,
+			Stats:    rawServer.Stats(),
+			Rejected: rawRejected.Snapshot(),
+		})
+		if err := stream.Send("tick", strconv.Itoa(id), string(data)); err != nil {
+			fmt.Printf("SSE: клиент %s отключился после %d событий\n", req.RemoteAddr, id-1)
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-rawServer.Done():
+			stream.Send("shutdown", "", "сервер останавливается")
+			return
+		}
+	}
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"labs1/cgi"
+	"labs1/httpraw"
+)
+
+var (
+	cgiDir     = flag.String("cgi-dir", "cgi-bin", "каталог CGI-скриптов raw сервера (/cgi-bin/)")
+	cgiTimeout = flag.Duration("cgi-timeout", 30*time.Second, "время работы CGI-скрипта, после которого он завершается")
+)
+
+// rawInternal — цепочка raw сервера без журнала и сжатия: виртуальные
+// хосты, авторизация и ограничение частоты. Её собирает
+// newRawLabServer уже после маршрутизаторов.
+var rawInternal httpraw.Handler
+
+// newRawCGI монтирует CGI на /cgi-bin/. Локальные перенаправления
+// скриптов проходят через rawInternal, как новый запрос: Host тот же,
+// поэтому виртуальный хост не меняется, а правила авторизации и лимиты
+// проверяются для нового пути.
+func newRawCGI() *cgi.Handler {
+	return &cgi.Handler{
+		Root:         "/cgi-bin/",
+		Dir:          *cgiDir,
+		DocumentRoot: *rawStaticDir,
+		Timeout:      *cgiTimeout,
+		LocalRedirect: httpraw.HandlerFunc(func(w httpraw.ResponseWriter, req *httpraw.Request) {
+			rawInternal.ServeHTTP(w, req)
+		}),
+		Logf: func(format string, args ...any) {
+			fmt.Printf(format+"\n", args...)
+		},
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"labs1/httpraw"
+)
+
+// request — запрос в том виде, в каком он уйдёт в сокет. Поля
+// заголовка хранятся списком, чтобы сохранить порядок и написание.
+type request struct {
+	method  string
+	url     *url.URL
+	header  [][2]string
+	body    []byte // nil — без тела
+	chunked bool
+}
+
+// has проверяет, задано ли поле name (без учёта регистра).
+func (r *request) has(name string) bool {
+	for _, f := range r.header {
+		if strings.EqualFold(f[0], name) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutFields возвращает поля заголовка без перечисленных.
+func withoutFields(header [][2]string, names ...string) [][2]string {
+	var h [][2]string
+	for _, f := range header {
+		drop := false
+		for _, n := range names {
+			if strings.EqualFold(f[0], n) {
+				drop = true
+			}
+		}
+		if !drop {
+			h = append(h, f)
+		}
+	}
+	return h
+}
+
+// write отправляет запрос одним куском: стартовая строка, Host и
+// заголовки по умолчанию (если их не задали через -H), поля -H, затем
+// тело с Content-Length или чанками.
+func (r *request) write(w io.Writer) error {
+	bw := bufio.NewWriterSize(w, 4096)
+	fmt.Fprintf(bw, "%s %s HTTP/1.1\r\n", r.method, r.url.RequestURI())
+	for _, def := range [][2]string{
+		{"Host", r.url.Host},
+		{"User-Agent", "rawcurl/1.0"},
+		{"Accept", "*/*"},
+	} {
+		if !r.has(def[0]) {
+			fmt.Fprintf(bw, "%s: %s\r\n", def[0], def[1])
+		}
+	}
+	for _, f := range r.header {
+		fmt.Fprintf(bw, "%s: %s\r\n", f[0], f[1])
+	}
+
+	switch {
+	case r.body == nil:
+		bw.WriteString("\r\n")
+	case r.chunked:
+		bw.WriteString("Transfer-Encoding: chunked\r\n\r\n")
+		for rest := r.body; len(rest) > 0; {
+			n := min(len(rest), 16<<10)
+			fmt.Fprintf(bw, "%x\r\n", n)
+			bw.Write(rest[:n])
+			bw.WriteString("\r\n")
+			rest = rest[n:]
+		}
+		bw.WriteString("0\r\n\r\n")
+	default:
+		fmt.Fprintf(bw, "Content-Length: %d\r\n\r\n", len(r.body))
+		bw.Write(r.body)
+	}
+	return bw.Flush()
+}
+
+// redirect строит запрос по Location (RFC 9110 15.4): 301–303 меняют
+// метод на GET без тела, 307 и 308 повторяют запрос как есть.
+// Учётные данные на другой хост не пересылаются.
+func (r *request) redirect(status int, location string) (*request, error) {
+	target, err := r.url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный Location %q: %v", location, err)
+	}
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return nil, fmt.Errorf("перенаправление на неподдерживаемую схему %q", target.Scheme)
+	}
+	next := &request{method: r.method, url: target, header: r.header, body: r.body, chunked: r.chunked}
+	if status != 307 && status != 308 && r.method != "HEAD" {
+		next.method = "GET"
+		next.body = nil
+		next.header = withoutFields(next.header, "Content-Type", "Content-Length", "Transfer-Encoding")
+	}
+	if target.Host != r.url.Host {
+		next.header = withoutFields(next.header, "Authorization", "Cookie")
+	}
+	return next, nil
+}
+
+// client держит по одному простаивающему соединению на адрес: rawcurl
+// выполняет запросы по очереди, больше не нужно.
+type client struct {
+	timeout  time.Duration
+	insecure bool
+	trace    *wireTrace
+	idle     map[string]*clientConn
+}
+
+type clientConn struct {
+	net.Conn
+	key    string
+	parser *httpraw.Parser
+	served int
+}
+
+// do отправляет запрос и читает статус и заголовки ответа. Тело читает
+// вызывающий, после чего отдаёт соединение в release или discard.
+func (c *client) do(req *request) (*httpraw.Response, *clientConn, error) {
+	key := connKey(req.url)
+	if cc := c.idle[key]; cc != nil {
+		delete(c.idle, key)
+		c.tracef("Повторно используется соединение с %s (запросов на нём: %d)", cc.RemoteAddr(), cc.served)
+		resp, err := c.roundTrip(cc, req)
+		if err == nil {
+			return resp, cc, nil
+		}
+		cc.Close()
+		if !isStale(err) {
+			return nil, nil, err
+		}
+		// сервер закрыл простаивающее соединение раньше, чем мы пришли
+		c.tracef("Соединение закрыто сервером (%v), открываем новое", err)
+	}
+
+	cc, err := c.dial(req.url)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.roundTrip(cc, req)
+	if err != nil {
+		cc.Close()
+		return nil, nil, err
+	}
+	return resp, cc, nil
+}
+
+func (c *client) roundTrip(cc *clientConn, req *request) (*httpraw.Response, error) {
+	cc.SetDeadline(time.Now().Add(c.timeout))
+	if err := req.write(cc); err != nil {
+		return nil, err
+	}
+	resp, err := cc.parser.ReadResponse(req.method)
+	if err != nil {
+		return nil, err
+	}
+	cc.served++
+	return resp, nil
+}
+
+// release возвращает соединение в запас, если ответ это допускает.
+// Тело ответа к этому моменту должно быть дочитано.
+func (c *client) release(cc *clientConn, resp *httpraw.Response) {
+	if resp.Close {
+		c.tracef("Сервер закрывает соединение с %s", cc.RemoteAddr())
+		cc.Close()
+		return
+	}
+	cc.SetDeadline(time.Time{})
+	if old := c.idle[cc.key]; old != nil {
+		old.Close()
+	}
+	c.idle[cc.key] = cc
+}
+
+// discard закрывает соединение с недочитанным ответом.
+func (c *client) discard(cc *clientConn) {
+	cc.Close()
+}
+
+func (c *client) closeIdle() {
+	for key, cc := range c.idle {
+		cc.Close()
+		delete(c.idle, key)
+	}
+}
+
+func (c *client) dial(u *url.URL) (*clientConn, error) {
+	addr := hostPort(u)
+	c.tracef("Соединение с %s...", addr)
+	conn, err := net.DialTimeout("tcp", addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.tracef("Соединение с %s (%s) установлено", addr, conn.RemoteAddr())
+
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{
+			ServerName:         u.Hostname(),
+			InsecureSkipVerify: c.insecure,
+			NextProtos:         []string{"http/1.1"},
+		})
+		tlsConn.SetDeadline(time.Now().Add(c.timeout))
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS: %w", err)
+		}
+		st := tlsConn.ConnectionState()
+		c.tracef("Рукопожатие %s завершено, шифр %s", tls.VersionName(st.Version), tls.CipherSuiteName(st.CipherSuite))
+		conn = tlsConn
+	}
+
+	if c.trace != nil {
+		// байты показываем уже расшифрованными: поверх TLS
+		conn = &tracedConn{Conn: conn, trace: c.trace}
+	}
+	return &clientConn{Conn: conn, key: connKey(u), parser: httpraw.NewParser(conn, nil)}, nil
+}
+
+func (c *client) tracef(format string, args ...any) {
+	if c.trace != nil {
+		c.trace.info(fmt.Sprintf(format, args...))
+	}
+}
+
+// connKey — соединения переиспользуются только для той же схемы,
+// хоста и порта.
+func connKey(u *url.URL) string {
+	return u.Scheme + "://" + hostPort(u)
+}
+
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// isStale — ошибки, с которыми запрос на старом соединении можно
+// повторить на новом: сервер закрыл его до нашего запроса.
+func isStale(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
@@ -0,0 +1,249 @@
+// rawcurl — клиент HTTP/1.1 в духе curl, парный raw серверу labs1:
+// запрос собирается вручную и пишется в сокет net.Dial, ответ
+// разбирается httpraw.Parser (Content-Length, chunked, до закрытия).
+// Соединения переиспользуются между адресами из командной строки и
+// перенаправлениями, а -v показывает байты в том виде, в каком они
+// ушли в сокет и пришли из него.
+//
+//	go run ./rawcurl -v http://localhost:8080/ http://localhost:8080/metrics
+//	go run ./rawcurl -i -L localhost:8080/static
+//	go run ./rawcurl -chunked -d @upload.bin localhost:8080/
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"labs1/httpraw"
+)
+
+var (
+	method    = flag.String("X", "", "метод запроса (по умолчанию GET, с -d — POST)")
+	data      = flag.String("d", "", "тело запроса; @file — из файла, @- — из stdin")
+	chunked   = flag.Bool("chunked", false, "отправить тело с Transfer-Encoding: chunked")
+	head      = flag.Bool("I", false, "запрос HEAD, вывести только заголовки")
+	include   = flag.Bool("i", false, "вывести строку статуса и заголовки ответа перед телом")
+	follow    = flag.Bool("L", false, "следовать перенаправлениям 3xx")
+	maxRedirs = flag.Int("max-redirs", 10, "сколько перенаправлений проходить с -L")
+	verbose   = flag.Bool("v", false, "показать байты запроса и ответа на проводе (в stderr)")
+	output    = flag.String("o", "", "записать тело ответа в файл вместо stdout")
+	fail      = flag.Bool("f", false, "на ответ 4xx/5xx завершиться с кодом 22, не выводя тело")
+	timeout   = flag.Duration("timeout", 30*time.Second, "предельное время одного запроса")
+	insecure  = flag.Bool("k", false, "не проверять сертификат сервера для https")
+)
+
+// headerFlags — повторяемый флаг -H "Name: value".
+type headerFlags [][2]string
+
+func (h *headerFlags) String() string { return fmt.Sprint(*h) }
+
+func (h *headerFlags) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return fmt.Errorf("ожидается \"Name: value\", получено %q", s)
+	}
+	*h = append(*h, [2]string{name, strings.TrimSpace(value)})
+	return nil
+}
+
+var headers headerFlags
+
+func main() {
+	os.Exit(run())
+}
+
+// run возвращает код выхода: 0, 1 — ошибка запроса, 2 — ошибка в
+// аргументах, 22 — ответ 4xx/5xx при -f.
+func run() int {
+	flag.Var(&headers, "H", "дополнительный заголовок \"Name: value\" (можно повторять)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Использование: rawcurl [флаги] URL...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		return 2
+	}
+
+	body, err := readData(*data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ошибка: -d: %v\n", err)
+		return 2
+	}
+
+	out := io.Writer(os.Stdout)
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ошибка: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+
+	c := &client{timeout: *timeout, insecure: *insecure, idle: make(map[string]*clientConn)}
+	if *verbose {
+		c.trace = &wireTrace{w: os.Stderr}
+	}
+	defer c.closeIdle()
+
+	code := 0
+	for _, rawURL := range flag.Args() {
+		req, err := newRequest(rawURL, body)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ошибка: %v\n", err)
+			return 2
+		}
+		status, err := fetch(c, req, out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Ошибка: %s: %v\n", rawURL, err)
+			return 1
+		}
+		if *fail && status >= 400 {
+			fmt.Fprintf(os.Stderr, "Ошибка: %s: сервер ответил %d\n", rawURL, status)
+			code = 22
+		}
+	}
+	return code
+}
+
+// newRequest собирает запрос из флагов.
+func newRequest(rawURL string, body []byte) (*request, error) {
+	if i := strings.Index(rawURL, "://"); i < 0 || strings.ContainsAny(rawURL[:i], "/?#:") {
+		// как curl: адрес без схемы — http
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("схема %q не поддерживается (http или https)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("в адресе %q нет хоста", rawURL)
+	}
+
+	req := &request{method: "GET", url: u, header: append([][2]string(nil), headers...), body: body, chunked: *chunked}
+	if body != nil {
+		req.method = "POST"
+		if !req.has("Content-Type") {
+			req.header = append(req.header, [2]string{"Content-Type", "application/x-www-form-urlencoded"})
+		}
+	}
+	if *head {
+		req.method = "HEAD"
+	}
+	if *method != "" {
+		req.method = *method
+	}
+	return req, nil
+}
+
+// fetch выполняет запрос, при -L проходя перенаправления, и выводит
+// ответ. Возвращает код последнего ответа.
+func fetch(c *client, req *request, out io.Writer) (int, error) {
+	for redirects := 0; ; redirects++ {
+		resp, cc, err := c.do(req)
+		if err != nil {
+			return 0, err
+		}
+
+		location := resp.Header.Get("Location")
+		if *follow && isRedirect(resp.Status) && location != "" {
+			if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+				c.discard(cc)
+				return 0, err
+			}
+			c.release(cc, resp)
+			if redirects >= *maxRedirs {
+				return 0, fmt.Errorf("больше %d перенаправлений", *maxRedirs)
+			}
+			next, err := req.redirect(resp.Status, location)
+			if err != nil {
+				return 0, err
+			}
+			c.tracef("Перенаправление %d на %s", resp.Status, next.url)
+			req = next
+			continue
+		}
+
+		if *fail && resp.Status >= 400 {
+			c.discard(cc)
+			return resp.Status, nil
+		}
+		if *include || *head {
+			writeHead(out, resp)
+		}
+		if _, err := io.Copy(out, resp.Body); err != nil {
+			c.discard(cc)
+			return 0, fmt.Errorf("чтение тела: %w", err)
+		}
+		c.release(cc, resp)
+		return resp.Status, nil
+	}
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case 301, 302, 303, 307, 308:
+		return true
+	}
+	return false
+}
+
+// writeHead выводит строку статуса и заголовки для -i. Порядок полей на
+// проводе показывает -v; здесь они отсортированы.
+func writeHead(w io.Writer, resp *httpraw.Response) {
+	fmt.Fprintf(w, "%s %d %s\r\n", resp.Proto, resp.Status, resp.Reason)
+	keys := make([]string, 0, len(resp.Header))
+	for k := range resp.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range resp.Header[k] {
+			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+		}
+	}
+	io.WriteString(w, "\r\n")
+}
+
+func readData(s string) ([]byte, error) {
+	switch {
+	case s == "":
+		if isFlagSet("d") {
+			return []byte{}, nil
+		}
+		return nil, nil
+	case s == "@-":
+		return io.ReadAll(os.Stdin)
+	case strings.HasPrefix(s, "@"):
+		b, err := os.ReadFile(s[1:])
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("файл %s не найден", s[1:])
+		}
+		return b, err
+	}
+	return []byte(s), nil
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"unicode/utf8"
+)
+
+// wireTrace печатает байты соединения для -v: строки запроса с "> ",
+// ответа с "< ", сообщения клиента с "* ". Управляющие байты видны как
+// \r, \t и \xNN, поэтому на экране ровно то, что прошло через сокет.
+type wireTrace struct {
+	w io.Writer
+
+	mu sync.Mutex
+	// dir — направление незаконченной строки, 0 — строка закончена.
+	dir byte
+}
+
+func (t *wireTrace) info(msg string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.endLine()
+	fmt.Fprintf(t.w, "* %s\n", msg)
+}
+
+func (t *wireTrace) dump(dir byte, b []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.dir != dir {
+		t.endLine()
+	}
+	buf := make([]byte, 0, len(b)+16)
+	for len(b) > 0 {
+		if t.dir == 0 {
+			buf = append(buf, dir, ' ')
+			t.dir = dir
+		}
+		r, size := utf8.DecodeRune(b)
+		switch {
+		case b[0] == '\n':
+			buf = append(buf, `\n`...)
+			buf = append(buf, '\n')
+			t.dir = 0
+		case b[0] == '\r':
+			buf = append(buf, `\r`...)
+		case b[0] == '\t':
+			buf = append(buf, `\t`...)
+		case b[0] == '\\':
+			buf = append(buf, `\\`...)
+		case r == utf8.RuneError && size <= 1, b[0] < 0x20, b[0] == 0x7f:
+			buf = fmt.Appendf(buf, `\x%02x`, b[0])
+			size = 1
+		default:
+			buf = append(buf, b[:size]...)
+		}
+		b = b[size:]
+	}
+	t.w.Write(buf)
+}
+
+// endLine завершает строку, оборванную на середине (тело без перевода
+// строки в конце или смена направления).
+func (t *wireTrace) endLine() {
+	if t.dir != 0 {
+		io.WriteString(t.w, "\n")
+		t.dir = 0
+	}
+}
+
+// tracedConn передаёт всё записанное и прочитанное в wireTrace.
+type tracedConn struct {
+	net.Conn
+	trace *wireTrace
+}
+
+func (c *tracedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.trace.dump('>', b[:n])
+	return n, err
+}
+
+func (c *tracedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.trace.dump('<', b[:n])
+	return n, err
+}
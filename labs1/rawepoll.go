@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"time"
+
+	"labs1/httpraw"
+)
+
+var (
+	rawBackend   = flag.String("raw-backend", "goroutine", "модель raw сервера: goroutine (горутина на соединение) или epoll (только Linux)")
+	epollWorkers = flag.Int("epoll-workers", 16, "сколько запросов raw сервер на epoll выполняет одновременно")
+)
+
+// checkRawBackend проверяет -raw-backend до открытия портов.
+func checkRawBackend() error {
+	switch *rawBackend {
+	case "goroutine":
+		return nil
+	case "epoll":
+	default:
+		return fmt.Errorf("-raw-backend: неизвестная модель %q (goroutine или epoll)", *rawBackend)
+	}
+	if !httpraw.EpollSupported() {
+		return errors.New("-raw-backend epoll: epoll есть только в Linux")
+	}
+	if serverTLS != nil {
+		// TLS живёт в crypto/tls поверх net.Conn, а цикл epoll читает
+		// сокет напрямую
+		return errors.New("-raw-backend epoll не поддерживает TLS")
+	}
+	return nil
+}
+
+// serveRawEpoll обслуживает raw сервер циклом epoll с пулом из
+// -epoll-workers обработчиков. Соединения h2c с prior knowledge цикл
+// отдаёт обычной горутине с сервером HTTP/2.
+func serveRawEpoll(listener net.Listener, addr string) error {
+	printRawBanner(addr)
+	fmt.Printf("Модель соединений: epoll, обработчиков %d\n", *epollWorkers)
+
+	return rawServer.ServeEpoll(listener, httpraw.EpollOptions{
+		Workers: *epollWorkers,
+		Opened: func(conn net.Conn) {
+			rawConns.Add(1, "http1")
+		},
+		Closed: func(conn net.Conn, served int, lifetime time.Duration) {
+			rawConns.Add(-1, "http1")
+			fmt.Printf("Соединение %s закрыто: запросов %d, время %v\n",
+				conn.RemoteAddr(), served, lifetime.Round(time.Millisecond))
+		},
+		Detach: func(conn net.Conn, br *bufio.Reader) {
+			defer conn.Close()
+			start := time.Now()
+			rawConns.Add(1, "h2")
+			defer rawConns.Add(-1, "h2")
+			served := rawH2Server.ServeConn(conn, br)
+			fmt.Printf("Соединение HTTP/2 %s закрыто: потоков %d, время %v\n",
+				conn.RemoteAddr(), served, time.Since(start).Round(time.Millisecond))
+		},
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"labs1/httpraw"
+	"labs1/proxy"
+)
+
+// Режим обратного прокси: с -proxy raw сервер на :8080 не отдаёт свои
+// страницы, а пересылает запросы на перечисленные upstream серверы.
+var (
+	proxyUpstreams = flag.String("proxy", "", "upstream серверы через запятую (host:port или http://host:port); включает режим обратного прокси на :8080")
+	proxyPolicy    = flag.String("proxy-policy", proxy.RoundRobin, "балансировка: round-robin, least-conn или ip-hash")
+	proxyHealth    = flag.String("proxy-health", "/", "путь для проверки upstream серверов")
+	proxyInterval  = flag.Duration("proxy-health-interval", 5*time.Second, "период проверок upstream серверов, 0 — без проверок")
+	proxyRetries   = flag.Int("proxy-retries", 1, "сколько других upstream попробовать, если соединение не удалось")
+	proxyTimeout   = flag.Duration("proxy-timeout", 30*time.Second, "ожидание ответа upstream")
+)
+
+var rawProxy *proxy.Proxy
+
+// newRawProxy собирает прокси из флагов. Служебная страница состояния
+// остаётся на самом сервере, всё остальное уходит на upstream.
+func newRawProxy() (httpraw.Handler, error) {
+	p := &proxy.Proxy{
+		Policy:          *proxyPolicy,
+		HealthPath:      *proxyHealth,
+		HealthInterval:  *proxyInterval,
+		Retries:         *proxyRetries,
+		ResponseTimeout: *proxyTimeout,
+		Logf: func(format string, args ...any) {
+			fmt.Printf(format+"\n", args...)
+		},
+	}
+	for _, addr := range strings.Split(*proxyUpstreams, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		b, err := proxy.NewBackend(addr)
+		if err != nil {
+			return nil, err
+		}
+		p.Backends = append(p.Backends, b)
+	}
+	if err := p.Start(); err != nil {
+		return nil, err
+	}
+	rawProxy = p
+
+	rt := httpraw.NewRouter()
+	rt.HandleFunc("GET", "/_proxy/status", rawProxyStatusHandler)
+	rt.Handle("GET", "/metrics", labMetrics.RawHandler())
+	rt.NotFound = p
+	return rt, nil
+}
+
+func rawProxyStatusHandler(w httpraw.ResponseWriter, req *httpraw.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Policy   string               `json:"policy"`
+		Backends []proxy.BackendStats `json:"backends"`
+	}{rawProxy.Policy, rawProxy.Stats()})
+}
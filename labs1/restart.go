@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var restartTimeout = flag.Duration("restart-timeout", 30*time.Second, "сколько ждать готовности нового процесса при перезапуске по SIGHUP")
+
+// handoffDrain — пауза между закрытием слушателей старого процесса и
+// его остановкой.
+const handoffDrain = 200 * time.Millisecond
+
+// readyFDEnv — номер дескриптора канала готовности в новом процессе.
+const readyFDEnv = "LABS1_READY_FD"
+
+// readyPipe — канал готовности от предыдущего процесса, nil — процесс
+// запущен не перезапуском.
+var readyPipe *os.File
+
+// restart — перезапуск без простоя. Процесс запускает свою копию с теми
+// же аргументами и передаёт ей слушающие сокеты через LISTEN_FDS, как
+// это делает systemd. Новый процесс заново читает настройки, открывает
+// журналы и сообщает о готовности в канал из LABS1_READY_FD; только
+// после этого старый перестаёт принимать соединения. Соединения из
+// очереди сокета принимает уже новый процесс, поэтому ни одно не
+// теряется. Если новый процесс не запустился, возвращается ошибка, и
+// старый работает дальше.
+func restart(servers []*labServer) (pid int, err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	var files []*os.File
+	var names []string
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, srv := range servers {
+		fl, ok := srv.socket.(interface{ File() (*os.File, error) })
+		if !ok {
+			return 0, fmt.Errorf("сокет сервера %s нельзя передать", srv.name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return 0, fmt.Errorf("сокет сервера %s: %v", srv.name, err)
+		}
+		files = append(files, f)
+		names = append(names, srv.name)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// дескрипторы ExtraFiles получают номера с 3 по порядку
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(os.Environ(),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		readyFDEnv+"="+strconv.Itoa(listenFDsStart+len(files)),
+	)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		// конец канала без данных — процесс завершился, не начав работу
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err := <-ready:
+		if err != nil {
+			cmd.Wait()
+			return 0, fmt.Errorf("новый процесс завершился (%v)", cmd.ProcessState)
+		}
+	case <-time.After(*restartTimeout):
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, fmt.Errorf("новый процесс не сообщил о готовности за %v", *restartTimeout)
+	}
+	pid = cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
+
+// notifyReady сообщает, что серверы принимают соединения: предыдущему
+// процессу, если нас запустил перезапуск, иначе systemd (Type=notify).
+func notifyReady() {
+	if readyPipe != nil {
+		readyPipe.Write([]byte{1})
+		readyPipe.Close()
+		readyPipe = nil
+		return
+	}
+	sdNotify("READY=1")
+}
+
+// sdNotify отправляет состояние systemd в NOTIFY_SOCKET (sd_notify(3)).
+// Без systemd переменной нет и сообщение некому отправлять.
+func sdNotify(state string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return
+	}
+	// адрес с @ — абстрактный сокет; net переводит @ в нулевой байт сам
+	conn, err := net.Dial("unixgram", addr)
+	if err == nil {
+		_, err = conn.Write([]byte(state))
+		conn.Close()
+	}
+	if err != nil {
+		fmt.Printf("Ошибка уведомления systemd (%s): %v\n", state, err)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+
+	"labs1/accesslog"
+	"labs1/apierror"
+	"labs1/guard"
+	"labs1/middleware"
+)
+
+// serverMux — собственная таблица маршрутов net/http сервера. Помимо
+// http.ServeMux хранит список маршрутов для индексной страницы, поэтому
+// на каждом порту видно ровно то, что он обслуживает.
+type serverMux struct {
+	*http.ServeMux
+	title  string
+	routes []muxRoute
+}
+
+type muxRoute struct {
+	pattern string
+	about   string
+	example string
+}
+
+func newServerMux(title string) *serverMux {
+	m := &serverMux{ServeMux: http.NewServeMux(), title: title}
+	m.handle("GET /{$}", "список маршрутов этого сервера", "/", http.HandlerFunc(m.index))
+	return m
+}
+
+// handle регистрирует обработчик и запоминает его для индексной страницы.
+func (m *serverMux) handle(pattern, about, example string, h http.Handler) {
+	m.ServeMux.Handle(pattern, h)
+	m.routes = append(m.routes, muxRoute{pattern: pattern, about: about, example: example})
+}
+
+func (m *serverMux) index(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<html>\n<head><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<ul>\n",
+		html.EscapeString(m.title), html.EscapeString(m.title))
+	for _, rt := range m.routes {
+		fmt.Fprintf(w, "<li><a href=\"%s\"><code>%s</code></a> — %s</li>\n",
+			html.EscapeString(rt.example), html.EscapeString(rt.pattern), html.EscapeString(rt.about))
+	}
+	fmt.Fprint(w, "</ul>\n</body>\n</html>\n")
+}
+
+// serverMiddleware собирает цепочку net/http сервера name: журнал
+// доступа снаружи, чтобы в него попадали и отказы, затем единый формат
+// ошибок, предел тела, CORS (предварительные запросы браузер шлёт без
+// авторизации), ограничение частоты и авторизация. Отказы по телу
+// считаются в rejected.
+func serverMiddleware(name string, rejected *guard.Counters) []middleware.Middleware {
+	return []middleware.Middleware{
+		func(next http.Handler) http.Handler {
+			return accesslog.Handler(name, requestSink(), next)
+		},
+		apierror.Handler,
+		func(next http.Handler) http.Handler {
+			return limitBody(next, rejected)
+		},
+		corsMiddleware(name),
+		rateLimitMiddleware(name),
+		authMiddleware(name),
+	}
+}
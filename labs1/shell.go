@@ -6,23 +6,38 @@ import (
 	"net/http"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
+
+	"labs1/apierror"
+	"labs1/compress"
+	"labs1/middleware"
 )
 
 type DNSResponse struct {
 	Domain    string    `json:"domain"`
+	Type      string    `json:"type"`
 	Command   string    `json:"command"`
 	Result    string    `json:"result"`
-	Error     string    `json:"error,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
 }
 
-func handleDNSShellExec() {
-	http.HandleFunc("/dns", dnsHandler)
-	fmt.Println("DNS Shell Exec сервер запущен на порту 8082")
-	fmt.Println("Используйте: http://localhost:8082/dns?domain=google.com&type=A")
-	http.ListenAndServe(":8082", nil)
+// dnsTypes — типы записей, которые принимает /dns.
+var dnsTypes = []string{"A", "AAAA", "CNAME", "MX", "NS", "PTR", "SOA", "SRV", "TXT", "CAA", "ANY"}
+
+func newDNSLabServer(addr string) *labServer {
+	mux := newServerMux("DNS Shell Exec сервер")
+	mux.handle("GET /dns", "DNS запрос через dig или nslookup", "/dns?domain=google.com&type=A",
+		compress.Handler(http.HandlerFunc(dnsHandler)))
+	mux.handle("GET /metrics", "метрики в формате Prometheus", "/metrics", labMetrics.Handler())
+	mux.handle("GET /openapi.json", "описание API file и dns в формате OpenAPI 3", "/openapi.json", http.HandlerFunc(openAPIHandler))
+	handler := middleware.Chain(mux, serverMiddleware("dns", dnsRejected)...)
+	registerAPIServer("dns", addr)
+	return newHTTPLabServer("dns", addr, handler, dnsRejected, func() {
+		fmt.Printf("DNS Shell Exec сервер запущен на %s\n", addr)
+		fmt.Printf("Используйте: %s://%s/dns?domain=google.com&type=A\n", scheme(), urlHost(addr))
+	})
 }
 
 func dnsHandler(w http.ResponseWriter, r *http.Request) {
@@ -30,37 +45,62 @@ func dnsHandler(w http.ResponseWriter, r *http.Request) {
 	queryType := r.URL.Query().Get("type")
 
 	if domain == "" {
-		http.Error(w, "Параметр 'domain' обязателен", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, apierror.MissingParameter, "Параметр 'domain' обязателен")
+		return
+	}
+	// имя уходит аргументом dig/nslookup: ведущий "-" превратил бы его
+	// в ключ команды
+	if strings.HasPrefix(domain, "-") || strings.ContainsAny(domain, " \t\r\n") {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.InvalidParameter, fmt.Sprintf("Некорректное доменное имя %q", domain))
 		return
 	}
 
 	if queryType == "" {
 		queryType = "A"
 	}
-
-	// Выполняем DNS запрос через shell команды
-	result, err := executeDNSQuery(domain, queryType)
-
-	response := DNSResponse{
-		Domain:    domain,
-		Timestamp: time.Now(),
+	queryType = strings.ToUpper(queryType)
+	if !slices.Contains(dnsTypes, queryType) {
+		apierror.Write(w, r, http.StatusBadRequest, apierror.InvalidParameter,
+			fmt.Sprintf("Неизвестный тип записи %q, допустимы: %s", queryType, strings.Join(dnsTypes, ", ")))
+		return
 	}
 
+	// Выполняем DNS запрос через shell команды
+	command, result, err := executeDNSQuery(domain, queryType)
 	if err != nil {
-		response.Error = err.Error()
-	} else {
-		response.Result = result
+		apierror.Write(w, r, http.StatusBadGateway, apierror.DNSQueryFailed, err.Error())
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-
-	fmt.Printf("DNS запрос для %s (тип: %s) выполнен\n", domain, queryType)
+	json.NewEncoder(w).Encode(DNSResponse{
+		Domain:    domain,
+		Type:      queryType,
+		Command:   command,
+		Result:    result,
+		Timestamp: time.Now(),
+	})
 }
 
-func executeDNSQuery(domain, queryType string) (string, error) {
+// dnsNotFound — результат, когда команда отработала, но записей нет.
+const dnsNotFound = "DNS запись не найдена"
+
+func executeDNSQuery(domain, queryType string) (cmdStr, result string, err error) {
+	start := time.Now()
+	defer func() {
+		outcome := "ok"
+		switch {
+		case err != nil:
+			outcome = "error"
+		case result == dnsNotFound:
+			outcome = "empty"
+		}
+		label := dnsTypeLabel(queryType)
+		dnsQueries.Inc(label, outcome)
+		dnsDuration.Observe(time.Since(start).Seconds(), label)
+	}()
+
 	var cmd *exec.Cmd
-	var cmdStr string
 
 	// Выбираем команду в зависимости от ОС
 	switch runtime.GOOS {
@@ -78,22 +118,22 @@ func executeDNSQuery(domain, queryType string) (string, error) {
 			cmd = exec.Command("nslookup", "-type="+queryType, domain)
 		}
 	default:
-		return "", fmt.Errorf("неподдерживаемая ОС: %s", runtime.GOOS)
+		return "", "", fmt.Errorf("неподдерживаемая ОС: %s", runtime.GOOS)
 	}
 
 	// Выполняем команду
 	output, err := cmd.Output()
 	if err != nil {
-		return "", fmt.Errorf("ошибка выполнения команды '%s': %v", cmdStr, err)
+		return cmdStr, "", fmt.Errorf("ошибка выполнения команды '%s': %v", cmdStr, err)
 	}
 
-	result := strings.TrimSpace(string(output))
+	result = strings.TrimSpace(string(output))
 
 	if result == "" {
-		result = "DNS запись не найдена"
+		result = dnsNotFound
 	}
 
-	return result, nil
+	return cmdStr, result, nil
 }
 
 // Вспомогательная функция для проверки доступности команды
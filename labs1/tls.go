@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	tlsEnabled = flag.Bool("tls", false, "включить TLS на всех серверах")
+	tlsCert    = flag.String("tls-cert", "", "PEM сертификат сервера (без него будет сгенерирован)")
+	tlsKey     = flag.String("tls-key", "", "PEM ключ сервера")
+	tlsDir     = flag.String("tls-dir", "certs", "каталог для сгенерированных CA и сертификата")
+)
+
+// serverTLS заполняется в main, если включён TLS; nil — обычный TCP.
+var serverTLS *tls.Config
+
+// loadTLSConfig загружает сертификат из -tls-cert/-tls-key или создаёт
+// локальный CA и подписанный им сертификат для localhost.
+func loadTLSConfig() (*tls.Config, error) {
+	certFile, keyFile := *tlsCert, *tlsKey
+	if certFile == "" && keyFile == "" {
+		var err error
+		certFile, keyFile, err = ensureLocalCertificate(*tlsDir)
+		if err != nil {
+			return nil, err
+		}
+	} else if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("нужно указать и -tls-cert, и -tls-key")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("загрузка сертификата: %v", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ensureLocalCertificate возвращает пути к сертификату и ключу в dir,
+// создавая CA и серверный сертификат, если их нет или срок истёк.
+func ensureLocalCertificate(dir string) (certFile, keyFile string, err error) {
+	caFile := filepath.Join(dir, "ca.pem")
+	caKeyFile := filepath.Join(dir, "ca-key.pem")
+	certFile = filepath.Join(dir, "server.pem")
+	keyFile = filepath.Join(dir, "server-key.pem")
+
+	if certificateValid(certFile) {
+		return certFile, keyFile, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", "", err
+	}
+
+	var ca *x509.Certificate
+	var caKey *ecdsa.PrivateKey
+	if certificateValid(caFile) {
+		ca, caKey, err = loadCA(caFile, caKeyFile)
+	} else {
+		ca, caKey, err = createCA(caFile, caKeyFile)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := createServerCertificate(certFile, keyFile, ca, caKey); err != nil {
+		return "", "", err
+	}
+	fmt.Printf("Создан сертификат %s, подписанный локальным CA %s\n", certFile, caFile)
+	fmt.Printf("Проверка: curl --cacert %s https://localhost:8080/\n", caFile)
+	return certFile, keyFile, nil
+}
+
+func certificateValid(file string) bool {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	return err == nil && time.Now().Before(cert.NotAfter)
+}
+
+func createCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "labs1 local CA", Organization: []string{"networks-labs"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0o644); err != nil {
+		return nil, nil, err
+	}
+	if err := writeKey(keyFile, key); err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+func loadCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("загрузка CA: %v", err)
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("ключ CA %s должен быть ECDSA", keyFile)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	return cert, key, err
+}
+
+func createServerCertificate(certFile, keyFile string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	dnsNames := []string{"localhost"}
+	if host, err := os.Hostname(); err == nil && host != "localhost" {
+		dnsNames = append(dnsNames, host)
+	}
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: "localhost", Organization: []string{"networks-labs"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		// браузеры не принимают серверные сертификаты дольше 825 дней
+		NotAfter:    time.Now().AddDate(0, 0, 825),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:    dnsNames,
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1"), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0o644); err != nil {
+		return err
+	}
+	return writeKey(keyFile, key)
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+func writeKey(file string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEM(file, "PRIVATE KEY", der, 0o600)
+}
+
+func writePEM(file, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	return os.WriteFile(file, data, perm)
+}
+
+// scheme возвращает схему URL для сообщений о запуске.
+func scheme() string {
+	if serverTLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// wsScheme — схема WebSocket, соответствующая scheme().
+func wsScheme() string {
+	if serverTLS != nil {
+		return "wss"
+	}
+	return "ws"
+}
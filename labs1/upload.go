@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"labs1/httpraw"
+)
+
+var uploadDir = flag.String("upload-dir", "uploads", "каталог, куда raw сервер сохраняет файлы из POST /upload")
+
+// maxFieldBytes ограничивает обычное (не файловое) поле multipart формы:
+// оно читается в память.
+const maxFieldBytes = 1 << 20
+
+type uploadedFile struct {
+	Field       string `json:"field"`
+	FileName    string `json:"filename"`
+	StoredAs    string `json:"stored_as"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"`
+	SHA256      string `json:"sha256"`
+}
+
+type uploadResult struct {
+	Files  []uploadedFile      `json:"files"`
+	Fields map[string][]string `json:"fields"`
+}
+
+// rawUploadHandler принимает форму application/x-www-form-urlencoded или
+// multipart/form-data. Файлы пишутся в -upload-dir по мере чтения тела,
+// поля возвращаются в ответе вместе со сведениями о файлах. Если тело
+// оборвалось, уже записанные файлы этого запроса удаляются.
+func rawUploadHandler(w httpraw.ResponseWriter, req *httpraw.Request) {
+	result := uploadResult{Files: []uploadedFile{}, Fields: map[string][]string{}}
+
+	mediaType, _, err := httpraw.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		uploadError(w, err)
+		return
+	}
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		if err := req.ParseForm(); err != nil {
+			uploadError(w, err)
+			return
+		}
+		result.Fields = req.PostForm
+	case "multipart/form-data":
+		if err := readMultipartUpload(req, &result); err != nil {
+			for _, f := range result.Files {
+				os.Remove(filepath.Join(*uploadDir, f.StoredAs))
+			}
+			uploadError(w, err)
+			return
+		}
+	default:
+		uploadError(w, &httpraw.StatusError{Status: 415, Reason: fmt.Sprintf("ожидалась форма, получено %s", mediaType)})
+		return
+	}
+
+	fmt.Printf("Upload: %s прислал %d файлов и %d полей\n", req.RemoteAddr, len(result.Files), len(result.Fields))
+	w.Header().Set("Content-Type", "application/json")
+	if len(result.Files) > 0 {
+		w.WriteHeader(201)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func readMultipartUpload(req *httpraw.Request, result *uploadResult) error {
+	mr, err := req.MultipartReader()
+	if err != nil {
+		return err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := part.FormName()
+		if name == "" {
+			// части без имени поля форме не принадлежат
+			continue
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFieldBytes+1))
+			if err != nil {
+				return err
+			}
+			if len(value) > maxFieldBytes {
+				return &httpraw.StatusError{Status: 413, Reason: fmt.Sprintf("поле %s длиннее %d байт", name, maxFieldBytes)}
+			}
+			result.Fields[name] = append(result.Fields[name], string(value))
+			continue
+		}
+		f, err := storeUpload(part)
+		if err != nil {
+			return err
+		}
+		result.Files = append(result.Files, f)
+	}
+}
+
+// storeUpload записывает файловую часть под очищенным именем, не
+// перезаписывая существующие файлы.
+func storeUpload(part *httpraw.Part) (uploadedFile, error) {
+	info := uploadedFile{
+		Field:       part.FormName(),
+		FileName:    part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+	}
+	if err := os.MkdirAll(*uploadDir, 0o755); err != nil {
+		return info, err
+	}
+	f, stored, err := createUnique(*uploadDir, safeFileName(info.FileName))
+	if err != nil {
+		return info, err
+	}
+	info.StoredAs = stored
+
+	hash := sha256.New()
+	info.Size, err = io.Copy(io.MultiWriter(f, hash), part)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filepath.Join(*uploadDir, stored))
+		return info, err
+	}
+	info.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return info, nil
+}
+
+// safeFileName оставляет от присланного имени только последний элемент
+// пути (клиенты на Windows шлют и '\') без управляющих символов.
+func safeFileName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		name = "upload"
+	}
+	if len(name) > 200 {
+		ext := filepath.Ext(name)
+		if len(ext) > 20 {
+			ext = ""
+		}
+		name = strings.ToValidUTF8(name[:200-len(ext)], "") + ext
+	}
+	return name
+}
+
+// createUnique создаёт name в dir, а если он занят — name-1, name-2 и
+// так далее перед расширением.
+func createUnique(dir, name string) (*os.File, string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; i <= 1000; i++ {
+		f, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err == nil {
+			return f, candidate, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, "", err
+		}
+		candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	return nil, "", fmt.Errorf("не удалось подобрать свободное имя для %s", name)
+}
+
+// uploadError отвечает JSON с текстом ошибки. Код берётся из ошибки
+// разбора тела; прочие ошибки (диск) — 500.
+func uploadError(w httpraw.ResponseWriter, err error) {
+	code := httpraw.StatusCode(err)
+	if code == 0 {
+		code = 500
+	}
+	fmt.Printf("Upload: ошибка %d: %v\n", code, err)
+	w.Header().Set("Content-Type", "application/json")
+	// тело могло остаться недочитанным
+	w.Header().Set("Connection", "close")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
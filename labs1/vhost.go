@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"labs1/accesslog"
+	"labs1/httpraw"
+)
+
+// vhostsConfig — раздел "vhosts" файла настроек: виртуальные хосты raw
+// сервера, выбираемые по полю Host (и по SNI, если включён TLS).
+type vhostsConfig struct {
+	// Strict: запрос с неизвестным Host получает 421 Misdirected Request
+	// вместо основного сайта или хоста по умолчанию.
+	Strict bool `json:"strict"`
+	// Default — имя хоста, который отвечает на запросы без Host и с
+	// неизвестным Host (если Strict выключен).
+	Default string        `json:"default"`
+	Hosts   []vhostConfig `json:"hosts"`
+}
+
+type vhostConfig struct {
+	// Names — имена хоста; "*.example.com" подходит к любому поддомену.
+	Names []string `json:"names"`
+	// Root — каталог, который хост раздаёт по всем путям, не занятым
+	// разделами из Routes.
+	Root string `json:"root"`
+	// Routes — разделы raw сервера, включённые на хосте: hello, events,
+	// ws, static, metrics, upload, cgi.
+	Routes []string `json:"routes"`
+	// Log — отдельный журнал доступа хоста (в дополнение к общему).
+	Log string `json:"log"`
+	// Cert и Key — PEM сертификат хоста, который выдаётся по SNI.
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// validate проверяет раздел до запуска серверов: имена не повторяются,
+// разделы известны, хост по умолчанию существует.
+func (vc vhostsConfig) validate() error {
+	seen := map[string]bool{}
+	for i, h := range vc.Hosts {
+		if len(h.Names) == 0 {
+			return fmt.Errorf("vhosts: у хоста %d нет имён", i+1)
+		}
+		for _, name := range h.Names {
+			name = normalizeHost(name)
+			if name == "" || name == "*" || strings.Contains(name[1:], "*") ||
+				strings.HasPrefix(name, "*") && !strings.HasPrefix(name, "*.") {
+				return fmt.Errorf("vhosts: некорректное имя %q", name)
+			}
+			if seen[name] {
+				return fmt.Errorf("vhosts: имя %s указано дважды", name)
+			}
+			seen[name] = true
+		}
+		if h.Root == "" {
+			return fmt.Errorf("vhosts: у хоста %s не задан root", h.Names[0])
+		}
+		for _, r := range h.Routes {
+			if rawFeatures[r] == nil {
+				return fmt.Errorf("vhosts: у хоста %s неизвестный раздел %q", h.Names[0], r)
+			}
+		}
+		if (h.Cert == "") != (h.Key == "") {
+			return fmt.Errorf("vhosts: у хоста %s нужно указать и cert, и key", h.Names[0])
+		}
+	}
+	if vc.Default != "" && !seen[normalizeHost(vc.Default)] {
+		return fmt.Errorf("vhosts: хост по умолчанию %s не описан в hosts", vc.Default)
+	}
+	return nil
+}
+
+// vhost — собранный виртуальный хост.
+type vhost struct {
+	name    string
+	names   []string
+	root    string
+	handler httpraw.Handler
+	log     *accesslog.Logger
+	cert    *tls.Certificate
+}
+
+// vhostRouter выбирает хост по полю Host. Запросы, не попавшие ни в один
+// хост, идут в хост по умолчанию, а без него — в основной обработчик.
+type vhostRouter struct {
+	hosts     []*vhost
+	exact     map[string]*vhost
+	wildcards []vhostWildcard // длинные суффиксы первыми
+	def       *vhost
+	fallback  httpraw.Handler
+	strict    bool
+}
+
+type vhostWildcard struct {
+	suffix string // ".example.com"
+	host   *vhost
+}
+
+// rawVHosts задаётся при запуске raw сервера, если виртуальные хосты
+// настроены: по нему TLS выбирает сертификат.
+var rawVHosts *vhostRouter
+
+func newVHostRouter(vc vhostsConfig, fallback httpraw.Handler) (*vhostRouter, error) {
+	vr := &vhostRouter{exact: map[string]*vhost{}, fallback: fallback, strict: vc.Strict}
+	for _, hc := range vc.Hosts {
+		h := &vhost{name: normalizeHost(hc.Names[0]), root: hc.Root, handler: newVHostHandler(hc)}
+		vr.hosts = append(vr.hosts, h)
+		for _, name := range hc.Names {
+			h.names = append(h.names, normalizeHost(name))
+		}
+		if hc.Log != "" {
+			f, err := accesslog.OpenRotating(hc.Log, *accessLogMaxSize<<20, *accessLogBackups)
+			if err != nil {
+				return nil, fmt.Errorf("журнал хоста %s: %w", h.name, err)
+			}
+			if h.log, err = accesslog.New(f, *accessLogFormat); err != nil {
+				return nil, err
+			}
+		}
+		if hc.Cert != "" {
+			cert, err := tls.LoadX509KeyPair(hc.Cert, hc.Key)
+			if err != nil {
+				return nil, fmt.Errorf("сертификат хоста %s: %v", h.name, err)
+			}
+			h.cert = &cert
+		}
+		for _, name := range h.names {
+			if suffix, ok := strings.CutPrefix(name, "*"); ok {
+				vr.wildcards = append(vr.wildcards, vhostWildcard{suffix, h})
+			} else {
+				vr.exact[name] = h
+			}
+			if name == normalizeHost(vc.Default) {
+				vr.def = h
+			}
+		}
+	}
+	// самое конкретное имя проверяется первым: *.a.example.com раньше *.example.com
+	for i := 1; i < len(vr.wildcards); i++ {
+		for j := i; j > 0 && len(vr.wildcards[j].suffix) > len(vr.wildcards[j-1].suffix); j-- {
+			vr.wildcards[j], vr.wildcards[j-1] = vr.wildcards[j-1], vr.wildcards[j]
+		}
+	}
+	return vr, nil
+}
+
+// newVHostHandler собирает маршрутизатор хоста: выбранные разделы и
+// раздача Root по всем остальным путям.
+func newVHostHandler(hc vhostConfig) httpraw.Handler {
+	rt := httpraw.NewRouter()
+	for _, name := range hc.Routes {
+		rawFeatures[name](rt)
+	}
+	rt.Handle("", "/{path...}", httpraw.FileServer(hc.Root))
+	return rt
+}
+
+// normalizeHost приводит значение Host или SNI к виду для сравнения:
+// нижний регистр, без порта и без точки в конце.
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// match ищет хост по имени: сначала точное совпадение, затем шаблоны.
+func (vr *vhostRouter) match(name string) *vhost {
+	if h := vr.exact[name]; h != nil {
+		return h
+	}
+	for _, w := range vr.wildcards {
+		if len(name) > len(w.suffix) && strings.HasSuffix(name, w.suffix) {
+			return w.host
+		}
+	}
+	return nil
+}
+
+// resolve возвращает хост, который обслужит запрос с таким Host; nil —
+// основной обработчик или, в строгом режиме, 421.
+func (vr *vhostRouter) resolve(host string) *vhost {
+	name := normalizeHost(host)
+	if h := vr.match(name); h != nil {
+		return h
+	}
+	if name == "" || !vr.strict {
+		return vr.def
+	}
+	return nil
+}
+
+func (vr *vhostRouter) ServeHTTP(w httpraw.ResponseWriter, req *httpraw.Request) {
+	h := vr.resolve(req.Host)
+	if vr.strict {
+		known := h != nil || normalizeHost(req.Host) == ""
+		// RFC 9110 15.5.20: соединение, открытое для одного имени по SNI,
+		// не должно обслуживать другое
+		if known && req.TLS != nil && req.TLS.ServerName != "" && vr.resolve(req.TLS.ServerName) != h {
+			known = false
+		}
+		if !known {
+			httpraw.Error(w, fmt.Sprintf("хост %s не обслуживается этим сервером", req.Host), 421)
+			return
+		}
+	}
+	if h == nil {
+		vr.fallback.ServeHTTP(w, req)
+		return
+	}
+	h.handler.ServeHTTP(w, req)
+}
+
+// Log дописывает строку в журнал хоста, который обслужил запрос.
+// vhostRouter подключается к журналу доступа raw сервера как Sink.
+func (vr *vhostRouter) Log(e accesslog.Entry) {
+	if h := vr.resolve(e.Host); h != nil {
+		h.log.Log(e)
+	}
+}
+
+// certificate выбирает сертификат по SNI. Для хоста без своего
+// сертификата возвращается nil, и crypto/tls берёт основной.
+func (vr *vhostRouter) certificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if h := vr.resolve(hello.ServerName); h != nil && h.cert != nil {
+		return h.cert, nil
+	}
+	return nil, nil
+}
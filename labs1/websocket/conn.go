@@ -0,0 +1,334 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Коды операций кадров (RFC 6455 5.2).
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// Коды закрытия (RFC 6455 7.4.1).
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseAbnormal        = 1006
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// Управляющие кадры не длиннее 125 байт и не фрагментируются.
+const maxControlPayload = 125
+
+// DefaultMaxMessageSize ограничивает размер собранного сообщения.
+const DefaultMaxMessageSize = 1 << 20
+
+// CloseError возвращается из ReadMessage, когда соединение закрыто
+// кадром Close — своим или собеседника.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket закрыт: %d %s", e.Code, e.Reason)
+}
+
+// Conn — установленное WebSocket-соединение на стороне сервера.
+// ReadMessage вызывается из одной горутины, WriteMessage безопасен для
+// одновременного вызова из нескольких.
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// MaxMessageSize — предел размера сообщения, иначе закрытие с 1009.
+	MaxMessageSize int64
+	// WriteTimeout ограничивает отправку одного кадра; медленный клиент
+	// не должен задерживать рассылку остальным.
+	WriteTimeout time.Duration
+
+	wmu       sync.Mutex
+	bw        *bufio.Writer
+	closeSent bool
+}
+
+func newConn(conn net.Conn, rw *bufio.ReadWriter) *Conn {
+	return &Conn{
+		conn:           conn,
+		br:             rw.Reader,
+		bw:             rw.Writer,
+		MaxMessageSize: DefaultMaxMessageSize,
+		WriteTimeout:   10 * time.Second,
+	}
+}
+
+// RemoteAddr возвращает адрес клиента.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline ограничивает ожидание следующего кадра.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// protocolError — нарушение протокола со стороны клиента: ответить кадром
+// Close с кодом и закрыть соединение.
+type protocolError struct {
+	code   int
+	reason string
+}
+
+func (e *protocolError) Error() string { return e.reason }
+
+func failf(code int, format string, args ...any) error {
+	return &protocolError{code: code, reason: fmt.Sprintf(format, args...)}
+}
+
+// readFrame читает и демаскирует один кадр.
+func (c *Conn) readFrame(limit int64) (*frame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return nil, err
+	}
+	f := &frame{
+		fin:    head[0]&0x80 != 0,
+		opcode: head[0] & 0x0F,
+	}
+	if head[0]&0x70 != 0 {
+		// расширения не согласовывались, RSV1-3 должны быть нулями
+		return nil, failf(CloseProtocolError, "установлены биты RSV")
+	}
+	masked := head[1]&0x80 != 0
+	if !masked {
+		// RFC 6455 5.1: кадры клиента всегда маскированы
+		return nil, failf(CloseProtocolError, "кадр клиента без маски")
+	}
+
+	length := int64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint64(ext[:])
+		if n>>63 != 0 {
+			return nil, failf(CloseProtocolError, "старший бит длины установлен")
+		}
+		length = int64(n)
+	}
+
+	if f.opcode >= OpClose {
+		if !f.fin {
+			return nil, failf(CloseProtocolError, "фрагментированный управляющий кадр")
+		}
+		if length > maxControlPayload {
+			return nil, failf(CloseProtocolError, "управляющий кадр длиннее 125 байт")
+		}
+	} else if length > limit {
+		return nil, failf(CloseMessageTooBig, "сообщение больше %d байт", c.MaxMessageSize)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return nil, err
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return nil, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+// ReadMessage возвращает следующее сообщение (OpText или OpBinary),
+// собирая фрагменты. Ping получает Pong автоматически. Если клиент
+// закрыл соединение или нарушил протокол, отправляется ответный Close и
+// возвращается *CloseError.
+func (c *Conn) ReadMessage() (opcode byte, data []byte, err error) {
+	var message []byte
+	var messageOp byte
+	inMessage := false
+
+	for {
+		f, err := c.readFrame(c.MaxMessageSize - int64(len(message)))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+
+		switch f.opcode {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, f.payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			return 0, nil, c.handleClose(f.payload)
+		case OpContinuation:
+			if !inMessage {
+				return 0, nil, c.fail(failf(CloseProtocolError, "продолжение без начала сообщения"))
+			}
+		case OpText, OpBinary:
+			if inMessage {
+				return 0, nil, c.fail(failf(CloseProtocolError, "новое сообщение до конца предыдущего"))
+			}
+			inMessage = true
+			messageOp = f.opcode
+		default:
+			return 0, nil, c.fail(failf(CloseProtocolError, "неизвестный код операции %#x", f.opcode))
+		}
+
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+		if messageOp == OpText && !utf8.Valid(message) {
+			return 0, nil, c.fail(failf(CloseInvalidPayload, "текст не в UTF-8"))
+		}
+		return messageOp, message, nil
+	}
+}
+
+// fail превращает нарушение протокола в кадр Close; сетевые ошибки
+// возвращаются как есть.
+func (c *Conn) fail(err error) error {
+	var pe *protocolError
+	if errors.As(err, &pe) {
+		c.Close(pe.code, pe.reason)
+		return &CloseError{Code: pe.code, Reason: pe.reason}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &CloseError{Code: CloseAbnormal, Reason: "соединение оборвано без Close"}
+	}
+	return err
+}
+
+// handleClose разбирает кадр Close клиента и отвечает на него тем же
+// кодом (RFC 6455 5.5.1).
+func (c *Conn) handleClose(payload []byte) error {
+	if len(payload) == 0 {
+		c.Close(CloseNormal, "")
+		return &CloseError{Code: CloseNoStatus}
+	}
+	if len(payload) == 1 {
+		return c.fail(failf(CloseProtocolError, "кадр Close длиной 1 байт"))
+	}
+	code := int(binary.BigEndian.Uint16(payload))
+	reason := payload[2:]
+	if !validCloseCode(code) {
+		return c.fail(failf(CloseProtocolError, "недопустимый код закрытия %d", code))
+	}
+	if !utf8.Valid(reason) {
+		return c.fail(failf(CloseInvalidPayload, "причина закрытия не в UTF-8"))
+	}
+	c.Close(code, "")
+	return &CloseError{Code: code, Reason: string(reason)}
+}
+
+// validCloseCode — коды, которые разрешено передавать в кадре Close.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// WriteMessage отправляет данные одним кадром. Кадры сервера не
+// маскируются.
+func (c *Conn) WriteMessage(opcode byte, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.writeFrame(opcode, data)
+}
+
+func (c *Conn) writeFrame(opcode byte, data []byte) error {
+	if c.closeSent {
+		return errors.New("кадр Close уже отправлен")
+	}
+	if c.WriteTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout))
+	}
+
+	var head [10]byte
+	head[0] = 0x80 | opcode
+	n := 2
+	switch {
+	case len(data) <= 125:
+		head[1] = byte(len(data))
+	case len(data) <= 0xFFFF:
+		head[1] = 126
+		binary.BigEndian.PutUint16(head[2:], uint16(len(data)))
+		n = 4
+	default:
+		head[1] = 127
+		binary.BigEndian.PutUint64(head[2:], uint64(len(data)))
+		n = 10
+	}
+	c.bw.Write(head[:n])
+	c.bw.Write(data)
+	return c.bw.Flush()
+}
+
+// Ping отправляет Ping; ответный Pong ReadMessage пропустит.
+func (c *Conn) Ping(data []byte) error {
+	return c.WriteMessage(OpPing, data)
+}
+
+// Close отправляет кадр Close с кодом и причиной. Повторные вызовы ничего
+// не делают. Само TCP-соединение закрывает сервер после возврата
+// обработчика.
+func (c *Conn) Close(code int, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return nil
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		payload = payload[:maxControlPayload]
+	}
+	err := c.writeFrame(OpClose, payload)
+	c.closeSent = true
+	return err
+}
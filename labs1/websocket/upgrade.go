@@ -0,0 +1,72 @@
+// Package websocket реализует серверную сторону RFC 6455 поверх
+// соединений httpraw: рукопожатие Upgrade и разбор кадров без сторонних
+// библиотек.
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"labs1/httpraw"
+)
+
+// GUID из RFC 6455 1.3, который дописывается к ключу клиента.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// IsUpgrade сообщает, просит ли клиент перейти на WebSocket.
+func IsUpgrade(r *httpraw.Request) bool {
+	return r.Header.HasToken("Upgrade", "websocket") && r.Header.HasToken("Connection", "upgrade")
+}
+
+// AcceptKey вычисляет Sec-WebSocket-Accept для ключа клиента.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Upgrade проверяет запрос рукопожатия, отвечает 101 Switching Protocols
+// и забирает соединение у HTTP-сервера. При ошибке ответ клиенту уже
+// отправлен.
+func Upgrade(w httpraw.ResponseWriter, r *httpraw.Request) (*Conn, error) {
+	if r.Method != "GET" || !r.ProtoAtLeast(1, 1) {
+		httpraw.Error(w, "WebSocket требует GET и HTTP/1.1", 400)
+		return nil, errors.New("рукопожатие не через GET HTTP/1.1")
+	}
+	if !IsUpgrade(r) {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		httpraw.Error(w, "ожидается Upgrade: websocket", 426)
+		return nil, errors.New("нет заголовков Upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		httpraw.Error(w, "поддерживается только версия 13", 426)
+		return nil, fmt.Errorf("версия %q не поддерживается", r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if raw, err := base64.StdEncoding.DecodeString(key); err != nil || len(raw) != 16 {
+		httpraw.Error(w, "некорректный Sec-WebSocket-Key", 400)
+		return nil, errors.New("некорректный Sec-WebSocket-Key")
+	}
+
+	hj, ok := w.(httpraw.Hijacker)
+	if !ok {
+		httpraw.Error(w, "соединение нельзя забрать", 500)
+		return nil, errors.New("ResponseWriter не поддерживает Hijack")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", AcceptKey(key))
+	if err := rw.Flush(); err != nil {
+		return nil, err
+	}
+	return newConn(conn, rw), nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"labs1/httpraw"
+	"labs1/websocket"
+)
+
+// wsEchoHandler возвращает клиенту каждое сообщение тем же типом кадра.
+func wsEchoHandler(w httpraw.ResponseWriter, req *httpraw.Request) {
+	ws, err := websocket.Upgrade(w, req)
+	if err != nil {
+		fmt.Printf("WebSocket: отказ в рукопожатии %s: %v\n", req.RemoteAddr, err)
+		return
+	}
+	fmt.Printf("WebSocket echo: клиент %s подключился\n", req.RemoteAddr)
+	defer closeOnShutdown(ws)()
+
+	for {
+		op, data, err := ws.ReadMessage()
+		if err != nil {
+			logWebSocketClose("echo", req.RemoteAddr, err)
+			return
+		}
+		if err := ws.WriteMessage(op, data); err != nil {
+			fmt.Printf("WebSocket echo: ошибка отправки %s: %v\n", req.RemoteAddr, err)
+			return
+		}
+	}
+}
+
+// wsHub рассылает сообщения всем подключённым к /ws/broadcast.
+type wsHub struct {
+	mu    sync.Mutex
+	conns map[*websocket.Conn]string
+}
+
+var broadcastHub = &wsHub{conns: make(map[*websocket.Conn]string)}
+
+func (h *wsHub) join(ws *websocket.Conn, name string) {
+	h.mu.Lock()
+	h.conns[ws] = name
+	h.mu.Unlock()
+}
+
+func (h *wsHub) leave(ws *websocket.Conn) {
+	h.mu.Lock()
+	delete(h.conns, ws)
+	h.mu.Unlock()
+}
+
+// broadcast отправляет сообщение всем; клиенты, которым не удалось
+// отправить, удаляются из рассылки.
+func (h *wsHub) broadcast(op byte, data []byte) {
+	h.mu.Lock()
+	targets := make([]*websocket.Conn, 0, len(h.conns))
+	for ws := range h.conns {
+		targets = append(targets, ws)
+	}
+	h.mu.Unlock()
+
+	for _, ws := range targets {
+		if err := ws.WriteMessage(op, data); err != nil {
+			h.leave(ws)
+		}
+	}
+}
+
+func (h *wsHub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.conns)
+}
+
+// wsBroadcastHandler пересылает каждое сообщение клиента всем участникам.
+// Раз в 30 секунд сервер шлёт Ping, чтобы заметить пропавших клиентов.
+func wsBroadcastHandler(w httpraw.ResponseWriter, req *httpraw.Request) {
+	ws, err := websocket.Upgrade(w, req)
+	if err != nil {
+		fmt.Printf("WebSocket: отказ в рукопожатии %s: %v\n", req.RemoteAddr, err)
+		return
+	}
+	defer closeOnShutdown(ws)()
+	name := req.RemoteAddr
+	broadcastHub.join(ws, name)
+	defer broadcastHub.leave(ws)
+	broadcastHub.broadcast(websocket.OpText, []byte(fmt.Sprintf("*** %s подключился (участников: %d)", name, broadcastHub.count())))
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				ws.Ping([]byte("ping"))
+			}
+		}
+	}()
+
+	for {
+		ws.SetReadDeadline(time.Now().Add(90 * time.Second))
+		op, data, err := ws.ReadMessage()
+		if err != nil {
+			logWebSocketClose("broadcast", name, err)
+			broadcastHub.leave(ws)
+			broadcastHub.broadcast(websocket.OpText, []byte(fmt.Sprintf("*** %s отключился", name)))
+			return
+		}
+		if op == websocket.OpText {
+			data = []byte(name + ": " + string(data))
+		}
+		broadcastHub.broadcast(op, data)
+	}
+}
+
+// closeOnShutdown закрывает WebSocket с кодом 1001, когда raw сервер
+// начинает остановку: ReadMessage вернёт ответный Close клиента, и
+// обработчик завершится сам. Возвращённую функцию нужно вызвать при
+// выходе из обработчика.
+func closeOnShutdown(ws *websocket.Conn) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case <-rawServer.Done():
+			ws.Close(websocket.CloseGoingAway, "сервер останавливается")
+			// клиент, не ответивший на Close, не задержит остановку
+			ws.SetReadDeadline(time.Now().Add(3 * time.Second))
+		}
+	}()
+	return func() { close(done) }
+}
+
+func logWebSocketClose(endpoint, client string, err error) {
+	var ce *websocket.CloseError
+	if errors.As(err, &ce) {
+		fmt.Printf("WebSocket %s: %s закрыл соединение, код %d %s\n", endpoint, client, ce.Code, ce.Reason)
+		return
+	}
+	fmt.Printf("WebSocket %s: ошибка соединения %s: %v\n", endpoint, client, err)
+}